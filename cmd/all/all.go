@@ -1,6 +1,7 @@
 package all
 
 import (
+	_ "github.com/sagan/goaider/cmd/audio/all"
 	_ "github.com/sagan/goaider/cmd/base64decode"
 	_ "github.com/sagan/goaider/cmd/base64encode"
 	_ "github.com/sagan/goaider/cmd/caption"
@@ -11,12 +12,15 @@ import (
 	_ "github.com/sagan/goaider/cmd/crc32sum"
 	_ "github.com/sagan/goaider/cmd/crop"
 	_ "github.com/sagan/goaider/cmd/csv/all"
+	_ "github.com/sagan/goaider/cmd/dataset/all"
+	_ "github.com/sagan/goaider/cmd/dedupe"
 	_ "github.com/sagan/goaider/cmd/extractall"
 	_ "github.com/sagan/goaider/cmd/fetch"
 	_ "github.com/sagan/goaider/cmd/findfiles"
 	_ "github.com/sagan/goaider/cmd/indexfiles"
 	_ "github.com/sagan/goaider/cmd/jq"
 	_ "github.com/sagan/goaider/cmd/jsonschema"
+	_ "github.com/sagan/goaider/cmd/llm/all"
 	_ "github.com/sagan/goaider/cmd/md5sum"
 	_ "github.com/sagan/goaider/cmd/mediainfo"
 	_ "github.com/sagan/goaider/cmd/mustrun"
@@ -37,5 +41,6 @@ import (
 	_ "github.com/sagan/goaider/cmd/text2utf8"
 	_ "github.com/sagan/goaider/cmd/translate"
 	_ "github.com/sagan/goaider/cmd/tts"
+	_ "github.com/sagan/goaider/cmd/ttsdataset/all"
 	_ "github.com/sagan/goaider/cmd/watch"
 )
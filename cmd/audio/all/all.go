@@ -0,0 +1,7 @@
+package all
+
+import (
+	_ "github.com/sagan/goaider/cmd/audio"
+	_ "github.com/sagan/goaider/cmd/audio/normalize"
+	_ "github.com/sagan/goaider/cmd/audio/slice"
+)
@@ -0,0 +1,16 @@
+package audio
+
+import (
+	"github.com/sagan/goaider/cmd"
+	"github.com/spf13/cobra"
+)
+
+var AudioCmd = &cobra.Command{
+	Use:   "audio",
+	Short: "Audio files processing (for TTS dataset) related actions",
+	Long:  `Audio files processing (for TTS dataset) related actions.`,
+}
+
+func init() {
+	cmd.RootCmd.AddCommand(AudioCmd)
+}
@@ -0,0 +1,269 @@
+package normalize
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/sagan/goaider/cmd/audio"
+	"github.com/sagan/goaider/constants"
+	"github.com/sagan/goaider/features/audiofeature"
+	"github.com/sagan/goaider/features/batchfeature"
+	"github.com/sagan/goaider/features/mediainfo"
+	"github.com/sagan/goaider/features/ttsfeature"
+	"github.com/sagan/goaider/util/helper"
+)
+
+const STATS_FILENAME = "normalize.csv"
+
+var normalizeCmd = &cobra.Command{
+	Use:   "normalize [dir]",
+	Args:  cobra.MaximumNArgs(1),
+	Short: "Normalize audio files to uniform sample rate, channels, loudness and format",
+	Long: `Normalize audio files to uniform sample rate, channels, loudness and format.
+
+It's used to prepare speech (TTS) datasets before "stt" / "tts-dataset genlist".
+Each audio file is converted to a 16-bit wav / flac file (--format) of --output dir, keeping the relative path of dir.
+
+Processing (in order):
+- --trim: trim leading and trailing silence (level below --trim-threshold dBFS).
+- --channels: mix to the channel count. 0 == keep.
+- --sample-rate: resample to the sample rate. 0 == keep.
+- --loudness: "ebu": EBU R128 loudness normalization to --lufs integrated loudness, with max --true-peak;
+  "peak": peak normalization to --peak dBFS; "none": keep.
+
+ffmpeg is required, it must be in PATH or set by ` + constants.ENV_FFMPEG + ` env.
+Except for the simple cases: 16-bit PCM wav / mp3 input, wav output, "peak" or "none" loudness mode
+and no resampling, which are processed natively.
+
+The before / after stats (duration, sample rate, channels, size) of each file are written to
+--stats CSV (default "<output>/normalize.csv"). Duration, sample rate and channels require ffprobe.
+The records of existing stats CSV are kept, so that a resumed run (skipping existing outputs) doesn't lose them.
+
+Examples:
+  goaider audio normalize ./clips -o ./clips-norm --sample-rate 32000 --channels 1 --trim
+  goaider audio normalize ./raw -r -o ./flac --format flac --loudness peak --peak -1`,
+	RunE: normalize,
+}
+
+var (
+	flagOutput        string
+	flagStats         string
+	flagFormat        string
+	flagSampleRate    int
+	flagChannels      int
+	flagLoudness      string
+	flagLufs          float64
+	flagTruePeak      float64
+	flagPeak          float64
+	flagTrim          bool
+	flagTrimThreshold float64
+	flagForce         bool
+	flagListFile      string
+	flagCsvColumn     string
+	flagFailures      string
+	flagConcurrency   int
+	flagRecursive     bool
+	flagMaxDepth      int
+	flagIncludes      []string
+	flagExcludes      []string
+)
+
+func init() {
+	normalizeCmd.Flags().StringVarP(&flagOutput, "output", "o", "",
+		`Output dir. Default is "<dir>-normalized"`)
+	normalizeCmd.Flags().StringVarP(&flagStats, "stats", "", "",
+		`Output stats CSV file. Default is "`+STATS_FILENAME+`" in output dir. "-" == stdout`)
+	normalizeCmd.Flags().StringVarP(&flagFormat, "format", "f", audiofeature.FORMAT_WAV,
+		`Output format: "wav" or "flac"`)
+	normalizeCmd.Flags().IntVarP(&flagSampleRate, "sample-rate", "", 0, "Target sample rate (Hz). 0 == keep")
+	normalizeCmd.Flags().IntVarP(&flagChannels, "channels", "", 0, "Target channel count. 0 == keep")
+	normalizeCmd.Flags().StringVarP(&flagLoudness, "loudness", "", audiofeature.LOUDNESS_EBU,
+		`Loudness normalization: "ebu" (EBU R128), "peak" or "none"`)
+	normalizeCmd.Flags().Float64VarP(&flagLufs, "lufs", "", -23, "EBU R128 target integrated loudness (LUFS)")
+	normalizeCmd.Flags().Float64VarP(&flagTruePeak, "true-peak", "", -1, "EBU R128 max true peak (dBTP)")
+	normalizeCmd.Flags().Float64VarP(&flagPeak, "peak", "", -1, "Peak normalization target level (dBFS)")
+	normalizeCmd.Flags().BoolVarP(&flagTrim, "trim", "", false, "Trim leading and trailing silence")
+	normalizeCmd.Flags().Float64VarP(&flagTrimThreshold, "trim-threshold", "", -50,
+		"Level (dBFS) below which audio is considered silence when trimming")
+	normalizeCmd.Flags().BoolVarP(&flagForce, "force", "", false, "Overwrite existing output files")
+	normalizeCmd.Flags().StringVarP(&flagListFile, "list", "l", "", constants.HELP_BATCH_LIST_FLAG)
+	normalizeCmd.Flags().StringVarP(&flagCsvColumn, "csv-column", "c", "", constants.HELP_BATCH_CSV_COLUMN_FLAG)
+	normalizeCmd.Flags().BoolVarP(&flagRecursive, "recursive", "r", false, constants.HELP_BATCH_RECURSIVE_FLAG)
+	normalizeCmd.Flags().IntVarP(&flagMaxDepth, "max-depth", "", 0, constants.HELP_BATCH_MAX_DEPTH_FLAG)
+	normalizeCmd.Flags().StringArrayVarP(&flagIncludes, "include", "", nil, constants.HELP_BATCH_INCLUDE_FLAG)
+	normalizeCmd.Flags().StringArrayVarP(&flagExcludes, "exclude", "", nil, constants.HELP_BATCH_EXCLUDE_FLAG)
+	normalizeCmd.Flags().StringVarP(&flagFailures, "failures", "", "", constants.HELP_BATCH_FAILURES_FLAG)
+	normalizeCmd.Flags().IntVarP(&flagConcurrency, "concurrency", "", runtime.NumCPU(),
+		"Number of files to process concurrently")
+	audio.AudioCmd.AddCommand(normalizeCmd)
+}
+
+// Media stats of a file. Empty if not available.
+type stats struct {
+	duration   string
+	sampleRate string
+	channels   string
+	size       string
+}
+
+func normalize(cmd *cobra.Command, args []string) error {
+	if flagFormat != audiofeature.FORMAT_WAV && flagFormat != audiofeature.FORMAT_FLAC {
+		return fmt.Errorf("invalid format %q", flagFormat)
+	}
+	if !slices.Contains([]string{audiofeature.LOUDNESS_EBU, audiofeature.LOUDNESS_PEAK, audiofeature.LOUDNESS_NONE},
+		flagLoudness) {
+		return fmt.Errorf("invalid loudness mode %q", flagLoudness)
+	}
+	if flagSampleRate < 0 || flagChannels < 0 {
+		return fmt.Errorf("invalid sample rate or channels")
+	}
+	options := &batchfeature.InputOptions{
+		Recursive:  flagRecursive,
+		MaxDepth:   flagMaxDepth,
+		Includes:   flagIncludes,
+		Excludes:   flagExcludes,
+		ListFile:   flagListFile,
+		CsvColumn:  flagCsvColumn,
+		MimePrefix: "audio/",
+	}
+	if len(args) > 0 {
+		options.Dir = args[0]
+	} else if flagListFile == "" {
+		return fmt.Errorf("dir arg or --list flag is required")
+	}
+	if flagOutput == "" {
+		if options.Dir == "" {
+			return fmt.Errorf("--output flag is required when dir arg is not set")
+		}
+		flagOutput = filepath.Clean(options.Dir) + "-normalized"
+	}
+	statsFile := flagStats
+	if statsFile == "" {
+		statsFile = filepath.Join(flagOutput, STATS_FILENAME)
+	}
+	files, err := batchfeature.SelectInputs(options, cmd.InOrStdin())
+	if err != nil {
+		return err
+	}
+
+	ttsfeature.Init()
+	mediainfo.Init()
+	if mediainfo.Ffprobe == "" {
+		log.Warnf("ffprobe not found, only file size stats are recorded")
+	}
+	normalizeOptions := &audiofeature.NormalizeOptions{
+		SampleRate:    flagSampleRate,
+		Channels:      flagChannels,
+		Loudness:      flagLoudness,
+		Lufs:          flagLufs,
+		TruePeak:      flagTruePeak,
+		Peak:          flagPeak,
+		Trim:          flagTrim,
+		TrimThreshold: flagTrimThreshold,
+		Format:        flagFormat,
+	}
+	if ttsfeature.Ffmpeg == "" && slices.ContainsFunc(files, func(file string) bool {
+		return !audiofeature.CanNormalizeNative(file, normalizeOptions)
+	}) {
+		return fmt.Errorf("ffmpeg not found. It's required by the format / loudness options or input files")
+	}
+
+	mu := &sync.Mutex{}
+	var rows [][]string
+	summary := batchfeature.Run(files, flagConcurrency, os.Stdout, func(file string) (bool, error) {
+		output := getOutputPath(options.Dir, file)
+		if !flagForce {
+			if _, err := os.Stat(output); err == nil {
+				return true, nil
+			}
+		}
+		if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+			return false, err
+		}
+		before := getStats(file)
+		if err := audiofeature.Normalize(file, output, normalizeOptions); err != nil {
+			return false, err
+		}
+		after := getStats(output)
+		mu.Lock()
+		rows = append(rows, []string{file, output, before.duration, after.duration, before.sampleRate,
+			after.sampleRate, before.channels, after.channels, before.size, after.size})
+		mu.Unlock()
+		return false, nil
+	})
+	log.Printf("Processing complete.")
+	summary.Print(os.Stdout)
+	if flagFailures != "" {
+		if err := summary.WriteFailures(flagFailures); err != nil {
+			return fmt.Errorf("failed to write failures file: %w", err)
+		}
+	}
+
+	if len(rows) > 0 {
+		sort.Slice(rows, func(i, j int) bool {
+			return rows[i][0] < rows[j][0]
+		})
+		header := []string{"file", "output", "duration_before", "duration_after", "sample_rate_before",
+			"sample_rate_after", "channels_before", "channels_after", "size_before", "size_after"}
+		if statsFile == "-" {
+			writer := csv.NewWriter(cmd.OutOrStdout())
+			writer.Write(header)
+			writer.WriteAll(rows)
+			err = writer.Error()
+		} else {
+			err = helper.WriteMergedCsv(statsFile, header, rows, "output")
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write stats: %w", err)
+		}
+	}
+	if summary.Failed > 0 {
+		return fmt.Errorf("%d errors", summary.Failed)
+	}
+	return nil
+}
+
+// Return the output file path of input file: "<output>/<relative path to dir>" with new ext.
+// If dir is empty (files of list file), use the file name only.
+func getOutputPath(dir string, file string) string {
+	relpath := filepath.Base(file)
+	if dir != "" {
+		if rel, err := filepath.Rel(dir, file); err == nil && !strings.HasPrefix(rel, "..") {
+			relpath = rel
+		}
+	}
+	return filepath.Join(flagOutput, strings.TrimSuffix(relpath, filepath.Ext(relpath))+"."+flagFormat)
+}
+
+func getStats(file string) (s stats) {
+	f, err := os.Open(file)
+	if err != nil {
+		return s
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil {
+		s.size = strconv.FormatInt(info.Size(), 10)
+	}
+	if mediainfo.Ffprobe == "" {
+		return s
+	}
+	if info, err := mediainfo.ParseVideoAudioMediaInfo(f); err == nil {
+		s.duration = info.Duration
+		s.sampleRate = strconv.Itoa(info.SampleRate)
+		s.channels = strconv.Itoa(info.Channels)
+	} else {
+		log.Debugf("failed to parse media info of %q: %v", file, err)
+	}
+	return s
+}
@@ -0,0 +1,200 @@
+package slice
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/natefinch/atomic"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/sagan/goaider/cmd/audio"
+	"github.com/sagan/goaider/constants"
+	"github.com/sagan/goaider/features/audiofeature"
+	"github.com/sagan/goaider/features/ttsfeature"
+	"github.com/sagan/goaider/util"
+)
+
+const MANIFEST_FILENAME = "slices.csv"
+
+var sliceCmd = &cobra.Command{
+	Use:   "slice {file}...",
+	Short: "Slice long audio files into utterance-sized clips by silence detection",
+	Long: `Slice long audio files into utterance-sized clips by silence detection.
+
+It's used to build TTS training datasets (e.g. GPT-SoVITS) from long recordings.
+The audio is cut at silence (RMS level below --threshold dBFS for at least --min-silence seconds).
+Adjacent short parts are merged into clips of --min-length to --max-length seconds,
+parts longer than --max-length are cut at their quietest point, clips still shorter than --min-length are dropped.
+Each clip keeps at most --padding seconds silence before and after it.
+
+Clips are written to --output dir as 16-bit PCM wav files "<name>_0001.wav", "<name>_0002.wav"...,
+where <name> is the source filename without ext. If multiple sources have the same name
+(e.g. "a/rec.wav" and "b/rec.wav"), the 1-based index of source in args is appended to it: "rec-1", "rec-2".
+A manifest CSV (default "<output>/slices.csv") is also written, with columns:
+  file (clip filename, relative to output dir),source,start,end,duration (seconds)
+
+wav (16-bit PCM) and mp3 files are decoded natively. Other formats (including 24-bit, 32-bit or float wav)
+require ffmpeg,
+which must be in PATH or set by ` + constants.ENV_FFMPEG + ` env.
+
+Then generate the transcripts of clips and the GPT-SoVITS list file:
+  goaider stt ./clips
+  goaider sovits-genlist ./clips
+
+Examples:
+  goaider audio slice recording.wav -o ./clips
+  goaider audio slice *.mp3 -o ./clips --threshold -35 --min-length 3 --max-length 10`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: doSlice,
+}
+
+var (
+	flagOutput     string
+	flagManifest   string
+	flagThreshold  float64
+	flagMinSilence float64
+	flagMinLength  float64
+	flagMaxLength  float64
+	flagPadding    float64
+	flagForce      bool
+)
+
+func init() {
+	sliceCmd.Flags().StringVarP(&flagOutput, "output", "o", "sliced", "Output dir of clips")
+	sliceCmd.Flags().StringVarP(&flagManifest, "manifest", "", "",
+		`Output manifest CSV file. Default is "`+MANIFEST_FILENAME+`" in output dir. "-" == stdout`)
+	sliceCmd.Flags().Float64VarP(&flagThreshold, "threshold", "", -40,
+		"Silence threshold: RMS level (dBFS) below it is considered silence")
+	sliceCmd.Flags().Float64VarP(&flagMinSilence, "min-silence", "", 0.3, "Min silence length (seconds) to cut at")
+	sliceCmd.Flags().Float64VarP(&flagMinLength, "min-length", "", 2, "Min clip length (seconds)")
+	sliceCmd.Flags().Float64VarP(&flagMaxLength, "max-length", "", 12, "Max clip length (seconds). 0 == unlimited")
+	sliceCmd.Flags().Float64VarP(&flagPadding, "padding", "", 0.2,
+		"Max silence (seconds) to keep before and after each clip")
+	sliceCmd.Flags().BoolVarP(&flagForce, "force", "", false, "Overwrite existing clip and manifest files")
+	audio.AudioCmd.AddCommand(sliceCmd)
+}
+
+func doSlice(cmd *cobra.Command, args []string) (err error) {
+	if flagMinSilence < 0 || flagMinLength < 0 || flagMaxLength < 0 || flagPadding < 0 {
+		return fmt.Errorf("invalid negative length flag")
+	}
+	if flagMaxLength > 0 && flagMinLength > flagMaxLength {
+		return fmt.Errorf("--min-length must not be larger than --max-length")
+	}
+	manifest := util.FirstNonZeroArg(flagManifest, filepath.Join(flagOutput, MANIFEST_FILENAME))
+	if manifest != "-" && !flagForce {
+		if exists, err := util.FileExists(manifest); err != nil || exists {
+			return fmt.Errorf("manifest file %q exists or can't access, err=%w", manifest, err)
+		}
+	}
+	if err := os.MkdirAll(flagOutput, 0755); err != nil {
+		return err
+	}
+	ttsfeature.Init()
+	options := &audiofeature.SliceOptions{
+		Threshold:  flagThreshold,
+		MinSilence: flagMinSilence,
+		MinLength:  flagMinLength,
+		MaxLength:  flagMaxLength,
+		Padding:    flagPadding,
+	}
+
+	rows := [][]string{{"file", "source", "start", "end", "duration"}}
+	names := clipNames(args)
+	for i, source := range args {
+		clipRows, err := sliceFile(source, names[i], options)
+		if err != nil {
+			return fmt.Errorf("failed to slice %q: %w", source, err)
+		}
+		rows = append(rows, clipRows...)
+	}
+
+	var output strings.Builder
+	writer := csv.NewWriter(&output)
+	writer.WriteAll(rows)
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	if manifest == "-" {
+		_, err = cmd.OutOrStdout().Write([]byte(output.String()))
+	} else {
+		err = atomic.WriteFile(manifest, strings.NewReader(output.String()))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	log.Printf("Wrote %d clips to %q", len(rows)-1, flagOutput)
+	return nil
+}
+
+// Return the clip filename prefixes of sources: filename without ext,
+// with source index appended if it's shared by multiple sources, so that clips of them don't collide.
+func clipNames(sources []string) []string {
+	names := make([]string, len(sources))
+	counts := map[string]int{}
+	for i, source := range sources {
+		names[i] = strings.TrimSuffix(filepath.Base(source), filepath.Ext(source))
+		counts[strings.ToLower(names[i])]++
+	}
+	used := map[string]bool{}
+	for i, name := range names {
+		if counts[strings.ToLower(name)] > 1 {
+			name = fmt.Sprintf("%s-%d", name, i+1)
+			for k := 2; used[strings.ToLower(name)] || counts[strings.ToLower(name)] > 0; k++ {
+				name = fmt.Sprintf("%s-%d-%d", names[i], i+1, k)
+			}
+			names[i] = name
+		}
+		used[strings.ToLower(names[i])] = true
+	}
+	return names
+}
+
+// Slice a source audio file, return the manifest rows of it's clips.
+// Clips are named "<name>_0001.wav"...
+func sliceFile(source string, name string, options *audiofeature.SliceOptions) (rows [][]string, err error) {
+	input, err := audiofeature.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	clips, total, err := audiofeature.DetectClips(input, options)
+	input.Close()
+	if err != nil {
+		return nil, err
+	}
+	filenames := make([]string, len(clips))
+	for i := range clips {
+		filenames[i] = filepath.Join(flagOutput, fmt.Sprintf("%s_%04d.wav", name, i+1))
+		if !flagForce {
+			if exists, err := util.FileExists(filenames[i]); err != nil || exists {
+				return nil, fmt.Errorf("clip file %q exists or can't access, err=%w", filenames[i], err)
+			}
+		}
+	}
+
+	// decode it again to write clips, so that the whole audio doesn't need to be kept in memory
+	input, err = audiofeature.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+	if err := audiofeature.WriteClips(input, clips, filenames); err != nil {
+		return nil, err
+	}
+	sampleRate := float64(input.SampleRate)
+	for i, clip := range clips {
+		rows = append(rows, []string{
+			filepath.Base(filenames[i]),
+			source,
+			fmt.Sprintf("%.3f", float64(clip.Start)/sampleRate),
+			fmt.Sprintf("%.3f", float64(clip.End)/sampleRate),
+			fmt.Sprintf("%.3f", float64(clip.End-clip.Start)/sampleRate),
+		})
+	}
+	log.Printf("%s: %.1fs audio, %d clips", source, float64(total)/sampleRate, len(clips))
+	return rows, nil
+}
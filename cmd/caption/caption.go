@@ -5,15 +5,16 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
-	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/sagan/goaider/cmd"
 	"github.com/sagan/goaider/config"
 	"github.com/sagan/goaider/constants"
+	"github.com/sagan/goaider/features/batchfeature"
 	"github.com/sagan/goaider/features/llm"
 	"github.com/sagan/goaider/util"
+	"github.com/sagan/goaider/util/helper"
 )
 
 const (
@@ -34,8 +35,6 @@ Good example: "pink puffer jacket, ponytail, hair clips, crouching, holding toy"
 
 Bad example: "young girl, pink puffer jacket, fur collar, black pants, slippers, pink bunny hair clips, ponytail, pink bobbles, crouching, holding a pink plastic toy, child's room, pink desk, pink chair, toys, curtains, wooden floor".
 `
-
-	maxRetries = 3
 )
 
 var (
@@ -44,69 +43,153 @@ var (
 	flagIdentity    string
 	flagModel       string
 	flagModelKey    string
+	flagListFile    string
+	flagCsvColumn   string
+	flagFailures    string
+	flagConcurrency int
+	flagRecursive   bool
+	flagMaxDepth    int
+	flagIncludes    []string
+	flagExcludes    []string
+	flagPrompt      string
+	flagMode        string
+	flagIdentityPos string
+	flagMaxLength   int
+	flagMaxTokens   int
+	flagOutputExt   string
+)
+
+var (
+	promptTemplate *helper.Template // nil: use default prompt of mode
+	identityIndex  int
 )
 
 var captionCmd = &cobra.Command{
-	Use:   "caption {dir}",
+	Use:   "caption [dir]",
 	Short: "Generate captions for images in a directory",
 	Long: `This command generates captions for all images in a specified directory using the Gemini API.
 
 It requires GEMINI_API_KEY env.
 
-It saves the caption of each image file to <filename>.txt file of the same dir.`,
-	Args: cobra.ExactArgs(1),
+It saves the caption of each image file to <filename>.txt file of the same dir.
+Use --output-ext to change the caption file ext (e.g. ".caption"), as required by the trainer.
+
+Use "--mode tags" to generate booru-style tags (e.g. "1girl, solo, long hair") instead of natural caption.
+Use --prompt to set a custom prompt. It's a Go text template, rendered with the following data of each image:
+  name, stem (name without ext), ext, dir, path, mime, width, height, identity,
+  caption (contents of the existing caption file; empty if not exists)
+E.g. "Refine this caption of a {{.width}}x{{.height}} image: {{.caption}}". Use "@prompt.txt" to read it from file.
+
+The --identity trigger word is inserted at --identity-position: "start", "end" or a segment index
+(e.g. 1: after the first comma-separated segment / tag).
+Use --max-length (chars) / --max-tokens (approximate CLIP tokens) to truncate the caption,
+trailing segments are dropped but the trigger word is always kept.
+The caption is only split into segments (and rejoined with ", ") when --identity, --max-length or --max-tokens
+is set, otherwise the generated caption is saved as is.
+
+Images are processed by --concurrency workers concurrently; the rate limit of config file is shared by all workers.
+Use "--failures failures.txt" to write failed images to a file, and "--list failures.txt" to retry only them.
+
+Use --recursive (or --max-depth) to process images of sub dirs, and --include / --exclude globs to filter them.
+Use --list (and --csv-column) to process images of a list file or a CSV file column instead.
+
+Examples:
+  goaider caption ./images --concurrency 4 --failures failures.txt
+  goaider caption ./images --mode tags --identity foobar --identity-position 1 --max-tokens 75
+  goaider caption --list failures.txt
+  goaider caption ./dataset -r --include "**/*.png" --exclude "rejected/**"
+  goaider csv query "select filename from index where score > 5" | goaider caption ./dataset --list - -c filename`,
+	Args: cobra.MaximumNArgs(1),
 	RunE: caption,
 }
 
 func init() {
 	cmd.RootCmd.AddCommand(captionCmd)
 	captionCmd.Flags().BoolVarP(&flagForce, "force", "", false,
-		"Optional: Force re-generation of all captions, even if .txt files exist")
+		"Optional: Force re-generation of all captions, even if caption files exist")
 	captionCmd.Flags().Float64VarP(&flagTemperature, "temperature", "T", 0.4, constants.HELP_TEMPERATURE_FLAG)
 	captionCmd.Flags().StringVarP(&flagIdentity, "identity", "", "",
-		"Optional: The trigger word (e.g., 'foobar' or 'photo of foobar') to prepend to each caption")
+		"Optional: The trigger word (e.g., 'foobar' or 'photo of foobar') to insert into each caption "+
+			"at --identity-position (default: start)")
 	captionCmd.Flags().StringVarP(&flagModel, "model", "", "", "The model to use. "+constants.HELP_MODEL)
 	captionCmd.Flags().StringVarP(&flagModelKey, "model-key", "", "", constants.HELP_MODEL_KEY)
+	captionCmd.Flags().StringVarP(&flagListFile, "list", "l", "", constants.HELP_BATCH_LIST_FLAG)
+	captionCmd.Flags().StringVarP(&flagCsvColumn, "csv-column", "c", "", constants.HELP_BATCH_CSV_COLUMN_FLAG)
+	captionCmd.Flags().BoolVarP(&flagRecursive, "recursive", "r", false, constants.HELP_BATCH_RECURSIVE_FLAG)
+	captionCmd.Flags().IntVarP(&flagMaxDepth, "max-depth", "", 0, constants.HELP_BATCH_MAX_DEPTH_FLAG)
+	captionCmd.Flags().StringArrayVarP(&flagIncludes, "include", "", nil, constants.HELP_BATCH_INCLUDE_FLAG)
+	captionCmd.Flags().StringArrayVarP(&flagExcludes, "exclude", "", nil, constants.HELP_BATCH_EXCLUDE_FLAG)
+	captionCmd.Flags().StringVarP(&flagFailures, "failures", "", "", constants.HELP_BATCH_FAILURES_FLAG)
+	captionCmd.Flags().IntVarP(&flagConcurrency, "concurrency", "", 1, constants.HELP_CONCURRENCY_FLAG)
+	captionCmd.Flags().StringVarP(&flagPrompt, "prompt", "p", "",
+		"Custom prompt template. Default is a builtin prompt of --mode. "+constants.HELP_TEMPLATE_FLAG)
+	captionCmd.Flags().StringVarP(&flagMode, "mode", "", MODE_CAPTION,
+		`Output mode: "caption" (natural comma-separated caption) or "tags" (booru-style tags)`)
+	captionCmd.Flags().StringVarP(&flagIdentityPos, "identity-position", "", POSITION_START,
+		`Position to insert --identity trigger word: "start", "end" or a segment index`)
+	captionCmd.Flags().IntVarP(&flagMaxLength, "max-length", "", 0, "Max caption length (chars). 0 == unlimited")
+	captionCmd.Flags().IntVarP(&flagMaxTokens, "max-tokens", "", 0,
+		"Max caption length (approximate CLIP tokens). 0 == unlimited")
+	captionCmd.Flags().StringVarP(&flagOutputExt, "output-ext", "", ".txt", `Caption file ext, e.g. ".caption"`)
 }
 
-func caption(cmd *cobra.Command, args []string) error {
-	if flagModel == "" {
-		flagModel = config.GetDefaultModel()
+func caption(cmd *cobra.Command, args []string) (err error) {
+	if flagModel, err = config.GetCommandModel(cmd, flagModel, &flagTemperature); err != nil {
+		return err
 	}
-	argDir := args[0]
-
-	files, err := os.ReadDir(argDir)
+	if flagMode != MODE_CAPTION && flagMode != MODE_TAGS {
+		return fmt.Errorf("invalid mode %q", flagMode)
+	}
+	if !strings.HasPrefix(flagOutputExt, ".") {
+		return fmt.Errorf("invalid output ext %q: must start with '.'", flagOutputExt)
+	}
+	if identityIndex, err = parseIdentityPosition(flagIdentityPos); err != nil {
+		return err
+	}
+	if flagPrompt != "" {
+		if promptTemplate, err = helper.GetTemplate(flagPrompt, false); err != nil {
+			return fmt.Errorf("invalid prompt template: %w", err)
+		}
+	}
+	options := &batchfeature.InputOptions{
+		Recursive:  flagRecursive,
+		MaxDepth:   flagMaxDepth,
+		Includes:   flagIncludes,
+		Excludes:   flagExcludes,
+		ListFile:   flagListFile,
+		CsvColumn:  flagCsvColumn,
+		MimePrefix: "image/",
+	}
+	if len(args) > 0 {
+		options.Dir = args[0]
+	} else if flagListFile == "" {
+		return fmt.Errorf("dir arg or --list flag is required")
+	}
+	images, err := batchfeature.SelectInputs(options, cmd.InOrStdin())
 	if err != nil {
-		return fmt.Errorf("failed to read directory %s: %w", argDir, err)
+		return err
 	}
+	fmt.Printf("Starting captioning for %d images\n", len(images))
 
-	fmt.Printf("Starting captioning for images in: %s\n", argDir)
 	if flagForce {
 		fmt.Printf("FORCE flag set: Re-generating all captions.\n")
 	}
 	if flagIdentity != "" {
-		fmt.Printf("IDENTITY set: Prepending %q to all new captions.\n", flagIdentity)
+		fmt.Printf("IDENTITY set: Inserting %q to all new captions at %s.\n", flagIdentity, flagIdentityPos)
 	}
 
-	errorCnt := 0
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
-		mimeType := util.GetMimeType(file.Name())
-		if !strings.HasPrefix(mimeType, "image/") {
-			continue
-		}
-		fullPath := filepath.Join(argDir, file.Name())
-		err := processImage(fullPath, mimeType, flagTemperature, flagModelKey, flagForce, flagIdentity)
-		if err != nil {
-			fmt.Printf("Processing %s: ❌ FAILED (%v)\n", file.Name(), err)
-			errorCnt++
+	summary := batchfeature.Run(images, flagConcurrency, os.Stdout, func(imagePath string) (bool, error) {
+		return processImage(imagePath, flagTemperature, flagModelKey, flagForce, flagIdentity)
+	})
+	fmt.Printf("Captioning complete.\n")
+	summary.Print(os.Stdout)
+	if flagFailures != "" {
+		if err := summary.WriteFailures(flagFailures); err != nil {
+			return fmt.Errorf("failed to write failures file: %w", err)
 		}
 	}
-	fmt.Printf("Captioning complete.\n")
-	if errorCnt > 0 {
-		return fmt.Errorf("%d errors", errorCnt)
+	if summary.Failed > 0 {
+		return fmt.Errorf("%d errors", summary.Failed)
 	}
 	return nil
 }
@@ -114,61 +197,73 @@ func caption(cmd *cobra.Command, args []string) error {
 /**
  * processImage handles the full logic for a single image:
  * 1. Checks if caption file exists (and skips if -force is not set)
- * 2. Reads the image file
- * 3. Calls the LLM API (with retries)
- * 4. Prepends identity (if provided)
- * 5. Saves the caption to a .txt file
+ * 2. Reads the image file and renders the prompt
+ * 3. Calls the LLM API (temporary errors are retried by llm package)
+ * 4. Inserts identity (if provided) and truncates the caption
+ * 5. Saves the caption to a <filename><output-ext> file
  */
-func processImage(imagePath string, mimeType string, temperature float64,
-	apiKey string, force bool, identity string) (err error) {
-	// 1. Check for existing .txt file before doing any work
-	baseName := filepath.Base(imagePath)
-	ext := filepath.Ext(baseName)
-	txtFileName := strings.TrimSuffix(baseName, ext) + ".txt"
-	txtPath := filepath.Join(filepath.Dir(imagePath), txtFileName)
+func processImage(imagePath string, temperature float64,
+	apiKey string, force bool, identity string) (skipped bool, err error) {
+	// 1. Check for existing caption file before doing any work
+	captionPath := strings.TrimSuffix(imagePath, filepath.Ext(imagePath)) + flagOutputExt
 
 	if !force {
-		if _, err := os.Stat(txtPath); err == nil {
+		if _, err := os.Stat(captionPath); err == nil {
 			// File exists, skip processing
-			fmt.Printf("Processing %s: ⏩ SKIPPED (caption already exists)\n", baseName)
-			return nil
+			return true, nil
 		}
 	}
 
-	fmt.Printf("Processing %s: ⏳ GENERATING...\n", baseName)
 	imageData, err := os.ReadFile(imagePath)
 	if err != nil {
-		return fmt.Errorf("failed to read image: %w", err)
+		return false, fmt.Errorf("failed to read image: %w", err)
+	}
+	mimeType := util.GetMimeType(imagePath)
+	prompt := captionPrompt
+	if flagMode == MODE_TAGS {
+		prompt = tagsPrompt
+	}
+	if promptTemplate != nil {
+		prompt, err = promptTemplate.Exec(getTemplateData(imagePath, imageData, mimeType, captionPath))
+		if err != nil {
+			return false, fmt.Errorf("failed to render prompt: %w", err)
+		}
 	}
 
-	var caption string
-	for retries := range maxRetries {
-		caption, err = llm.ImageToText(apiKey, flagModel, captionPrompt, imageData, mimeType, temperature)
-		if err == nil {
-			break
+	var segments []string
+	if flagMode == MODE_TAGS {
+		resp, err := llm.ImageToJson[tagsResponse](apiKey, flagModel, prompt, imageData, mimeType, temperature)
+		if err != nil {
+			return false, err
 		}
-		if util.IsTemporaryError(err) {
-			wait := util.CalculateBackoff(llm.GeminiApiBaseBackoff, llm.GeminiApiMaxBackoff, retries)
-			fmt.Printf("  .. error (%v), retrying in %v\n", err, wait)
-			time.Sleep(wait)
-			continue
-		} else {
-			break
+		segments = normalizeTags(resp.Tags)
+	} else {
+		caption, err := llm.ImageToText(apiKey, flagModel, prompt, imageData, mimeType, temperature)
+		if err != nil {
+			return false, err
+		}
+		caption = strings.TrimSpace(caption)
+		if identity == "" && flagMaxLength == 0 && flagMaxTokens == 0 {
+			// Nothing to insert or truncate, keep the caption as is.
+			if caption == "" {
+				return false, fmt.Errorf("empty caption")
+			}
+			return false, writeCaption(captionPath, caption)
 		}
+		segments = splitCaption(caption)
 	}
-	if err != nil {
-		return err
+	if len(segments) == 0 {
+		return false, fmt.Errorf("empty caption")
 	}
 
-	finalCaption := caption
-	if identity != "" {
-		finalCaption = identity + ", " + finalCaption
-	}
-	err = os.WriteFile(txtPath, []byte(finalCaption), 0644)
-	if err != nil {
+	segments = injectIdentity(segments, identity, identityIndex)
+	segments = truncateSegments(segments, ", ", flagMaxLength, flagMaxTokens, identity)
+	return false, writeCaption(captionPath, strings.Join(segments, ", "))
+}
+
+func writeCaption(captionPath string, caption string) error {
+	if err := os.WriteFile(captionPath, []byte(caption), 0644); err != nil {
 		return fmt.Errorf("failed to write caption file: %w", err)
 	}
-
-	fmt.Printf("Processing %s: ✅ SUCCESS\n", baseName)
 	return nil
 }
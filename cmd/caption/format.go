@@ -0,0 +1,141 @@
+package caption
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+
+	"github.com/sagan/goaider/util/stringutil"
+)
+
+const (
+	MODE_CAPTION = "caption" // natural comma-separated caption
+	MODE_TAGS    = "tags"    // booru-style tags
+
+	POSITION_START = "start"
+	POSITION_END   = "end"
+)
+
+// The default prompt of tags mode
+const tagsPrompt = `Generate Danbooru-style tags for this image, optimized for LoRa training.
+
+RULES:
+1.  Use lowercase English tags, words separated by spaces (e.g., "long hair", "looking at viewer", "1girl", "solo").
+2.  Tag the main subject first, then clothing, pose, expression, objects, then background and composition.
+3.  Output each tag only once. Do not output ratings or artist names.
+`
+
+type tagsResponse struct {
+	Tags []string `json:"tags" jsonschema:"description=The Danbooru-style tags of the image."`
+}
+
+// Return the prompt template data of an image:
+// name, stem (name without ext), ext, dir, path, mime, width, height, identity,
+// and caption (the contents of existing caption file, empty if not exists).
+func getTemplateData(imagePath string, imageData []byte, mimeType string, captionPath string) map[string]any {
+	name := filepath.Base(imagePath)
+	ext := filepath.Ext(name)
+	data := map[string]any{
+		"name":     name,
+		"stem":     strings.TrimSuffix(name, ext),
+		"ext":      ext,
+		"dir":      filepath.Dir(imagePath),
+		"path":     imagePath,
+		"mime":     mimeType,
+		"width":    0,
+		"height":   0,
+		"identity": flagIdentity,
+		"caption":  "",
+	}
+	if config, _, err := image.DecodeConfig(bytes.NewReader(imageData)); err == nil {
+		data["width"] = config.Width
+		data["height"] = config.Height
+	}
+	if contents, err := os.ReadFile(captionPath); err == nil {
+		data["caption"] = strings.TrimSpace(string(contents))
+	}
+	return data
+}
+
+// Split a caption to comma-separated segments. Empty segments are removed.
+func splitCaption(caption string) (segments []string) {
+	for _, segment := range strings.FieldsFunc(caption, func(r rune) bool {
+		return r == ',' || r == '\n'
+	}) {
+		if segment = strings.TrimSpace(segment); segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+// Normalize tags: lowercase, "_" => " ", collapse spaces, remove empty and duplicate ones.
+func normalizeTags(tags []string) (normalized []string) {
+	for _, tag := range tags {
+		tag = strings.Join(strings.Fields(strings.ReplaceAll(strings.ToLower(tag), "_", " ")), " ")
+		if tag != "" && !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized
+}
+
+// Validate identity position: "start", "end", or a non-negative index.
+func parseIdentityPosition(position string) (index int, err error) {
+	switch position {
+	case POSITION_START:
+		return 0, nil
+	case POSITION_END:
+		return -1, nil
+	}
+	index, err = strconv.Atoi(position)
+	if err != nil || index < 0 {
+		return 0, fmt.Errorf(`invalid identity position %q: must be "start", "end" or a non-negative index`, position)
+	}
+	return index, nil
+}
+
+// Insert identity into segments at index. -1 (or out of range) index means end.
+// The identity is not duplicated if segments already has it.
+func injectIdentity(segments []string, identity string, index int) []string {
+	if identity == "" {
+		return segments
+	}
+	segments = slices.DeleteFunc(slices.Clone(segments), func(segment string) bool {
+		return strings.EqualFold(segment, identity)
+	})
+	if index < 0 || index > len(segments) {
+		index = len(segments)
+	}
+	return slices.Insert(segments, index, identity)
+}
+
+// Drop trailing segments until the joined caption fits maxLength (chars) and maxTokens. 0 means unlimited.
+// The reserved segment (e.g. identity) is never dropped. At least one segment is kept.
+func truncateSegments(segments []string, sep string, maxLength int, maxTokens int, reserved string) []string {
+	fits := func(segments []string) bool {
+		caption := strings.Join(segments, sep)
+		return (maxLength <= 0 || len([]rune(caption)) <= maxLength) &&
+			(maxTokens <= 0 || stringutil.CountTokens(caption) <= maxTokens)
+	}
+	for len(segments) > 1 && !fits(segments) {
+		i := len(segments) - 1
+		if segments[i] == reserved && reserved != "" {
+			i--
+		}
+		segments = slices.Delete(slices.Clone(segments), i, i+1)
+	}
+	return segments
+}
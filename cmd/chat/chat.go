@@ -51,10 +51,31 @@ It supports Gemini / OpenAI / OpenRouter / Any OpenAI API compatible models.
 
 See help of "--model" flag for how to config the model.
 
+Local tools can be exposed to the model via function calling, using "--tool" flag:
+- read_file : Read a text file from the current dir.
+- run_command : Run a command line. Only commands set by "--allow-cmd" flag are allowed.
+- csv_query : Run a csvq SQL query on csv files of the current dir. See "goaider csv query".
+
+E.g.: goaider chat --tool csv_query "Which product sold the most in sales.csv?"
+
 Running "goaider chat" without providing any input will open a simple interactive shell,
 
 Some special directives are available in shell:
 - /clear , /c : Reset / Clear current LLM session
+- /save [name] : Save current session to name (default the current session name) and switch to it
+- /load <name> : Load a saved session and switch to it
+- /list : List saved sessions
+- /model [model] : Show or switch model
+- /system [prompt] : Set system prompt. If prompt is empty, remove it
+- /temperature [value] : Show or set temperature
+- /retry : Re-generate the last response
+
+Sessions are saved in "~/.config/goaider/sessions" dir as <name>.jsonl files.
+Use "--session <name>" flag to resume a saved session. In shell, the session is auto saved after each turn.
+Non-interactive calls with "--session <name>" flag append the request and response to the session,
+so scripts can hold a multi-turn conversation:
+  goaider chat --session foo "My name is foo"
+  goaider chat --session foo "What's my name?"
 `,
 	RunE: doChat,
 }
@@ -65,12 +86,16 @@ var (
 	flagForce           bool
 	flagAutoCopy        bool // auto copy LLM response text to clipboard
 	flagAutoCopyOnly    bool
+	flagMaxToolRounds   int
 	flagTemperature     float64
 	flagOutput          string // output file, "-" for stdout (default)
 	flagModel           string
 	flagModelKey        string
 	flagSchema          string   // response json schema file
+	flagSession         string   // session name
 	flagInputs          []string // input file
+	flagTools           []string // local tools exposed to LLM
+	flagAllowCmds       []string // allowed commands of run_command tool
 )
 
 func init() {
@@ -90,6 +115,14 @@ func init() {
 	chatCmd.Flags().StringVarP(&flagSchema, "schema", "", "",
 		`Response JSON schema file. If provided, the LLM will be instructed to return JSON `+
 			`that conforms to this schema. See https://json-schema.org/learn/miscellaneous-examples for examples`)
+	chatCmd.Flags().IntVarP(&flagMaxToolRounds, "max-tool-rounds", "", 10, "Max rounds of tool calls of one request")
+	chatCmd.Flags().StringSliceVarP(&flagTools, "tool", "", nil,
+		`Expose local tools to the model. Comma-separated list, can be set multiple times. `+
+			`Available tools: `+strings.Join(toolNames, ", "))
+	chatCmd.Flags().StringArrayVarP(&flagAllowCmds, "allow-cmd", "", nil,
+		`Allowed command (binary name, e.g. "ls") of run_command tool. Can be set multiple times`)
+	chatCmd.Flags().StringVarP(&flagSession, "session", "", "",
+		`Resume (and save to) a named chat session. If it does not exist, create a new one`)
 	chatCmd.Flags().StringArrayVarP(&flagInputs, "input", "i", nil,
 		`Usen file as input. Use "-" for stdin. Can provide multiple input. Non-text file are used as attachment`)
 	cmd.RootCmd.AddCommand(chatCmd)
@@ -102,8 +135,8 @@ func doChat(cmd *cobra.Command, args []string) (err error) {
 	if flagAutoCopy {
 		clipboard.Init()
 	}
-	if flagModel == "" {
-		flagModel = config.GetDefaultModel()
+	if flagModel, err = config.GetCommandModel(cmd, flagModel, &flagTemperature); err != nil {
+		return err
 	}
 	if !flagOutputPrompt {
 		cmd.Printf("Use %q model\n", flagModel)
@@ -117,6 +150,21 @@ func doChat(cmd *cobra.Command, args []string) (err error) {
 		Model:       flagModel,
 		Temperature: flagTemperature,
 	}
+	tools, err := getTools(flagTools, flagAllowCmds)
+	if err != nil {
+		return err
+	}
+	onToolCall := func(toolCall *llm.OpenAIToolCall) {
+		log.Printf("Call tool %s(%s)", toolCall.Function.Name, toolCall.Function.Arguments)
+	}
+	if flagSession != "" {
+		if openaiReq.Messages, err = loadSession(flagSession); err != nil {
+			return fmt.Errorf("failed to load session %q: %w", flagSession, err)
+		}
+		if !flagOutputPrompt {
+			cmd.Printf("Use %q session (%d messages)\n", flagSession, len(openaiReq.Messages))
+		}
+	}
 
 	if argInput == "" && len(flagInputs) == 0 {
 		if !term.IsTerminal(int(os.Stdout.Fd())) {
@@ -124,44 +172,16 @@ func doChat(cmd *cobra.Command, args []string) (err error) {
 		}
 		var lastSignalTime time.Time
 		fmt.Printf(constants.SHELL_TIP)
-		p := prompt.New(func(input string) {
-			input = strings.TrimSpace(input)
-			if input == "/clear" || input == "/c" {
-				openaiReq.Messages = nil
-				fmt.Printf("<session cleared>\n")
-				return
-			}
-			openaiReq.Messages = append(openaiReq.Messages, &llm.OpenAIMessage{
-				Role:    "user",
-				Content: input,
-			})
-			response := strings.Builder{}
-			err := llm.Stream(flagModelKey, flagModel, openaiReq, func(content string) error {
-				response.WriteString(content)
-				fmt.Printf("%s", content)
-				return nil
-			})
-			if err != nil {
-				fmt.Printf("<error: %v>\n", err)
-				openaiReq.Messages = openaiReq.Messages[0 : len(openaiReq.Messages)-1]
-				return
-			}
-			openaiReq.Messages = append(openaiReq.Messages, &llm.OpenAIMessage{
-				Role:    "assistant",
-				Content: response,
-			})
-			if flagAutoCopy {
-				clipboard.CopyString(response.String())
-			}
-			fmt.Printf("\n")
-		}, prompt.WithTitle("goaider-chat"), prompt.WithSignalChecker(func(signal os.Signal) bool {
-			now := time.Now()
-			if now.Sub(lastSignalTime) > constants.CTRL_C_FORCE_EXIT_INTERVAL {
-				lastSignalTime = now
-				return true
-			}
-			return false
-		}))
+		shell := &chatShell{req: openaiReq, tools: tools, session: flagSession, onToolCall: onToolCall}
+		p := prompt.New(shell.execute, prompt.WithTitle("goaider-chat"),
+			prompt.WithSignalChecker(func(signal os.Signal) bool {
+				now := time.Now()
+				if now.Sub(lastSignalTime) > constants.CTRL_C_FORCE_EXIT_INTERVAL {
+					lastSignalTime = now
+					return true
+				}
+				return false
+			}))
 		// https://github.com/elk-language/go-prompt/issues/265
 		if runtime.GOOS != "windows" {
 			defer exec.Command("reset").Run()
@@ -176,7 +196,6 @@ func doChat(cmd *cobra.Command, args []string) (err error) {
 		}
 	}
 
-	openaiReq.Messages = nil
 	inputFiles := helper.ParseFilenameArgs(flagInputs...)
 	for _, inputFile := range inputFiles {
 		var input io.Reader
@@ -278,11 +297,12 @@ func doChat(cmd *cobra.Command, args []string) (err error) {
 	reader, writer := io.Pipe()
 	go func() {
 		response := strings.Builder{}
-		err := llm.Stream(flagModelKey, flagModel, openaiReq, func(content string) error {
-			response.WriteString(content)
-			writer.Write([]byte(content))
-			return nil
-		})
+		_, err := llm.StreamWithTools(flagModelKey, flagModel, openaiReq, tools, flagMaxToolRounds,
+			func(content string) error {
+				response.WriteString(content)
+				writer.Write([]byte(content))
+				return nil
+			}, onToolCall)
 		if err != nil {
 			writer.CloseWithError(err)
 			return
@@ -297,6 +317,12 @@ func doChat(cmd *cobra.Command, args []string) (err error) {
 		if flagAutoCopy {
 			clipboard.CopyString(responseStr)
 		}
+		if flagSession != "" {
+			if err := saveSession(flagSession, openaiReq.Messages); err != nil {
+				writer.CloseWithError(fmt.Errorf("failed to save session %q: %w", flagSession, err))
+				return
+			}
+		}
 		writer.Close()
 	}()
 	if !flagAutoCopyOnly {
@@ -0,0 +1,115 @@
+package chat
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/natefinch/atomic"
+
+	"github.com/sagan/goaider/constants"
+	"github.com/sagan/goaider/features/llm"
+)
+
+// Chat sessions are saved as "<UserConfigDir>/goaider/sessions/<name>.jsonl" files.
+// Each line is a JSON serialized llm.OpenAIMessage.
+const SESSION_EXT = ".jsonl"
+
+// Create sessions dir and return
+func getSessionsDir() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(configDir, constants.CONFIG_DIR_NAME, "sessions")
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+func getSessionFile(name string) (string, error) {
+	if name == "" || !filepath.IsLocal(name) || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid session name %q", name)
+	}
+	dir, err := getSessionsDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+SESSION_EXT), nil
+}
+
+// Load messages of a session. Return nil messages if session does not exist.
+func loadSession(name string) (messages []*llm.OpenAIMessage, err error) {
+	sessionFile, err := getSessionFile(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(sessionFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024*1024) // messages may contain base64 encoded attachments
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		message := &llm.OpenAIMessage{}
+		if err = json.Unmarshal(line, message); err != nil {
+			return nil, fmt.Errorf("invalid session %q file: %w", name, err)
+		}
+		messages = append(messages, message)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// Save (overwrite) messages of a session.
+func saveSession(name string, messages []*llm.OpenAIMessage) error {
+	sessionFile, err := getSessionFile(name)
+	if err != nil {
+		return err
+	}
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	for _, message := range messages {
+		if err = encoder.Encode(message); err != nil {
+			return err
+		}
+	}
+	return atomic.WriteFile(sessionFile, buf)
+}
+
+// Return names of all saved sessions.
+func listSessions() (names []string, err error) {
+	dir, err := getSessionsDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), SESSION_EXT) {
+			names = append(names, strings.TrimSuffix(entry.Name(), SESSION_EXT))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
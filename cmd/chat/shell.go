@@ -0,0 +1,192 @@
+package chat
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sagan/goaider/features/clipboard"
+	"github.com/sagan/goaider/features/llm"
+)
+
+// The interactive chat shell state.
+type chatShell struct {
+	req        *llm.OpenAIChatRequest
+	tools      []*llm.Tool
+	session    string // current session name. If not empty, messages are auto saved after each turn
+	onToolCall func(toolCall *llm.OpenAIToolCall)
+}
+
+// Shell directive handler. arg is the trimmed rest part of input after the directive name
+type directiveFunc func(shell *chatShell, arg string)
+
+var directives = map[string]directiveFunc{
+	"/clear":       (*chatShell).clear,
+	"/c":           (*chatShell).clear,
+	"/save":        (*chatShell).save,
+	"/load":        (*chatShell).load,
+	"/list":        (*chatShell).list,
+	"/model":       (*chatShell).model,
+	"/system":      (*chatShell).system,
+	"/temperature": (*chatShell).temperature,
+	"/retry":       (*chatShell).retry,
+}
+
+// Execute an input of shell. Inputs start with a known directive are handled as directives.
+func (s *chatShell) execute(input string) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return
+	}
+	name, arg, _ := strings.Cut(input, " ")
+	if directive := directives[name]; directive != nil {
+		directive(s, strings.TrimSpace(arg))
+		return
+	}
+	s.req.Messages = append(s.req.Messages, &llm.OpenAIMessage{
+		Role:    "user",
+		Content: input,
+	})
+	if !s.send() {
+		// remove the failed user message so that next input doesn't repeat it
+		s.req.Messages = s.req.Messages[:len(s.req.Messages)-1]
+	}
+}
+
+// Send current messages to LLM and stream the response. The last message should be user message.
+// If failed, the messages added during sending (e.g. tool calls) are removed, the user message is kept,
+// and it returns false.
+func (s *chatShell) send() bool {
+	messagesCnt := len(s.req.Messages)
+	response := strings.Builder{}
+	_, err := llm.StreamWithTools(flagModelKey, flagModel, s.req, s.tools, flagMaxToolRounds,
+		func(content string) error {
+			response.WriteString(content)
+			fmt.Printf("%s", content)
+			return nil
+		}, s.onToolCall)
+	if err != nil {
+		fmt.Printf("<error: %v>\n", err)
+		s.req.Messages = s.req.Messages[:messagesCnt]
+		return false
+	}
+	if flagAutoCopy {
+		clipboard.CopyString(response.String())
+	}
+	fmt.Printf("\n")
+	if s.session != "" {
+		if err := saveSession(s.session, s.req.Messages); err != nil {
+			fmt.Printf("<failed to save session %q: %v>\n", s.session, err)
+		}
+	}
+	return true
+}
+
+func (s *chatShell) clear(arg string) {
+	s.req.Messages = nil
+	fmt.Printf("<session cleared>\n")
+}
+
+// "/save [name]": save messages to session name (default current session) and switch to it
+func (s *chatShell) save(arg string) {
+	name := arg
+	if name == "" {
+		name = s.session
+	}
+	if name == "" {
+		fmt.Printf("<session name is required>\n")
+		return
+	}
+	if err := saveSession(name, s.req.Messages); err != nil {
+		fmt.Printf("<error: %v>\n", err)
+		return
+	}
+	s.session = name
+	fmt.Printf("<saved %d messages to session %q>\n", len(s.req.Messages), name)
+}
+
+// "/load name": load session name and switch to it
+func (s *chatShell) load(arg string) {
+	if arg == "" {
+		fmt.Printf("<session name is required>\n")
+		return
+	}
+	messages, err := loadSession(arg)
+	if err != nil {
+		fmt.Printf("<error: %v>\n", err)
+		return
+	}
+	s.req.Messages = messages
+	s.session = arg
+	fmt.Printf("<loaded %d messages of session %q>\n", len(messages), arg)
+}
+
+func (s *chatShell) list(arg string) {
+	names, err := listSessions()
+	if err != nil {
+		fmt.Printf("<error: %v>\n", err)
+		return
+	}
+	for _, name := range names {
+		if name == s.session {
+			fmt.Printf("* %s\n", name)
+		} else {
+			fmt.Printf("  %s\n", name)
+		}
+	}
+}
+
+// "/model [model]": show or switch model
+func (s *chatShell) model(arg string) {
+	if arg != "" {
+		if _, _, err := llm.ResolveModel(arg); err != nil {
+			fmt.Printf("<error: %v>\n", err)
+			return
+		}
+		flagModel = arg
+	}
+	fmt.Printf("<model: %s>\n", flagModel)
+}
+
+// "/system [prompt]": set system prompt, or remove it if prompt is empty
+func (s *chatShell) system(arg string) {
+	var messages []*llm.OpenAIMessage
+	for _, message := range s.req.Messages {
+		if message.Role != "system" {
+			messages = append(messages, message)
+		}
+	}
+	if arg != "" {
+		messages = append([]*llm.OpenAIMessage{{Role: "system", Content: arg}}, messages...)
+		fmt.Printf("<system prompt set>\n")
+	} else {
+		fmt.Printf("<system prompt removed>\n")
+	}
+	s.req.Messages = messages
+}
+
+// "/temperature [value]": show or set temperature
+func (s *chatShell) temperature(arg string) {
+	if arg != "" {
+		temperature, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			fmt.Printf("<invalid temperature %q>\n", arg)
+			return
+		}
+		s.req.Temperature = temperature
+	}
+	fmt.Printf("<temperature: %g>\n", s.req.Temperature)
+}
+
+// "/retry": remove the last response and re-send the last user message.
+// The user message is kept if it fails again, so it can be retried later.
+func (s *chatShell) retry(arg string) {
+	for i := len(s.req.Messages) - 1; i >= 0; i-- {
+		if s.req.Messages[i].Role == "user" {
+			s.req.Messages = s.req.Messages[:i+1]
+			s.send()
+			return
+		}
+	}
+	fmt.Printf("<no user message to retry>\n")
+}
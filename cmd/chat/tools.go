@@ -0,0 +1,110 @@
+package chat
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/google/shlex"
+
+	"github.com/sagan/goaider/features/csvfeature"
+	"github.com/sagan/goaider/features/llm"
+	"github.com/sagan/goaider/util/helper"
+)
+
+const (
+	TOOL_READ_FILE   = "read_file"
+	TOOL_RUN_COMMAND = "run_command"
+	TOOL_CSV_QUERY   = "csv_query"
+
+	// Max bytes of a tool result text sent back to LLM
+	MAX_TOOL_RESULT_SIZE = 64 * 1024
+)
+
+var toolNames = []string{TOOL_READ_FILE, TOOL_RUN_COMMAND, TOOL_CSV_QUERY}
+
+type readFileArgs struct {
+	Path string `json:"path" jsonschema:"description=Relative path of the file to read (in the current dir)."`
+}
+
+type runCommandArgs struct {
+	Cmdline string `json:"cmdline" jsonschema:"description=The command line to run. It's parsed using shell-like syntax but is not run in a shell."`
+}
+
+type csvQueryArgs struct {
+	Query string `json:"query" jsonschema:"description=The csvq SQL query. Table names are csv filenames of current dir; e.g. 'select * from foo limit 10' queries foo.csv. Wrap identifiers which contain special chars in backticks."`
+}
+
+// Create the local tools of names.
+// The run_command tool is restricted to allowedCmds.
+func getTools(names []string, allowedCmds []string) (tools []*llm.Tool, err error) {
+	for _, name := range names {
+		switch name {
+		case TOOL_READ_FILE:
+			tools = append(tools, llm.NewTool(TOOL_READ_FILE, "Read a text file from the current dir.",
+				func(args *readFileArgs) (string, error) {
+					// OpenInRoot also rejects symlinks that point outside current dir.
+					f, err := os.OpenInRoot(".", args.Path)
+					if err != nil {
+						return "", fmt.Errorf("path %q is not a readable file in current dir: %w", args.Path, err)
+					}
+					defer f.Close()
+					contents, err := io.ReadAll(io.LimitReader(f, MAX_TOOL_RESULT_SIZE))
+					if err != nil {
+						return "", err
+					}
+					return string(contents), nil
+				}))
+		case TOOL_RUN_COMMAND:
+			if len(allowedCmds) == 0 {
+				return nil, fmt.Errorf("%s tool requires --allow-cmd flag", TOOL_RUN_COMMAND)
+			}
+			tools = append(tools, llm.NewTool(TOOL_RUN_COMMAND,
+				fmt.Sprintf("Run a command line and return it's stdout and stderr. Allowed commands: %s.",
+					strings.Join(allowedCmds, ", ")),
+				func(args *runCommandArgs) (string, error) {
+					cmdArgs, err := shlex.Split(args.Cmdline)
+					if err != nil {
+						return "", err
+					}
+					if len(cmdArgs) == 0 || !slices.Contains(allowedCmds, cmdArgs[0]) {
+						return "", fmt.Errorf("command is not allowed")
+					}
+					output := &bytes.Buffer{}
+					err = helper.RunCmdline(args.Cmdline, false, nil, output, output)
+					result := truncateToolResult(output.String())
+					if err != nil {
+						return fmt.Sprintf("%s\n(command failed: %v)", result, err), nil
+					}
+					return result, nil
+				}))
+		case TOOL_CSV_QUERY:
+			tools = append(tools, llm.NewTool(TOOL_CSV_QUERY,
+				"Run a read-only SQL query (one SELECT statement) on csv files of the current dir using csvq. "+
+					"Return the result as csv.",
+				func(args *csvQueryArgs) (string, error) {
+					if err := csvfeature.CheckReadOnlyQuery(args.Query); err != nil {
+						return "", err
+					}
+					output := &bytes.Buffer{}
+					if err := csvfeature.Query(".", args.Query, output, false, false); err != nil {
+						return "", err
+					}
+					return truncateToolResult(output.String()), nil
+				}))
+		default:
+			return nil, fmt.Errorf("unknown tool %q. Available tools: %s", name, strings.Join(toolNames, ", "))
+		}
+	}
+	return tools, nil
+}
+
+func truncateToolResult(result string) string {
+	if len(result) > MAX_TOOL_RESULT_SIZE {
+		result = result[:MAX_TOOL_RESULT_SIZE] + "\n(truncated)"
+	}
+	return result
+}
@@ -5,12 +5,11 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
-	"io"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"unicode/utf8"
@@ -22,6 +21,7 @@ import (
 
 	"github.com/sagan/goaider/constants"
 	"github.com/sagan/goaider/util"
+	"github.com/sagan/goaider/util/imgutil"
 	"github.com/sagan/goaider/util/pathutil"
 )
 
@@ -58,6 +58,7 @@ func parseAddrFromUrl(rawURL string) (schema string, addr string, port int, err
 // 这个 client.ComfyClient 真 TMD 难用。
 type Client struct {
 	*client.ComfyClient
+	Progress *Progress // optional progress reporter of workflow runs
 }
 
 // clientaddr : "127.0.0.1:8188" or "http://127.0.0.1:8188" .
@@ -83,22 +84,45 @@ func CreateAndInitComfyClient(clientaddr string) (comfyClient *Client, err error
 
 // comfyui output file
 type ComfyuiOutput struct {
-	Data     []byte // image data
-	Filename string // unique filename. format: "cu-<hash>png". hash is sha256 url-safe base64.
+	Data     []byte // file data. Empty for text output
+	Filename string // unique filename. format: "cu-<hash>.png". hash is sha256 url-safe base64. Empty for text output
 	Text     string // exists if it's "text" type data output
-	Type     string // "output", "input"
+	Type     string // "output", "temp" (e.g. PreviewImage node) or "text"
+	Kind     string // output kind, the key in node output: "images", "gifs", "audio", "videos", "3d", "text"...
+	Node     string // output node id
+	Title    string // output node title
 }
 
 type ComfyuiOutputs []*ComfyuiOutput
 
-// Save the first output to filename. If filename is "-", output to stdout.
+// Filter of workflow outputs that RunWorkflow collects.
+type OutputFilter struct {
+	Nodes []string // output node selectors: "#id", node title or type (see selectorRegexp). Empty: all nodes
+	Temp  bool     // also collect "temp" type outputs (previews)
+}
+
+// Return the subdir name of output node: "<id>-<title>".
+func (output *ComfyuiOutput) NodeDir() string {
+	return pathutil.CleanBasename(output.Node + "-" + output.Title)
+}
+
+// Print text output to log (stderr), so that it doesn't mix with the stdout data (e.g. JSON progress events),
+// and is written above the progress status lines.
+func (output *ComfyuiOutput) printText() {
+	log.Printf("text output of node #%s (%s): %s", output.Node, output.Title, output.Text)
+}
+
+// Save the first (non text) output to filename. If filename is "-", output to stdout.
 // If filename exists and force is false, returns an error.
 func (outputs ComfyuiOutputs) Save(filename string, force bool) (err error) {
-	for _, output := range outputs {
+	for i, output := range outputs {
 		if output.Type == "text" {
-			fmt.Printf("text output %s: %s\n", output.Filename, output.Text)
+			output.printText()
 			continue
 		}
+		if others := len(outputs[i+1:]); others > 0 {
+			log.Warnf("only the first output (node #%s) is saved, %d other output(s) are discarded", output.Node, others)
+		}
 		if filename == "-" {
 			_, err = os.Stdout.Write(output.Data)
 			return err
@@ -114,7 +138,8 @@ func (outputs ComfyuiOutputs) Save(filename string, force bool) (err error) {
 // Save all outputs to dir.
 // If force is true, overwrite any existing file, otherwise skip them.
 // The savePrefix is used as saved filenames prefix.
-func (outputs ComfyuiOutputs) SaveAll(dir string, force bool, savePrefix string) error {
+// If perNode is true, outputs are saved to the "<node id>-<node title>" subdir of dir.
+func (outputs ComfyuiOutputs) SaveAll(dir string, force bool, savePrefix string, perNode bool) error {
 	if savePrefix != "" {
 		savePrefix = pathutil.CleanBasename(savePrefix)
 		if !strings.HasSuffix(savePrefix, "-") && !strings.HasSuffix(savePrefix, "_") {
@@ -124,10 +149,18 @@ func (outputs ComfyuiOutputs) SaveAll(dir string, force bool, savePrefix string)
 	var lastErr error
 	for _, output := range outputs {
 		if output.Type == "text" {
-			fmt.Printf("text output %s: %s\n", output.Filename, output.Text)
+			output.printText()
 			continue
 		}
-		outputFile := filepath.Join(dir, savePrefix+output.Filename)
+		outputDir := dir
+		if perNode {
+			outputDir = filepath.Join(dir, output.NodeDir())
+			if err := os.MkdirAll(outputDir, 0755); err != nil {
+				lastErr = fmt.Errorf("failed to create output directory %q: %w", outputDir, err)
+				continue
+			}
+		}
+		outputFile := filepath.Join(outputDir, savePrefix+output.Filename)
 		if exists, err := util.FileExists(outputFile); err != nil || (exists && !force) {
 			if err != nil {
 				lastErr = fmt.Errorf("output file %q access failed: %w", outputFile, err)
@@ -145,6 +178,26 @@ func (outputs ComfyuiOutputs) SaveAll(dir string, force bool, savePrefix string)
 	return lastErr
 }
 
+// Convert all image (png / jpg / webp) outputs to options.Format. Video and animated outputs are kept as is.
+// The converted output is renamed to new ext. Note the workflow metadata of ComfyUI output png is lost.
+func (outputs ComfyuiOutputs) Convert(options *imgutil.Options) error {
+	format := imgutil.NormalizeFormat(options.Format)
+	for _, output := range outputs {
+		ext := filepath.Ext(output.Filename)
+		if output.Type == "text" || !slices.Contains([]string{imgutil.FORMAT_PNG, imgutil.FORMAT_JPG,
+			imgutil.FORMAT_WEBP}, imgutil.NormalizeFormat(ext)) {
+			continue
+		}
+		buf := &bytes.Buffer{}
+		if err := imgutil.Convert(bytes.NewReader(output.Data), buf, options); err != nil {
+			return fmt.Errorf("failed to convert output %s: %w", output.Filename, err)
+		}
+		output.Data = buf.Bytes()
+		output.Filename = strings.TrimSuffix(output.Filename, ext) + "." + format
+	}
+	return nil
+}
+
 // generate a global unique "cu-<hash>.png" style filename for a ComfyUI output file.
 func genFilename(data []byte, output *client.DataOutput) string {
 	s := sha256.New()
@@ -154,11 +207,30 @@ func genFilename(data []byte, output *client.DataOutput) string {
 	return "cu-" + b64 + ext
 }
 
-// Ensure all input images in graph exists in ComfyUI server, upload missing files
+// Ensure all input images in workflow exists in ComfyUI server, upload missing files
 // node: LoadImage .
-// filename: widgets_values[0].
-// Note: it modify the graph.
-func (comfyClient *Client) PrepareGraph(graph *graphapi.Graph) (err error) {
+// filename: widgets_values[0] (UI format) or "image" input (API format).
+// Note: it modify the workflow.
+func (comfyClient *Client) PrepareWorkflow(workflow *Workflow) (err error) {
+	if workflow.Graph == nil {
+		for id, node := range workflow.Prompt {
+			if node.ClassType != NODE_TYPE_LOAD_IMAGE && node.ClassType != NODE_TYPE_LOAD_IMAGE_MASK {
+				continue
+			}
+			filename, ok := node.Inputs["image"].(string)
+			if !ok {
+				log.Warnf("node %s (LoadImage) has no filename in image input", id)
+				continue
+			}
+			serverFilename, err := comfyClient.uploadInputFile(filename)
+			if err != nil {
+				return err
+			}
+			node.Inputs["image"] = serverFilename
+		}
+		return nil
+	}
+	graph := workflow.Graph
 	for _, node := range graph.Nodes {
 		if node.Type != NODE_TYPE_LOAD_IMAGE && node.Type != NODE_TYPE_LOAD_IMAGE_MASK || node.WidgetValues == nil {
 			continue
@@ -173,27 +245,9 @@ func (comfyClient *Client) PrepareGraph(graph *graphapi.Graph) (err error) {
 			log.Warnf("node %d (LoadImage) has no filename in widget values", node.ID)
 			continue
 		}
-		hash, err := util.HashFile(filename, constants.HASH_SHA256, false)
+		serverFilename, err := comfyClient.uploadInputFile(filename)
 		if err != nil {
-			return fmt.Errorf("failed to calc input image %q hash: %w", filename, err)
-		}
-		serverFilename := hash + filepath.Ext(filename)
-		log.Printf("check image %q => %q", filename, serverFilename)
-		exists, err := comfyClient.CheckInputFileExists(serverFilename)
-		if err != nil {
-			return fmt.Errorf("failed to check if input file filename %q (%q) exists: %w", filename, serverFilename, err)
-		}
-		if !exists {
-			log.Printf("uploading input file %q => %q", filename, serverFilename)
-			file, err := os.Open(filename)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
-			_, err = comfyClient.UploadFileFromReader(file, serverFilename, false, client.InputImageType, "", nil)
-			if err != nil {
-				return fmt.Errorf("failed to upload input file %q: %w", filename, err)
-			}
+			return err
 		}
 		err = SetGraphNodeWidetValue(graph, node.ID, "0", serverFilename, 0)
 		if err != nil {
@@ -212,17 +266,71 @@ func (comfyClient *Client) PrepareGraph(graph *graphapi.Graph) (err error) {
 	return nil
 }
 
+// Upload local filename to ComfyUI server "input/" folder if it doesn't exist there yet.
+// Return the server filename: "<sha256>.<ext>".
+func (comfyClient *Client) uploadInputFile(filename string) (serverFilename string, err error) {
+	hash, err := util.HashFile(filename, constants.HASH_SHA256, false)
+	if err != nil {
+		return "", fmt.Errorf("failed to calc input image %q hash: %w", filename, err)
+	}
+	serverFilename = hash + filepath.Ext(filename)
+	log.Printf("check image %q => %q", filename, serverFilename)
+	exists, err := comfyClient.CheckInputFileExists(serverFilename)
+	if err != nil {
+		return "", fmt.Errorf("failed to check if input file filename %q (%q) exists: %w", filename, serverFilename, err)
+	}
+	if !exists {
+		log.Printf("uploading input file %q => %q", filename, serverFilename)
+		file, err := os.Open(filename)
+		if err != nil {
+			return "", err
+		}
+		defer file.Close()
+		_, err = comfyClient.UploadFileFromReader(file, serverFilename, false, client.InputImageType, "", nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to upload input file %q: %w", filename, err)
+		}
+	}
+	return serverFilename, nil
+}
+
 // RunWorkflow runs a ComfyUI workflow and returns the outputs.
-// It initializes the client, queues the prompt, and waits for the workflow to complete,
-// collecting any image or GIF outputs.
+// It queues the prompt, and waits for the workflow to complete,
+// collecting outputs of all kinds (images, gifs, audio, videos, 3d, text...) of all output nodes.
+// filter selects the output nodes and whether "temp" outputs (previews) are collected. nil: all non-temp outputs.
 // Each item in returned outputs have global unique filename.
-func (comfyClient *Client) RunWorkflow(ctx context.Context, graph *graphapi.Graph) (outputs ComfyuiOutputs, err error) {
+func (comfyClient *Client) RunWorkflow(ctx context.Context, workflow *Workflow,
+	filter *OutputFilter) (outputs ComfyuiOutputs, err error) {
+	if filter == nil {
+		filter = &OutputFilter{}
+	}
+	var outputNodes map[string]bool // nil: all nodes
+	for _, selector := range filter.Nodes {
+		ids := workflow.findNodes(selector)
+		if len(ids) == 0 {
+			return nil, fmt.Errorf("no node matches output node %s", selector)
+		}
+		if outputNodes == nil {
+			outputNodes = map[string]bool{}
+		}
+		for _, id := range ids {
+			outputNodes[id] = true
+		}
+	}
+
 	// queue the prompt and get the resulting image
-	item, err := comfyClient.QueuePrompt(graph)
+	var item *client.QueueItem
+	if workflow.Graph != nil {
+		item, err = comfyClient.QueuePrompt(workflow.Graph)
+	} else {
+		item, err = comfyClient.QueuePromptNodes(workflow.Prompt, nil)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to queue prompt: %w", err)
 	}
+	run := comfyClient.Progress.startRun(comfyClient.Origin, item.PromptID, workflow)
 	defer func() {
+		run.finish(err)
 		go func() {
 			// read and discard all left message in item.Messages channel.
 			for range item.Messages {
@@ -230,13 +338,17 @@ func (comfyClient *Client) RunWorkflow(ctx context.Context, graph *graphapi.Grap
 		}()
 	}()
 
+	seen := map[string]bool{} // node id + output filename
 	// continuously read messages from the QueuedItem until we get the "stopped" message type
-	for continueLoop := true; continueLoop; {
+	for {
 		select {
 		case <-ctx.Done():
 			comfyClient.CancelTask("")
 			return nil, ctx.Err()
-		case msg := <-item.Messages:
+		case msg, ok := <-item.Messages:
+			if !ok {
+				return outputs, fmt.Errorf("comfyui server disconnected")
+			}
 			switch msg.Type {
 			case "stopped":
 				// if we were stopped for an exception, display the exception message
@@ -244,52 +356,66 @@ func (comfyClient *Client) RunWorkflow(ctx context.Context, graph *graphapi.Grap
 				if qm.Exception != nil {
 					return nil, fmt.Errorf("exception: %v", qm.Exception)
 				}
-				continueLoop = false
+				if len(outputs) == 0 {
+					return nil, fmt.Errorf("workflow finished without outputs")
+				}
+				return outputs, nil
 			case "data":
 				qm := msg.ToPromptMessageData()
+				node := qm.Node
+				if node == "" {
+					node = strconv.Itoa(qm.NodeID)
+				}
+				if outputNodes != nil && !outputNodes[node] {
+					continue
+				}
 				// data objects have the fields: Filename, Subfolder, Type
 				// * Subfolder is the subfolder in the output directory
-				// * Type is the type of the image temp/
-				for k, v := range qm.Data {
-					if k == "images" || k == "gifs" { // video outputs are also in "images".
-						for _, output := range v {
-							imgData, err := comfyClient.GetImage(output)
+				// * Type is the type of the image: output / temp, or "text" for text output
+				kinds := util.Keys(qm.Data)
+				slices.Sort(kinds)
+				for _, kind := range kinds {
+					for _, output := range qm.Data[kind] {
+						if output.Type == string(client.TempImageType) && !filter.Temp {
+							continue
+						}
+						comfyuiOutput := &ComfyuiOutput{
+							Text:  output.Text,
+							Type:  output.Type,
+							Kind:  kind,
+							Node:  node,
+							Title: workflow.nodeTitle(node),
+						}
+						if output.Type != "text" {
+							key := node + "/" + output.Type + "/" + output.Subfolder + "/" + output.Filename
+							if seen[key] {
+								continue
+							}
+							seen[key] = true
+							data, err := comfyClient.GetImage(output)
 							if err != nil {
-								return outputs, fmt.Errorf("failed to get image: %w", err)
+								return outputs, fmt.Errorf("failed to get output %s of node #%s: %w",
+									output.Filename, node, err)
 							}
-							if imgData == nil || len(*imgData) == 0 {
-								log.Warnf("image data is empty for output %v", output)
+							if data == nil || len(*data) == 0 {
+								log.Warnf("output data is empty for output %v of node #%s", output, node)
 								continue
 							}
-							outputs = append(outputs, &ComfyuiOutput{
-								Data:     *imgData,
-								Filename: genFilename(*imgData, &output),
-								Text:     output.Text,
-								Type:     output.Type,
-							})
+							comfyuiOutput.Data = *data
+							comfyuiOutput.Filename = genFilename(*data, &output)
 						}
-						return outputs, nil
+						outputs = append(outputs, comfyuiOutput)
 					}
 				}
-			default: // progress
-				// log.Printf("event %s: %v", msg.Type, msg.Message)
+			default: // started, executing, progress, cached, progress_state
+				run.update(&msg)
 			}
 		}
 	}
-	return outputs, fmt.Errorf("comfyui server disconnected")
 }
 
-// Load graph from filename, if it's "-", read from stdin.
-func NewGraph(comfyClient *Client, filename string) (graph *graphapi.Graph, err error) {
-	var data []byte
-	if filename == "-" {
-		data, err = io.ReadAll(os.Stdin)
-	} else {
-		data, err = os.ReadFile(filename)
-	}
-	if err != nil {
-		return nil, err
-	}
+// Load UI format workflow graph from json or ComfyUI generated png file contents.
+func newGraph(comfyClient *Client, data []byte) (graph *graphapi.Graph, err error) {
 	jsonWorkflow := ""
 	if utf8.Valid(data) {
 		jsonWorkflow = string(data)
@@ -297,33 +423,9 @@ func NewGraph(comfyClient *Client, filename string) (graph *graphapi.Graph, err
 		// Try to parse workflow from ComfyUI generated png file
 		jsonWorkflow = util.ToJson(meta.Workflow)
 	}
-	// json workflow
+	// json workflow. Subgraphs and group nodes are flattened by comfy2go.
 	if jsonWorkflow != "" {
-		var obj map[string]any
-		err = json.Unmarshal([]byte(jsonWorkflow), &obj)
-		if err != nil {
-			return nil, err
-		}
-		// Remove some nodes that comfy2go doesn't yet support.
-		// remove those nodes from obj.nodes array.
-		if nodes, ok := obj["nodes"].([]any); ok {
-			var filteredNodes []any
-			for _, node := range nodes {
-				if nodeMap, isMap := node.(map[string]any); isMap {
-					if nodeType, typeOk := nodeMap["type"].(string); typeOk &&
-						(nodeType == "MarkdownNote" || nodeType == "Note") {
-						continue
-					}
-				}
-				filteredNodes = append(filteredNodes, node)
-			}
-			obj["nodes"] = filteredNodes
-			data, err = json.Marshal(obj)
-			if err != nil {
-				return nil, err
-			}
-		}
-		graph, _, err = comfyClient.NewGraphFromJsonString(string(data))
+		graph, _, err = comfyClient.NewGraphFromJsonString(jsonWorkflow)
 	} else {
 		graph, _, err = comfyClient.NewGraphFromPNGReader(bytes.NewReader(data))
 	}
@@ -384,47 +486,50 @@ func SetGraphNodeWidetValue(graph *graphapi.Graph, nodeId int, accessor string,
 	if str, ok := value.(string); ok {
 		value = strings.ReplaceAll(str, "%rand%", fmt.Sprint(seed))
 	}
+	value, err = coalesceValue(arr[index], value)
+	if err != nil {
+		return fmt.Errorf("node %d widget value at index %d: %w", nodeId, index, err)
+	}
+	arr[index] = value
+	node.WidgetValues = arr
+	return nil
+}
 
-	// if new value and existing value has different types (string / number), coalesce value to match existing type
-	switch arr[index].(type) {
+// If new value and existing value has different types (string / number / bool),
+// coalesce value to match existing type.
+func coalesceValue(existing any, value any) (any, error) {
+	switch existing.(type) {
 	case string:
 		if v, isString := value.(string); isString {
-			arr[index] = v
-		} else {
-			arr[index] = fmt.Sprintf("%v", value)
+			return v, nil
 		}
+		return fmt.Sprintf("%v", value), nil
 	case float64: // JSON unmarshals numbers to float64 by default
 		if v, isFloat := value.(float64); isFloat {
-			arr[index] = v
+			return v, nil
 		} else if v, isInt := value.(int); isInt {
-			arr[index] = float64(v)
+			return float64(v), nil
 		} else if v, isString := value.(string); isString {
 			if fv, err := strconv.ParseFloat(v, 64); err == nil {
-				arr[index] = fv
-			} else {
-				return fmt.Errorf("cannot convert string %q to float64 for node %d widget value at index %d", v, nodeId, index)
+				return fv, nil
 			}
-		} else {
-			return fmt.Errorf("unsupported value type for float64 target at node %d widget value at index %d", nodeId, index)
+			return nil, fmt.Errorf("cannot convert string %q to float64", v)
 		}
+		return nil, fmt.Errorf("unsupported value type for float64 target")
 	case bool:
 		if v, isBool := value.(bool); isBool {
-			arr[index] = v
+			return v, nil
 		} else if v, isString := value.(string); isString {
 			if bv, err := strconv.ParseBool(v); err == nil {
-				arr[index] = bv
-			} else {
-				return fmt.Errorf("cannot convert string %q to bool for node %d widget value at index %d", v, nodeId, index)
+				return bv, nil
 			}
-		} else {
-			return fmt.Errorf("unsupported value type for bool target at node %d widget value at index %d", nodeId, index)
+			return nil, fmt.Errorf("cannot convert string %q to bool", v)
 		}
+		return nil, fmt.Errorf("unsupported value type for bool target")
 	default:
 		// Fallback for other types, attempt direct assignment
-		arr[index] = value
+		return value, nil
 	}
-	node.WidgetValues = arr
-	return nil
 }
 
 // Return a random seed for ComfyUI of range [0, 2⁵³ - 1].
@@ -433,27 +538,42 @@ func RandSeed() int64 {
 	return util.RandInt(0, 9007199254740991)
 }
 
-// values item format: "node_id:index:value", e.g. "42:0:foo.png"
-func SetGraphNodeWeightValues(graph *graphapi.Graph, values []string, seed int64) error {
+// values item format: `<node>.<input>=<value>` selector (see selectorRegexp),
+// e.g. `"Positive Prompt".text=girl`, `KSampler.seed=%rand%`, `#41.steps=30`;
+// or legacy "node_id:accessor:value", e.g. "42:0:foo.png".
+// For UI format workflow, legacy accessor is the index of node "widgets_values";
+// for API format workflow, it's the name of node input, e.g. "3:seed:%rand%".
+func SetWorkflowValues(workflow *Workflow, values []string, seed int64) error {
 	for _, item := range values {
+		if isSelector, err := setWorkflowSelectorValue(workflow, item, seed); isSelector {
+			if err != nil {
+				return fmt.Errorf("failed to set %q: %w", item, err)
+			}
+			continue
+		}
 		parts := strings.SplitN(item, ":", 3)
 		if len(parts) != 3 {
-			return fmt.Errorf("invalid value format: %s, expected 'node_id:index:value'", item)
-		}
-
-		nodeID, err := strconv.Atoi(parts[0])
-		if err != nil {
-			return fmt.Errorf("invalid node ID %q: %w", parts[0], err)
+			return fmt.Errorf("invalid value format: %s, expected 'node_id:accessor:value'", item)
 		}
-
-		accessor := parts[1] // This is the index as a string
+		nodeID := parts[0]
+		accessor := parts[1]
 
 		// Attempt to infer type for the value.
 		// For now, we'll pass it as a string and let SetGraphNodeWidetValue handle conversion.
 		value := parts[2]
 
-		if err := SetGraphNodeWidetValue(graph, nodeID, accessor, value, seed); err != nil {
-			return fmt.Errorf("failed to set widget value for node %d, accessor %s: %w", nodeID, accessor, err)
+		if workflow.Graph == nil {
+			if err := SetPromptNodeInputValue(workflow.Prompt, nodeID, accessor, value, seed); err != nil {
+				return fmt.Errorf("failed to set input value for node %s, accessor %s: %w", nodeID, accessor, err)
+			}
+			continue
+		}
+		id, err := strconv.Atoi(nodeID)
+		if err != nil {
+			return fmt.Errorf("invalid node ID %q: %w", nodeID, err)
+		}
+		if err := SetGraphNodeWidetValue(workflow.Graph, id, accessor, value, seed); err != nil {
+			return fmt.Errorf("failed to set widget value for node %d, accessor %s: %w", id, accessor, err)
 		}
 	}
 	return nil
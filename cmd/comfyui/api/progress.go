@@ -0,0 +1,323 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/richinsley/comfy2go/client"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/term"
+)
+
+// Progress modes of ComfyUI workflow runs.
+const (
+	PROGRESS_TEXT = "text" // status line of each server to stderr, redrawn in place if it's a terminal
+	PROGRESS_JSON = "json" // progress events as JSON lines to stdout
+	PROGRESS_NONE = "none"
+)
+
+var ProgressModes = []string{PROGRESS_TEXT, PROGRESS_JSON, PROGRESS_NONE}
+
+// Progress event types.
+const (
+	EVENT_QUEUED    = "queued"
+	EVENT_STARTED   = "started"
+	EVENT_EXECUTING = "executing"
+	EVENT_PROGRESS  = "progress"
+	EVENT_CACHED    = "cached"
+	EVENT_FINISHED  = "finished"
+	EVENT_FAILED    = "failed"
+)
+
+// Min interval of redrawing status lines on "progress" events.
+const PROGRESS_REDRAW_INTERVAL = 100 * time.Millisecond
+
+// A progress event of a workflow run.
+type ProgressEvent struct {
+	Time       string   `json:"time"`
+	Server     string   `json:"server"`
+	PromptID   string   `json:"prompt_id"`
+	Event      string   `json:"event"`
+	Node       string   `json:"node,omitempty"`        // current node id
+	Title      string   `json:"title,omitempty"`       // current node title
+	Value      int      `json:"value,omitempty"`       // current node (e.g. sampler) step
+	Max        int      `json:"max,omitempty"`         // current node total steps
+	Cached     []string `json:"cached,omitempty"`      // ids of cached nodes, only in "cached" event
+	CachedAll  int      `json:"cached_all,omitempty"`  // number of cached nodes of the run
+	NodesDone  int      `json:"nodes_done,omitempty"`  // number of executed or cached nodes
+	NodesTotal int      `json:"nodes_total,omitempty"` // number of nodes of the prompt
+	Elapsed    float64  `json:"elapsed"`               // seconds since queued
+	Eta        float64  `json:"eta,omitempty"`         // estimated remaining seconds of current node steps
+	Error      string   `json:"error,omitempty"`
+}
+
+// Progress reporter of ComfyUI workflow runs. It's safe for concurrent use by runs on multiple servers.
+type Progress struct {
+	mode     string
+	output   io.Writer
+	terminal bool // output is a terminal, status lines are redrawn in place
+	mu       sync.Mutex
+	servers  []string                  // servers in order of first run
+	states   map[string]*ProgressEvent // server => last event
+	lines    int                       // number of drawn status lines
+	drawTime time.Time
+	logOut   io.Writer // original log output, restored by Close
+}
+
+// Create a progress reporter of mode.
+// In terminal text mode, log output is redirected to the reporter, so that it's written above status lines.
+func NewProgress(mode string) (*Progress, error) {
+	if !slices.Contains(ProgressModes, mode) {
+		return nil, fmt.Errorf("invalid progress mode %q", mode)
+	}
+	p := &Progress{
+		mode:   mode,
+		output: os.Stderr,
+		states: map[string]*ProgressEvent{},
+	}
+	switch mode {
+	case PROGRESS_JSON:
+		p.output = os.Stdout
+	case PROGRESS_TEXT:
+		if term.IsTerminal(int(os.Stderr.Fd())) {
+			p.terminal = true
+			p.logOut = log.StandardLogger().Out
+			log.SetOutput(p)
+		}
+	}
+	return p, nil
+}
+
+// Write writes (log) output above the status lines.
+func (p *Progress) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	n, err := p.output.Write(b)
+	p.draw()
+	return n, err
+}
+
+// Close stops redirecting log output. The last status lines are kept.
+func (p *Progress) Close() {
+	if p == nil || p.logOut == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	log.SetOutput(p.logOut)
+	p.logOut = nil
+	p.lines = 0
+}
+
+func (p *Progress) report(event *ProgressEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch p.mode {
+	case PROGRESS_JSON:
+		data, _ := json.Marshal(event)
+		p.output.Write(append(data, '\n'))
+	case PROGRESS_TEXT:
+		if _, ok := p.states[event.Server]; !ok {
+			p.servers = append(p.servers, event.Server)
+		}
+		state := *event
+		p.states[event.Server] = &state
+		if !p.terminal {
+			// print a line for each node, not each step
+			if event.Event != EVENT_PROGRESS && event.Event != EVENT_CACHED {
+				fmt.Fprintln(p.output, formatProgressLine(event))
+			}
+			return
+		}
+		if event.Event == EVENT_PROGRESS && time.Since(p.drawTime) < PROGRESS_REDRAW_INTERVAL {
+			return
+		}
+		p.clear()
+		p.draw()
+	}
+}
+
+// Clear drawn status lines (terminal text mode).
+func (p *Progress) clear() {
+	if p.lines > 0 {
+		fmt.Fprintf(p.output, "\033[%dA\033[J", p.lines)
+		p.lines = 0
+	}
+}
+
+// Draw status lines of all servers (terminal text mode).
+func (p *Progress) draw() {
+	if !p.terminal || p.logOut == nil {
+		return
+	}
+	width, _, err := term.GetSize(int(os.Stderr.Fd()))
+	for _, server := range p.servers {
+		line := formatProgressLine(p.states[server])
+		if err == nil && width > 1 && len([]rune(line)) >= width {
+			line = string([]rune(line)[:width-1])
+		}
+		fmt.Fprintln(p.output, line)
+	}
+	p.lines = len(p.servers)
+	p.drawTime = time.Now()
+}
+
+// Return a status line of event, e.g.
+// "127.0.0.1:8188 | KSampler #3 | step 12/20 | nodes 5/12 (4 cached) | 00:12, ETA 00:08".
+func formatProgressLine(event *ProgressEvent) string {
+	parts := []string{event.Server}
+	switch event.Event {
+	case EVENT_QUEUED:
+		parts = append(parts, "queued")
+	case EVENT_STARTED:
+		parts = append(parts, "started")
+	case EVENT_FINISHED:
+		parts = append(parts, "finished")
+	case EVENT_FAILED:
+		parts = append(parts, "failed: "+event.Error)
+	default:
+		parts = append(parts, fmt.Sprintf("%s #%s", event.Title, event.Node))
+		if event.Max > 0 {
+			parts = append(parts, fmt.Sprintf("step %d/%d", event.Value, event.Max))
+		}
+	}
+	if event.NodesTotal > 0 {
+		nodes := fmt.Sprintf("nodes %d/%d", event.NodesDone, event.NodesTotal)
+		if event.CachedAll > 0 {
+			nodes += fmt.Sprintf(" (%d cached)", event.CachedAll)
+		}
+		parts = append(parts, nodes)
+	}
+	elapsed := formatProgressDuration(event.Elapsed)
+	if event.Eta > 0 {
+		elapsed += ", ETA " + formatProgressDuration(event.Eta)
+	}
+	return strings.Join(append(parts, elapsed), " | ")
+}
+
+// Format seconds as "mm:ss" or "h:mm:ss".
+func formatProgressDuration(seconds float64) string {
+	d := time.Duration(seconds) * time.Second
+	if d >= time.Hour {
+		return fmt.Sprintf("%d:%02d:%02d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60)
+	}
+	return fmt.Sprintf("%02d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+}
+
+// Progress tracker of a single workflow run.
+type progressRun struct {
+	progress  *Progress
+	workflow  *Workflow
+	event     ProgressEvent
+	start     time.Time
+	stepStart time.Time // start time of current node steps
+	done      map[string]bool
+}
+
+// Start tracking a run of workflow on server. Return nil if p is nil.
+func (p *Progress) startRun(server string, promptID string, workflow *Workflow) *progressRun {
+	if p == nil || p.mode == PROGRESS_NONE {
+		return nil
+	}
+	run := &progressRun{
+		progress: p,
+		workflow: workflow,
+		event: ProgressEvent{
+			Server:     server,
+			PromptID:   promptID,
+			NodesTotal: workflow.nodeCount(),
+		},
+		start: time.Now(),
+		done:  map[string]bool{},
+	}
+	run.report(EVENT_QUEUED)
+	return run
+}
+
+func (run *progressRun) report(eventType string) {
+	run.event.Event = eventType
+	run.event.Time = time.Now().Format(time.RFC3339)
+	run.event.Elapsed = time.Since(run.start).Seconds()
+	run.event.NodesDone = len(run.done)
+	run.event.Eta = 0
+	if run.event.Value > 0 && run.event.Max > run.event.Value {
+		run.event.Eta = time.Since(run.stepStart).Seconds() * float64(run.event.Max-run.event.Value) /
+			float64(run.event.Value)
+	}
+	run.progress.report(&run.event)
+	run.event.Cached = nil
+}
+
+// Update progress by a prompt message of the run.
+func (run *progressRun) update(msg *client.PromptMessage) {
+	if run == nil {
+		return
+	}
+	switch msg.Type {
+	case "started":
+		run.report(EVENT_STARTED)
+	case "executing":
+		m := msg.ToPromptMessageExecuting()
+		node := m.Node
+		if node == "" {
+			node = strconv.Itoa(m.NodeID)
+		}
+		if run.event.Node != "" {
+			run.done[run.event.Node] = true
+		}
+		run.event.Node = node
+		run.event.Title = run.workflow.nodeTitle(node)
+		run.event.Value, run.event.Max = 0, 0
+		run.report(EVENT_EXECUTING)
+	case "progress":
+		m := msg.ToPromptMessageProgress()
+		if m.Node != "" && m.Node != run.event.Node {
+			run.event.Node = m.Node
+			run.event.Title = run.workflow.nodeTitle(m.Node)
+		}
+		if run.event.Value == 0 || m.Value < run.event.Value {
+			run.stepStart = time.Now()
+		}
+		run.event.Value, run.event.Max = m.Value, m.Max
+		run.report(EVENT_PROGRESS)
+	case "cached":
+		m := msg.ToPromptMessageCached()
+		for _, node := range m.Nodes {
+			run.done[node] = true
+		}
+		run.event.Cached = m.Nodes
+		run.event.CachedAll += len(m.Nodes)
+		run.report(EVENT_CACHED)
+	case "progress_state":
+		for id, node := range msg.ToPromptMessageProgressState().Nodes {
+			if node.State == "finished" {
+				run.done[id] = true
+			}
+		}
+	}
+}
+
+// Finish the run. err is nil if it succeeded.
+func (run *progressRun) finish(err error) {
+	if run == nil {
+		return
+	}
+	if err == nil && run.event.Node != "" {
+		run.done[run.event.Node] = true
+	}
+	run.event.Node, run.event.Title, run.event.Value, run.event.Max = "", "", 0, 0
+	if err != nil {
+		run.event.Error = err.Error()
+		run.report(EVENT_FAILED)
+		return
+	}
+	run.report(EVENT_FINISHED)
+}
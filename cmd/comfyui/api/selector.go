@@ -0,0 +1,190 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/richinsley/comfy2go/graphapi"
+
+	"github.com/sagan/goaider/util"
+)
+
+// Workflow variable selector: `<node>.<input>=<value>`. <node> is one of:
+//   - `#41` : node id.
+//   - `"Positive Prompt"` : node title (or display name if node has no title). Double quoted.
+//   - `KSampler` : node title (or display name), or node type if no node has that title.
+//
+// <input> is the input (widget) name of the node, e.g. "text", "seed".
+var selectorRegexp = regexp.MustCompile(`(?s)^(#\d+|"[^"]+"|[^".=:]+)\.([^=]+)=(.*)$`)
+
+// Max number of combo options shown in error message.
+const MAX_COMBO_OPTIONS_SHOWN = 10
+
+// Set workflow value of `<node>.<input>=<value>` format selector item.
+// Return false if item is not a selector.
+func setWorkflowSelectorValue(workflow *Workflow, item string, seed int64) (isSelector bool, err error) {
+	match := selectorRegexp.FindStringSubmatch(item)
+	if match == nil {
+		return false, nil
+	}
+	selector, input := match[1], match[2]
+	value := strings.ReplaceAll(match[3], "%rand%", fmt.Sprint(seed))
+	if workflow.Graph == nil {
+		id, err := selectPromptNode(workflow.Prompt, selector)
+		if err != nil {
+			return true, err
+		}
+		return true, SetPromptNodeInputValue(workflow.Prompt, id, input, value, seed)
+	}
+	node, err := selectGraphNode(workflow.Graph, selector)
+	if err != nil {
+		return true, err
+	}
+	return true, setGraphNodeInputValue(node, input, value)
+}
+
+// Find the only one node of UI format workflow graph that matches selector.
+func selectGraphNode(graph *graphapi.Graph, selector string) (*graphapi.GraphNode, error) {
+	nodes := findGraphNodes(graph, selector)
+	switch len(nodes) {
+	case 0:
+		return nil, fmt.Errorf("no node matches %s", selector)
+	case 1:
+		return nodes[0], nil
+	}
+	var ids []string
+	for _, node := range nodes {
+		ids = append(ids, fmt.Sprintf("#%d", node.ID))
+	}
+	return nil, fmt.Errorf(`%s matches %d nodes (%s), use "#<id>" to select one`,
+		selector, len(nodes), strings.Join(ids, ", "))
+}
+
+// Find all nodes of UI format workflow graph that match selector.
+func findGraphNodes(graph *graphapi.Graph, selector string) (nodes []*graphapi.GraphNode) {
+	if id, ok := strings.CutPrefix(selector, "#"); ok {
+		nodeId, _ := strconv.Atoi(id)
+		if node := graph.GetNodeById(nodeId); node != nil {
+			nodes = append(nodes, node)
+		}
+	} else if title, ok := strings.CutPrefix(selector, `"`); ok {
+		nodes = graph.GetNodesWithTitle(strings.TrimSuffix(title, `"`))
+	} else {
+		nodes = graph.GetNodesWithTitle(selector)
+		if len(nodes) == 0 {
+			nodes = graph.GetNodesWithType(selector)
+		}
+	}
+	return nodes
+}
+
+// Find the only one node of API format workflow (prompt) that matches selector. Return the node id.
+func selectPromptNode(prompt map[string]graphapi.PromptNode, selector string) (string, error) {
+	ids := findPromptNodes(prompt, selector)
+	switch len(ids) {
+	case 0:
+		return "", fmt.Errorf("no node matches %s", selector)
+	case 1:
+		return ids[0], nil
+	}
+	return "", fmt.Errorf(`%s matches %d nodes (#%s), use "#<id>" to select one`,
+		selector, len(ids), strings.Join(ids, ", #"))
+}
+
+// Find the ids of all nodes of API format workflow (prompt) that match selector, sorted.
+func findPromptNodes(prompt map[string]graphapi.PromptNode, selector string) (ids []string) {
+	if id, ok := strings.CutPrefix(selector, "#"); ok {
+		if _, ok := prompt[id]; ok {
+			ids = append(ids, id)
+		}
+		return ids
+	}
+	title, quoted := strings.CutPrefix(selector, `"`)
+	title = strings.TrimSuffix(title, `"`)
+	for id, node := range prompt {
+		if node.Meta != nil && node.Meta.Title == title {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 && !quoted {
+		for id, node := range prompt {
+			if node.ClassType == title {
+				ids = append(ids, id)
+			}
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// Find the ids of all nodes of workflow that match selector.
+func (workflow *Workflow) findNodes(selector string) (ids []string) {
+	if workflow.Graph == nil {
+		return findPromptNodes(workflow.Prompt, selector)
+	}
+	for _, node := range findGraphNodes(workflow.Graph, selector) {
+		ids = append(ids, strconv.Itoa(node.ID))
+	}
+	return ids
+}
+
+// Set the input (widget) value of a node of UI format workflow graph,
+// by the named property of the node. The value is checked against the property type and range.
+func setGraphNodeInputValue(node *graphapi.GraphNode, name string, value string) error {
+	prop := node.GetPropertyWithName(name)
+	if prop == nil {
+		names := util.Keys(node.Properties)
+		slices.Sort(names)
+		return fmt.Errorf("node #%d (%s) has no input %q, available inputs: %s",
+			node.ID, node.Type, name, strings.Join(names, ", "))
+	}
+	if slot := node.GetInputWithName(name); slot != nil && slot.Link != 0 {
+		if link := node.Graph.GetLinkById(slot.Link); link != nil {
+			return fmt.Errorf("node #%d input %q is linked from node #%d, set that node instead",
+				node.ID, name, link.OriginID)
+		}
+	}
+	if err := checkPropertyValue(prop, value); err != nil {
+		return fmt.Errorf("node #%d input %q: %w", node.ID, name, err)
+	}
+	if target := prop.GetTargetNode(); target != nil && target.IsWidgetValueArray() &&
+		prop.TargetIndex() >= len(target.WidgetValuesArray()) {
+		return fmt.Errorf("node #%d input %q has no widget value", node.ID, name)
+	}
+	return prop.SetValue(value)
+}
+
+// Check whether value is valid for Int / Float / Bool / Combo property.
+func checkPropertyValue(prop graphapi.Property, value string) error {
+	if p, ok := prop.ToIntProperty(); ok {
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		if p.HasRange() && (v < p.Min || v > p.Max) {
+			return fmt.Errorf("%d is out of range [%d, %d]", v, p.Min, p.Max)
+		}
+	} else if p, ok := prop.ToFloatProperty(); ok {
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		if p.HasRange() && (v < p.Min || v > p.Max) {
+			return fmt.Errorf("%g is out of range [%g, %g]", v, p.Min, p.Max)
+		}
+	} else if _, ok := prop.ToBoolProperty(); ok {
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%q is not a bool", value)
+		}
+	} else if p, ok := prop.ToComboProperty(); ok && !p.IsBool && !slices.Contains(p.Values, value) {
+		options := p.Values
+		if len(options) > MAX_COMBO_OPTIONS_SHOWN {
+			options = append(slices.Clone(options[:MAX_COMBO_OPTIONS_SHOWN]), "...")
+		}
+		return fmt.Errorf("%q is not one of the %d options: %s", value, len(p.Values), strings.Join(options, ", "))
+	}
+	return nil
+}
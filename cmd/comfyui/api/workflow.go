@@ -0,0 +1,154 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/richinsley/comfy2go/graphapi"
+
+	"github.com/sagan/goaider/util"
+)
+
+// A ComfyUI workflow. It's either a UI format workflow graph ("nodes" / "links"),
+// or an API format workflow ("Export (API)"), which is queued as is.
+type Workflow struct {
+	Graph  *graphapi.Graph                // UI format workflow. nil if it's API format
+	Prompt map[string]graphapi.PromptNode // API format workflow: node id => node
+}
+
+// Load workflow from filename, if it's "-", read from stdin.
+// The file can be an UI / API format json workflow, or a ComfyUI generated png file;
+// the "workflow" metadata of png is preferred, the "prompt" metadata is used if the former doesn't exist.
+func NewWorkflow(comfyClient *Client, filename string) (workflow *Workflow, err error) {
+	var data []byte
+	if filename == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var prompt []byte // API format workflow json
+	if utf8.Valid(data) {
+		var obj map[string]any
+		if err = json.Unmarshal(data, &obj); err != nil {
+			return nil, err
+		}
+		if IsPrompt(obj) {
+			prompt = data
+		}
+	} else if meta, err := ExtractComfyMetadata(bytes.NewReader(data)); err == nil &&
+		meta.Workflow == nil && IsPrompt(meta.Prompt) {
+		prompt = []byte(util.ToJson(meta.Prompt))
+	}
+	if prompt != nil {
+		workflow = &Workflow{}
+		if err = json.Unmarshal(prompt, &workflow.Prompt); err != nil {
+			return nil, fmt.Errorf("invalid API format workflow: %w", err)
+		}
+		return workflow, nil
+	}
+	graph, err := newGraph(comfyClient, data)
+	if err != nil {
+		return nil, err
+	}
+	return &Workflow{Graph: graph}, nil
+}
+
+// Check whether obj is an API format workflow (prompt):
+// a non-empty map of node id => {"class_type": "...", "inputs": {...}}.
+func IsPrompt(obj map[string]any) bool {
+	if len(obj) == 0 {
+		return false
+	}
+	for _, value := range obj {
+		node, ok := value.(map[string]any)
+		if !ok {
+			return false
+		}
+		if _, ok := node["class_type"].(string); !ok {
+			return false
+		}
+		if _, ok := node["inputs"].(map[string]any); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Set the input value of a node of API format workflow (prompt).
+// accessor is the input name. The input must not be a link to other node's output.
+// Special placeholder in value: "%rand%" : a random integer.
+func SetPromptNodeInputValue(prompt map[string]graphapi.PromptNode, nodeId string, accessor string,
+	value any, seed int64) (err error) {
+	node, ok := prompt[nodeId]
+	if !ok {
+		return fmt.Errorf("node %s not found", nodeId)
+	}
+	existing, ok := node.Inputs[accessor]
+	if !ok {
+		names := util.Keys(node.Inputs)
+		slices.Sort(names)
+		return fmt.Errorf("node %s (%s) has no input %q, available inputs: %s",
+			nodeId, node.ClassType, accessor, strings.Join(names, ", "))
+	}
+	if _, isLink := existing.([]any); isLink {
+		return fmt.Errorf("node %s input %q is a link to other node", nodeId, accessor)
+	}
+	if str, ok := value.(string); ok {
+		value = strings.ReplaceAll(str, "%rand%", fmt.Sprint(seed))
+	}
+	value, err = coalesceValue(existing, value)
+	if err != nil {
+		return fmt.Errorf("node %s input %q: %w", nodeId, accessor, err)
+	}
+	node.Inputs[accessor] = value
+	return nil
+}
+
+// Return the number of nodes that are executed by server.
+func (workflow *Workflow) nodeCount() (count int) {
+	if workflow.Graph == nil {
+		return len(workflow.Prompt)
+	}
+	for _, node := range workflow.Graph.NodesInExecutionOrder {
+		if !node.IsVirtual() && node.Mode != 2 {
+			count++
+		}
+	}
+	return count
+}
+
+// Return the title of node of id. Fallback to display name or type.
+func (workflow *Workflow) nodeTitle(id string) string {
+	if workflow.Graph == nil {
+		node, ok := workflow.Prompt[id]
+		if !ok {
+			return ""
+		}
+		if node.Meta != nil && node.Meta.Title != "" {
+			return node.Meta.Title
+		}
+		return node.ClassType
+	}
+	nodeId, _ := strconv.Atoi(id)
+	node := workflow.Graph.GetNodeById(nodeId)
+	if node == nil {
+		return ""
+	}
+	if node.Title != "" {
+		return node.Title
+	}
+	if node.DisplayName != "" {
+		return node.DisplayName
+	}
+	return node.Type
+}
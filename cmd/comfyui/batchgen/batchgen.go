@@ -18,6 +18,7 @@ import (
 
 	"github.com/sagan/goaider/cmd/comfyui"
 	"github.com/sagan/goaider/cmd/comfyui/api"
+	"github.com/sagan/goaider/constants"
 	"github.com/sagan/goaider/features/csvfeature"
 	"github.com/sagan/goaider/util"
 	"github.com/sagan/goaider/util/pathutil"
@@ -86,6 +87,7 @@ var (
 	flagServer   []string // ComfyUI servers
 	flagVars     []string // workflow variables
 	flagResume   string   // resume token "actionIdx:contextIdx"
+	flagProgress string   // progress output mode
 )
 
 // Global state for resume token generation on interrupt
@@ -99,14 +101,17 @@ func init() {
 	batchGenCmd.Flags().BoolVarP(&flagForce, "force", "", false, "Force overwriting existing file(s)")
 	batchGenCmd.Flags().IntVarP(&flagBatch, "batch", "b", 8, "Batch run N times for each prompt")
 	batchGenCmd.Flags().StringVarP(&flagOutput, "output", "o", "", "(Required) Output directory")
-	batchGenCmd.Flags().StringArrayVarP(&flagVars, "var", "v", nil, `Workflow variables (e.g. "41:0:%prompt%"). `+
-		`Special values: %rand% : a random seed; %prompt% : the generated prompt from action & context`)
+	batchGenCmd.Flags().StringArrayVarP(&flagVars, "var", "v", nil,
+		`Workflow variables: "<node>.<input>=<value>" or "node_id:index:value" (e.g. "#41.text=%prompt%"). `+
+			`Special values: %rand% : a random seed; %prompt% : the generated prompt from action & context`)
 	batchGenCmd.Flags().StringVarP(&flagWorkflow, "workflow", "w", "", "(Required) Workflow file path")
 	batchGenCmd.Flags().StringVarP(&flagActions, "actions", "a", "", "(Required) Actions CSV file")
 	batchGenCmd.Flags().StringVarP(&flagContext, "contexts", "c", "", "Contexts CSV file")
 	batchGenCmd.Flags().StringArrayVarP(&flagServer, "server", "s", []string{"127.0.0.1:8188"},
 		"ComfyUI server address(es)")
 	batchGenCmd.Flags().StringVarP(&flagResume, "resume", "r", "", "Resume from token 'actionIdx:contextIdx'")
+	batchGenCmd.Flags().StringVarP(&flagProgress, "progress", "", api.PROGRESS_TEXT,
+		constants.HELP_COMFYUI_PROGRESS_FLAG)
 	batchGenCmd.MarkFlagRequired("workflow")
 	batchGenCmd.MarkFlagRequired("output")
 	batchGenCmd.MarkFlagRequired("actions")
@@ -143,12 +148,19 @@ func doBatchGen(cmd *cobra.Command, args []string) (err error) {
 	}
 
 	// 3. Initialize Client Pool
+	progress, err := api.NewProgress(flagProgress)
+	if err != nil {
+		return err
+	}
+	defer progress.Close()
+
 	clientPool := make(chan *api.Client, len(flagServer))
 	for _, addr := range flagServer {
 		client, err := api.CreateAndInitComfyClient(addr)
 		if err != nil {
 			return fmt.Errorf("failed to init client %s: %w", addr, err)
 		}
+		client.Progress = progress
 		clientPool <- client
 	}
 
@@ -261,8 +273,8 @@ func runWithRetry(ctx context.Context, pool chan *api.Client, prompt, outputDir
 }
 
 func executeWorkflow(ctx context.Context, client *api.Client, prompt, outputDir string) error {
-	// 1. Create Graph (New instance to avoid state pollution)
-	graph, err := api.NewGraph(client, flagWorkflow)
+	// 1. Create Workflow (New instance to avoid state pollution)
+	workflow, err := api.NewWorkflow(client, flagWorkflow)
 	if err != nil {
 		return err
 	}
@@ -276,23 +288,23 @@ func executeWorkflow(ctx context.Context, client *api.Client, prompt, outputDir
 	}
 
 	if len(processedVars) > 0 {
-		if err := api.SetGraphNodeWeightValues(graph, processedVars, seed); err != nil {
+		if err := api.SetWorkflowValues(workflow, processedVars, seed); err != nil {
 			return err
 		}
 	}
 
 	// 3. Prepare & Run
-	if err := client.PrepareGraph(graph); err != nil {
+	if err := client.PrepareWorkflow(workflow); err != nil {
 		return err
 	}
 
-	outputs, err := client.RunWorkflow(ctx, graph)
+	outputs, err := client.RunWorkflow(ctx, workflow, nil)
 	if err != nil {
 		return err
 	}
 
 	// 4. Save
-	return outputs.SaveAll(outputDir, flagForce, "")
+	return outputs.SaveAll(outputDir, flagForce, "", false)
 }
 
 // --- Helper Functions ---
@@ -325,8 +337,7 @@ func updateState(aIdx, cIdx int) {
 func printResumeToken() {
 	stateMutex.Lock()
 	defer stateMutex.Unlock()
-	fmt.Printf("\n\nTo resume from this point, use:\n")
-	fmt.Printf("  --resume \"%d:%d\"\n\n", currentActionIdx, currentContextIdx)
+	log.Printf("To resume from this point, use: --resume \"%d:%d\"", currentActionIdx, currentContextIdx)
 }
 
 func truncate(s string, n int) string {
@@ -81,6 +81,7 @@ var (
 	flagResume       string
 	flagServer       []string
 	flagVars         []string
+	flagProgress     string
 )
 
 func init() {
@@ -94,7 +95,8 @@ func init() {
 	batchI2VCmd.Flags().StringArrayVarP(&flagServer, "server", "s", []string{"127.0.0.1:8188"},
 		"ComfyUI server address(es)")
 	batchI2VCmd.Flags().StringArrayVarP(&flagVars, "var", "v", nil,
-		`Workflow variables (e.g. "41:0:%prompt%"). Special values: %rand% : a random seed; `+
+		`Workflow variables: "<node>.<input>=<value>" or "node_id:index:value" (e.g. "#41.text=%prompt%"). `+
+			`Special values: %rand% : a random seed; `+
 			`%image% : input image full path; %prompt% : video prompt; %negative_prompt% : video negative prompt; `+
 			`%audio_prompt% : audio prompt; %audio_negative_prompt% : audio negative prompt`,
 	)
@@ -105,6 +107,8 @@ func init() {
 		"Instruction prompt for the LLM")
 	batchI2VCmd.Flags().StringVarP(&flagResume, "resume", "", "",
 		"Resume from this image basename (skips alphabetically previous images)")
+	batchI2VCmd.Flags().StringVarP(&flagProgress, "progress", "", api.PROGRESS_TEXT,
+		constants.HELP_COMFYUI_PROGRESS_FLAG)
 	batchI2VCmd.MarkFlagRequired("workflow")
 	batchI2VCmd.MarkFlagRequired("input")
 	batchI2VCmd.MarkFlagRequired("output")
@@ -147,8 +151,8 @@ func (p *ProgressTracker) GetResumeString() string {
 }
 
 func doBatchI2V(cmd *cobra.Command, args []string) (err error) {
-	if flagModel == "" {
-		flagModel = config.GetDefaultModel()
+	if flagModel, err = config.GetCommandModel(cmd, flagModel, &flagTemperature); err != nil {
+		return err
 	}
 
 	err = os.MkdirAll(flagOutput, 0755)
@@ -205,10 +209,8 @@ func doBatchI2V(cmd *cobra.Command, args []string) (err error) {
 	defer func() {
 		resumePoint := tracker.GetResumeString()
 		if resumePoint != "" {
-			fmt.Println("\n---------------------------------------------------------")
-			fmt.Printf("⚠️  Process incomplete. To resume from the current position:\n")
-			fmt.Printf("   goaider comfyui batchi2v ... --resume \"%s\"\n", resumePoint)
-			fmt.Println("---------------------------------------------------------")
+			log.Warnf("⚠️  Process incomplete. To resume from the current position: "+
+				"goaider comfyui batchi2v ... --resume \"%s\"", resumePoint)
 		} else {
 			log.Println("✅ All tasks completed successfully.")
 		}
@@ -259,12 +261,19 @@ func doBatchI2V(cmd *cobra.Command, args []string) (err error) {
 		}
 	}()
 
+	progress, err := api.NewProgress(flagProgress)
+	if err != nil {
+		return err
+	}
+	defer progress.Close()
+
 	clientPool := make(chan *api.Client, len(flagServer))
 	for _, addr := range flagServer {
 		c, err := api.CreateAndInitComfyClient(addr)
 		if err != nil {
 			return err
 		}
+		c.Progress = progress
 		clientPool <- c
 	}
 
@@ -315,19 +324,15 @@ func processI2V(ctx context.Context, pool chan *api.Client, task i2vTask) error
 
 		mimeType := util.GetMimeType(task.imagePath)
 
-		err = retry(3, func() error {
-			var lErr error
-			llmResp, lErr = llm.ImageToJson[I2VResponse](
-				flagModelKey,
-				flagModel,
-				flagPromptTmpl, // Template should instruct to fill the JSON fields
-				imgData,
-				mimeType,
-				flagTemperature,
-			)
-			log.Printf("llm request %s, err=%v, response: %s", flagPromptTmpl, lErr, util.ToJson(llmResp))
-			return lErr
-		})
+		llmResp, err = llm.ImageToJson[I2VResponse](
+			flagModelKey,
+			flagModel,
+			flagPromptTmpl, // Template should instruct to fill the JSON fields
+			imgData,
+			mimeType,
+			flagTemperature,
+		)
+		log.Printf("llm request %s, err=%v, response: %s", flagPromptTmpl, err, util.ToJson(llmResp))
 
 		if err != nil {
 			return fmt.Errorf("LLM generation failed: %w", err)
@@ -376,7 +381,7 @@ func processI2V(ctx context.Context, pool chan *api.Client, task i2vTask) error
 }
 
 func executeI2VWorkflow(ctx context.Context, client *api.Client, task i2vTask, llmResp *I2VResponse) error {
-	graph, err := api.NewGraph(client, flagWorkflow)
+	workflow, err := api.NewWorkflow(client, flagWorkflow)
 	if err != nil {
 		return err
 	}
@@ -398,31 +403,20 @@ func executeI2VWorkflow(ctx context.Context, client *api.Client, task i2vTask, l
 	}
 
 	if len(processedVars) > 0 {
-		if err := api.SetGraphNodeWeightValues(graph, processedVars, seed); err != nil {
+		if err := api.SetWorkflowValues(workflow, processedVars, seed); err != nil {
 			return err
 		}
 	}
 
-	if err := client.PrepareGraph(graph); err != nil {
-		return fmt.Errorf("prepare graph: %w", err)
+	if err := client.PrepareWorkflow(workflow); err != nil {
+		return fmt.Errorf("prepare workflow: %w", err)
 	}
 
-	outputs, err := client.RunWorkflow(ctx, graph)
+	outputs, err := client.RunWorkflow(ctx, workflow, nil)
 	if err != nil {
 		return err
 	}
 
 	// Pass ShortDescription as the 3rd argument (prefix)
-	return outputs.SaveAll(flagOutput, flagForce, llmResp.TitleZh)
-}
-
-func retry(attempts int, fn func() error) error {
-	var err error
-	for attempt := range attempts {
-		if err = fn(); err == nil {
-			return nil
-		}
-		time.Sleep(util.CalculateBackoff(2*time.Second, 60*time.Second, attempt))
-	}
-	return err
+	return outputs.SaveAll(flagOutput, flagForce, llmResp.TitleZh, false)
 }
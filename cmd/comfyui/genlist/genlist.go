@@ -56,8 +56,8 @@ func init() {
 }
 
 func doGen(cmd *cobra.Command, args []string) (err error) {
-	if flagModel == "" {
-		flagModel = config.GetDefaultModel()
+	if flagModel, err = config.GetCommandModel(cmd, flagModel, &flagTemperature); err != nil {
+		return err
 	}
 	actionsFile := filepath.Join(flatOutput, ACTIONS_FILE)
 	contextsFile := filepath.Join(flatOutput, CONTEXTS_FILE)
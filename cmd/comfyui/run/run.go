@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
 	"syscall"
 
 	log "github.com/sirupsen/logrus"
@@ -13,6 +14,8 @@ import (
 
 	"github.com/sagan/goaider/cmd/comfyui"
 	"github.com/sagan/goaider/cmd/comfyui/api"
+	"github.com/sagan/goaider/constants"
+	"github.com/sagan/goaider/util/imgutil"
 )
 
 var runCmd = &cobra.Command{
@@ -21,8 +24,30 @@ var runCmd = &cobra.Command{
 	Long: `Run a ComfyUI workflow and save output.
 
 The {workflow.json} argument can be "-" for reading from stdin.
+The workflow can be in UI format (the default "Save"), API format ("Export (API)"),
+or a ComfyUI generated png file, which contains the workflow (UI format) and / or prompt (API format) metadata.
+API format workflow is queued as is, so it also works with nodes that are unknown to this tool.
+Subgraphs and (legacy) group nodes of UI format workflow are flattened into plain nodes before running.
+
+The --var flag sets node input values, format: "<node>.<input>=<value>", where <node> is one of:
+- "#41": node id.
+- '"Positive Prompt"': node title (or display name if node has no title), double quoted.
+- "KSampler": node title (or display name), or node type if no node has that title.
+<input> is the input (widget) name, e.g. "text", "seed". It's an error if the <node> matches multiple nodes.
+For UI format workflow, the value is checked against the input type and range.
+The legacy "node_id:index:value" format is also supported, where index is the index of node "widgets_values"
+(UI format workflow) or input name (API format workflow).
+
+While running, a status line (current node, sampler step, cached nodes, elapsed time and ETA) is shown in stderr.
+Use "--progress json" to write progress events as JSON lines to stdout instead, for other tools to consume.
+Other messages (logs, text outputs) are always written to stderr, so stdout has only the JSON lines.
+
+Outputs of all kinds (images, videos, audio, 3d, text...) of all output nodes are collected when the run finishes.
+Use "--output-node" to save only outputs of some nodes, "--temp" to also save previews (e.g. "PreviewImage" node),
+and "--per-node" to save outputs to a subdir of each output node.
 
 Example:
+  goaider comfyui run flux.json -s 127.0.0.1:8188 -v '"Positive Prompt".text=young girl, smiling' -v "KSampler.seed=%rand%"
   goaider comfyui run flux.json -s 127.0.0.1:8188 -v "41:0:young girl, smiling" -v "31:0:%rand%"`,
 	RunE: doRun,
 	Args: cobra.ExactArgs(1),
@@ -35,6 +60,12 @@ var (
 	flagOutputDir string   // output dir for saving generated image / video.
 	flagServer    string   // ComfyUI server, can or "http://ip:port" or "ip:port".
 	flagVars      []string // workflow variables
+	flagFormat    string   // convert image outputs to this format
+	flagQuality   int      // jpg / webp quality of converted outputs
+	flagProgress  string   // progress output mode
+	flagNodes     []string // output nodes to save
+	flagTemp      bool     // also save "temp" outputs
+	flagPerNode   bool     // save outputs to subdir of each output node
 )
 
 func init() {
@@ -47,8 +78,22 @@ func init() {
 	runCmd.Flags().StringVarP(&flagServer, "server", "s", "127.0.0.1:8188",
 		`ComfyUI server, can be either "http://ip:port" or "ip:port".`)
 	runCmd.Flags().StringArrayVarP(&flagVars, "var", "v", nil,
-		`Set workflow node "widgets_values" variable. Format: "node_id:index:value". E.g. "42:0:girl, smiling". `+
+		`Set workflow node input value. Format: "<node>.<input>=<value>" or legacy "node_id:index:value". `+
+			`E.g. "#42.text=girl, smiling", "KSampler.steps=30". `+
 			`Can be specified multiple times. Special values: "%rand%" : a random seed`)
+	runCmd.Flags().StringVarP(&flagFormat, "format", "f", "",
+		`Optional: convert image outputs to this format: "png", "jpg" or "webp". `+
+			`Note the embedded workflow metadata is lost after conversion`)
+	runCmd.Flags().IntVarP(&flagQuality, "quality", "q", imgutil.DEFAULT_QUALITY,
+		"Output jpg / webp quality (1-100) of converted outputs. webp quality 100 is lossless")
+	runCmd.Flags().StringVarP(&flagProgress, "progress", "", api.PROGRESS_TEXT, constants.HELP_COMFYUI_PROGRESS_FLAG)
+	runCmd.Flags().StringArrayVarP(&flagNodes, "output-node", "N", nil,
+		`Only save outputs of these output nodes. Node is "#id", node title or node type, as in --var. `+
+			`Can be specified multiple times`)
+	runCmd.Flags().BoolVarP(&flagTemp, "temp", "", false,
+		`Also save "temp" type outputs, e.g. images of "PreviewImage" node`)
+	runCmd.Flags().BoolVarP(&flagPerNode, "per-node", "", false,
+		`Save outputs to "<node id>-<node title>" subdir of output dir for each output node`)
 	runCmd.MarkFlagRequired("server")
 	comfyui.ComfyuiCmd.AddCommand(runCmd)
 }
@@ -57,6 +102,21 @@ func doRun(cmd *cobra.Command, args []string) (err error) {
 	if flagOutput != "" && flagBatch > 1 {
 		return fmt.Errorf("cannot use --output with --batch > 1. use --output-dir instead")
 	}
+	if flagOutput != "" && flagPerNode {
+		return fmt.Errorf("cannot use --output with --per-node")
+	}
+	if flagFormat != "" && !slices.Contains([]string{imgutil.FORMAT_PNG, imgutil.FORMAT_JPG, imgutil.FORMAT_WEBP},
+		imgutil.NormalizeFormat(flagFormat)) {
+		return fmt.Errorf("invalid format %q", flagFormat)
+	}
+	if flagProgress == api.PROGRESS_JSON && flagOutput == "-" {
+		return fmt.Errorf("cannot use --progress json with --output -")
+	}
+	progress, err := api.NewProgress(flagProgress)
+	if err != nil {
+		return err
+	}
+	defer progress.Close()
 	err = os.MkdirAll(flagOutputDir, 0755)
 	if err != nil {
 		return fmt.Errorf("failed to create output directory %q: %w", flagOutputDir, err)
@@ -88,28 +148,34 @@ func doRun(cmd *cobra.Command, args []string) (err error) {
 	if err != nil {
 		return fmt.Errorf("failed to create client: %w", err)
 	}
-	graph, err := api.NewGraph(client, argWorkflow)
+	client.Progress = progress
+	workflow, err := api.NewWorkflow(client, argWorkflow)
 	if err != nil {
-		return fmt.Errorf("failed to create graph: %w", err)
+		return fmt.Errorf("failed to load workflow: %w", err)
 	}
 
 	for i := range flagBatch {
 		seed := api.RandSeed()
 		log.Printf("%d/%d run workflow (seed=%d)", i+1, flagBatch, seed)
 		if len(flagVars) > 0 {
-			if err := api.SetGraphNodeWeightValues(graph, flagVars, seed); err != nil {
-				return fmt.Errorf("failed to set graph node widget values: %w", err)
+			if err := api.SetWorkflowValues(workflow, flagVars, seed); err != nil {
+				return fmt.Errorf("failed to set workflow values: %w", err)
 			}
 		}
-		err = client.PrepareGraph(graph)
+		err = client.PrepareWorkflow(workflow)
 		if err != nil {
-			return fmt.Errorf("failed to prepare graph: %w", err)
+			return fmt.Errorf("failed to prepare workflow: %w", err)
 		}
-		outputs, err := client.RunWorkflow(ctx, graph)
+		outputs, err := client.RunWorkflow(ctx, workflow, &api.OutputFilter{Nodes: flagNodes, Temp: flagTemp})
 		if err != nil {
 			return err
 		}
 
+		if flagFormat != "" {
+			if err = outputs.Convert(&imgutil.Options{Format: flagFormat, Quality: flagQuality}); err != nil {
+				return err
+			}
+		}
 		if flagOutput != "" {
 			outputPath := flagOutput
 			if outputPath != "-" {
@@ -117,7 +183,7 @@ func doRun(cmd *cobra.Command, args []string) (err error) {
 			}
 			err = outputs.Save(outputPath, flagForce)
 		} else {
-			err = outputs.SaveAll(flagOutputDir, flagForce, "")
+			err = outputs.SaveAll(flagOutputDir, flagForce, "", flagPerNode)
 		}
 		if err != nil {
 			return err
@@ -0,0 +1,188 @@
+package crop
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+
+	"github.com/sagan/goaider/features/csvfeature"
+	"github.com/sagan/goaider/features/llm"
+)
+
+// Crop anchor sources
+const (
+	ANCHOR_SMART   = "smart"   // smartcrop saliency heuristic
+	ANCHOR_CENTER  = "center"  // center of image
+	ANCHOR_SIDECAR = "sidecar" // subject box of --boxes file or "<filename>.box.json" sidecar file
+	ANCHOR_MASK    = "mask"    // bounding box of "<name>.mask.png" mask file
+	ANCHOR_LLM     = "llm"     // subject box detected by LLM vision
+)
+
+var anchors = []string{ANCHOR_SMART, ANCHOR_CENTER, ANCHOR_SIDECAR, ANCHOR_MASK, ANCHOR_LLM}
+
+const (
+	BOX_SIDECAR_SUFFIX = ".box.json"
+	MASK_SUFFIX        = ".mask.png"
+)
+
+// Subject bounding box. Values are pixels, or normalized (0-1) if all of them <= 1.
+type subjectBox struct {
+	File   string  `json:"file,omitempty"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// Return pixel rect of box in image of width x height.
+func (b *subjectBox) rect(width, height int) image.Rectangle {
+	x, y, w, h := b.X, b.Y, b.Width, b.Height
+	if x <= 1 && y <= 1 && w <= 1 && h <= 1 {
+		x, y, w, h = x*float64(width), y*float64(height), w*float64(width), h*float64(height)
+	}
+	return image.Rect(int(x), int(y), int(x+w), int(y+h)).Intersect(image.Rect(0, 0, width, height))
+}
+
+// LLM detected subject box. Values are normalized to 0-1000.
+type llmSubjectBox struct {
+	Xmin int `json:"xmin" jsonschema:"description=Left edge of the box, normalized to 0-1000."`
+	Ymin int `json:"ymin" jsonschema:"description=Top edge of the box, normalized to 0-1000."`
+	Xmax int `json:"xmax" jsonschema:"description=Right edge of the box, normalized to 0-1000."`
+	Ymax int `json:"ymax" jsonschema:"description=Bottom edge of the box, normalized to 0-1000."`
+}
+
+const subjectPrompt = `Detect the main subject (e.g. the person or character, including the whole head and face) ` +
+	`of this image. Return it's bounding box, with coordinates normalized to 0-1000.`
+
+// Read subject boxes file (JSON or CSV). Return a map of image file base name => box.
+// JSON: an array of {"file", "x", "y", "width", "height"} objects,
+// or an object of {"<file>": {"x", "y", "width", "height"}}.
+// CSV: columns "file", "x", "y", "width", "height".
+func readBoxesFile(name string) (map[string]*subjectBox, error) {
+	contents, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	boxes := map[string]*subjectBox{}
+	var list []*subjectBox
+	if strings.ToLower(filepath.Ext(name)) == ".csv" {
+		rows, err := csvfeature.UnmarshalCsv[map[string]string](bytes.NewReader(contents))
+		if err != nil {
+			return nil, err
+		}
+		for i, row := range rows {
+			box := &subjectBox{File: row["file"]}
+			for _, field := range []struct {
+				name  string
+				value *float64
+			}{{"x", &box.X}, {"y", &box.Y}, {"width", &box.Width}, {"height", &box.Height}} {
+				if *field.value, err = strconv.ParseFloat(row[field.name], 64); err != nil {
+					return nil, fmt.Errorf("invalid row %d %s: %w", i+1, field.name, err)
+				}
+			}
+			list = append(list, box)
+		}
+	} else if err := json.Unmarshal(contents, &list); err != nil {
+		if err := json.Unmarshal(contents, &boxes); err != nil {
+			return nil, fmt.Errorf("invalid boxes file: %w", err)
+		}
+	}
+	for _, box := range list {
+		boxes[box.File] = box
+	}
+	for file, box := range boxes {
+		if file == "" || box == nil || box.Width <= 0 || box.Height <= 0 {
+			return nil, fmt.Errorf("invalid box of file %q", file)
+		}
+		if file != filepath.Base(file) {
+			delete(boxes, file)
+			boxes[filepath.Base(file)] = box
+		}
+	}
+	return boxes, nil
+}
+
+// Return the subject rect of image using anchor source. Return an empty rect if not found.
+func findSubject(anchor string, inputPath string, img image.Image, boxes map[string]*subjectBox) (
+	image.Rectangle, error) {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	switch anchor {
+	case ANCHOR_SIDECAR:
+		box := boxes[filepath.Base(inputPath)]
+		if box == nil {
+			contents, err := os.ReadFile(inputPath + BOX_SIDECAR_SUFFIX)
+			if err != nil {
+				return image.Rectangle{}, nil
+			}
+			box = &subjectBox{}
+			if err := json.Unmarshal(contents, box); err != nil {
+				return image.Rectangle{}, fmt.Errorf("invalid box sidecar file: %w", err)
+			}
+		}
+		return box.rect(width, height), nil
+	case ANCHOR_MASK:
+		mask, err := imaging.Open(strings.TrimSuffix(inputPath, filepath.Ext(inputPath)) + MASK_SUFFIX)
+		if err != nil {
+			return image.Rectangle{}, nil
+		}
+		if mask.Bounds().Dx() != width || mask.Bounds().Dy() != height {
+			mask = imaging.Resize(mask, width, height, imaging.NearestNeighbor)
+		}
+		return maskBounds(mask), nil
+	case ANCHOR_LLM:
+		buf := &bytes.Buffer{}
+		if err := imaging.Encode(buf, img, imaging.JPEG, imaging.JPEGQuality(90)); err != nil {
+			return image.Rectangle{}, err
+		}
+		box, err := llm.ImageToJson[llmSubjectBox](flagModelKey, flagModel, subjectPrompt, buf.Bytes(),
+			"image/jpeg", flagTemperature)
+		if err != nil {
+			return image.Rectangle{}, fmt.Errorf("llm subject detection failed: %w", err)
+		}
+		return image.Rect(box.Xmin*width/1000, box.Ymin*height/1000, box.Xmax*width/1000,
+			box.Ymax*height/1000).Intersect(image.Rect(0, 0, width, height)), nil
+	}
+	return image.Rectangle{}, nil
+}
+
+// Return the bounding box of "on" pixels of mask: opaque and brighter than 50% gray.
+func maskBounds(mask image.Image) image.Rectangle {
+	b := mask.Bounds()
+	minX, minY, maxX, maxY := b.Dx(), b.Dy(), 0, 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(mask.At(x, y)).(color.NRGBA)
+			if c.A < 128 || (int(c.R)+int(c.G)+int(c.B))/3 < 128 {
+				continue
+			}
+			minX, minY = min(minX, x-b.Min.X), min(minY, y-b.Min.Y)
+			maxX, maxY = max(maxX, x-b.Min.X+1), max(maxY, y-b.Min.Y+1)
+		}
+	}
+	if maxX <= minX || maxY <= minY {
+		return image.Rectangle{}
+	}
+	return image.Rect(minX, minY, maxX, maxY)
+}
+
+// Return the crop rect of cropWidth x cropHeight in image of width x height, which covers subject most.
+// The crop is centered on subject; if subject is taller than crop, the top of subject (head) is kept.
+func subjectCrop(subject image.Rectangle, width, height, cropWidth, cropHeight int) image.Rectangle {
+	clamp := func(v, size, total int) int {
+		return max(0, min(v, total-size))
+	}
+	x := clamp((subject.Min.X+subject.Max.X)/2-cropWidth/2, cropWidth, width)
+	y := clamp((subject.Min.Y+subject.Max.Y)/2-cropHeight/2, cropHeight, height)
+	if subject.Dy() > cropHeight {
+		y = clamp(subject.Min.Y, cropHeight, height)
+	}
+	return image.Rect(x, y, x+cropWidth, y+cropHeight)
+}
@@ -0,0 +1,108 @@
+package crop
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/sagan/goaider/util"
+	"github.com/sagan/goaider/util/helper"
+)
+
+// Aspect-ratio bucket resolution
+type bucket struct {
+	Width  int
+	Height int
+}
+
+// Bucket resolutions are rounded to multiple of this.
+const BUCKET_STEP = 64
+
+// Builtin bucket set presets.
+var bucketPresets = map[string]string{
+	// SDXL / Flux official buckets at 1024x1024 pixel area
+	"sdxl": "1024x1024,1152x896,896x1152,1216x832,832x1216,1344x768,768x1344,1536x640,640x1536",
+	// SD 1.5 buckets at 512x512 pixel area
+	"sd15": "512x512,576x448,448x576,640x384,384x640,768x320,320x768",
+}
+
+// Parse bucket set: a preset name or comma-separated "WxH" resolutions.
+// If area > 0, each bucket is scaled to that target pixel area (keeping ratio, rounded to BUCKET_STEP).
+func parseBuckets(value string, area int) (buckets []*bucket, err error) {
+	if preset, ok := bucketPresets[strings.ToLower(value)]; ok {
+		value = preset
+	}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		w, h, ok := strings.Cut(strings.ToLower(item), "x")
+		width, err1 := strconv.Atoi(w)
+		height, err2 := strconv.Atoi(h)
+		if !ok || err1 != nil || err2 != nil || width <= 0 || height <= 0 {
+			return nil, fmt.Errorf("invalid bucket %q: must be a preset (%s) or WxH resolution",
+				item, strings.Join(util.Keys(bucketPresets), ", "))
+		}
+		b := &bucket{Width: width, Height: height}
+		if area > 0 {
+			scale := math.Sqrt(float64(area) / float64(width*height))
+			b.Width = max(int(math.Round(float64(width)*scale/BUCKET_STEP))*BUCKET_STEP, BUCKET_STEP)
+			b.Height = max(int(math.Round(float64(height)*scale/BUCKET_STEP))*BUCKET_STEP, BUCKET_STEP)
+		}
+		if !slices.ContainsFunc(buckets, func(e *bucket) bool { return *e == *b }) {
+			buckets = append(buckets, b)
+		}
+	}
+	if len(buckets) == 0 {
+		return nil, fmt.Errorf("no bucket")
+	}
+	return buckets, nil
+}
+
+// Return the bucket which aspect ratio is nearest to width x height.
+func nearestBucket(buckets []*bucket, width, height int) *bucket {
+	ratio := math.Log(float64(width) / float64(height))
+	var nearest *bucket
+	minDiff := math.Inf(1)
+	for _, b := range buckets {
+		if diff := math.Abs(ratio - math.Log(float64(b.Width)/float64(b.Height))); diff < minDiff {
+			nearest, minDiff = b, diff
+		}
+	}
+	return nearest
+}
+
+// Default crop manifest file name in output dir.
+const REPORT_FILENAME = "crops.csv"
+
+// Write crop results to manifest CSV file, merged with the existing records of previous runs (by output).
+func writeReport(reportFile string, results []*cropResult) error {
+	var rows [][]string
+	for _, result := range results {
+		subject := "" // "x,y,width,height"
+		if !result.Subject.Empty() {
+			subject = fmt.Sprintf("%d,%d,%d,%d", result.Subject.Min.X, result.Subject.Min.Y,
+				result.Subject.Dx(), result.Subject.Dy())
+		}
+		rows = append(rows, []string{
+			result.Input,
+			result.Output,
+			strconv.Itoa(result.Width),
+			strconv.Itoa(result.Height),
+			fmt.Sprintf("%dx%d", result.TargetWidth, result.TargetHeight),
+			strconv.Itoa(result.Crop.Min.X),
+			strconv.Itoa(result.Crop.Min.Y),
+			strconv.Itoa(result.Crop.Dx()),
+			strconv.Itoa(result.Crop.Dy()),
+			result.Anchor,
+			subject,
+			strconv.FormatBool(result.Upscaled),
+			strconv.FormatBool(result.Skipped),
+		})
+	}
+	return helper.WriteMergedCsv(reportFile, []string{"input", "output", "width", "height", "bucket", "crop_x",
+		"crop_y", "crop_width", "crop_height", "anchor", "subject", "upscaled", "skipped"}, rows, "output")
+}
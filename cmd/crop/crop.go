@@ -1,27 +1,56 @@
 package crop
 
 import (
+	"bytes"
 	"fmt"
 	"image"
-	"image/png"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/disintegration/imaging"
 	"github.com/muesli/smartcrop"
-	"github.com/rwcarlsen/goexif/exif"
+	"github.com/natefinch/atomic"
 	"github.com/sagan/goaider/cmd"
+	"github.com/sagan/goaider/config"
+	"github.com/sagan/goaider/constants"
+	"github.com/sagan/goaider/util/imgutil"
 	"github.com/spf13/cobra"
 )
 
 // Flag variables to store command line arguments
 var (
-	flagForce  bool
-	flagCenter bool // Crop the center of the image, do not use smartcrop
-	flagWidth  int
-	flagHeight int
-	flagOutput string
+	flagForce       bool
+	flagCenter      bool // Crop the center of the image, do not use smartcrop
+	flagWidth       int
+	flagHeight      int
+	flagOutput      string
+	flagBucket      string
+	flagBucketArea  int
+	flagReport      string
+	flagUpscale     string
+	flagAnchor      string
+	flagBoxes       string
+	flagModel       string
+	flagModelKey    string
+	flagTemperature float64
+	flagFormat      string
+	flagQuality     int
+	flagBackground  string
+	flagMetadata    string
+)
+
+// Output formats
+var formats = []string{imgutil.FORMAT_PNG, imgutil.FORMAT_JPG, imgutil.FORMAT_WEBP}
+
+const (
+	UPSCALE_ALLOW = "allow" // silently upscale small images
+	UPSCALE_FLAG  = "flag"  // upscale small images, but print a warning and flag them in report
+	UPSCALE_SKIP  = "skip"  // skip small images
+
+	METADATA_KEEP  = "keep"  // keep EXIF metadata of input image
+	METADATA_STRIP = "strip" // strip all metadata
 )
 
 var cropCmd = &cobra.Command{
@@ -29,7 +58,48 @@ var cropCmd = &cobra.Command{
 	Short: "Crop and resize images in a directory",
 	Long: `Crop and resize images in a directory.
 	
-It crops images using smartcrop ( https://github.com/muesli/smartcrop ).`,
+It crops images using smartcrop ( https://github.com/muesli/smartcrop ).
+
+By default it crops and resizes all images to --width x --height.
+Use --bucket to enable aspect-ratio bucketing mode: each image is assigned to the bucket of nearest aspect ratio,
+minimally cropped to the bucket ratio and resized to the bucket resolution.
+The --bucket value is a preset ("sdxl", "sd15") or comma-separated resolutions (e.g. "1024x1024,1216x832,832x1216").
+Use --bucket-area to scale the bucket resolutions to a target pixel area (e.g. 589824 for 768x768).
+In bucketing mode, the bucket of each image is recorded in the crop manifest CSV ("crops.csv", see below).
+
+Images smaller than the target resolution are handled by --upscale:
+"allow": upscale them; "flag": upscale them but print a warning and flag them in report; "skip": skip them.
+
+The crop position is decided by --anchor:
+- "smart" (default): smartcrop saliency heuristic.
+- "center": center of image.
+- "sidecar": center on the subject bounding box of image, read from --boxes file (JSON or CSV),
+  or "<filename>.box.json" sidecar file ({"x": 0, "y": 0, "width": 100, "height": 100}).
+  Box values are pixels, or normalized (0-1) if all of them <= 1.
+  --boxes JSON file is an array of {"file", "x", "y", "width", "height"} objects,
+  or an object of {"<file>": {"x", "y", "width", "height"}}; CSV file has same columns.
+- "mask": center on the bounding box of white / opaque pixels of "<name>.mask.png" mask file.
+- "llm": center on the subject bounding box detected by LLM vision (--model).
+If the subject is taller than the crop, the top of subject (head) is kept.
+If the subject of an image is not found, it falls back to "smart".
+
+Supported input formats: jpg, png, webp, gif (first frame), bmp, tiff, avif and heif (requires ffmpeg).
+Output format is set by --format: "png", "jpg" or "webp" (requires ffmpeg).
+By default it's the input format if it's one of them, otherwise "png".
+Images are converted to 8-bit sRGB; RGB color profiles (e.g. Display P3, Adobe RGB) are converted by their
+colorants and tone curves, other color profiles (e.g. CMYK) are ignored with a warning.
+Metadata is stripped by default. Use "--metadata keep" to keep the EXIF metadata (orientation is reset).
+
+The crop rect of each image is recorded in manifest CSV (--report, default "crops.csv" of output dir),
+which is written in bucketing mode or if --anchor is not "smart" / "center".
+The records of existing manifest are kept, so that a resumed run (skipping existing outputs) doesn't lose them.
+
+Examples:
+  goaider crop ./images
+  goaider crop ./images --bucket sdxl --upscale skip
+  goaider crop ./images --bucket "1024x1024,1216x832,832x1216" --bucket-area 589824
+  goaider crop ./images --bucket sdxl --anchor llm
+  goaider crop ./images --format webp --quality 90 --background white`,
 	Args: cobra.ExactArgs(1),
 	RunE: crop,
 }
@@ -39,15 +109,87 @@ func init() {
 	cropCmd.Flags().BoolVarP(&flagForce, "force", "", false,
 		"Optional: Process and generate the target output file even if the file already exists")
 	cropCmd.Flags().BoolVarP(&flagCenter, "center", "", false,
-		"Optional: Crop the center of the image, do not use smartcrop")
+		`Optional: Crop the center of the image, do not use smartcrop. Same as "--anchor center"`)
 	cropCmd.Flags().IntVarP(&flagWidth, "width", "", 1024, "Optional: target photo width")
 	cropCmd.Flags().IntVarP(&flagHeight, "height", "", 1024, "Optional: target photo height")
 	cropCmd.Flags().StringVarP(&flagOutput, "output", "o", "",
 		`Optional: output dir name. default to "<input-dir>-crop"`)
+	cropCmd.Flags().StringVarP(&flagBucket, "bucket", "b", "",
+		`Optional: enable aspect-ratio bucketing mode using this bucket set: a preset ("sdxl", "sd15") `+
+			`or comma-separated "WxH" resolutions`)
+	cropCmd.Flags().IntVarP(&flagBucketArea, "bucket-area", "", 0,
+		"Optional: scale bucket resolutions to this target pixel area. 0 == use as is")
+	cropCmd.Flags().StringVarP(&flagReport, "report", "", "",
+		`Optional: crop manifest CSV file. Default is "crops.csv" of output dir`)
+	cropCmd.Flags().StringVarP(&flagUpscale, "upscale", "", UPSCALE_FLAG,
+		`How to handle images smaller than target resolution: "allow", "flag" or "skip"`)
+	cropCmd.Flags().StringVarP(&flagAnchor, "anchor", "", ANCHOR_SMART,
+		`Crop anchor source: "smart", "center", "sidecar", "mask" or "llm"`)
+	cropCmd.Flags().StringVarP(&flagBoxes, "boxes", "", "",
+		`Optional: subject bounding boxes file (JSON or CSV) of "sidecar" anchor`)
+	cropCmd.Flags().StringVarP(&flagModel, "model", "", "", `The model of "llm" anchor. `+constants.HELP_MODEL)
+	cropCmd.Flags().StringVarP(&flagModelKey, "model-key", "", "", constants.HELP_MODEL_KEY)
+	cropCmd.Flags().Float64VarP(&flagTemperature, "temperature", "T", 0, constants.HELP_TEMPERATURE_FLAG)
+	cropCmd.Flags().StringVarP(&flagFormat, "format", "f", "",
+		`Optional: output format: "png", "jpg" or "webp". Default is input format if it's one of them, or "png"`)
+	cropCmd.Flags().IntVarP(&flagQuality, "quality", "q", imgutil.DEFAULT_QUALITY,
+		"Output jpg / webp quality (1-100). webp quality 100 is lossless")
+	cropCmd.Flags().StringVarP(&flagBackground, "background", "", "",
+		`Optional: flatten alpha channel onto this background color (e.g. "white", "#808080"). `+
+			`jpg output is always flattened (default white)`)
+	cropCmd.Flags().StringVarP(&flagMetadata, "metadata", "", METADATA_STRIP,
+		`Output metadata: "strip" (strip all metadata) or "keep" (keep EXIF metadata of input)`)
+	cropCmd.MarkFlagsMutuallyExclusive("center", "anchor")
+	cropCmd.MarkFlagsMutuallyExclusive("bucket", "width")
+	cropCmd.MarkFlagsMutuallyExclusive("bucket", "height")
 }
 
-func crop(cmd *cobra.Command, args []string) error {
+func crop(cmd *cobra.Command, args []string) (err error) {
 	argDir := args[0]
+	if flagUpscale != UPSCALE_ALLOW && flagUpscale != UPSCALE_FLAG && flagUpscale != UPSCALE_SKIP {
+		return fmt.Errorf("invalid upscale %q", flagUpscale)
+	}
+	if flagCenter {
+		flagAnchor = ANCHOR_CENTER
+	}
+	if !slices.Contains(anchors, flagAnchor) {
+		return fmt.Errorf("invalid anchor %q", flagAnchor)
+	}
+	var boxes map[string]*subjectBox
+	if flagBoxes != "" {
+		var err error
+		if boxes, err = readBoxesFile(flagBoxes); err != nil {
+			return fmt.Errorf("failed to read boxes file: %w", err)
+		}
+	}
+	if flagAnchor == ANCHOR_LLM {
+		if flagModel, err = config.GetCommandModel(cmd, flagModel, &flagTemperature); err != nil {
+			return err
+		}
+	}
+	if flagFormat != "" {
+		flagFormat = imgutil.NormalizeFormat(flagFormat)
+		if !slices.Contains(formats, flagFormat) {
+			return fmt.Errorf("invalid format %q", flagFormat)
+		}
+	}
+	if flagMetadata != METADATA_KEEP && flagMetadata != METADATA_STRIP {
+		return fmt.Errorf("invalid metadata %q", flagMetadata)
+	}
+	baseOutputOptions := &imgutil.Options{Quality: flagQuality}
+	if flagBackground != "" {
+		var err error
+		if baseOutputOptions.Background, err = imgutil.ParseColor(flagBackground); err != nil {
+			return err
+		}
+	}
+	var buckets []*bucket
+	if flagBucket != "" {
+		var err error
+		if buckets, err = parseBuckets(flagBucket, flagBucketArea); err != nil {
+			return err
+		}
+	}
 
 	// Logic: specific output directory calculation
 	finalOutput := flagOutput
@@ -68,14 +210,30 @@ func crop(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to read directory %s: %w", argDir, err)
 	}
 
+	// target resolution of an image
+	targetSize := func(width, height int) (int, int) {
+		if buckets != nil {
+			b := nearestBucket(buckets, width, height)
+			return b.Width, b.Height
+		}
+		return flagWidth, flagHeight
+	}
+
 	errorCnt := 0
+	var results []*cropResult
 	for _, file := range files {
-		if file.IsDir() || !isProcessableImage(file.Name()) {
+		if file.IsDir() || !isProcessableImage(file.Name()) ||
+			strings.HasSuffix(strings.ToLower(file.Name()), MASK_SUFFIX) {
 			continue
 		}
 
 		inputPath := filepath.Join(argDir, file.Name())
+		outputOptions := *baseOutputOptions
+		outputOptions.Format = getOutputFormat(file.Name())
 		outputPath := filepath.Join(finalOutput, file.Name())
+		if outputOptions.Format != imgutil.NormalizeFormat(filepath.Ext(file.Name())) {
+			outputPath = strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + "." + outputOptions.Format
+		}
 
 		if !flagForce {
 			if _, err := os.Stat(outputPath); err == nil {
@@ -84,10 +242,23 @@ func crop(cmd *cobra.Command, args []string) error {
 			}
 		}
 
-		if err := processImageFile(inputPath, outputPath, flagWidth, flagHeight); err != nil {
+		result, err := processImageFile(inputPath, outputPath, targetSize, boxes, &outputOptions)
+		if err != nil {
 			fmt.Printf("Failed to process %s: %v\n", inputPath, err)
 			errorCnt++
+			continue
+		}
+		results = append(results, result)
+	}
+	reportFile := flagReport
+	if reportFile == "" && (buckets != nil || (flagAnchor != ANCHOR_SMART && flagAnchor != ANCHOR_CENTER)) {
+		reportFile = filepath.Join(finalOutput, REPORT_FILENAME)
+	}
+	if reportFile != "" {
+		if err := writeReport(reportFile, results); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
 		}
+		fmt.Printf("Report written to %s\n", reportFile)
 	}
 	if errorCnt > 0 {
 		return fmt.Errorf("%d errors", errorCnt)
@@ -96,13 +267,19 @@ func crop(cmd *cobra.Command, args []string) error {
 }
 
 func isProcessableImage(filename string) bool {
-	ext := strings.ToLower(filepath.Ext(filename))
-	switch ext {
-	case ".jpg", ".jpeg", ".png":
-		return true
-	default:
-		return false
+	return slices.Contains(imgutil.InputExts, strings.ToLower(filepath.Ext(filename)))
+}
+
+// Return the output format of an input file: --format, or input format if it's png / jpg / webp, or png.
+func getOutputFormat(filename string) string {
+	if flagFormat != "" {
+		return flagFormat
 	}
+	switch format := imgutil.NormalizeFormat(filepath.Ext(filename)); format {
+	case imgutil.FORMAT_PNG, imgutil.FORMAT_JPG, imgutil.FORMAT_WEBP:
+		return format
+	}
+	return imgutil.FORMAT_PNG
 }
 
 type resizer struct{}
@@ -112,52 +289,41 @@ func (r resizer) Resize(img image.Image, width, height uint) image.Image {
 	return imaging.Resize(img, int(width), int(height), imaging.Lanczos)
 }
 
-func processImageFile(inputPath, outputPath string, width, height int) (err error) {
-	file, err := os.Open(inputPath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	// -----------------------------------------------------------------
-	// START: EXIF Orientation Fix
-	// -----------------------------------------------------------------
-
-	// 1. Read EXIF data first
-	x, err := exif.Decode(file)
-	var orientation int
-	if err == nil { // If EXIF data exists
-		tag, err := x.Get(exif.Orientation)
-		if err == nil { // If Orientation tag exists
-			orientation, _ = tag.Int(0) // Get the orientation value
-		}
-	}
+// Crop result of an image.
+type cropResult struct {
+	Input        string
+	Output       string
+	Width        int // original size
+	Height       int
+	TargetWidth  int
+	TargetHeight int
+	Crop         image.Rectangle
+	Anchor       string          // the actually used anchor source
+	Subject      image.Rectangle // subject box. Empty if not used
+	Upscaled     bool            // crop area is smaller than target size
+	Skipped      bool            // skipped by upscale guard
+}
 
-	// 2. Rewind the file to read it again for image decoding
-	_, err = file.Seek(0, 0)
+// Crop and resize image. targetSize returns the target resolution of the original image size.
+// boxes is the subject boxes of "sidecar" anchor, can be nil.
+// The output image is encoded using outputOptions.
+func processImageFile(inputPath, outputPath string, targetSize func(width, height int) (int, int),
+	boxes map[string]*subjectBox, outputOptions *imgutil.Options) (result *cropResult, err error) {
+	data, err := os.ReadFile(inputPath)
 	if err != nil {
-		return fmt.Errorf("failed to rewind file: %w", err)
+		return nil, err
 	}
-
-	// 3. Decode the image (and get its format)
-	img, imgFormat, err := image.Decode(file)
+	// Decode the image, EXIF orientation is applied
+	img, _, err := imgutil.Decode(data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// 4. Apply rotation IF it's a JPEG and has an orientation tag
-	if imgFormat == "jpeg" && orientation > 1 {
-		img = applyExifOrientation(img, orientation)
-	}
-
-	// -----------------------------------------------------------------
-	// END: EXIF Orientation Fix
-	// -----------------------------------------------------------------
-
 	// Calculate crop size
-	targetRatio := float64(width) / float64(height)
 	imgWidth := img.Bounds().Dx()
 	imgHeight := img.Bounds().Dy()
+	width, height := targetSize(imgWidth, imgHeight)
+	targetRatio := float64(width) / float64(height)
 	imgRatio := float64(imgWidth) / float64(imgHeight)
 
 	var cropWidth, cropHeight int
@@ -169,21 +335,56 @@ func processImageFile(inputPath, outputPath string, width, height int) (err erro
 		cropHeight = int(float64(imgWidth) / targetRatio)
 	}
 
+	result = &cropResult{
+		Input:        inputPath,
+		Output:       outputPath,
+		Width:        imgWidth,
+		Height:       imgHeight,
+		TargetWidth:  width,
+		TargetHeight: height,
+		Upscaled:     cropWidth < width || cropHeight < height,
+	}
+	if result.Upscaled {
+		switch flagUpscale {
+		case UPSCALE_SKIP:
+			fmt.Printf("Skipping %s, it's smaller than target %dx%d\n", inputPath, width, height)
+			result.Skipped = true
+			return result, nil
+		case UPSCALE_FLAG:
+			fmt.Printf("Warning: %s is smaller than target %dx%d, upscaling it\n", inputPath, width, height)
+		}
+	}
+
+	result.Anchor = flagAnchor
+	if flagAnchor != ANCHOR_SMART && flagAnchor != ANCHOR_CENTER {
+		if result.Subject, err = findSubject(flagAnchor, inputPath, img, boxes); err != nil {
+			return nil, err
+		}
+		if result.Subject.Empty() {
+			fmt.Printf("Warning: subject of %s not found, fallback to smartcrop\n", inputPath)
+			result.Anchor = ANCHOR_SMART
+		}
+	}
+
 	var topCrop image.Rectangle
-	if flagCenter {
+	switch result.Anchor {
+	case ANCHOR_CENTER:
 		topCrop = image.Rect(
 			(imgWidth-cropWidth)/2,
 			(imgHeight-cropHeight)/2,
 			(imgWidth+cropWidth)/2,
 			(imgHeight+cropHeight)/2,
 		)
-	} else {
+	case ANCHOR_SMART:
 		analyzer := smartcrop.NewAnalyzer(resizer{})
 		topCrop, err = analyzer.FindBestCrop(img, cropWidth, cropHeight)
 		if err != nil {
-			return err
+			return nil, err
 		}
+	default:
+		topCrop = subjectCrop(result.Subject, imgWidth, imgHeight, cropWidth, cropHeight)
 	}
+	result.Crop = topCrop
 
 	type subImager interface {
 		SubImage(r image.Rectangle) image.Image
@@ -194,52 +395,18 @@ func processImageFile(inputPath, outputPath string, width, height int) (err erro
 	// Use imaging.Resize for the final resize
 	resizedImg := imaging.Resize(croppedImg, width, height, imaging.Lanczos)
 
-	// -----------------------------------------------------------------
-	// START: Corrected Save Logic
-	// -----------------------------------------------------------------
-
-	// Use imaging.Save, passing the image and the *path string*.
-	ext := strings.ToLower(filepath.Ext(outputPath))
-	switch ext {
-	case ".jpg", ".jpeg":
-		// Correct signature: imaging.Save(image, path, ...options)
-		err = imaging.Save(resizedImg, outputPath, imaging.JPEGQuality(95))
-	case ".png":
-		// Correct signature: imaging.Save(image, path, ...options)
-		err = imaging.Save(resizedImg, outputPath, imaging.PNGCompressionLevel(png.DefaultCompression))
-	default:
-		return fmt.Errorf("unsupported image format: %s", ext)
+	var exif []byte
+	if flagMetadata == METADATA_KEEP {
+		exif = imgutil.ExtractExif(data)
 	}
-
-	// -----------------------------------------------------------------
-	// END: Corrected Save Logic
-	// -----------------------------------------------------------------
-
-	if err == nil {
-		fmt.Printf("Successfully cropped and resized %s to %s\n", inputPath, outputPath)
+	buf := &bytes.Buffer{}
+	if err = imgutil.Encode(buf, resizedImg, exif, outputOptions); err != nil {
+		return nil, err
 	}
-
-	return err
-}
-
-// applyExifOrientation checks for an EXIF orientation tag and rotates the image accordingly.
-func applyExifOrientation(img image.Image, orientation int) image.Image {
-	switch orientation {
-	case 2: // F: Horizontal Flip
-		return imaging.FlipH(img)
-	case 3: // R180: Rotate 180
-		return imaging.Rotate180(img)
-	case 4: // FV: Vertical Flip
-		return imaging.FlipV(img)
-	case 5: // T: Transpose (FlipH + R270)
-		return imaging.Transpose(img)
-	case 6: // R270: Rotate 270 (or 90 clockwise)
-		return imaging.Rotate270(img)
-	case 7: // TV: Transverse (FlipV + R270)
-		return imaging.Transverse(img)
-	case 8: // R90: Rotate 90 (or 270 clockwise)
-		return imaging.Rotate90(img)
-	default: // 1 or unknown
-		return img
+	err = atomic.WriteFile(outputPath, buf)
+	if err != nil {
+		return nil, err
 	}
+	fmt.Printf("Successfully cropped and resized %s to %s (%dx%d)\n", inputPath, outputPath, width, height)
+	return result, nil
 }
@@ -3,10 +3,8 @@ package query
 import (
 	"bytes"
 	"database/sql"
-	"encoding/csv"
 	"fmt"
 	"io"
-	"sort"
 	"strings"
 
 	"github.com/jmoiron/sqlx"
@@ -16,6 +14,7 @@ import (
 
 	csvCmd "github.com/sagan/goaider/cmd/csv"
 	"github.com/sagan/goaider/constants"
+	"github.com/sagan/goaider/features/csvfeature"
 	"github.com/sagan/goaider/util"
 	"github.com/sagan/goaider/util/helper"
 	"github.com/sagan/goaider/util/stringutil"
@@ -87,7 +86,7 @@ func query(cmd *cobra.Command, args []string) (err error) {
 		if flagText {
 			err = writeSqlRowsToText(rows, writer, flagTemplate, flagOneLine)
 		} else {
-			err = writeSqlRowsToCsv(rows, writer, csvCmd.FlagNoHeader, flagOneLine)
+			err = csvfeature.WriteSqlRowsToCsv(rows, writer, csvCmd.FlagNoHeader, flagOneLine)
 		}
 		writer.CloseWithError(err)
 	}()
@@ -117,105 +116,6 @@ func init() {
 			`It replaces one or more consecutive newline characters (\r, \n) with single space`)
 }
 
-// writeSqlRowsToCsv writes the result of a sql query to a CSV writer.
-// It sorts the columns alphabetically by name in the output.
-func writeSqlRowsToCsv(rows *sql.Rows, csvOutput io.Writer, noHeader bool, oneLine bool) error {
-	// 1. Get the original column names from the query result
-	cols, err := rows.Columns()
-	if err != nil {
-		return fmt.Errorf("failed to get columns: %w", err)
-	}
-
-	// 2. Create a mapping structure to track the original index of each column
-	type colMap struct {
-		Name      string
-		OrigIndex int
-	}
-
-	mapping := make([]colMap, len(cols))
-	for i, name := range cols {
-		mapping[i] = colMap{
-			Name:      name,
-			OrigIndex: i,
-		}
-	}
-
-	// 3. Sort the mapping slice alphabetically by column Name
-	sort.Slice(mapping, func(i, j int) bool {
-		return mapping[i].Name < mapping[j].Name
-	})
-
-	// 4. Set up the CSV writer
-	writer := csv.NewWriter(csvOutput)
-	defer writer.Flush()
-
-	// 5. Write the Header (using the sorted order)
-	if !noHeader {
-		header := make([]string, len(cols))
-		for i, m := range mapping {
-			header[i] = m.Name
-		}
-		if err := writer.Write(header); err != nil {
-			return fmt.Errorf("failed to write header: %w", err)
-		}
-	}
-
-	// 6. Prepare buffers for scanning rows
-	// values: holds the data scanned from the database (in original order)
-	// scanArgs: pointers to 'values' required by rows.Scan
-	values := make([]any, len(cols))
-	scanArgs := make([]any, len(cols))
-	for i := range values {
-		scanArgs[i] = &values[i]
-	}
-
-	// Buffer to hold the reordered string data for the CSV writer
-	rowString := make([]string, len(cols))
-
-	// 7. Iterate through the rows
-	for rows.Next() {
-		if err := rows.Scan(scanArgs...); err != nil {
-			return fmt.Errorf("failed to scan row: %w", err)
-		}
-
-		// Map the raw data from original index -> sorted index
-		for sortedIdx, m := range mapping {
-			val := values[m.OrigIndex]
-
-			// Convert interface{} to string
-			var strVal string
-			if val != nil {
-				switch v := val.(type) {
-				case []byte:
-					// Handle byte slices (common for strings/blobs in some drivers)
-					strVal = string(v)
-				default:
-					// generic string representation for ints, floats, dates, etc.
-					strVal = fmt.Sprintf("%v", v)
-				}
-			}
-			// If val is nil, strVal remains "" (empty string)
-
-			if oneLine {
-				strVal = stringutil.ReplaceNewLinesWithSpace(strVal)
-			}
-			rowString[sortedIdx] = strVal
-		}
-
-		// Write the sorted row to CSV
-		if err := writer.Write(rowString); err != nil {
-			return fmt.Errorf("failed to write row: %w", err)
-		}
-	}
-
-	// Check for errors that occurred during iteration
-	if err := rows.Err(); err != nil {
-		return fmt.Errorf("error iterating rows: %w", err)
-	}
-
-	return nil
-}
-
 // writeSqlRowsToText writes rows to output as plain text.
 // Each row is a line formatted by the provided template string.
 // If the rendered line is empty (after trimming), the row is skipped.
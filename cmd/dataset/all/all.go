@@ -0,0 +1,7 @@
+package all
+
+import (
+	_ "github.com/sagan/goaider/cmd/dataset"
+	_ "github.com/sagan/goaider/cmd/dataset/check"
+	_ "github.com/sagan/goaider/cmd/dataset/export"
+)
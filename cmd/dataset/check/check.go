@@ -0,0 +1,163 @@
+package check
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/sagan/goaider/cmd/dataset"
+	"github.com/sagan/goaider/constants"
+	"github.com/sagan/goaider/features/batchfeature"
+	"github.com/sagan/goaider/features/datasetfeature"
+	"github.com/sagan/goaider/util"
+)
+
+const (
+	FORMAT_CSV  = "csv"
+	FORMAT_JSON = "json"
+)
+
+var checkCmd = &cobra.Command{
+	Use:   "check {dir}",
+	Short: "Check a dataset dir for problems of image-caption / audio-transcript pairs",
+	Long: `Check a dataset dir for problems of image-caption / audio-transcript pairs.
+
+A dataset item is an image / audio file and it's caption / transcript .txt file of same name,
+e.g. "foo.png" and "foo.txt", as generated by "crop", "caption" and "stt" commands.
+
+Issue codes:
+- missing_caption: image / audio file has no caption file.
+- orphan_caption: caption file has no image / audio file.
+- empty_caption: caption is empty.
+- long_caption: caption exceeds --max-length chars or --max-tokens (approximate CLIP) tokens.
+- non_utf8: caption file is not UTF-8 text (the detected charset is reported), or has UTF-8 BOM.
+- low_resolution: image is smaller than --min-width x --min-height.
+- duration: audio duration is out of --min-duration - --max-duration range. Requires ffprobe.
+- invalid_media: image / audio file can not be decoded / parsed.
+- read_error: file can not be read.
+
+The issues report is written to --output (default stdout) as CSV (columns: file, code, message) or JSON.
+It exits with non-zero code if any issue is found.
+
+Examples:
+  goaider dataset check ./images-crop
+  goaider dataset check ./images-crop --min-width 1024 --min-height 1024 --format json -o issues.json
+  goaider dataset check ./audios -r --min-duration 3 --max-duration 10`,
+	Args: cobra.ExactArgs(1),
+	RunE: doCheck,
+}
+
+var (
+	flagOutput      string
+	flagFormat      string
+	flagMaxLength   int
+	flagMaxTokens   int
+	flagMinWidth    int
+	flagMinHeight   int
+	flagMinDuration float64
+	flagMaxDuration float64
+	flagConcurrency int
+	flagRecursive   bool
+	flagMaxDepth    int
+	flagIncludes    []string
+	flagExcludes    []string
+)
+
+func init() {
+	checkCmd.Flags().StringVarP(&flagOutput, "output", "o", "-", `Output report file. "-" == stdout`)
+	checkCmd.Flags().StringVarP(&flagFormat, "format", "f", FORMAT_CSV, `Output report format: "csv" or "json"`)
+	checkCmd.Flags().IntVarP(&flagMaxLength, "max-length", "", 0, "Max caption length (chars). 0 == unlimited")
+	checkCmd.Flags().IntVarP(&flagMaxTokens, "max-tokens", "", 225,
+		"Max caption tokens (approximate CLIP tokens, each word / punctuation is a token). 0 == unlimited")
+	checkCmd.Flags().IntVarP(&flagMinWidth, "min-width", "", 512, "Min image width. 0 == unlimited")
+	checkCmd.Flags().IntVarP(&flagMinHeight, "min-height", "", 512, "Min image height. 0 == unlimited")
+	checkCmd.Flags().Float64VarP(&flagMinDuration, "min-duration", "", 0,
+		"Min audio duration (seconds). 0 == unlimited")
+	checkCmd.Flags().Float64VarP(&flagMaxDuration, "max-duration", "", 0,
+		"Max audio duration (seconds). 0 == unlimited")
+	checkCmd.Flags().IntVarP(&flagConcurrency, "concurrency", "", runtime.NumCPU(),
+		"Number of files to check concurrently")
+	checkCmd.Flags().BoolVarP(&flagRecursive, "recursive", "r", false, constants.HELP_BATCH_RECURSIVE_FLAG)
+	checkCmd.Flags().IntVarP(&flagMaxDepth, "max-depth", "", 0, constants.HELP_BATCH_MAX_DEPTH_FLAG)
+	checkCmd.Flags().StringArrayVarP(&flagIncludes, "include", "", nil, constants.HELP_BATCH_INCLUDE_FLAG)
+	checkCmd.Flags().StringArrayVarP(&flagExcludes, "exclude", "", nil, constants.HELP_BATCH_EXCLUDE_FLAG)
+	dataset.DatasetCmd.AddCommand(checkCmd)
+}
+
+func doCheck(cmd *cobra.Command, args []string) error {
+	if flagFormat != FORMAT_CSV && flagFormat != FORMAT_JSON {
+		return fmt.Errorf("invalid format %q", flagFormat)
+	}
+	files, err := batchfeature.SelectInputs(&batchfeature.InputOptions{
+		Dir:       args[0],
+		Recursive: flagRecursive,
+		MaxDepth:  flagMaxDepth,
+		Includes:  flagIncludes,
+		Excludes:  flagExcludes,
+	}, cmd.InOrStdin())
+	if err != nil {
+		return err
+	}
+	items, orphans := datasetfeature.FindPairs(files)
+	log.Printf("Checking %d items", len(items))
+	issues := datasetfeature.Check(items, orphans, &datasetfeature.CheckOptions{
+		MaxLength:   flagMaxLength,
+		MaxTokens:   flagMaxTokens,
+		MinWidth:    flagMinWidth,
+		MinHeight:   flagMinHeight,
+		MinDuration: flagMinDuration,
+		MaxDuration: flagMaxDuration,
+		Concurrency: flagConcurrency,
+	})
+
+	output := cmd.OutOrStdout()
+	if flagOutput != "-" {
+		f, err := os.Create(flagOutput)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		output = f
+	}
+	if err := writeReport(output, issues); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+
+	if len(issues) > 0 {
+		counts := map[string]int{}
+		for _, issue := range issues {
+			counts[issue.Code]++
+		}
+		for _, code := range util.Keys(counts) {
+			log.Printf("%s: %d", code, counts[code])
+		}
+		return fmt.Errorf("%d issues found in %d items", len(issues), len(items))
+	}
+	log.Printf("No issues found in %d items", len(items))
+	return nil
+}
+
+func writeReport(output io.Writer, issues []*datasetfeature.Issue) error {
+	if flagFormat == FORMAT_JSON {
+		if issues == nil {
+			issues = []*datasetfeature.Issue{}
+		}
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		return encoder.Encode(issues)
+	}
+	writer := csv.NewWriter(output)
+	writer.Write([]string{"file", "code", "message"})
+	for _, issue := range issues {
+		writer.Write([]string{issue.File, issue.Code, issue.Message})
+	}
+	writer.Flush()
+	return writer.Error()
+}
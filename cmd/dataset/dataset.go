@@ -0,0 +1,16 @@
+package dataset
+
+import (
+	"github.com/sagan/goaider/cmd"
+	"github.com/spf13/cobra"
+)
+
+var DatasetCmd = &cobra.Command{
+	Use:   "dataset",
+	Short: "Training dataset (image-caption / audio-transcript pairs) related actions",
+	Long:  `Training dataset (image-caption / audio-transcript pairs) related actions.`,
+}
+
+func init() {
+	cmd.RootCmd.AddCommand(DatasetCmd)
+}
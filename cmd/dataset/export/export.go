@@ -0,0 +1,450 @@
+package export
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/natefinch/atomic"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/sagan/goaider/cmd/dataset"
+	"github.com/sagan/goaider/cmd/indexfiles"
+	"github.com/sagan/goaider/constants"
+	"github.com/sagan/goaider/features/datasetfeature"
+	"github.com/sagan/goaider/features/mediainfo"
+	"github.com/sagan/goaider/util"
+	"github.com/sagan/goaider/util/stringutil"
+)
+
+const (
+	FORMAT_HF         = "hf"
+	FORMAT_WEBDATASET = "webdataset"
+	FORMAT_PARQUET    = "parquet"
+)
+
+const (
+	HF_METADATA_FILENAME = "metadata.jsonl"
+	DEFAULT_COLUMNS      = "size,sha256,media_width,media_height,media_duration"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export {dir}",
+	Short: "Export a dataset dir to Hugging Face / webdataset / Parquet formats",
+	Long: `Export a dataset dir to Hugging Face / webdataset / Parquet formats.
+
+A dataset item is an image / audio file and it's caption / transcript .txt file of same name,
+e.g. "foo.png" and "foo.txt". Items without caption file are skipped.
+
+Formats (--format):
+- hf: write a "metadata.jsonl" file for the Hugging Face "imagefolder" / "audiofolder" loader.
+  Each line is {"file_name": "foo.png", "text": "<caption>", ...metadata columns}.
+  Default output: "<dir>/metadata.jsonl". The output file must be in dir or it's ancestor dir.
+- webdataset: write webdataset .tar shards "shard-000000.tar", "shard-000001.tar"... to output dir.
+  Each sample has "<key>.<ext>" (media), "<key>.txt" (caption) and "<key>.json" (metadata columns) files.
+  Default output: "<dir>-wds".
+- parquet: write Parquet files "train-00000-of-00001.parquet"... to output dir, with embedded media file bytes,
+  in the Hugging Face datasets layout: an "image" / "audio" column ({bytes, path} struct),
+  a "text" column and metadata columns. All items must be of the same kind (image or audio).
+  Default output: "<dir>-parquet".
+
+Metadata columns (--columns) are the "indexfiles" command FileInfo fields (json tag names), e.g.
+"path", "size", "mtime", "sha256", "media_width", "media_height", "media_duration".
+
+Examples:
+  goaider dataset export ./images-crop
+  goaider dataset export ./images-crop --format webdataset --shard-size 5000 -o ./wds
+  goaider dataset export ./audios -r --format parquet --columns size,media_duration`,
+	Args: cobra.ExactArgs(1),
+	RunE: doExport,
+}
+
+var (
+	flagFormat    string
+	flagOutput    string
+	flagColumns   []string
+	flagShardSize int
+	flagRecursive bool
+	flagForce     bool
+)
+
+func init() {
+	exportCmd.Flags().StringVarP(&flagFormat, "format", "", FORMAT_HF,
+		`Export format: "hf" (metadata.jsonl), "webdataset" (.tar shards) or "parquet"`)
+	exportCmd.Flags().StringVarP(&flagOutput, "output", "o", "",
+		`Output file ("hf" format, "-" == stdout) or dir ("webdataset" / "parquet" format). See help for defaults`)
+	exportCmd.Flags().StringSliceVarP(&flagColumns, "columns", "", strings.Split(DEFAULT_COLUMNS, ","),
+		`Comma-separated metadata columns (indexfiles FileInfo json tag names). Set to "" to disable`)
+	exportCmd.Flags().IntVarP(&flagShardSize, "shard-size", "", 1000,
+		`Max number of samples per .tar shard / parquet file. 0 == unlimited`)
+	exportCmd.Flags().BoolVarP(&flagRecursive, "recursive", "r", false, constants.HELP_BATCH_RECURSIVE_FLAG)
+	exportCmd.Flags().BoolVarP(&flagForce, "force", "", false, "Force overwriting existing output files")
+	dataset.DatasetCmd.AddCommand(exportCmd)
+}
+
+// A dataset item to export.
+type sample struct {
+	item    *datasetfeature.Item
+	info    *indexfiles.FileInfo // media file info
+	caption string
+}
+
+func doExport(cmd *cobra.Command, args []string) (err error) {
+	dir := args[0]
+	if flagShardSize < 0 {
+		return fmt.Errorf("invalid shard size %d", flagShardSize)
+	}
+	columns := slices.DeleteFunc(slices.Clone(flagColumns), func(s string) bool { return s == "" })
+	validColumns := slices.DeleteFunc(util.Values(util.GetAllJSONTags(&indexfiles.FileInfo{})), func(s string) bool {
+		return s == "data" || strings.HasPrefix(s, "data_")
+	})
+	for _, column := range columns {
+		if !slices.Contains(validColumns, column) {
+			return fmt.Errorf("invalid column %q", column)
+		}
+		if column == "text" || column == "file_name" || column == "image" || column == "audio" {
+			return fmt.Errorf("column %q is reserved", column)
+		}
+	}
+	if util.HasDuplicates(columns) {
+		return fmt.Errorf("--columns flag has duplicate value(s)")
+	}
+	parseMedia := slices.ContainsFunc(columns, func(c string) bool { return strings.HasPrefix(c, "media_") })
+	if parseMedia {
+		mediainfo.Init()
+	}
+
+	var output string
+	switch flagFormat {
+	case FORMAT_HF:
+		output = util.FirstNonZeroArg(flagOutput, filepath.Join(dir, HF_METADATA_FILENAME))
+	case FORMAT_WEBDATASET:
+		output = util.FirstNonZeroArg(flagOutput, filepath.Clean(dir)+"-wds")
+	case FORMAT_PARQUET:
+		output = util.FirstNonZeroArg(flagOutput, filepath.Clean(dir)+"-parquet")
+	default:
+		return fmt.Errorf("invalid format %q", flagFormat)
+	}
+	if output == "-" && flagFormat != FORMAT_HF {
+		return fmt.Errorf(`output to stdout is only supported by "hf" format`)
+	}
+	if output != "-" && !flagForce {
+		if exists, err := util.FileExists(output); err != nil || exists {
+			return fmt.Errorf("output %q exists or can't access, err=%w", output, err)
+		}
+	}
+
+	filelist, err := indexfiles.Index(dir, indexfiles.IndexOptions{
+		NoRecursive: !flagRecursive,
+		MaxDepth:    -1,
+		NoHash: !slices.ContainsFunc(columns, func(c string) bool {
+			return c == "md5" || c == "sha1" || c == "sha256"
+		}),
+		ParseMedia: parseMedia,
+	})
+	if err != nil {
+		return err
+	}
+	infos := map[string]*indexfiles.FileInfo{}
+	var files []string
+	for _, info := range filelist {
+		file := filepath.Join(dir, filepath.FromSlash(info.Path))
+		infos[file] = info
+		files = append(files, file)
+	}
+	items, _ := datasetfeature.FindPairs(files)
+	var samples []*sample
+	for _, item := range items {
+		if item.Caption == "" {
+			log.Warnf("Skip %q: no caption file", item.Media)
+			continue
+		}
+		caption, err := readCaption(item.Caption)
+		if err != nil {
+			return fmt.Errorf("failed to read caption %q: %w", item.Caption, err)
+		}
+		samples = append(samples, &sample{item: item, info: infos[item.Media], caption: caption})
+	}
+	if len(samples) == 0 {
+		return fmt.Errorf("no dataset item found in %q", dir)
+	}
+	log.Printf("Exporting %d items", len(samples))
+
+	switch flagFormat {
+	case FORMAT_HF:
+		err = exportHf(cmd.OutOrStdout(), dir, output, samples, columns)
+	case FORMAT_WEBDATASET:
+		err = exportWebdataset(output, samples, columns)
+	case FORMAT_PARQUET:
+		err = exportParquet(output, samples, columns)
+	}
+	if err != nil {
+		return err
+	}
+	if output != "-" {
+		log.Printf("Exported to %q", output)
+	}
+	return nil
+}
+
+func exportHf(stdout io.Writer, dir string, output string, samples []*sample, columns []string) error {
+	// file_name is relative to the dir of metadata.jsonl
+	metadataDir := dir
+	if output != "-" {
+		metadataDir = filepath.Dir(output)
+	}
+	buf := &bytes.Buffer{}
+	for _, s := range samples {
+		filename, err := filepath.Rel(metadataDir, s.item.Media)
+		if err != nil || filename == ".." || strings.HasPrefix(filename, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("%q is not under the dir of output %q", s.item.Media, output)
+		}
+		// Use a manually ordered object so that file_name and text are the first keys.
+		line := &bytes.Buffer{}
+		line.WriteString("{")
+		writeJsonField(line, "file_name", filepath.ToSlash(filename), true)
+		writeJsonField(line, "text", s.caption, false)
+		for _, column := range columns {
+			writeJsonField(line, column, getColumn(s.info, column), false)
+		}
+		line.WriteString("}\n")
+		buf.Write(line.Bytes())
+	}
+	if output == "-" {
+		_, err := io.Copy(stdout, buf)
+		return err
+	}
+	return atomic.WriteFile(output, buf)
+}
+
+func writeJsonField(buf *bytes.Buffer, key string, value any, first bool) {
+	if !first {
+		buf.WriteString(",")
+	}
+	data, _ := json.Marshal(key)
+	buf.Write(data)
+	buf.WriteString(":")
+	data, _ = json.Marshal(value)
+	buf.Write(data)
+}
+
+func exportWebdataset(output string, samples []*sample, columns []string) error {
+	if err := os.MkdirAll(output, 0755); err != nil {
+		return err
+	}
+	shards := splitShards(samples)
+	for i, shard := range shards {
+		filename := filepath.Join(output, fmt.Sprintf("shard-%06d.tar", i))
+		if err := writeTarShard(filename, shard, columns); err != nil {
+			return fmt.Errorf("failed to write shard %q: %w", filename, err)
+		}
+		log.Printf("Wrote %q (%d samples)", filename, len(shard))
+	}
+	return nil
+}
+
+func writeTarShard(filename string, samples []*sample, columns []string) (err error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	writer := tar.NewWriter(file)
+	for _, s := range samples {
+		// webdataset splits sample key and file ext at the first dot of filename.
+		key := strings.TrimSuffix(s.info.Path, path.Ext(s.info.Path))
+		dirname, basename := path.Split(key)
+		key = dirname + strings.ReplaceAll(basename, ".", "_")
+		metadata := map[string]any{}
+		for _, column := range columns {
+			metadata[column] = getColumn(s.info, column)
+		}
+		metadataJson, err := json.Marshal(metadata)
+		if err != nil {
+			return err
+		}
+		mtime := s.info.Mtime
+		if err := writeTarFile(writer, key+strings.ToLower(s.info.Ext), mtime, s.info.Size,
+			func(w io.Writer) error {
+				f, err := os.Open(s.item.Media)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				_, err = io.CopyN(w, f, s.info.Size)
+				return err
+			}); err != nil {
+			return err
+		}
+		for _, f := range []struct {
+			ext  string
+			data []byte
+		}{{datasetfeature.CAPTION_EXT, []byte(s.caption)}, {".json", metadataJson}} {
+			if err := writeTarFile(writer, key+f.ext, mtime, int64(len(f.data)), func(w io.Writer) error {
+				_, err := w.Write(f.data)
+				return err
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return writer.Close()
+}
+
+func writeTarFile(writer *tar.Writer, name string, mtime time.Time, size int64, write func(io.Writer) error) error {
+	if err := writer.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  mtime,
+		Format:   tar.FormatPAX,
+	}); err != nil {
+		return err
+	}
+	return write(writer)
+}
+
+func exportParquet(output string, samples []*sample, columns []string) error {
+	kind := samples[0].item.Kind
+	for _, s := range samples {
+		if s.item.Kind != kind {
+			return fmt.Errorf("parquet format requires all items of same kind, but found both %s and %s files",
+				kind, s.item.Kind)
+		}
+	}
+	if err := os.MkdirAll(output, 0755); err != nil {
+		return err
+	}
+	shards := splitShards(samples)
+	for i, shard := range shards {
+		filename := filepath.Join(output, fmt.Sprintf("train-%05d-of-%05d.parquet", i, len(shards)))
+		if err := writeParquetFile(filename, kind, shard, columns); err != nil {
+			return fmt.Errorf("failed to write %q: %w", filename, err)
+		}
+		log.Printf("Wrote %q (%d rows)", filename, len(shard))
+	}
+	return nil
+}
+
+func writeParquetFile(filename string, kind string, samples []*sample, columns []string) (err error) {
+	var parquetColumns []*datasetfeature.ParquetColumn
+	parquetColumns = append(parquetColumns, &datasetfeature.ParquetColumn{
+		Path: []string{kind, "bytes"},
+		Type: datasetfeature.PARQUET_BYTE_ARRAY,
+		Value: func(row int) (any, error) {
+			return os.ReadFile(samples[row].item.Media)
+		},
+	}, &datasetfeature.ParquetColumn{
+		Path:   []string{kind, "path"},
+		Type:   datasetfeature.PARQUET_BYTE_ARRAY,
+		String: true,
+		Value: func(row int) (any, error) {
+			return samples[row].info.Path, nil
+		},
+	}, &datasetfeature.ParquetColumn{
+		Path:   []string{"text"},
+		Type:   datasetfeature.PARQUET_BYTE_ARRAY,
+		String: true,
+		Value: func(row int) (any, error) {
+			return samples[row].caption, nil
+		},
+	})
+	// Hugging Face datasets features, in columns order
+	features := &bytes.Buffer{}
+	features.WriteString("{")
+	writeJsonField(features, kind, map[string]string{"_type": strings.ToUpper(kind[:1]) + kind[1:]}, true)
+	writeJsonField(features, "text", map[string]string{"dtype": "string", "_type": "Value"}, false)
+	for _, column := range columns {
+		column := column
+		parquetColumn := &datasetfeature.ParquetColumn{
+			Path: []string{column},
+			Value: func(row int) (any, error) {
+				return getColumn(samples[row].info, column), nil
+			},
+		}
+		dtype := "string"
+		if _, ok := getColumn(&indexfiles.FileInfo{}, column).(int64); ok {
+			parquetColumn.Type = datasetfeature.PARQUET_INT64
+			dtype = "int64"
+		} else {
+			parquetColumn.Type = datasetfeature.PARQUET_BYTE_ARRAY
+			parquetColumn.String = true
+		}
+		parquetColumns = append(parquetColumns, parquetColumn)
+		writeJsonField(features, column, map[string]string{"dtype": dtype, "_type": "Value"}, false)
+	}
+	features.WriteString("}")
+	metadata := map[string]string{
+		"huggingface": fmt.Sprintf(`{"info":{"features":%s}}`, features.String()),
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	return datasetfeature.WriteParquet(file, parquetColumns, len(samples), metadata)
+}
+
+// Split samples to shards of at most flagShardSize samples.
+func splitShards(samples []*sample) (shards [][]*sample) {
+	if flagShardSize == 0 {
+		return [][]*sample{samples}
+	}
+	return slices.Collect(slices.Chunk(samples, flagShardSize))
+}
+
+// Return the value of FileInfo field of json tag column.
+// Integers are returned as int64, time.Time as formatted string, others as is.
+func getColumn(info *indexfiles.FileInfo, column string) any {
+	for name, tag := range util.GetAllJSONTags(info) {
+		if tag != column {
+			continue
+		}
+		value := reflect.ValueOf(info).Elem().FieldByName(name)
+		switch value.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return value.Int()
+		}
+		if t, ok := value.Interface().(time.Time); ok {
+			if t.IsZero() {
+				return ""
+			}
+			return t.UTC().Format(constants.TIME_FORMAT)
+		}
+		return value.Interface()
+	}
+	return nil
+}
+
+func readCaption(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(stringutil.GetTextReader(f))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
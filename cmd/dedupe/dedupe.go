@@ -0,0 +1,383 @@
+package dedupe
+
+import (
+	"encoding/csv"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/sagan/goaider/cmd"
+	"github.com/sagan/goaider/constants"
+	"github.com/sagan/goaider/features/batchfeature"
+	"github.com/sagan/goaider/util/imgutil"
+)
+
+var (
+	flagHash        string
+	flagThreshold   int
+	flagKeep        string
+	flagAction      string
+	flagAsideDir    string
+	flagOutput      string
+	flagDryRun      bool
+	flagConcurrency int
+	flagListFile    string
+	flagCsvColumn   string
+	flagRecursive   bool
+	flagMaxDepth    int
+	flagIncludes    []string
+	flagExcludes    []string
+)
+
+const (
+	KEEP_RESOLUTION = "resolution" // keep the image of largest resolution (pixels)
+	KEEP_SIZE       = "size"       // keep the largest file
+	KEEP_MTIME      = "mtime"      // keep the earliest modified file (the original)
+
+	ACTION_REPORT   = "report"   // only output clusters CSV
+	ACTION_MOVE     = "move"     // move non-keeper files to aside dir
+	ACTION_HARDLINK = "hardlink" // hardlink non-keeper files to aside dir
+)
+
+var dedupeCmd = &cobra.Command{
+	Use:   "dedupe [dir]",
+	Args:  cobra.MaximumNArgs(1),
+	Short: "Find near-duplicate images in a directory using perceptual hashes",
+	Long: `Find near-duplicate images in a directory using perceptual hashes.
+
+It computes the perceptual hash (--hash "phash" or "dhash") of each image, and clusters images
+which hash Hamming distance (0-64) is within --threshold. So resized, recompressed or slightly cropped
+copies of an image are found, which are not pixel-identical.
+
+In each cluster, one keeper image is chosen by --keep, and every other image of the cluster
+is within --threshold of the keeper. So images that are only similar through a chain of other images
+(A ~ B ~ C, but A !~ C) are not put into the same cluster. The keeper is chosen by:
+- "resolution" (default): the image of largest resolution.
+- "size": the largest file.
+- "mtime": the earliest modified file (the original).
+Ties are broken by the other criteria, then by file path.
+
+The clusters (of 2 or more images) are written to --output CSV (default stdout), with columns:
+cluster, file, keeper, distance (to keeper), width, height, size, mtime, hash.
+
+Use --action to handle non-keeper files:
+- "report" (default): do nothing.
+- "move": move them to --aside-dir, keeping the relative path of dir.
+- "hardlink": hardlink them to --aside-dir (original files are kept), for review.
+
+Examples:
+  goaider dedupe ./images
+  goaider dedupe ./images -r --threshold 4 -o clusters.csv
+  goaider dedupe ./images --keep mtime --action move --aside-dir ./dupes`,
+	RunE: dedupe,
+}
+
+func init() {
+	dedupeCmd.Flags().StringVarP(&flagHash, "hash", "", imgutil.HASH_PHASH, `Perceptual hash: "phash" or "dhash"`)
+	dedupeCmd.Flags().IntVarP(&flagThreshold, "threshold", "t", 8,
+		"Max hash Hamming distance (0-64) of near-duplicate images. 0 == only identical hashes")
+	dedupeCmd.Flags().StringVarP(&flagKeep, "keep", "", KEEP_RESOLUTION,
+		`How to choose the keeper image of a cluster: "resolution", "size" or "mtime"`)
+	dedupeCmd.Flags().StringVarP(&flagAction, "action", "", ACTION_REPORT,
+		`Action of non-keeper files: "report", "move" or "hardlink"`)
+	dedupeCmd.Flags().StringVarP(&flagAsideDir, "aside-dir", "O", "",
+		`Aside dir of "move" / "hardlink" action. Default is "<dir>-dupes"`)
+	dedupeCmd.Flags().StringVarP(&flagOutput, "output", "o", "-", `Output clusters CSV file. "-" == stdout`)
+	dedupeCmd.Flags().BoolVarP(&flagDryRun, "dry-run", "d", false, "Dry run. Do not move / hardlink files")
+	dedupeCmd.Flags().IntVarP(&flagConcurrency, "concurrency", "", runtime.NumCPU(),
+		"Number of images to hash concurrently")
+	dedupeCmd.Flags().StringVarP(&flagListFile, "list", "l", "", constants.HELP_BATCH_LIST_FLAG)
+	dedupeCmd.Flags().StringVarP(&flagCsvColumn, "csv-column", "c", "", constants.HELP_BATCH_CSV_COLUMN_FLAG)
+	dedupeCmd.Flags().BoolVarP(&flagRecursive, "recursive", "r", false, constants.HELP_BATCH_RECURSIVE_FLAG)
+	dedupeCmd.Flags().IntVarP(&flagMaxDepth, "max-depth", "", 0, constants.HELP_BATCH_MAX_DEPTH_FLAG)
+	dedupeCmd.Flags().StringArrayVarP(&flagIncludes, "include", "", nil, constants.HELP_BATCH_INCLUDE_FLAG)
+	dedupeCmd.Flags().StringArrayVarP(&flagExcludes, "exclude", "", nil, constants.HELP_BATCH_EXCLUDE_FLAG)
+	cmd.RootCmd.AddCommand(dedupeCmd)
+}
+
+// Hash and properties of an image file.
+type imageInfo struct {
+	Path   string
+	Hash   uint64
+	Width  int
+	Height int
+	Size   int64
+	Mtime  time.Time
+}
+
+func dedupe(cmd *cobra.Command, args []string) error {
+	var hashFunc func(img image.Image) uint64
+	switch flagHash {
+	case imgutil.HASH_PHASH:
+		hashFunc = imgutil.PHash
+	case imgutil.HASH_DHASH:
+		hashFunc = imgutil.DHash
+	default:
+		return fmt.Errorf("invalid hash %q", flagHash)
+	}
+	if flagKeep != KEEP_RESOLUTION && flagKeep != KEEP_SIZE && flagKeep != KEEP_MTIME {
+		return fmt.Errorf("invalid keep %q", flagKeep)
+	}
+	if flagAction != ACTION_REPORT && flagAction != ACTION_MOVE && flagAction != ACTION_HARDLINK {
+		return fmt.Errorf("invalid action %q", flagAction)
+	}
+
+	options := &batchfeature.InputOptions{
+		Recursive:  flagRecursive,
+		MaxDepth:   flagMaxDepth,
+		Includes:   flagIncludes,
+		Excludes:   flagExcludes,
+		ListFile:   flagListFile,
+		CsvColumn:  flagCsvColumn,
+		MimePrefix: "image/",
+	}
+	if len(args) > 0 {
+		options.Dir = args[0]
+	} else if flagListFile == "" {
+		return fmt.Errorf("dir arg or --list flag is required")
+	}
+	if flagAction != ACTION_REPORT && flagAsideDir == "" {
+		if options.Dir == "" {
+			return fmt.Errorf("--aside-dir flag is required if dir arg is not set")
+		}
+		absDir, err := filepath.Abs(options.Dir)
+		if err != nil {
+			return fmt.Errorf("failed to resolve path %s: %w", options.Dir, err)
+		}
+		flagAsideDir = absDir + "-dupes"
+	}
+	files, err := batchfeature.SelectInputs(options, cmd.InOrStdin())
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Hashing %d images", len(files))
+	mu := &sync.Mutex{}
+	infos := make([]*imageInfo, 0, len(files))
+	summary := batchfeature.Run(files, flagConcurrency, io.Discard, func(file string) (bool, error) {
+		info, err := hashImage(file, hashFunc)
+		if err != nil {
+			log.Warnf("Failed to hash %s: %v", file, err)
+			return false, err
+		}
+		mu.Lock()
+		infos = append(infos, info)
+		mu.Unlock()
+		return false, nil
+	})
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Path < infos[j].Path
+	})
+
+	clusters := clusterImages(infos, flagThreshold)
+	log.Printf("Found %d duplicate clusters in %d images (%d failed)", len(clusters), len(infos), summary.Failed)
+
+	output := cmd.OutOrStdout()
+	if flagOutput != "-" {
+		f, err := os.Create(flagOutput)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		output = f
+	}
+	if err := writeClusters(output, clusters); err != nil {
+		return fmt.Errorf("failed to write clusters: %w", err)
+	}
+
+	if flagAction != ACTION_REPORT {
+		errorCnt := 0
+		for _, cluster := range clusters {
+			for _, info := range cluster[1:] {
+				if err := moveAside(info.Path, options.Dir); err != nil {
+					log.Errorf("Failed to %s %s: %v", flagAction, info.Path, err)
+					errorCnt++
+				}
+			}
+		}
+		if errorCnt > 0 {
+			return fmt.Errorf("%d errors", errorCnt)
+		}
+	}
+	if summary.Failed > 0 {
+		return fmt.Errorf("%d images failed to hash", summary.Failed)
+	}
+	return nil
+}
+
+func hashImage(file string, hashFunc func(img image.Image) uint64) (*imageInfo, error) {
+	stat, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := imgutil.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return &imageInfo{
+		Path:   file,
+		Hash:   hashFunc(img),
+		Width:  img.Bounds().Dx(),
+		Height: img.Bounds().Dy(),
+		Size:   stat.Size(),
+		Mtime:  stat.ModTime(),
+	}, nil
+}
+
+// Cluster images which hash distance is within threshold.
+// Images are first grouped transitively, then each group is split around keepers: the best keeper of the group
+// takes all images within threshold of it, and the rest images are clustered again the same way.
+// So every image of a cluster is within threshold of the keeper, even if the group is a long chain of images.
+// Only clusters of 2 or more images are returned. The first image of each cluster is the keeper.
+func clusterImages(infos []*imageInfo, threshold int) (clusters [][]*imageInfo) {
+	parents := make([]int, len(infos))
+	for i := range parents {
+		parents[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parents[i] != i {
+			parents[i] = find(parents[i])
+		}
+		return parents[i]
+	}
+	for i := range infos {
+		for j := i + 1; j < len(infos); j++ {
+			if imgutil.HammingDistance(infos[i].Hash, infos[j].Hash) <= threshold {
+				if a, b := find(i), find(j); a != b {
+					parents[max(a, b)] = min(a, b)
+				}
+			}
+		}
+	}
+	members := map[int][]*imageInfo{}
+	var roots []int
+	for i, info := range infos {
+		root := find(i)
+		if members[root] == nil {
+			roots = append(roots, root)
+		}
+		members[root] = append(members[root], info)
+	}
+	for _, root := range roots {
+		group := members[root]
+		sort.SliceStable(group, func(i, j int) bool {
+			return isBetterKeeper(group[i], group[j])
+		})
+		for len(group) >= 2 {
+			keeper := group[0]
+			cluster := []*imageInfo{keeper}
+			var rest []*imageInfo
+			for _, info := range group[1:] {
+				if imgutil.HammingDistance(keeper.Hash, info.Hash) <= threshold {
+					cluster = append(cluster, info)
+				} else {
+					rest = append(rest, info)
+				}
+			}
+			if len(cluster) >= 2 {
+				clusters = append(clusters, cluster)
+			}
+			group = rest
+		}
+	}
+	return clusters
+}
+
+// Whether a is a better keeper than b, according to --keep.
+func isBetterKeeper(a, b *imageInfo) bool {
+	compareResolution := func() int {
+		return a.Width*a.Height - b.Width*b.Height
+	}
+	compareSize := func() int {
+		return int(a.Size - b.Size)
+	}
+	compareMtime := func() int {
+		return b.Mtime.Compare(a.Mtime) // earlier is better
+	}
+	var comparisons []func() int
+	switch flagKeep {
+	case KEEP_SIZE:
+		comparisons = []func() int{compareSize, compareResolution, compareMtime}
+	case KEEP_MTIME:
+		comparisons = []func() int{compareMtime, compareResolution, compareSize}
+	default:
+		comparisons = []func() int{compareResolution, compareSize, compareMtime}
+	}
+	for _, compare := range comparisons {
+		if result := compare(); result != 0 {
+			return result > 0
+		}
+	}
+	return a.Path < b.Path
+}
+
+func writeClusters(output io.Writer, clusters [][]*imageInfo) error {
+	writer := csv.NewWriter(output)
+	writer.Write([]string{"cluster", "file", "keeper", "distance", "width", "height", "size", "mtime", "hash"})
+	for i, cluster := range clusters {
+		for j, info := range cluster {
+			writer.Write([]string{
+				strconv.Itoa(i + 1),
+				info.Path,
+				strconv.FormatBool(j == 0),
+				strconv.Itoa(imgutil.HammingDistance(cluster[0].Hash, info.Hash)),
+				strconv.Itoa(info.Width),
+				strconv.Itoa(info.Height),
+				strconv.FormatInt(info.Size, 10),
+				info.Mtime.Format(time.RFC3339),
+				fmt.Sprintf("%016x", info.Hash),
+			})
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// Move or hardlink a non-keeper file to aside dir, keeping it's relative path of dir.
+// Files not in dir are put in the root of aside dir.
+func moveAside(file string, dir string) error {
+	relpath := filepath.Base(file)
+	if dir != "" {
+		if rel, err := filepath.Rel(dir, file); err == nil && filepath.IsLocal(rel) {
+			relpath = rel
+		}
+	}
+	target := filepath.Join(flagAsideDir, relpath)
+	if flagDryRun {
+		log.Printf("[DryRun] Would %s: %s -> %s", flagAction, file, target)
+		return nil
+	}
+	if _, err := os.Lstat(target); err == nil {
+		return fmt.Errorf("target %s already exists", target)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if flagAction == ACTION_MOVE {
+		err := os.Rename(file, target)
+		if err == nil {
+			log.Printf("Moved %s -> %s", file, target)
+		}
+		return err
+	}
+	err := os.Link(file, target)
+	if err == nil {
+		log.Printf("Hardlinked %s -> %s", file, target)
+	}
+	return err
+}
@@ -0,0 +1,60 @@
+package dedupe
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestClusterImages(t *testing.T) {
+	tests := []struct {
+		name   string
+		images []string // "path:hash:width", sorted by path
+		want   []string // clusters of "path,path...", keeper first
+	}{
+		{
+			name:   "chain with keeper at end",
+			images: []string{"a:0:200", "b:f:100", "c:ff:100"},
+			want:   []string{"a,b"},
+		},
+		{
+			name:   "chain with keeper in middle",
+			images: []string{"a:0:100", "b:f:200", "c:ff:100"},
+			want:   []string{"b,a,c"},
+		},
+		{
+			name:   "chain split into clusters",
+			images: []string{"a:0:400", "b:f:300", "c:ff:200", "d:1ff:100"},
+			want:   []string{"a,b", "c,d"},
+		},
+		{
+			name:   "separate groups",
+			images: []string{"a:0:100", "b:1:200", "c:ffff0000:100", "d:ffff0001:100", "e:ff00ff00ff:100"},
+			want:   []string{"b,a", "c,d"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var infos []*imageInfo
+			for _, image := range tt.images {
+				fields := strings.Split(image, ":")
+				hash, _ := strconv.ParseUint(fields[1], 16, 64)
+				width, _ := strconv.Atoi(fields[2])
+				info := &imageInfo{Path: fields[0], Hash: hash, Width: width, Height: 100}
+				infos = append(infos, info)
+			}
+			var got []string
+			for _, cluster := range clusterImages(infos, 4) { // hash distance threshold
+				var paths []string
+				for _, info := range cluster {
+					paths = append(paths, info.Path)
+				}
+				got = append(got, strings.Join(paths, ","))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("clusters = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
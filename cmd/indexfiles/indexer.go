@@ -364,9 +364,9 @@ type IndexOptions struct {
 	MaxDepth    int
 }
 
-// doIndex scans the directory recursively and returns a list of FileInfo
+// Index scans the directory recursively and returns a list of FileInfo
 // allowedExts: if not nil, only index these extension (no dot) files
-func doIndex(dir string, options IndexOptions) (filelist FileList, err error) {
+func Index(dir string, options IndexOptions) (filelist FileList, err error) {
 	filelist = make([]*FileInfo, 0)
 
 	// Note: it must handle a lot of edge cases carefully. E.g. dir is "/" or "C:\".
@@ -211,7 +211,7 @@ func indexfiles(cmd *cobra.Command, args []string) (err error) {
 	if !slices.ContainsFunc(includes, func(i string) bool { return strings.HasPrefix(i, "media_") }) {
 		flagParseMedia = false
 	}
-	filelist, err := doIndex(inputDir, IndexOptions{
+	filelist, err := Index(inputDir, IndexOptions{
 		AllowedExts: flagExtensions,
 		NoRecursive: flagNoRecursive,
 		IncludeRoot: flagIncludeRoot,
@@ -0,0 +1,9 @@
+package all
+
+import (
+	_ "github.com/sagan/goaider/cmd/llm"
+	_ "github.com/sagan/goaider/cmd/llm/cache"
+	_ "github.com/sagan/goaider/cmd/llm/cache/prune"
+	_ "github.com/sagan/goaider/cmd/llm/cache/stats"
+	_ "github.com/sagan/goaider/cmd/llm/usage"
+)
@@ -0,0 +1,20 @@
+package cache
+
+import (
+	"github.com/spf13/cobra"
+
+	llmCmd "github.com/sagan/goaider/cmd/llm"
+)
+
+var CacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage on-disk LLM response cache",
+	Long: `Manage on-disk LLM response cache.
+
+The cache is enabled by "--cache" flag, or "cache: true" in config file.
+Cache dir is "cache_dir" in config file, default "~/.cache/goaider/llm".`,
+}
+
+func init() {
+	llmCmd.LlmCmd.AddCommand(CacheCmd)
+}
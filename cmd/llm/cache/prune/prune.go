@@ -0,0 +1,60 @@
+package prune
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sagan/goaider/cmd/llm/cache"
+	"github.com/sagan/goaider/config"
+	"github.com/sagan/goaider/features/llm"
+)
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Prune LLM response cache",
+	Long: `Prune LLM response cache.
+
+It removes cache entries older than --max-age, then removes the oldest entries
+until total cache size is not larger than --max-size.
+
+Examples:
+  goaider llm cache prune --max-age 720h
+  goaider llm cache prune --max-size 500
+  goaider llm cache prune --all
+`,
+	Args: cobra.ExactArgs(0),
+	RunE: doPrune,
+}
+
+var (
+	flagAll     bool
+	flagMaxAge  time.Duration
+	flagMaxSize int64
+)
+
+func init() {
+	pruneCmd.Flags().BoolVarP(&flagAll, "all", "a", false, "Remove all cache entries")
+	pruneCmd.Flags().DurationVarP(&flagMaxAge, "max-age", "", 0, `Remove entries older than this. E.g. "720h"`)
+	pruneCmd.Flags().Int64VarP(&flagMaxSize, "max-size", "", -1, "Max total cache size (MiB). -1 == unlimited")
+	pruneCmd.MarkFlagsOneRequired("all", "max-age", "max-size")
+	cache.CacheCmd.AddCommand(pruneCmd)
+}
+
+func doPrune(cmd *cobra.Command, args []string) error {
+	dir, err := config.GetCacheDir()
+	if err != nil {
+		return err
+	}
+	maxSize := flagMaxSize
+	if maxSize > 0 {
+		maxSize *= 1024 * 1024
+	}
+	if flagAll {
+		maxSize = 0
+	}
+	removed, freed, err := llm.PruneCache(dir, flagMaxAge, maxSize)
+	fmt.Printf("Removed %d entries, freed %.2f MiB\n", removed, float64(freed)/1024/1024)
+	return err
+}
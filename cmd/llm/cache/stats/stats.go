@@ -0,0 +1,43 @@
+package stats
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sagan/goaider/cmd/llm/cache"
+	"github.com/sagan/goaider/config"
+	"github.com/sagan/goaider/constants"
+	"github.com/sagan/goaider/features/llm"
+)
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show LLM response cache stats",
+	Long:  `Show LLM response cache stats.`,
+	Args:  cobra.ExactArgs(0),
+	RunE:  doStats,
+}
+
+func init() {
+	cache.CacheCmd.AddCommand(statsCmd)
+}
+
+func doStats(cmd *cobra.Command, args []string) error {
+	dir, err := config.GetCacheDir()
+	if err != nil {
+		return err
+	}
+	stats, err := llm.GetCacheStats(dir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Dir: %s\n", dir)
+	fmt.Printf("Entries: %d\n", stats.Entries)
+	fmt.Printf("Size: %.2f MiB\n", float64(stats.Size)/1024/1024)
+	if stats.Entries > 0 {
+		fmt.Printf("Oldest: %s\n", stats.Oldest.Format(constants.TIME_FORMAT))
+		fmt.Printf("Newest: %s\n", stats.Newest.Format(constants.TIME_FORMAT))
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+package llm
+
+import (
+	"github.com/sagan/goaider/cmd"
+	"github.com/spf13/cobra"
+)
+
+var LlmCmd = &cobra.Command{
+	Use:   "llm",
+	Short: "LLM related actions",
+	Long:  `LLM related actions.`,
+}
+
+func init() {
+	cmd.RootCmd.AddCommand(LlmCmd)
+}
@@ -0,0 +1,79 @@
+package usage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	llmCmd "github.com/sagan/goaider/cmd/llm"
+	"github.com/sagan/goaider/config"
+	"github.com/sagan/goaider/constants"
+	"github.com/sagan/goaider/features/llm"
+)
+
+var usageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "Summarize LLM usage ledger by model and day",
+	Long: `Summarize LLM usage ledger by model and day.
+
+Each goaider command run that calls LLM prints a usage summary (tokens & cost) at the end.
+If "usage_ledger: true" is set in config file, the usage is also appended to the ledger file
+("~/.config/goaider/usage.jsonl"), which this command reads.
+
+Cost is estimated from builtin model prices, or "input_price" / "output_price" (USD per 1M tokens)
+of the model profile in config file. It's 0 for models of unknown price.
+
+Examples:
+  goaider llm usage
+  goaider llm usage --since 2025-01-01 --model gemini-2.5-flash
+`,
+	Args: cobra.ExactArgs(0),
+	RunE: doUsage,
+}
+
+var (
+	flagLedger string
+	flagSince  string
+	flagUntil  string
+	flagModel  string
+)
+
+func init() {
+	usageCmd.Flags().StringVarP(&flagLedger, "ledger", "", "",
+		`Usage ledger file. Default is "usage.jsonl" file in goaider config dir`)
+	usageCmd.Flags().StringVarP(&flagSince, "since", "", "", `Only include usage since this day. Format: "2006-01-02"`)
+	usageCmd.Flags().StringVarP(&flagUntil, "until", "", "", `Only include usage until this day (inclusive). `+
+		`Format: "2006-01-02"`)
+	usageCmd.Flags().StringVarP(&flagModel, "model", "", "", "Only include usage of this model")
+	llmCmd.LlmCmd.AddCommand(usageCmd)
+}
+
+func doUsage(cmd *cobra.Command, args []string) (err error) {
+	ledgerFile := flagLedger
+	if ledgerFile == "" {
+		if ledgerFile, err = config.GetUsageLedgerFile(); err != nil {
+			return err
+		}
+	}
+	records, err := llm.ReadUsageLedger(ledgerFile)
+	if err != nil {
+		return fmt.Errorf("failed to read ledger %q: %w", ledgerFile, err)
+	}
+	var filtered []*llm.UsageRecord
+	for _, record := range records {
+		if flagModel != "" && record.Model != flagModel {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, record.Time)
+		if err != nil {
+			return fmt.Errorf("invalid ledger record time %q: %w", record.Time, err)
+		}
+		day := t.Local().Format(constants.DATE_FORMAT)
+		if (flagSince != "" && day < flagSince) || (flagUntil != "" && day > flagUntil) {
+			continue
+		}
+		filtered = append(filtered, record)
+	}
+	return llm.PrintUsageReport(cmd.OutOrStdout(), filtered, constants.DATE_FORMAT)
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/sagan/goaider/features/clipboard"
 	"github.com/sagan/goaider/util"
 	"github.com/sagan/goaider/util/helper"
+	"github.com/sagan/goaider/util/imgutil"
 )
 
 // pasteCmd represents the copy command
@@ -31,6 +32,9 @@ Output file path can be set by [filename] or --output <filename>.
 - If [filename] is not set, a "clipboard-<timestamp>" style name .txt or .png file
   in dir (default to ".") is used, where <timestamp> is yyyyMMddHHmmss format.
 
+Clipboard image is saved as png by default. It's converted to the format of --format flag,
+or the format of [filename] ext (e.g. ".jpg", ".webp").
+
 When [filename] is not "-", it prints the full path of generated file to stdout on success.
 `,
 	Args: cobra.MaximumNArgs(1),
@@ -41,6 +45,8 @@ var (
 	flagForce     bool   // override existing file
 	flagOutputDir string // Manually specify output dir, if set, it's joined with filename
 	flagOutput    string
+	flagFormat    string // image output format
+	flagQuality   int    // jpg / webp quality
 )
 
 func init() {
@@ -48,6 +54,10 @@ func init() {
 	pasteCmd.Flags().StringVarP(&flagOutputDir, "output-dir", "O", ".", "Optional: output dir. "+
 		"If both --dir flag and {filename} are set, the joined path is used")
 	pasteCmd.Flags().StringVarP(&flagOutput, "output", "o", "", `Output file path. Use "-" for stdout`)
+	pasteCmd.Flags().StringVarP(&flagFormat, "format", "f", "",
+		`Optional: image output format: "png", "jpg" or "webp". Default is the [filename] ext format, or "png"`)
+	pasteCmd.Flags().IntVarP(&flagQuality, "quality", "q", imgutil.DEFAULT_QUALITY,
+		"Image output jpg / webp quality (1-100). webp quality 100 is lossless")
 	cmd.RootCmd.AddCommand(pasteCmd)
 }
 
@@ -86,14 +96,28 @@ func doPaste(cmd *cobra.Command, args []string) (err error) {
 		return err
 	}
 	if isImage {
+		format := imgutil.NormalizeFormat(flagFormat)
 		if flagOutput == "" { // only append ext if filename is not provided by user
-			fullpath += ".png"
+			if format == "" {
+				format = imgutil.FORMAT_PNG
+			}
+			fullpath += "." + format
 		} else if flagOutput == "-" {
 			if cmd.OutOrStdout() == os.Stdout && term.IsTerminal(int(os.Stdout.Fd())) && !flagForce {
 				return fmt.Errorf("clipboard is image but stdout is tty, refuse to write")
 			}
-		} else if ext := filepath.Ext(fullpath); ext != ".png" && ext != ".PNG" {
-			return fmt.Errorf("clipboard is png image but filename ext is not")
+		} else if !strings.HasPrefix(util.GetMimeType(fullpath), "image/") {
+			return fmt.Errorf("clipboard is image but filename ext is not")
+		} else if format == "" {
+			format = imgutil.NormalizeFormat(filepath.Ext(fullpath))
+		}
+		if format != "" && format != imgutil.FORMAT_PNG {
+			buf := &bytes.Buffer{}
+			if err = imgutil.Convert(bytes.NewReader(data), buf,
+				&imgutil.Options{Format: format, Quality: flagQuality}); err != nil {
+				return fmt.Errorf("failed to convert clipboard image to %s: %w", format, err)
+			}
+			data = buf.Bytes()
 		}
 	} else if len(data) > 0 {
 		if flagOutput == "" {
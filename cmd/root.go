@@ -8,6 +8,8 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"github.com/sagan/goaider/config"
+	"github.com/sagan/goaider/features/llm"
 	"github.com/sagan/goaider/version"
 )
 
@@ -21,12 +23,19 @@ A CLI aider tool for AIGC.`,
 }
 
 var (
-	FlagEnv []string
+	FlagEnv     []string
+	FlagCache   bool
+	FlagNoCache bool
 )
 
 func init() {
 	RootCmd.PersistentFlags().StringArrayVarP(&FlagEnv, "env", "e", nil,
 		`Set env. "name=value" format. Set be set multiple times`)
+	RootCmd.PersistentFlags().BoolVarP(&FlagCache, "cache", "", false,
+		`Enable on-disk LLM response cache: identical non-streaming LLM requests of temperature 0 are served `+
+			`from cache. Requests of other temperature are never cached. Can be enabled by default via "cache: true" in config file`)
+	RootCmd.PersistentFlags().BoolVarP(&FlagNoCache, "no-cache", "", false, `Disable on-disk LLM response cache`)
+	RootCmd.MarkFlagsMutuallyExclusive("cache", "no-cache")
 }
 
 func Execute() {
@@ -41,8 +50,33 @@ func Execute() {
 				os.Exit(1)
 			}
 		}
+		// Commands that need the config (LLM commands) fail later on a malformed config file.
+		if err := config.Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: ignore malformed config file: %v\n", err)
+		}
+		if (config.Get().Cache || FlagCache) && !FlagNoCache {
+			cacheDir, err := config.GetCacheDir()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to get LLM cache dir: %v\n", err)
+				os.Exit(1)
+			}
+			llm.SetCacheDir(cacheDir)
+		}
 	}))
-	if err := RootCmd.Execute(); err != nil {
+	executedCmd, err := RootCmd.ExecuteC()
+	if len(llm.GetUsages()) > 0 {
+		llm.PrintUsageSummary(os.Stderr)
+		if config.Get().UsageLedger {
+			ledgerFile, ledgerErr := config.GetUsageLedgerFile()
+			if ledgerErr == nil {
+				ledgerErr = llm.AppendUsageLedger(ledgerFile, config.GetCommandName(executedCmd))
+			}
+			if ledgerErr != nil {
+				fmt.Fprintf(os.Stderr, "Failed to write usage ledger: %v\n", ledgerErr)
+			}
+		}
+	}
+	if err != nil {
 		fmt.Printf("%v\n", err)
 		os.Exit(1)
 	}
@@ -1,11 +1,13 @@
 package stt
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
-	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -13,136 +15,241 @@ import (
 	"github.com/sagan/goaider/cmd"
 	"github.com/sagan/goaider/config"
 	"github.com/sagan/goaider/constants"
+	"github.com/sagan/goaider/features/batchfeature"
 	"github.com/sagan/goaider/features/llm"
+	"github.com/sagan/goaider/features/mediainfo"
+	"github.com/sagan/goaider/features/sttfeature"
+	"github.com/sagan/goaider/features/ttsfeature"
 	"github.com/sagan/goaider/util"
 )
 
 var (
-	flagForce       bool
-	flagTemperature float64
-	flagModel       string
-	flagModelKey    string
+	flagForce         bool
+	flagChunkDuration float64
+	flagFormats       []string
+	flagTemperature   float64
+	flagModel         string
+	flagModelKey      string
+	flagListFile      string
+	flagCsvColumn     string
+	flagFailures      string
+	flagConcurrency   int
+	flagRecursive     bool
+	flagMaxDepth      int
+	flagIncludes      []string
+	flagExcludes      []string
 )
 
 // sttCmd represents the stt command
 var sttCmd = &cobra.Command{
-	Use:   "stt {dir}",
-	Args:  cobra.ExactArgs(1),
+	Use:   "stt [dir]",
+	Args:  cobra.MaximumNArgs(1),
 	Short: "Generates speech-to-text transcripts for audio files",
 	Long: `Generates speech-to-text transcripts for audio files.
 
 It uses LLM to process a directory of audio files (.wav, .mp3, .m4a, .flac, .ogg),
 and generates a corresponding .txt file for each one using the Google Gemini API.
 
-Implements exponential backoff to handle rate limiting (e.g., 10 RPM).
+Use --format to output timestamped transcripts, e.g. "--format srt,vtt" generates .srt and .vtt subtitle files.
+Supported formats: txt (plain text), srt, vtt (WebVTT) and json ({"text": "...", "segments": [{"start", "end", "text"}]}).
+The timestamps are in seconds and generated by LLM, their accuracy depends on the model.
 
-Requires the GEMINI_API_KEY environment variable to be set.`,
+Long audio files are split into chunks of about --chunk-duration seconds using ffmpeg,
+each chunk is transcribed separately and the results are stitched back with offset timestamps.
+Each chunk is cut at the quietest point of it's last few seconds, to avoid cutting words in half.
+ffmpeg must be in PATH or set by ` + constants.ENV_FFMPEG + ` env, otherwise audio files are not split.
+If ffprobe is available, the duration of audio file is probed first, so short files are not decoded.
+
+Temporary errors (e.g. rate limiting) are retried with exponential backoff.
+Set "rate_limit" in config file to limit requests per minute (e.g., 10 RPM).
+Files are processed by --concurrency workers concurrently, the rate limit is shared by all workers.
+
+Requires the GEMINI_API_KEY environment variable to be set.
+
+Use --recursive (or --max-depth) to process audio files of sub dirs, and --include / --exclude globs to filter them.
+Use --list (and --csv-column) to process audio files of a list file or a CSV file column instead.
+
+Examples:
+  goaider stt ./audios --concurrency 4 --failures failures.txt
+  goaider stt --list failures.txt
+  goaider stt ./audios -r --exclude "noise/**"
+  goaider stt ./videos --format srt,vtt --chunk-duration 600`,
 	// This is the main function that runs when the command is called
 	RunE: stt,
 }
 
 func init() {
 	cmd.RootCmd.AddCommand(sttCmd)
-	sttCmd.Flags().BoolVarP(&flagForce, "force", "", false, "Overwrite existing .txt transcript files")
+	sttCmd.Flags().BoolVarP(&flagForce, "force", "", false, "Overwrite existing transcript files")
+	sttCmd.Flags().StringSliceVarP(&flagFormats, "format", "f", []string{sttfeature.FORMAT_TXT},
+		`Comma-separated output formats: "txt", "srt", "vtt" or "json"`)
+	sttCmd.Flags().Float64VarP(&flagChunkDuration, "chunk-duration", "", 300,
+		"Split audio files longer than it (seconds, min "+strconv.Itoa(sttfeature.MIN_CHUNK_DURATION)+
+			") into chunks and transcribe them separately. 0 == never split")
 	sttCmd.Flags().Float64VarP(&flagTemperature, "temperature", "T", 0.4, constants.HELP_TEMPERATURE_FLAG)
 	sttCmd.Flags().StringVarP(&flagModel, "model", "", "", "The model to use. "+constants.HELP_MODEL)
 	sttCmd.Flags().StringVarP(&flagModelKey, "model-key", "", "", constants.HELP_MODEL_KEY)
+	sttCmd.Flags().StringVarP(&flagListFile, "list", "l", "", constants.HELP_BATCH_LIST_FLAG)
+	sttCmd.Flags().StringVarP(&flagCsvColumn, "csv-column", "c", "", constants.HELP_BATCH_CSV_COLUMN_FLAG)
+	sttCmd.Flags().BoolVarP(&flagRecursive, "recursive", "r", false, constants.HELP_BATCH_RECURSIVE_FLAG)
+	sttCmd.Flags().IntVarP(&flagMaxDepth, "max-depth", "", 0, constants.HELP_BATCH_MAX_DEPTH_FLAG)
+	sttCmd.Flags().StringArrayVarP(&flagIncludes, "include", "", nil, constants.HELP_BATCH_INCLUDE_FLAG)
+	sttCmd.Flags().StringArrayVarP(&flagExcludes, "exclude", "", nil, constants.HELP_BATCH_EXCLUDE_FLAG)
+	sttCmd.Flags().StringVarP(&flagFailures, "failures", "", "", constants.HELP_BATCH_FAILURES_FLAG)
+	sttCmd.Flags().IntVarP(&flagConcurrency, "concurrency", "", 1, constants.HELP_CONCURRENCY_FLAG)
 }
 
-func stt(cmd *cobra.Command, args []string) error {
-	if flagModel == "" {
-		flagModel = config.GetDefaultModel()
+func stt(cmd *cobra.Command, args []string) (err error) {
+	if len(flagFormats) == 0 || util.HasDuplicates(flagFormats) {
+		return fmt.Errorf("invalid --format flag")
+	}
+	for _, format := range flagFormats {
+		if !slices.Contains(sttfeature.Formats, format) {
+			return fmt.Errorf("invalid format %q", format)
+		}
+	}
+	if flagChunkDuration < 0 || (flagChunkDuration > 0 && flagChunkDuration < sttfeature.MIN_CHUNK_DURATION) {
+		return fmt.Errorf("invalid chunk duration %g, must be 0 or >= %d", flagChunkDuration,
+			sttfeature.MIN_CHUNK_DURATION)
+	}
+	if flagChunkDuration > 0 {
+		ttsfeature.Init()
+		mediainfo.Init()
+		if ttsfeature.Ffmpeg == "" {
+			log.Warnf("ffmpeg not found, long audio files will not be split into chunks")
+		}
+	}
+	if flagModel, err = config.GetCommandModel(cmd, flagModel, &flagTemperature); err != nil {
+		return err
 	}
-	argDir := args[0]
 	log.Printf("Using model: %s", flagModel)
 
-	// Read all files in the directory
-	files, err := os.ReadDir(argDir)
+	options := &batchfeature.InputOptions{
+		Recursive:  flagRecursive,
+		MaxDepth:   flagMaxDepth,
+		Includes:   flagIncludes,
+		Excludes:   flagExcludes,
+		ListFile:   flagListFile,
+		CsvColumn:  flagCsvColumn,
+		MimePrefix: "audio/",
+	}
+	if len(args) > 0 {
+		options.Dir = args[0]
+	} else if flagListFile == "" {
+		return fmt.Errorf("dir arg or --list flag is required")
+	}
+	audioFiles, err := batchfeature.SelectInputs(options, cmd.InOrStdin())
 	if err != nil {
-		return fmt.Errorf("error reading directory %q: %w", argDir, err)
+		return err
 	}
 
-	errorCnt := 0
-	for _, file := range files {
-		if file.IsDir() {
-			continue // Skip subdirectories
+	summary := batchfeature.Run(audioFiles, flagConcurrency, os.Stdout, processAudio)
+	log.Printf("Processing complete.")
+	summary.Print(os.Stdout)
+	if flagFailures != "" {
+		if err := summary.WriteFailures(flagFailures); err != nil {
+			return fmt.Errorf("failed to write failures file: %w", err)
 		}
+	}
+	if summary.Failed > 0 {
+		return fmt.Errorf("%d errors", summary.Failed)
+	}
+	return nil
+}
 
-		fileName := file.Name()
-		fileExt := filepath.Ext(fileName)
-		mimeType := util.GetMimeType(fileExt)
+// Generate the transcript files of an audio file.
+func processAudio(audioFilePath string) (skipped bool, err error) {
+	stem := strings.TrimSuffix(audioFilePath, filepath.Ext(audioFilePath))
+	timestamped := slices.ContainsFunc(flagFormats, func(f string) bool { return f != sttfeature.FORMAT_TXT })
+
+	// Check if output files exist
+	if !flagForce && !slices.ContainsFunc(flagFormats, func(format string) bool {
+		_, err := os.Stat(stem + "." + format)
+		return err != nil
+	}) {
+		return true, nil
+	}
 
-		if !strings.HasPrefix(mimeType, "audio/") {
-			// log.Printf("Skipping non-audio file: %s", fileName)
-			continue
+	// 1. Read audio file, split it to chunks if it's long
+	audioData, err := os.ReadFile(audioFilePath)
+	if err != nil {
+		return false, fmt.Errorf("error reading audio file: %w", err)
+	}
+	mimeType := util.GetMimeType(audioFilePath)
+	chunks := []*sttfeature.Chunk{{Data: audioData}}
+	if flagChunkDuration > 0 && ttsfeature.Ffmpeg != "" && !isShorterThan(audioFilePath, flagChunkDuration) {
+		if splitChunks, err := sttfeature.SplitAudio(audioFilePath, flagChunkDuration); err != nil {
+			log.Warnf("Failed to split %q, transcribe it as a whole: %v", audioFilePath, err)
+		} else if len(splitChunks) > 1 {
+			chunks = splitChunks
+			mimeType = constants.MIME_WAV
 		}
+	}
 
-		// Define input and output paths
-		audioFilePath := filepath.Join(argDir, fileName)
-		outputTxtPath := strings.TrimSuffix(audioFilePath, fileExt) + ".txt"
-
-		// Check if output file exists
-		if !flagForce {
-			if _, err := os.Stat(outputTxtPath); err == nil {
-				log.Printf("Skipping (exists): %s", fileName)
-				continue
+	// 2. Call Gemini API
+	var segments []*sttfeature.Segment
+	var texts []string
+	for i, chunk := range chunks {
+		if timestamped {
+			resp, err := llm.ImageToJson[transcriptResponse](flagModelKey, flagModel, TIMESTAMP_PROMPT, chunk.Data,
+				mimeType, flagTemperature)
+			if err != nil {
+				return false, fmt.Errorf("error generating transcript of chunk %d: %w", i, err)
 			}
+			segments = append(segments, sttfeature.FixSegments(resp.Segments, chunk.Offset, chunk.Duration)...)
+		} else {
+			transcript, err := llm.ImageToText(flagModelKey, flagModel, PROMPT, chunk.Data, mimeType, flagTemperature)
+			if err != nil {
+				return false, fmt.Errorf("error generating transcript of chunk %d: %w", i, err)
+			}
+			texts = append(texts, transcript)
 		}
+	}
 
-		// Process the file
-		log.Printf("Processing: %s", fileName)
-
-		// 1. Read audio file
-		audioData, err := os.ReadFile(audioFilePath)
-		if err != nil {
-			log.Printf("Error reading audio file %s: %v", fileName, err)
-			errorCnt++
-			continue
-		}
-
-		// 2. Call Gemini API
-		transcript, err := getTranscript(flagModelKey, flagModel, audioData, mimeType)
-		if err != nil {
-			log.Printf("Error generating transcript for %s: %v", fileName, err)
-			errorCnt++
-			continue
+	// 3. Write transcript files
+	for _, format := range flagFormats {
+		buf := &bytes.Buffer{}
+		if timestamped {
+			if err := sttfeature.Write(buf, format, segments); err != nil {
+				return false, err
+			}
+		} else {
+			buf.WriteString(strings.Join(texts, "\n"))
 		}
-
-		// 3. Write transcript to .txt file
-		err = os.WriteFile(outputTxtPath, []byte(transcript), 0644)
-		if err != nil {
-			log.Printf("Error writing transcript file %s: %v", outputTxtPath, err)
-			errorCnt++
-			continue
+		if err = os.WriteFile(stem+"."+format, buf.Bytes(), 0644); err != nil {
+			return false, fmt.Errorf("error writing transcript file: %w", err)
 		}
-
-		log.Printf("Generated: %s", filepath.Base(outputTxtPath))
 	}
+	return false, nil
+}
 
-	log.Printf("Processing complete.")
-	if errorCnt > 0 {
-		return fmt.Errorf("%d errors", errorCnt)
+// Whether the audio file duration is known (probed by ffprobe) and not longer than duration (seconds),
+// so it doesn't need to be decoded to be split.
+func isShorterThan(file string, duration float64) bool {
+	if mediainfo.Ffprobe == "" {
+		return false
 	}
-	return nil
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	info, err := mediainfo.ParseVideoAudioMediaInfo(f)
+	if err != nil {
+		log.Debugf("failed to parse media info of %q: %v", file, err)
+		return false
+	}
+	fileDuration, err := strconv.ParseFloat(info.Duration, 64)
+	return err == nil && fileDuration > 0 && fileDuration <= duration
+}
+
+type transcriptResponse struct {
+	Segments []*sttfeature.Segment `json:"segments" jsonschema:"description=The transcript segments in time order."`
 }
 
 const PROMPT = "Generate a transcript of this audio. Only output the transcribed text in it's original language."
 
-// getTranscript calls the Gemini API with retry logic
-func getTranscript(apiKey, modelName string, audioData []byte, mimeType string) (transcript string, err error) {
-	for attempt := range 5 {
-		transcript, err = llm.ImageToText(apiKey, modelName, PROMPT, audioData, mimeType, flagTemperature)
-		if err == nil {
-			return transcript, nil
-		}
-		if util.IsTemporaryError(err) {
-			wait := util.CalculateBackoff(llm.GeminiApiBaseBackoff, llm.GeminiApiMaxBackoff, attempt)
-			log.Printf("Attempt %d: error (%v). Retrying in %v...", attempt, err, wait)
-			time.Sleep(wait)
-			continue
-		} else {
-			return "", err
-		}
-	}
-	return "", fmt.Errorf("all attempts failed. Last error: %w", err)
-}
+const TIMESTAMP_PROMPT = "Generate a timestamped transcript of this audio. " +
+	"Split it into segments of sentences or short phrases, each segment no longer than about 10 seconds. " +
+	"For each segment, output it's start and end time in seconds from the beginning of the audio, " +
+	"and the transcribed text in it's original language. Skip silence, music and noise."
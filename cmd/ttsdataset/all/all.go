@@ -0,0 +1,6 @@
+package all
+
+import (
+	_ "github.com/sagan/goaider/cmd/ttsdataset"
+	_ "github.com/sagan/goaider/cmd/ttsdataset/genlist"
+)
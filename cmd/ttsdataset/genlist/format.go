@@ -0,0 +1,90 @@
+package genlist
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Output formats.
+const (
+	FORMAT_SOVITS   = "sovits"
+	FORMAT_LJSPEECH = "ljspeech"
+	FORMAT_COQUI    = "coqui"
+	FORMAT_F5       = "f5"
+	FORMAT_FISH     = "fish"
+)
+
+var Formats = []string{FORMAT_SOVITS, FORMAT_LJSPEECH, FORMAT_COQUI, FORMAT_F5, FORMAT_FISH}
+
+// GPT-SoVITS supported languages.
+var SovitsLangs = []string{"zh", "ja", "en", "ko", "yue"}
+
+// A TTS dataset entry: an audio file and it's transcript.
+type entry struct {
+	Audio   string // audio file path
+	Path    string // audio file path written to list, relative to dataset dir (slash) or absolute
+	Text    string // transcript, in single line
+	Speaker string
+	Lang    string
+}
+
+// Write list file of entries in format. Not applicable to FORMAT_FISH.
+func writeList(output io.Writer, format string, entries []*entry) (err error) {
+	switch format {
+	case FORMAT_COQUI:
+		_, err = fmt.Fprintf(output, "audio_file|text|speaker_name\n")
+	case FORMAT_F5:
+		_, err = fmt.Fprintf(output, "audio_file|text\n")
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		fields, err := listFields(format, e)
+		if err != nil {
+			return err
+		}
+		if _, err = fmt.Fprintf(output, "%s\n", strings.Join(fields, "|")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Return the "|" delimited fields of entry e in list file of format.
+func listFields(format string, e *entry) ([]string, error) {
+	switch format {
+	case FORMAT_SOVITS:
+		return []string{e.Path, e.Speaker, e.Lang, e.Text}, nil
+	case FORMAT_LJSPEECH:
+		id := strings.TrimSuffix(e.Path, path.Ext(e.Path))
+		return []string{id, e.Text, e.Text}, nil
+	case FORMAT_COQUI:
+		return []string{e.Path, e.Text, e.Speaker}, nil
+	case FORMAT_F5:
+		return []string{e.Path, e.Text}, nil
+	}
+	return nil, fmt.Errorf("format %q has no list file", format)
+}
+
+// Write Fish-Speech "<filename>.lab" transcript file of each entry, next to the audio file.
+// Existing .lab files are skipped unless force is true.
+func writeLabs(entries []*entry, force bool) (written int, err error) {
+	for _, e := range entries {
+		lab := strings.TrimSuffix(e.Audio, filepath.Ext(e.Audio)) + ".lab"
+		if !force {
+			if _, err := os.Stat(lab); err == nil {
+				continue
+			}
+		}
+		if err := os.WriteFile(lab, []byte(e.Text), 0644); err != nil {
+			return written, err
+		}
+		written++
+	}
+	return written, nil
+}
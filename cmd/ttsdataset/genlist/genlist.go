@@ -0,0 +1,316 @@
+package genlist
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/natefinch/atomic"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/sagan/goaider/cmd/ttsdataset"
+	"github.com/sagan/goaider/constants"
+	"github.com/sagan/goaider/features/batchfeature"
+	"github.com/sagan/goaider/features/csvfeature"
+	"github.com/sagan/goaider/features/datasetfeature"
+	"github.com/sagan/goaider/util/stringutil"
+)
+
+var genlistCmd = &cobra.Command{
+	Use:   "genlist {dir}",
+	Args:  cobra.ExactArgs(1),
+	Short: "Generates TTS training list file of audio files and transcripts in multiple formats",
+	Long: `Generates TTS training list file of audio files and transcripts in multiple formats.
+
+It reads all audio files and corresponding "<filename>.txt" transcript files of dir
+(as generated by "stt" command), and generates the list file of --format to --output (default stdout).
+Audio files without transcript file are skipped. Multi-line transcripts are joined to a single line.
+
+Formats:
+- sovits: GPT-SoVITS .list file. "path|speaker|lang|text". lang must be one of zh, ja, en, ko, yue.
+- ljspeech: LJSpeech "metadata.csv". "id|text|normalized_text", id is the path without ext.
+- coqui: Coqui TTS / XTTS "coqui" formatter metadata csv. "audio_file|text|speaker_name" with header.
+- f5: F5-TTS custom dataset metadata csv. "audio_file|text" with header.
+- fish: Fish-Speech. Write "<filename>.lab" transcript file next to each audio file, no list file.
+
+The path is relative to dir (use --absolute for absolute path, not supported by "ljspeech" format).
+Files of which any list field (path, speaker, lang or transcript) contains the "|" delimiter are skipped.
+
+Speaker and language of each file are determined by (in order of precedence):
+1. --csv: a CSV file (e.g. generated by "indexfiles" command) with --csv-path-column (path relative to dir),
+   --csv-speaker-column and --csv-lang-column columns.
+2. --dir-pattern: derive them from the dir names of file path (relative to dir).
+   E.g. "{speaker}" for "<speaker>/foo.wav" layout, "{lang}/{speaker}" for "<lang>/<speaker>/foo.wav" layout.
+   "*" matches any dir name. Files which dir path doesn't match the pattern use the default values.
+3. --speaker and --lang flags (default values).
+
+Use --val-ratio to split entries to train and validation sets randomly (with --seed, so it's reproducible).
+The validation set is written to "<output name>_val<ext>", e.g. "metadata_val.csv".
+
+Examples:
+  goaider tts-dataset genlist ./clips --format sovits --speaker alice --lang ja
+  goaider tts-dataset genlist ./voices -r --format coqui --dir-pattern "{speaker}" -o metadata.csv --val-ratio 0.05
+  goaider tts-dataset genlist ./voices -r --format fish`,
+	RunE: genlist,
+}
+
+var (
+	flagFormat           string
+	flagOutput           string
+	flagSpeaker          string
+	flagLang             string
+	flagDirPattern       string
+	flagCsv              string
+	flagCsvPathColumn    string
+	flagCsvSpeakerColumn string
+	flagCsvLangColumn    string
+	flagValRatio         float64
+	flagSeed             uint64
+	flagAbsolute         bool
+	flagRecursive        bool
+	flagForce            bool
+)
+
+func init() {
+	genlistCmd.Flags().StringVarP(&flagFormat, "format", "f", FORMAT_SOVITS,
+		`Output format: "sovits", "ljspeech", "coqui", "f5" or "fish"`)
+	genlistCmd.Flags().StringVarP(&flagOutput, "output", "o", "-", `Output list filename. "-" == stdout`)
+	genlistCmd.Flags().StringVarP(&flagSpeaker, "speaker", "", "speaker", "Default speaker name")
+	genlistCmd.Flags().StringVarP(&flagLang, "lang", "", "ja", "Default language spoken in the audio files")
+	genlistCmd.Flags().StringVarP(&flagDirPattern, "dir-pattern", "", "",
+		`Derive speaker and language from dir names, e.g. "{speaker}" or "{lang}/{speaker}"`)
+	genlistCmd.Flags().StringVarP(&flagCsv, "csv", "", "", "CSV file of per-file speaker and language")
+	genlistCmd.Flags().StringVarP(&flagCsvPathColumn, "csv-path-column", "", "path",
+		"The column of --csv for audio file path (relative to dir)")
+	genlistCmd.Flags().StringVarP(&flagCsvSpeakerColumn, "csv-speaker-column", "", "",
+		"The column of --csv for speaker")
+	genlistCmd.Flags().StringVarP(&flagCsvLangColumn, "csv-lang-column", "", "", "The column of --csv for language")
+	genlistCmd.Flags().Float64VarP(&flagValRatio, "val-ratio", "", 0,
+		"Ratio of entries to put in validation set (0-1). 0 == no validation set")
+	genlistCmd.Flags().Uint64VarP(&flagSeed, "seed", "", 42, "Random seed of train / validation split")
+	genlistCmd.Flags().BoolVarP(&flagAbsolute, "absolute", "", false, `Use absolute audio file path in list. Not supported by "ljspeech" format`)
+	genlistCmd.Flags().BoolVarP(&flagRecursive, "recursive", "r", false, constants.HELP_BATCH_RECURSIVE_FLAG)
+	genlistCmd.Flags().BoolVarP(&flagForce, "force", "", false,
+		`Force overwrite output files (and .lab files of "fish" format) even if they already exist`)
+	ttsdataset.TtsDatasetCmd.AddCommand(genlistCmd)
+}
+
+func genlist(cmd *cobra.Command, args []string) (err error) {
+	dir := args[0]
+	if !slices.Contains(Formats, flagFormat) {
+		return fmt.Errorf("invalid format %q", flagFormat)
+	}
+	if flagValRatio < 0 || flagValRatio >= 1 {
+		return fmt.Errorf("invalid val ratio %g", flagValRatio)
+	}
+	if flagFormat == FORMAT_FISH && flagValRatio > 0 {
+		return fmt.Errorf(`--val-ratio is not supported by "fish" format`)
+	}
+	if flagFormat == FORMAT_LJSPEECH && flagAbsolute {
+		// LJSpeech loaders resolve "<id>.wav" in the "wavs" dir next to metadata.csv
+		return fmt.Errorf(`--absolute is not supported by "ljspeech" format`)
+	}
+	var outputs []string
+	if flagFormat != FORMAT_FISH {
+		if flagOutput == "-" {
+			if flagValRatio > 0 {
+				return fmt.Errorf("--val-ratio requires --output file")
+			}
+		} else {
+			outputs = append(outputs, flagOutput)
+			if flagValRatio > 0 {
+				outputs = append(outputs, valOutput(flagOutput))
+			}
+		}
+	}
+	for _, output := range outputs {
+		if _, err := os.Stat(output); err == nil && !flagForce {
+			return fmt.Errorf("output file %q already exists", output)
+		}
+	}
+	var csvMeta map[string]map[string]string // relative path => row
+	if flagCsv != "" {
+		if csvMeta, err = readCsvMeta(flagCsv); err != nil {
+			return fmt.Errorf("failed to read csv: %w", err)
+		}
+	}
+
+	files, err := batchfeature.SelectInputs(&batchfeature.InputOptions{Dir: dir, Recursive: flagRecursive},
+		cmd.InOrStdin())
+	if err != nil {
+		return err
+	}
+	items, _ := datasetfeature.FindPairs(files)
+	var entries []*entry
+	for _, item := range items {
+		if item.Kind != datasetfeature.KIND_AUDIO {
+			continue
+		}
+		if item.Caption == "" {
+			log.Debugf("Skip %q: no transcript file", item.Media)
+			continue
+		}
+		e, err := newEntry(dir, item, csvMeta)
+		if err != nil {
+			log.Warnf("Skip %q: %v", item.Media, err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("no valid audio files found")
+	}
+
+	if flagFormat == FORMAT_FISH {
+		written, err := writeLabs(entries, flagForce)
+		if err != nil {
+			return err
+		}
+		log.Printf("Wrote %d .lab files (%d entries)", written, len(entries))
+		return nil
+	}
+
+	train, val := split(entries, flagValRatio, flagSeed)
+	if flagOutput == "-" {
+		return writeList(cmd.OutOrStdout(), flagFormat, train)
+	}
+	for i, set := range [][]*entry{train, val}[:len(outputs)] {
+		buf := &bytes.Buffer{}
+		if err := writeList(buf, flagFormat, set); err != nil {
+			return err
+		}
+		if err := atomic.WriteFile(outputs[i], buf); err != nil {
+			return fmt.Errorf("failed to output to %q : %w", outputs[i], err)
+		}
+		log.Printf("Wrote %q (%d entries)", outputs[i], len(set))
+	}
+	return nil
+}
+
+func newEntry(dir string, item *datasetfeature.Item, csvMeta map[string]map[string]string) (*entry, error) {
+	content, err := os.ReadFile(item.Caption)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transcript: %w", err)
+	}
+	text := strings.TrimSpace(stringutil.ReplaceNewLinesWithSpace(string(content)))
+	if text == "" {
+		return nil, fmt.Errorf("empty transcript")
+	}
+	relpath, err := filepath.Rel(dir, item.Media)
+	if err != nil {
+		return nil, err
+	}
+	relpath = filepath.ToSlash(relpath)
+	e := &entry{Audio: item.Media, Path: relpath, Text: text, Speaker: flagSpeaker, Lang: flagLang}
+	if flagAbsolute {
+		if e.Path, err = filepath.Abs(item.Media); err != nil {
+			return nil, err
+		}
+	}
+	if flagDirPattern != "" {
+		matchDirPattern(e, flagDirPattern, relpath)
+	}
+	if row := csvMeta[relpath]; row != nil {
+		if flagCsvSpeakerColumn != "" && row[flagCsvSpeakerColumn] != "" {
+			e.Speaker = row[flagCsvSpeakerColumn]
+		}
+		if flagCsvLangColumn != "" && row[flagCsvLangColumn] != "" {
+			e.Lang = row[flagCsvLangColumn]
+		}
+	}
+	if flagFormat == FORMAT_SOVITS && !slices.Contains(SovitsLangs, e.Lang) {
+		return nil, fmt.Errorf("invalid language %q. Must be one of: %s", e.Lang, strings.Join(SovitsLangs, ", "))
+	}
+	if flagFormat != FORMAT_FISH {
+		fields, err := listFields(flagFormat, e)
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range fields {
+			if strings.Contains(field, "|") {
+				return nil, fmt.Errorf(`list field %q contains "|" delimiter`, field)
+			}
+		}
+	}
+	return e, nil
+}
+
+// Set the speaker and lang of e from the dir names of it's relative path, if the dir path matches pattern.
+func matchDirPattern(e *entry, pattern string, relpath string) {
+	patterns := strings.Split(strings.Trim(pattern, "/"), "/")
+	names := strings.Split(relpath, "/")
+	names = names[:len(names)-1]
+	if len(names) != len(patterns) {
+		return
+	}
+	speaker, lang := e.Speaker, e.Lang
+	for i, p := range patterns {
+		switch p {
+		case "{speaker}":
+			speaker = names[i]
+		case "{lang}":
+			lang = names[i]
+		case "*":
+		default:
+			if p != names[i] {
+				return
+			}
+		}
+	}
+	e.Speaker, e.Lang = speaker, lang
+}
+
+// Read a CSV file, return the rows indexed by --csv-path-column value.
+func readCsvMeta(name string) (map[string]map[string]string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	rows, err := csvfeature.UnmarshalCsv[map[string]string](stringutil.GetTextReader(file))
+	if err != nil {
+		return nil, err
+	}
+	meta := map[string]map[string]string{}
+	for _, row := range rows {
+		if path := row[flagCsvPathColumn]; path != "" {
+			meta[filepath.ToSlash(filepath.Clean(path))] = row
+		}
+	}
+	if len(meta) == 0 {
+		return nil, fmt.Errorf("no rows with %q column found", flagCsvPathColumn)
+	}
+	return meta, nil
+}
+
+// Split entries to train and val sets randomly by seed. Both sets are sorted by path.
+func split(entries []*entry, valRatio float64, seed uint64) (train []*entry, val []*entry) {
+	shuffled := slices.Clone(entries)
+	random := rand.New(rand.NewPCG(seed, seed))
+	random.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	valSize := int(float64(len(entries))*valRatio + 0.5)
+	if valRatio > 0 {
+		valSize = min(max(valSize, 1), len(entries)-1)
+	}
+	val, train = shuffled[:valSize], shuffled[valSize:]
+	for _, set := range [][]*entry{train, val} {
+		sort.Slice(set, func(i, j int) bool {
+			return set[i].Path < set[j].Path
+		})
+	}
+	return train, val
+}
+
+// Return the validation set output filename of output, e.g. "metadata.csv" => "metadata_val.csv".
+func valOutput(output string) string {
+	ext := filepath.Ext(output)
+	return strings.TrimSuffix(output, ext) + "_val" + ext
+}
@@ -0,0 +1,16 @@
+package ttsdataset
+
+import (
+	"github.com/sagan/goaider/cmd"
+	"github.com/spf13/cobra"
+)
+
+var TtsDatasetCmd = &cobra.Command{
+	Use:   "tts-dataset",
+	Short: "TTS (text to speech) model training dataset related actions",
+	Long:  `TTS (text to speech) model training dataset related actions.`,
+}
+
+func init() {
+	cmd.RootCmd.AddCommand(TtsDatasetCmd)
+}
@@ -324,7 +324,19 @@ func (c *ComfyClient) OnWindowSocketMessage(msg string) {
 			qi.Messages <- m
 		}
 	case "execution_cached":
-		// this is probably not usefull for us
+		// @mod : report the cached nodes
+		s := message.Data.(*WSMessageDataExecutionCached)
+		qi := c.GetQueuedItem(s.PromptID)
+		if qi != nil {
+			nodes := make([]string, 0, len(s.Nodes))
+			for _, node := range s.Nodes {
+				nodes = append(nodes, fmt.Sprint(node))
+			}
+			qi.Messages <- PromptMessage{
+				Type:    "cached",
+				Message: &PromptMessageCached{Nodes: nodes},
+			}
+		}
 	case "executing":
 		s := message.Data.(*WSMessageDataExecuting)
 		qi := c.GetQueuedItem(s.PromptID)
@@ -347,26 +359,34 @@ func (c *ComfyClient) OnWindowSocketMessage(msg string) {
 				qi.Messages <- m
 				close(qi.Messages) // @mod : close the channel since this prompt is done.
 			} else {
-				node := qi.Workflow.GetNodeById(*s.Node)
 				m := PromptMessage{
 					Type: "executing",
 					Message: &PromptMessageExecuting{
 						NodeID: *s.Node,
-						Title:  node.DisplayName,
+						Node:   s.NodeID,
 					},
 				}
+				// @mod : the workflow graph is nil for API format prompt
+				if node := qi.Workflow.GetNodeById(*s.Node); node != nil {
+					m.Message.(*PromptMessageExecuting).Title = node.DisplayName
+				}
 				qi.Messages <- m
 			}
 		}
 	case "progress":
 		s := message.Data.(*WSMessageDataProgress)
-		qi := c.GetQueuedItem(c.lastProcessedPromptID)
+		promptID := c.lastProcessedPromptID
+		if s.PromptID != "" { // @mod
+			promptID = s.PromptID
+		}
+		qi := c.GetQueuedItem(promptID)
 		if qi != nil {
 			m := PromptMessage{
 				Type: "progress",
 				Message: &PromptMessageProgress{
 					Value: s.Value,
 					Max:   s.Max,
+					Node:  s.Node,
 				},
 			}
 			qi.Messages <- m
@@ -383,6 +403,7 @@ func (c *ComfyClient) OnWindowSocketMessage(msg string) {
 			// collect the data from the output
 			mdata := &PromptMessageData{
 				NodeID: s.Node,
+				Node:   s.NodeID,
 				Data:   make(map[string][]DataOutput),
 			}
 
@@ -425,6 +446,10 @@ func (c *ComfyClient) OnWindowSocketMessage(msg string) {
 		if qi != nil {
 			nindex, _ := strconv.Atoi(s.Node) // the node id is serialized as a string
 			tnode := qi.Workflow.GetNodeById(nindex)
+			nodeName := s.NodeType
+			if tnode != nil { // @mod
+				nodeName = tnode.Title
+			}
 			m := PromptMessage{
 				Type: "stopped",
 				Message: &PromptMessageStopped{
@@ -432,7 +457,7 @@ func (c *ComfyClient) OnWindowSocketMessage(msg string) {
 					Exception: &PromptMessageStoppedException{
 						NodeID:           nindex,
 						NodeType:         s.NodeType,
-						NodeName:         tnode.Title,
+						NodeName:         nodeName,
 						ExceptionMessage: s.ExceptionMessage,
 						ExceptionType:    s.ExceptionType,
 						Traceback:        s.Traceback,
@@ -449,7 +474,15 @@ func (c *ComfyClient) OnWindowSocketMessage(msg string) {
 			close(qi.Messages) // @mod
 		}
 	case "crystools.monitor":
-	case "progress_state":
+	case "progress_state": // @mod
+		s := message.Data.(*WSMessageDataProgressState)
+		qi := c.GetQueuedItem(s.PromptID)
+		if qi != nil {
+			qi.Messages <- PromptMessage{
+				Type:    "progress_state",
+				Message: &PromptMessageProgressState{Nodes: s.Nodes},
+			}
+		}
 	case "execution_success":
 	default:
 		// Handle unknown data types or return a dedicated error here
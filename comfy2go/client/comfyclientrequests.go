@@ -270,7 +270,24 @@ func (c *ComfyClient) QueuePrompt(graph *graphapi.Graph) (*QueueItem, error) {
 	if err != nil {
 		return nil, err
 	}
+	return c.queuePrompt(prompt, graph)
+}
+
+// @mod : QueuePromptNodes queues an API format prompt (node id => node), as is, without a graph.
+// The optional workflow is the UI format graph of the prompt, which is used to resolve node titles.
+func (c *ComfyClient) QueuePromptNodes(nodes map[string]graphapi.PromptNode, workflow *graphapi.Graph) (*QueueItem, error) {
+	err := c.CheckConnection()
+	if err != nil {
+		return nil, err
+	}
+	prompt := map[string]any{
+		"client_id": c.clientid,
+		"prompt":    nodes,
+	}
+	return c.queuePrompt(prompt, workflow)
+}
 
+func (c *ComfyClient) queuePrompt(prompt any, graph *graphapi.Graph) (*QueueItem, error) {
 	// prevent a race where the ws may provide messages about a queued item before
 	// we add the item to our internal map
 	c.webSocket.LockRead()
@@ -10,6 +10,8 @@ type PromptMessage struct {
 // started
 // executing
 // progress
+// cached (@mod)
+// progress_state (@mod)
 // data
 // stopped
 
@@ -30,6 +32,7 @@ func (p *PromptMessage) ToPromptMessageStarted() *PromptMessageStarted {
 
 type PromptMessageExecuting struct {
 	NodeID int
+	Node   string // @mod : raw node id, which may be a subgraph node id like "10:4"
 	Title  string
 }
 
@@ -40,14 +43,34 @@ func (p *PromptMessage) ToPromptMessageExecuting() *PromptMessageExecuting {
 type PromptMessageProgress struct {
 	Max   int
 	Value int
+	Node  string // @mod
 }
 
 func (p *PromptMessage) ToPromptMessageProgress() *PromptMessageProgress {
 	return p.Message.(*PromptMessageProgress)
 }
 
+// @mod : the nodes whose outputs are cached (not executed)
+type PromptMessageCached struct {
+	Nodes []string
+}
+
+func (p *PromptMessage) ToPromptMessageCached() *PromptMessageCached {
+	return p.Message.(*PromptMessageCached)
+}
+
+// @mod : the states of all nodes that have been started
+type PromptMessageProgressState struct {
+	Nodes map[string]ProgressStateNode
+}
+
+func (p *PromptMessage) ToPromptMessageProgressState() *PromptMessageProgressState {
+	return p.Message.(*PromptMessageProgressState)
+}
+
 type PromptMessageData struct {
 	NodeID int
+	Node   string // @mod : raw node id, which may be a subgraph node id like "10:4"
 	Data   map[string][]DataOutput
 }
 
@@ -45,6 +45,8 @@ func (sm *WSStatusMessage) UnmarshalJSON(b []byte) error {
 		sm.Data = &WSMessageExecutionInterrupted{}
 	case "execution_error":
 		sm.Data = &WSMessageExecutionError{}
+	case "progress_state": // @mod
+		sm.Data = &WSMessageDataProgressState{}
 	default:
 		// Handle unknown data types or return a dedicated error here
 		sm.Data = nil
@@ -91,6 +93,7 @@ type WSMessageDataExecutionCached struct {
 
 type WSMessageDataExecuting struct {
 	Node     *int   `json:"node"`
+	NodeID   string `json:"-"` // @mod : raw node id, which may be a subgraph node id like "10:4"; Node is -1 for it
 	PromptID string `json:"prompt_id"`
 }
 
@@ -107,9 +110,10 @@ func (mde *WSMessageDataExecuting) UnmarshalJSON(b []byte) error {
 
 	// Convert string to int
 	if temp.Node != nil {
+		mde.NodeID = *temp.Node
 		i, err := strconv.Atoi(*temp.Node)
 		if err != nil {
-			return err
+			i = -1 // @mod
 		}
 		mde.Node = &i
 	} else {
@@ -124,16 +128,39 @@ func (mde *WSMessageDataExecuting) UnmarshalJSON(b []byte) error {
 */
 
 type WSMessageDataProgress struct {
-	Value int `json:"value"`
-	Max   int `json:"max"`
+	Value    int    `json:"value"`
+	Max      int    `json:"max"`
+	PromptID string `json:"prompt_id"` // @mod
+	Node     string `json:"node"`      // @mod
 }
 
 /*
 {"type": "progress", "data": {"value": 1, "max": 20}}
+{"type": "progress", "data": {"value": 1, "max": 20, "prompt_id": "ed986d60-2a27-4d28-8871-2fdb36582902", "node": "3"}}
+*/
+
+// @mod
+type WSMessageDataProgressState struct {
+	PromptID string                       `json:"prompt_id"`
+	Nodes    map[string]ProgressStateNode `json:"nodes"`
+}
+
+// @mod
+type ProgressStateNode struct {
+	Value         float64 `json:"value"`
+	Max           float64 `json:"max"`
+	State         string  `json:"state"` // "pending", "running" or "finished"
+	NodeID        string  `json:"node_id"`
+	DisplayNodeID string  `json:"display_node_id"`
+}
+
+/*
+{"type": "progress_state", "data": {"prompt_id": "ed986d60-2a27-4d28-8871-2fdb36582902", "nodes": {"3": {"value": 5, "max": 20, "state": "running", "node_id": "3", "prompt_id": "ed986d60-2a27-4d28-8871-2fdb36582902", "display_node_id": "3", "parent_node_id": null, "real_node_id": "3"}}}}
 */
 
 type WSMessageDataExecuted struct {
 	Node     int                      `json:"node"`
+	NodeID   string                   `json:"-"` // @mod : raw node id, which may be a subgraph node id like "10:4"; Node is -1 for it
 	Output   map[string]*[]DataOutput `json:"output"`
 	PromptID string                   `json:"prompt_id"`
 }
@@ -201,6 +228,9 @@ func (mde *WSMessageDataExecuted) UnmarshalJSON(b []byte) error {
 						Text:      outstring,
 					}
 					*mde.Output[k] = append(*mde.Output[k], textout)
+				} else if _, ok := i.(bool); ok {
+					// @mod : flags like "animated": [true], not outputs
+					continue
 				} else {
 					slog.Warn(fmt.Sprintf("WSMessageDataExecuted output entry %v unknown type", i))
 					// create an "unknown" type
@@ -223,9 +253,10 @@ func (mde *WSMessageDataExecuted) UnmarshalJSON(b []byte) error {
 	mde.PromptID = temp.PromptID
 
 	// Convert string to int
+	mde.NodeID = temp.Node
 	i, err := strconv.Atoi(temp.Node)
 	if err != nil {
-		return err
+		i = -1 // @mod
 	}
 	mde.Node = i
 
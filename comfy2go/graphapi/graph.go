@@ -83,6 +83,15 @@ func (t *Graph) UnmarshalJSON(b []byte) error {
 		t.LinksByID[link.ID] = link
 	}
 
+	// @mod : flatten subgraphs and group nodes
+	definitions := &graphDefinitions{}
+	if err := json.Unmarshal(b, definitions); err != nil {
+		return err
+	}
+	if err := t.flatten(definitions); err != nil {
+		return err
+	}
+
 	// get the ordinality of nodes
 	t.NodesInExecutionOrder = make([]*GraphNode, len(t.Nodes))
 	copy(t.NodesInExecutionOrder, t.Nodes)
@@ -162,6 +171,15 @@ func (t *Graph) CreateNodeProperties(node_objects *NodeObjects) *[]string {
 			props := nobject.GetSettableProperties()
 			t.ProcessSettableProperties(n, &props, &pindex)
 
+			// @mod : apply the widget values passed from subgraph instance
+			for name, value := range n.widgetOverrides {
+				if p := n.GetPropertyWithName(name); p != nil {
+					if err := p.SetValue(value); err != nil {
+						slog.Warn("failed to set subgraph widget value", "node", n.ID, "input", name, "error", err)
+					}
+				}
+			}
+
 			// check if the number of properties is the same as the number of widget values
 			if n.WidgetValueCount() != len(props) {
 				// If the count of WidgetValues is not the same as props there may be potential issues
@@ -187,7 +205,10 @@ func (t *Graph) CreateNodeProperties(node_objects *NodeObjects) *[]string {
 			if n.Type == "PrimitiveNode" {
 				primitives = append(primitives, n)
 			} else if n.Type == "Note" || n.Type == "MarkdownNote" {
-				notewidgets := n.WidgetValues.([]interface{})
+				notewidgets, ok := n.WidgetValues.([]interface{})
+				if !ok || len(notewidgets) == 0 { // @mod
+					continue
+				}
 				// get the pointer to the first widget value
 				// we'll set the property direct_value to point to the widget inteface we want to target
 				np := newStringProperty("text", false, nil, 0)
@@ -355,6 +376,9 @@ func (t *Graph) GetLinkById(id int) *Link {
 }
 
 func (t *Graph) GetNodeById(id int) *GraphNode {
+	if t == nil { // @mod
+		return nil
+	}
 	val, ok := t.NodesByID[id]
 	if ok {
 		return val
@@ -15,6 +15,25 @@ type Link struct {
 }
 
 func (l *Link) UnmarshalJSON(b []byte) error {
+	// @mod : links of subgraph definitions are objects
+	if len(b) > 0 && b[0] == '{' {
+		var obj struct {
+			ID         int `json:"id"`
+			OriginID   int `json:"origin_id"`
+			OriginSlot int `json:"origin_slot"`
+			TargetID   int `json:"target_id"`
+			TargetSlot int `json:"target_slot"`
+			Type       any `json:"type"`
+		}
+		if err := json.Unmarshal(b, &obj); err != nil {
+			return err
+		}
+		l.ID, l.OriginID, l.OriginSlot, l.TargetID, l.TargetSlot = obj.ID, obj.OriginID, obj.OriginSlot,
+			obj.TargetID, obj.TargetSlot
+		l.Type, _ = obj.Type.(string)
+		return nil
+	}
+
 	var tmp []interface{}
 	if err := json.Unmarshal(b, &tmp); err != nil {
 		return err
@@ -30,6 +30,8 @@ type GraphNode struct {
 	DisplayName  string              `json:"-"`
 	Description  string              `json:"-"`
 	IsOutput     bool                `json:"-"`
+	// @mod : widget values (input name => value) passed from subgraph instance, applied after properties created
+	widgetOverrides map[string]any
 }
 
 func (n *GraphNode) WidgetValuesArray() []interface{} {
@@ -81,6 +83,8 @@ func (n *GraphNode) IsVirtual() bool {
 		return true
 	case "Note":
 		return true
+	case "MarkdownNote": // @mod
+		return true
 	}
 	return false
 }
@@ -16,6 +16,12 @@ type PromptNode struct {
 	//					     [1] is float64 (int) of slot index
 	Inputs    map[string]interface{} `json:"inputs"`
 	ClassType string                 `json:"class_type"`
+	Meta      *PromptNodeMeta        `json:"_meta,omitempty"` // @mod
+}
+
+// @mod : PromptNodeMeta is the metadata of node in API format workflow exported by ComfyUI.
+type PromptNodeMeta struct {
+	Title string `json:"title,omitempty"`
 }
 
 type PromptExtraData struct {
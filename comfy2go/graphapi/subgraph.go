@@ -0,0 +1,502 @@
+package graphapi
+
+// @mod : this file is added to support subgraphs and (legacy) group nodes.
+//
+// Subgraphs (ComfyUI frontend >= 1.24) are defined in workflow "definitions.subgraphs",
+// a subgraph instance node has the subgraph id as it's type.
+// Group nodes (legacy) are defined in workflow "extra.groupNodes",
+// a group node instance has the type "workflow>" + name ("workflow/" + name in older versions).
+//
+// Both are flattened when the graph is unmarshalled: each instance node is replaced by copies of
+// the inner nodes (with new ids), and the links to / from the instance node are rewired to the inner nodes.
+// A muted instance mutes all it's inner nodes. A bypassed instance is dropped, each of it's outputs
+// is fed by the instance input of the same type (like ComfyUI bypasses a node).
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	SUBGRAPH_INPUT_NODE_ID  = -10 // virtual node of subgraph inputs
+	SUBGRAPH_OUTPUT_NODE_ID = -20 // virtual node of subgraph outputs
+	MAX_FLATTEN_ROUNDS      = 32  // max nesting depth of subgraphs
+	NODE_MODE_MUTED         = 2
+	NODE_MODE_BYPASS        = 4
+)
+
+var groupNodeTypePrefixes = []string{"workflow>", "workflow/"}
+
+type subgraphDefinition struct {
+	ID    string       `json:"id"`
+	Nodes []*GraphNode `json:"nodes"`
+	Links []*Link      `json:"links"`
+}
+
+type groupNodeDefinition struct {
+	Nodes []*GraphNode `json:"nodes"`
+	// [origin node index, origin slot, target node index, target slot, origin node id, type]
+	Links  [][]any `json:"links"`
+	Config map[string]struct {
+		Output map[string]struct {
+			Visible *bool `json:"visible"`
+		} `json:"output"`
+	} `json:"config"`
+}
+
+// The subgraph and group node definitions of workflow json.
+type graphDefinitions struct {
+	Definitions struct {
+		Subgraphs []json.RawMessage `json:"subgraphs"`
+	} `json:"definitions"`
+	Extra struct {
+		GroupNodes map[string]json.RawMessage `json:"groupNodes"`
+	} `json:"extra"`
+}
+
+// A slot of a node: node id and slot index.
+type slotRef struct {
+	node int
+	slot int
+}
+
+// The inner nodes and links that replace an instance node.
+type expansion struct {
+	nodes []*GraphNode
+	links []*Link
+	// instance input slot index => inner node input slots
+	inputs map[int][]slotRef
+	// instance output slot index => inner node output slot
+	outputs map[int]slotRef
+	// instance output slot index => instance input slot index, the subgraph input is directly passed to output
+	passthroughs map[int]int
+}
+
+// Flatten all subgraph and group node instances of graph.
+func (t *Graph) flatten(definitions *graphDefinitions) error {
+	subgraphs := map[string]json.RawMessage{}
+	for _, data := range definitions.Definitions.Subgraphs {
+		var header struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(data, &header); err != nil {
+			return err
+		}
+		subgraphs[header.ID] = data
+	}
+	if len(subgraphs) == 0 && len(definitions.Extra.GroupNodes) == 0 {
+		return nil
+	}
+	t.LastNodeID = t.maxNodeID()
+	t.LastLinkID = t.maxLinkID()
+	for round := 0; ; round++ {
+		expanded := false
+		for _, node := range slices.Clone(t.Nodes) {
+			var e *expansion
+			var err error
+			subgraphData, isSubgraph := subgraphs[node.Type]
+			groupNodeData, isGroupNode := definitions.Extra.GroupNodes[groupNodeName(node.Type)]
+			switch {
+			case !isSubgraph && !isGroupNode:
+				continue
+			case node.Mode == NODE_MODE_BYPASS:
+				e = bypassExpansion(node)
+			case isSubgraph:
+				e, err = t.expandSubgraph(node, subgraphData)
+			default:
+				e, err = t.expandGroupNode(node, groupNodeData)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to flatten node %d (%s): %w", node.ID, node.Type, err)
+			}
+			if round >= MAX_FLATTEN_ROUNDS {
+				return fmt.Errorf("subgraphs are nested too deep")
+			}
+			t.replaceNode(node, e)
+			expanded = true
+		}
+		if !expanded {
+			break
+		}
+	}
+	t.Links = make([]*Link, 0, len(t.LinksByID))
+	for _, link := range t.LinksByID {
+		t.Links = append(t.Links, link)
+	}
+	slices.SortFunc(t.Links, func(a, b *Link) int {
+		return a.ID - b.ID
+	})
+	return nil
+}
+
+func groupNodeName(nodeType string) string {
+	for _, prefix := range groupNodeTypePrefixes {
+		if name, ok := strings.CutPrefix(nodeType, prefix); ok {
+			return name
+		}
+	}
+	return ""
+}
+
+func (t *Graph) maxNodeID() int {
+	id := t.LastNodeID
+	for _, node := range t.Nodes {
+		id = max(id, node.ID)
+	}
+	return id
+}
+
+func (t *Graph) maxLinkID() int {
+	id := t.LastLinkID
+	for _, link := range t.LinksByID {
+		id = max(id, link.ID)
+	}
+	return id
+}
+
+// Prepare the inner nodes of an expansion: assign new ids and clear all links.
+func (t *Graph) newExpansion(instance *GraphNode, nodes []*GraphNode) *expansion {
+	e := &expansion{
+		nodes:        nodes,
+		inputs:       map[int][]slotRef{},
+		outputs:      map[int]slotRef{},
+		passthroughs: map[int]int{},
+	}
+	for _, node := range nodes {
+		t.LastNodeID++
+		node.ID = t.LastNodeID
+		node.Order = instance.Order
+		if instance.Mode == NODE_MODE_MUTED {
+			node.Mode = NODE_MODE_MUTED
+		}
+		for i := range node.Inputs {
+			node.Inputs[i].Link = 0
+		}
+		for i := range node.Outputs {
+			node.Outputs[i].Links = &[]int{}
+		}
+	}
+	return e
+}
+
+// Return the expansion of a bypassed instance: no inner nodes, each output passes through
+// the input of the same type, preferring the input of the same index.
+// An output that has no input of the same type is left unconnected.
+func bypassExpansion(instance *GraphNode) *expansion {
+	e := &expansion{
+		inputs:       map[int][]slotRef{},
+		outputs:      map[int]slotRef{},
+		passthroughs: map[int]int{},
+	}
+	matches := func(input Slot, output Slot) bool {
+		return input.Type == output.Type || input.Type == "*" || output.Type == "*"
+	}
+	for i, output := range instance.Outputs {
+		if i < len(instance.Inputs) && matches(instance.Inputs[i], output) {
+			e.passthroughs[i] = i
+		} else if k := slices.IndexFunc(instance.Inputs, func(input Slot) bool {
+			return matches(input, output)
+		}); k >= 0 {
+			e.passthroughs[i] = k
+		}
+	}
+	return e
+}
+
+// Add an internal link between inner nodes of expansion.
+func (t *Graph) addExpansionLink(e *expansion, origin *GraphNode, originSlot int, target *GraphNode,
+	targetSlot int, linkType string) {
+	if originSlot < 0 || originSlot >= len(origin.Outputs) || targetSlot < 0 || targetSlot >= len(target.Inputs) {
+		slog.Warn("invalid link slot", "origin", origin.ID, "target", target.ID)
+		return
+	}
+	t.LastLinkID++
+	link := &Link{
+		ID:         t.LastLinkID,
+		OriginID:   origin.ID,
+		OriginSlot: originSlot,
+		TargetID:   target.ID,
+		TargetSlot: targetSlot,
+		Type:       linkType,
+	}
+	e.links = append(e.links, link)
+	target.Inputs[targetSlot].Link = link.ID
+	addOutputLink(origin, originSlot, link.ID)
+}
+
+func (t *Graph) expandSubgraph(instance *GraphNode, data []byte) (*expansion, error) {
+	definition := &subgraphDefinition{}
+	if err := json.Unmarshal(data, definition); err != nil {
+		return nil, err
+	}
+	nodesByID := map[int]*GraphNode{}
+	for _, node := range definition.Nodes {
+		nodesByID[node.ID] = node
+	}
+	e := t.newExpansion(instance, definition.Nodes)
+	for _, link := range definition.Links {
+		origin, target := nodesByID[link.OriginID], nodesByID[link.TargetID]
+		switch {
+		case link.OriginID == SUBGRAPH_INPUT_NODE_ID && link.TargetID == SUBGRAPH_OUTPUT_NODE_ID:
+			e.passthroughs[link.TargetSlot] = link.OriginSlot
+		case link.OriginID == SUBGRAPH_INPUT_NODE_ID && target != nil:
+			if link.TargetSlot < 0 || link.TargetSlot >= len(target.Inputs) {
+				slog.Warn("invalid subgraph input link slot", "target", target.ID, "slot", link.TargetSlot)
+				continue
+			}
+			e.inputs[link.OriginSlot] = append(e.inputs[link.OriginSlot], slotRef{target.ID, link.TargetSlot})
+		case link.TargetID == SUBGRAPH_OUTPUT_NODE_ID && origin != nil:
+			if link.OriginSlot < 0 || link.OriginSlot >= len(origin.Outputs) {
+				slog.Warn("invalid subgraph output link slot", "origin", origin.ID, "slot", link.OriginSlot)
+				continue
+			}
+			e.outputs[link.TargetSlot] = slotRef{origin.ID, link.OriginSlot}
+		case origin != nil && target != nil:
+			t.addExpansionLink(e, origin, link.OriginSlot, target, link.TargetSlot, link.Type)
+		default:
+			slog.Warn("subgraph link of unknown node", "origin", link.OriginID, "target", link.TargetID)
+		}
+	}
+
+	// The instance widgets are the subgraph inputs that are connected to inner widgets.
+	// If such an input is not linked, pass the instance widget value to the inner widgets.
+	// The value passed from an outer instance (if this instance is nested) takes precedence.
+	widgetValues := instance.WidgetValuesArray()
+	widgetIndex := 0
+	for i, slot := range instance.Inputs {
+		if slot.Widget == nil {
+			continue
+		}
+		if slot.Link == 0 {
+			value, ok := instance.widgetOverrides[slotWidgetName(slot)]
+			if !ok && widgetIndex < len(widgetValues) {
+				value, ok = widgetValues[widgetIndex], true
+			}
+			if ok {
+				e.setWidgetOverrides(e.inputs[i], value)
+			}
+		}
+		widgetIndex++
+	}
+	return e, nil
+}
+
+func (t *Graph) expandGroupNode(instance *GraphNode, data []byte) (*expansion, error) {
+	definition := &groupNodeDefinition{}
+	if err := json.Unmarshal(data, definition); err != nil {
+		return nil, err
+	}
+	e := t.newExpansion(instance, definition.Nodes)
+	for _, link := range definition.Links {
+		if len(link) < 4 {
+			continue
+		}
+		originIndex, ok1 := link[0].(float64)
+		originSlot, ok2 := link[1].(float64)
+		targetIndex, ok3 := link[2].(float64)
+		targetSlot, ok4 := link[3].(float64)
+		if !ok1 || !ok2 || !ok3 || !ok4 || int(originIndex) >= len(e.nodes) || int(targetIndex) >= len(e.nodes) ||
+			originIndex < 0 || targetIndex < 0 {
+			continue
+		}
+		linkType := ""
+		if len(link) > 5 {
+			linkType, _ = link[5].(string)
+		}
+		t.addExpansionLink(e, e.nodes[int(originIndex)], int(originSlot), e.nodes[int(targetIndex)], int(targetSlot),
+			linkType)
+	}
+
+	// The instance inputs are the inner node inputs that are not linked internally, in order.
+	// Widget inputs are matched by name, which may be prefixed by inner node title to be unique.
+	var instanceInputs []int
+	usedInstanceInputs := map[int]bool{}
+	for i, slot := range instance.Inputs {
+		if slot.Widget == nil {
+			instanceInputs = append(instanceInputs, i)
+		}
+	}
+	for _, node := range e.nodes {
+		for j, slot := range node.Inputs {
+			if slot.Link != 0 {
+				continue
+			}
+			if slot.Widget == nil {
+				if len(instanceInputs) > 0 {
+					e.inputs[instanceInputs[0]] = append(e.inputs[instanceInputs[0]], slotRef{node.ID, j})
+					instanceInputs = instanceInputs[1:]
+				}
+				continue
+			}
+			name := slotWidgetName(slot)
+			for i, instanceSlot := range instance.Inputs {
+				instanceName := slotWidgetName(instanceSlot)
+				if instanceSlot.Widget != nil && !usedInstanceInputs[i] &&
+					(instanceName == name || strings.HasSuffix(instanceName, " "+name)) {
+					e.inputs[i] = append(e.inputs[i], slotRef{node.ID, j})
+					usedInstanceInputs[i] = true
+					break
+				}
+			}
+		}
+	}
+
+	// Pass the widget values from outer subgraph instance (if this instance is nested) to inner widgets.
+	for i, slot := range instance.Inputs {
+		if value, ok := instance.widgetOverrides[slotWidgetName(slot)]; ok && slot.Widget != nil && slot.Link == 0 {
+			e.setWidgetOverrides(e.inputs[i], value)
+		}
+	}
+
+	// The instance outputs are all the visible outputs of inner nodes, in order.
+	outputIndex := 0
+	for i, node := range e.nodes {
+		for j := range node.Outputs {
+			if visible := definition.Config[strconv.Itoa(i)].Output[strconv.Itoa(j)].Visible; visible != nil && !*visible {
+				continue
+			}
+			e.outputs[outputIndex] = slotRef{node.ID, j}
+			outputIndex++
+		}
+	}
+
+	// The instance widget values are the widget values of inner nodes, concatenated.
+	widgetValues := instance.WidgetValuesArray()
+	count := 0
+	for _, node := range e.nodes {
+		count += len(node.WidgetValuesArray())
+	}
+	if len(widgetValues) == count {
+		for _, node := range e.nodes {
+			n := len(node.WidgetValuesArray())
+			node.WidgetValues = slices.Clone(widgetValues[:n])
+			widgetValues = widgetValues[n:]
+		}
+	} else if len(widgetValues) > 0 {
+		slog.Warn("group node widget values count mismatch, use the default values", "node", instance.ID)
+	}
+	return e, nil
+}
+
+func (e *expansion) node(id int) *GraphNode {
+	for _, node := range e.nodes {
+		if node.ID == id {
+			return node
+		}
+	}
+	return nil
+}
+
+// Set the value of inner widget inputs refs.
+func (e *expansion) setWidgetOverrides(refs []slotRef, value any) {
+	for _, ref := range refs {
+		target := e.node(ref.node)
+		name := slotWidgetName(target.Inputs[ref.slot])
+		if target.widgetOverrides == nil {
+			target.widgetOverrides = map[string]any{}
+		}
+		target.widgetOverrides[name] = value
+	}
+}
+
+func slotWidgetName(slot Slot) string {
+	if slot.Widget != nil && slot.Widget.Name != nil {
+		return *slot.Widget.Name
+	}
+	return slot.Name
+}
+
+// Replace instance node with the inner nodes of expansion, rewire the links to / from the instance node.
+func (t *Graph) replaceNode(instance *GraphNode, e *expansion) {
+	for _, node := range e.nodes {
+		node.Graph = t
+		t.NodesByID[node.ID] = node
+	}
+	for _, link := range e.links {
+		t.LinksByID[link.ID] = link
+	}
+
+	// instance input slot index => the link that feeds it
+	inputLinks := map[int]*Link{}
+	for i, slot := range instance.Inputs {
+		link := t.GetLinkById(slot.Link)
+		if link == nil {
+			continue
+		}
+		inputLinks[i] = link
+		delete(t.LinksByID, link.ID)
+		origin := t.GetNodeById(link.OriginID)
+		removeOutputLink(origin, link.OriginSlot, link.ID)
+		for _, ref := range e.inputs[i] {
+			target := t.GetNodeById(ref.node)
+			if target == nil || ref.slot < 0 || ref.slot >= len(target.Inputs) {
+				continue
+			}
+			t.LastLinkID++
+			newLink := &Link{
+				ID:         t.LastLinkID,
+				OriginID:   link.OriginID,
+				OriginSlot: link.OriginSlot,
+				TargetID:   ref.node,
+				TargetSlot: ref.slot,
+				Type:       link.Type,
+			}
+			t.LinksByID[newLink.ID] = newLink
+			target.Inputs[ref.slot].Link = newLink.ID
+			addOutputLink(origin, link.OriginSlot, newLink.ID)
+		}
+	}
+
+	for i, slot := range instance.Outputs {
+		if slot.Links == nil {
+			continue
+		}
+		for _, id := range *slot.Links {
+			link := t.GetLinkById(id)
+			if link == nil {
+				continue
+			}
+			ref, ok := e.outputs[i]
+			if k, isPassthrough := e.passthroughs[i]; !ok && isPassthrough && inputLinks[k] != nil {
+				ref, ok = slotRef{inputLinks[k].OriginID, inputLinks[k].OriginSlot}, true
+			}
+			if !ok {
+				// the output has no source
+				if target := t.GetNodeById(link.TargetID); target != nil && link.TargetSlot < len(target.Inputs) {
+					target.Inputs[link.TargetSlot].Link = 0
+				}
+				delete(t.LinksByID, link.ID)
+				continue
+			}
+			link.OriginID, link.OriginSlot = ref.node, ref.slot
+			addOutputLink(t.GetNodeById(link.OriginID), link.OriginSlot, link.ID)
+		}
+	}
+
+	delete(t.NodesByID, instance.ID)
+	index := slices.Index(t.Nodes, instance)
+	t.Nodes = append(append(append([]*GraphNode{}, t.Nodes[:index]...), e.nodes...), t.Nodes[index+1:]...)
+}
+
+func addOutputLink(node *GraphNode, slot int, id int) {
+	if node == nil || slot < 0 || slot >= len(node.Outputs) {
+		return
+	}
+	if node.Outputs[slot].Links == nil {
+		node.Outputs[slot].Links = &[]int{}
+	}
+	*node.Outputs[slot].Links = append(*node.Outputs[slot].Links, id)
+}
+
+func removeOutputLink(node *GraphNode, slot int, id int) {
+	if node == nil || slot < 0 || slot >= len(node.Outputs) || node.Outputs[slot].Links == nil {
+		return
+	}
+	*node.Outputs[slot].Links = slices.DeleteFunc(*node.Outputs[slot].Links, func(l int) bool {
+		return l == id
+	})
+}
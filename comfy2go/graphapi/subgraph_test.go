@@ -0,0 +1,200 @@
+package graphapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Workflows of testdata are in ComfyUI frontend (1.26) export format.
+func TestFlatten(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      string
+		replace   []string // old, new pairs of workflow json text replacements
+		nodes     []string // "label" or "label(mode N)" of nodes, where label is node title or type
+		links     []string // "origin:slot>target:slot"
+		overrides []string // "label.input=value" of widget values passed from subgraph instances
+		widgets   []string // "label=widgets_values", of legacy group node inner nodes
+	}{
+		{
+			name:  "nested subgraphs with passthrough and unlinked promoted widget",
+			file:  "subgraph_nested.json",
+			nodes: []string{"CLIPTextEncode", "CheckpointLoaderSimple", "KSampler"},
+			links: []string{
+				"CLIPTextEncode:0>KSampler:1",
+				"CheckpointLoaderSimple:0>KSampler:0",
+				"CheckpointLoaderSimple:1>CLIPTextEncode:0",
+			},
+			overrides: []string{"CLIPTextEncode.text=a photo of a cat"},
+		},
+		{
+			name:  "promoted widget of linked and unlinked instance inputs",
+			file:  "subgraph_widgets.json",
+			nodes: []string{"CLIPTextEncode", "CLIPTextEncode", "CheckpointLoaderSimple", "KSampler", "Positive Prompt"},
+			links: []string{
+				"CLIPTextEncode:0>KSampler:1",
+				"CLIPTextEncode:0>KSampler:2",
+				"CheckpointLoaderSimple:0>KSampler:0",
+				"CheckpointLoaderSimple:1>CLIPTextEncode:0",
+				"CheckpointLoaderSimple:1>CLIPTextEncode:0",
+				"Positive Prompt:0>CLIPTextEncode:1",
+			},
+			overrides: []string{"CLIPTextEncode.text=blurry, low quality"},
+		},
+		{
+			name:  "legacy group node with hidden outputs",
+			file:  "group_node.json",
+			nodes: []string{"CLIPTextEncode", "CheckpointLoaderSimple", "Detail LoRA", "KSampler", "Style LoRA"},
+			links: []string{
+				"CLIPTextEncode:0>KSampler:1",
+				"CheckpointLoaderSimple:0>Style LoRA:0",
+				"CheckpointLoaderSimple:1>Style LoRA:1",
+				"Detail LoRA:0>KSampler:0",
+				"Detail LoRA:1>CLIPTextEncode:0",
+				"Style LoRA:0>Detail LoRA:0",
+				"Style LoRA:1>Detail LoRA:1",
+			},
+			widgets: []string{"Detail LoRA=[detail.safetensors 0.5 0.4]", "Style LoRA=[style.safetensors 0.8 0.7]"},
+		},
+		{
+			name:  "bypassed instance",
+			file:  "subgraph_bypass.json",
+			nodes: []string{"CLIPTextEncode", "CheckpointLoaderSimple", "KSampler"},
+			links: []string{
+				"CLIPTextEncode:0>KSampler:1",
+				"CheckpointLoaderSimple:0>KSampler:0",
+				"CheckpointLoaderSimple:1>CLIPTextEncode:0",
+			},
+		},
+		{
+			name:    "muted instance",
+			file:    "subgraph_bypass.json",
+			replace: []string{`"order": 1,` + "\n      \"mode\": 4", `"order": 1,` + "\n      \"mode\": 2"},
+			nodes:   []string{"CLIPTextEncode", "CheckpointLoaderSimple", "KSampler", "LoraLoader(mode 2)"},
+			links: []string{
+				"CLIPTextEncode:0>KSampler:1",
+				"CheckpointLoaderSimple:0>LoraLoader:0",
+				"CheckpointLoaderSimple:1>LoraLoader:1",
+				"LoraLoader:0>KSampler:0",
+				"LoraLoader:1>CLIPTextEncode:0",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i+1 < len(tt.replace); i += 2 {
+				if !strings.Contains(string(data), tt.replace[i]) {
+					t.Fatalf("replaced text %q not found", tt.replace[i])
+				}
+				data = []byte(strings.ReplaceAll(string(data), tt.replace[i], tt.replace[i+1]))
+			}
+			graph := &Graph{}
+			if err := json.Unmarshal(data, graph); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			checkGraphLinks(t, graph)
+
+			var nodes, links, overrides, widgets []string
+			for _, node := range graph.Nodes {
+				label := nodeLabel(node)
+				if node.Mode != 0 {
+					label += fmt.Sprintf("(mode %d)", node.Mode)
+				}
+				nodes = append(nodes, label)
+				for name, value := range node.widgetOverrides {
+					overrides = append(overrides, fmt.Sprintf("%s.%s=%v", nodeLabel(node), name, value))
+				}
+				if node.Title != "" && node.Type == "LoraLoader" {
+					widgets = append(widgets, fmt.Sprintf("%s=%v", node.Title, node.WidgetValuesArray()))
+				}
+			}
+			for _, link := range graph.Links {
+				links = append(links, fmt.Sprintf("%s:%d>%s:%d", nodeLabel(graph.GetNodeById(link.OriginID)),
+					link.OriginSlot, nodeLabel(graph.GetNodeById(link.TargetID)), link.TargetSlot))
+			}
+			for _, check := range []struct {
+				name      string
+				got, want []string
+			}{
+				{"nodes", nodes, tt.nodes},
+				{"links", links, tt.links},
+				{"overrides", overrides, tt.overrides},
+				{"widgets", widgets, tt.widgets},
+			} {
+				slices.Sort(check.got)
+				if !slices.Equal(check.got, check.want) {
+					t.Errorf("%s = %q, want %q", check.name, check.got, check.want)
+				}
+			}
+		})
+	}
+}
+
+func nodeLabel(node *GraphNode) string {
+	if node == nil {
+		return "<nil>"
+	}
+	if node.Title != "" {
+		return node.Title
+	}
+	return node.Type
+}
+
+// Check that the links of graph are consistent with the node slots.
+func checkGraphLinks(t *testing.T, graph *Graph) {
+	t.Helper()
+	if len(graph.Links) != len(graph.LinksByID) {
+		t.Errorf("links count %d != links by id count %d", len(graph.Links), len(graph.LinksByID))
+	}
+	ids := map[int]bool{}
+	for _, node := range graph.Nodes {
+		if ids[node.ID] {
+			t.Errorf("duplicate node id %d", node.ID)
+		}
+		ids[node.ID] = true
+		if graph.GetNodeById(node.ID) != node {
+			t.Errorf("node %d is not in nodes by id", node.ID)
+		}
+		for i, slot := range node.Inputs {
+			if slot.Link == 0 {
+				continue
+			}
+			if link := graph.GetLinkById(slot.Link); link == nil || link.TargetID != node.ID || link.TargetSlot != i {
+				t.Errorf("node %d input %d: invalid link %d", node.ID, i, slot.Link)
+			}
+		}
+		for i, slot := range node.Outputs {
+			if slot.Links == nil {
+				continue
+			}
+			for _, id := range *slot.Links {
+				if link := graph.GetLinkById(id); link == nil || link.OriginID != node.ID || link.OriginSlot != i {
+					t.Errorf("node %d output %d: invalid link %d", node.ID, i, id)
+				}
+			}
+		}
+	}
+	for _, link := range graph.Links {
+		origin, target := graph.GetNodeById(link.OriginID), graph.GetNodeById(link.TargetID)
+		if origin == nil || target == nil {
+			t.Errorf("link %d: node not found", link.ID)
+			continue
+		}
+		if link.TargetSlot >= len(target.Inputs) || target.Inputs[link.TargetSlot].Link != link.ID {
+			t.Errorf("link %d: not set in target node %d input %d", link.ID, target.ID, link.TargetSlot)
+		}
+		if link.OriginSlot >= len(origin.Outputs) || origin.Outputs[link.OriginSlot].Links == nil ||
+			!slices.Contains(*origin.Outputs[link.OriginSlot].Links, link.ID) {
+			t.Errorf("link %d: not set in origin node %d output %d", link.ID, origin.ID, link.OriginSlot)
+		}
+	}
+}
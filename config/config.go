@@ -1,17 +1,294 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 
 	"github.com/sagan/goaider/constants"
+	"github.com/sagan/goaider/features/llm"
 )
 
+// A named LLM model profile. Use the profile name as model, e.g. "--model mylocal".
+type ModelProfile struct {
+	// "openai" (default): OpenAI API compatible; "gemini": native Gemini API.
+	Provider string `yaml:"provider"`
+	// API base url. Required for "openai" provider, e.g. "http://localhost:8080/v1".
+	BaseUrl string `yaml:"base_url"`
+	// Provider side model name, e.g. "gpt-oss-120b".
+	Model string `yaml:"model"`
+	// Env name to read the API key from, if --model-key flag is not set.
+	ApiKeyEnv string `yaml:"api_key_env"`
+	// Default temperature. Used if --temperature flag is not set.
+	Temperature *float64 `yaml:"temperature"`
+	// Extra http request headers.
+	Headers map[string]string `yaml:"headers"`
+	// Optional price in USD per 1M input / output tokens, used for cost accounting.
+	InputPrice  *float64 `yaml:"input_price"`
+	OutputPrice *float64 `yaml:"output_price"`
+	// Optional client-side rate limit of the profile. Override the global "rate_limit".
+	Rpm int64 `yaml:"rpm"`
+	Tpm int64 `yaml:"tpm"`
+}
+
+// Retry policy of LLM requests. Unset fields use the default values of llm.DefaultRetryPolicy.
+type RetryConfig struct {
+	MaxAttempts  int           `yaml:"max_attempts"`   // including the first attempt. 1: no retry
+	BaseBackoff  time.Duration `yaml:"base_backoff"`   // e.g. "2s"
+	MaxBackoff   time.Duration `yaml:"max_backoff"`    // e.g. "60s"
+	MaxTotalWait time.Duration `yaml:"max_total_wait"` // e.g. "5m"
+}
+
+// Client-side rate limit of LLM requests, applied to each model separately.
+type RateLimitConfig struct {
+	Rpm int64 `yaml:"rpm"` // max requests per minute
+	Tpm int64 `yaml:"tpm"` // max tokens per minute
+}
+
+// goaider config file contents.
+//
+//	default_model: mylocal
+//	commands:
+//	  caption: gemini-2.5-pro
+//	profiles:
+//	  mylocal:
+//	    base_url: http://localhost:8080/v1
+//	    model: gpt-oss-120b
+//	    api_key_env: MYLOCAL_API_KEY
+//	    temperature: 0.2
+//	    headers:
+//	      X-Foo: bar
+//	usage_ledger: true
+//	cache: true
+//	retry:
+//	  max_attempts: 5
+//	  max_total_wait: 5m
+//	rate_limit:
+//	  rpm: 10
+type Config struct {
+	// Default model (or profile name). GOAIDER_MODEL env takes precedence over it.
+	DefaultModel string `yaml:"default_model"`
+	// Per-command default model (or profile name), keyed by command path without root,
+	// e.g. "caption", "comfyui batchi2v". It takes precedence over default model.
+	Commands map[string]string `yaml:"commands"`
+	// Named model profiles.
+	Profiles map[string]*ModelProfile `yaml:"profiles"`
+	// Append LLM usage of each command run to the usage ledger file.
+	UsageLedger bool `yaml:"usage_ledger"`
+	// Enable on-disk LLM response cache by default. The "--cache" / "--no-cache" flag takes precedence.
+	// Only temperature 0 requests are cached.
+	Cache bool `yaml:"cache"`
+	// LLM response cache dir. Default is "<UserCacheDir>/goaider/llm", e.g. "~/.cache/goaider/llm" on Linux.
+	CacheDir string `yaml:"cache_dir"`
+	// Retry policy of LLM requests.
+	Retry *RetryConfig `yaml:"retry"`
+	// Default client-side rate limit of each model.
+	RateLimit *RateLimitConfig `yaml:"rate_limit"`
+}
+
+var (
+	loadOnce sync.Once
+	config   *Config
+	loadErr  error // error of loading config file
+)
+
+// Return the config file path.
+// It's GOAIDER_CONFIG env if set, otherwise "<UserConfigDir>/goaider/config.yaml",
+// e.g. "~/.config/goaider/config.yaml" on Linux.
+func GetConfigFile() string {
+	if configFile := os.Getenv(constants.ENV_CONFIG); configFile != "" {
+		return configFile
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(configDir, constants.CONFIG_DIR_NAME, constants.CONFIG_FILENAME)
+}
+
+// Load config file. Return an empty config if config file does not exist.
+func Load(configFile string) (*Config, error) {
+	cfg := &Config{}
+	if configFile == "" {
+		return cfg, nil
+	}
+	contents, err := os.ReadFile(configFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return cfg, nil
+		}
+		return nil, err
+	}
+	if err := yaml.Unmarshal(contents, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %q: %w", configFile, err)
+	}
+	for name, profile := range cfg.Profiles {
+		if profile == nil {
+			return nil, fmt.Errorf("invalid profile %q in config file %q: empty", name, configFile)
+		}
+		switch profile.Provider {
+		case "", "openai":
+			if profile.BaseUrl == "" {
+				return nil, fmt.Errorf("invalid profile %q in config file %q: base_url not set", name, configFile)
+			}
+		case "gemini":
+		default:
+			return nil, fmt.Errorf("invalid profile %q in config file %q: unsupported provider %q",
+				name, configFile, profile.Provider)
+		}
+		if profile.Model == "" {
+			return nil, fmt.Errorf("invalid profile %q in config file %q: model not set", name, configFile)
+		}
+	}
+	return cfg, nil
+}
+
+// Get the config, which is loaded from config file on first call.
+// If the config file is malformed, the default (empty) config is returned, use Err to get the error.
+func Get() *Config {
+	loadOnce.Do(func() {
+		config, loadErr = Load(GetConfigFile())
+		if loadErr != nil {
+			config = &Config{}
+			return
+		}
+		for name, profile := range config.Profiles {
+			if profile.InputPrice != nil || profile.OutputPrice != nil {
+				price := &llm.ModelPrice{}
+				if profile.InputPrice != nil {
+					price.Input = *profile.InputPrice
+				}
+				if profile.OutputPrice != nil {
+					price.Output = *profile.OutputPrice
+				}
+				llm.RegisterModelPrice(name, price)
+			}
+			if profile.Rpm > 0 || profile.Tpm > 0 {
+				llm.SetRateLimit(name, &llm.RateLimit{Rpm: profile.Rpm, Tpm: profile.Tpm})
+			}
+		}
+		if config.Retry != nil {
+			policy := llm.DefaultRetryPolicy
+			if config.Retry.MaxAttempts > 0 {
+				policy.MaxAttempts = config.Retry.MaxAttempts
+			}
+			if config.Retry.BaseBackoff > 0 {
+				policy.BaseBackoff = config.Retry.BaseBackoff
+			}
+			if config.Retry.MaxBackoff > 0 {
+				policy.MaxBackoff = config.Retry.MaxBackoff
+			}
+			if config.Retry.MaxTotalWait > 0 {
+				policy.MaxTotalWait = config.Retry.MaxTotalWait
+			}
+			llm.SetRetryPolicy(policy)
+		}
+		if config.RateLimit != nil {
+			llm.SetRateLimit("", &llm.RateLimit{Rpm: config.RateLimit.Rpm, Tpm: config.RateLimit.Tpm})
+		}
+	})
+	return config
+}
+
+// Return the error of loading config file. nil if it's loaded successfully or not exists.
+func Err() error {
+	Get()
+	return loadErr
+}
+
+// Get a named model profile. Return nil if not found.
+func GetProfile(name string) *ModelProfile {
+	return Get().Profiles[name]
+}
+
 // GetDefaultModel returns the default model to use for the AI.
-// It checks the GOAIDER_MODEL environment variable first, then falls back to constants.DEFAULT_MODEL.
+// It checks the GOAIDER_MODEL environment variable first, then the config file "default_model",
+// then falls back to constants.DEFAULT_MODEL.
 func GetDefaultModel() string {
 	model := os.Getenv(constants.ENV_MODEL)
+	if model == "" {
+		model = Get().DefaultModel
+	}
 	if model == "" {
 		model = constants.DEFAULT_MODEL
 	}
 	return model
 }
+
+// GetCommandModel resolves the model of a command from it's "--model" and "--temperature" flags.
+// If model is empty, it uses the per-command default model of config file, then GetDefaultModel().
+// If the final model is a profile that has temperature and the "--temperature" flag is not set by user,
+// the profile temperature is written to *temperature. temperature can be nil.
+// It returns the error of loading config file, which LLM commands can not work without.
+func GetCommandModel(cmd *cobra.Command, model string, temperature *float64) (string, error) {
+	if err := Err(); err != nil {
+		return "", err
+	}
+	if model == "" {
+		model = Get().Commands[GetCommandName(cmd)]
+	}
+	if model == "" {
+		model = GetDefaultModel()
+	}
+	if profile := GetProfile(model); profile != nil && profile.Temperature != nil && temperature != nil {
+		if flag := cmd.Flags().Lookup("temperature"); flag == nil || !flag.Changed {
+			*temperature = *profile.Temperature
+		}
+	}
+	return model, nil
+}
+
+// Return the usage ledger file path: "<UserConfigDir>/goaider/usage.jsonl".
+// The dir is created if not exists.
+func GetUsageLedgerFile() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(configDir, constants.CONFIG_DIR_NAME)
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, constants.USAGE_LEDGER_FILENAME), nil
+}
+
+// Return the LLM response cache dir.
+func GetCacheDir() (string, error) {
+	if dir := Get().CacheDir; dir != "" {
+		return dir, nil
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, constants.CONFIG_DIR_NAME, constants.LLM_CACHE_DIR_NAME), nil
+}
+
+// Return command path without root command name, e.g. "comfyui batchi2v".
+func GetCommandName(cmd *cobra.Command) string {
+	name := cmd.Name()
+	for parent := cmd.Parent(); parent != nil && parent.HasParent(); parent = parent.Parent() {
+		name = parent.Name() + " " + name
+	}
+	return name
+}
+
+func init() {
+	llm.RegisterProvider("profile", func(model string) (llm.Provider, string, error) {
+		profile := GetProfile(model)
+		if profile == nil {
+			return nil, "", nil
+		}
+		if profile.Provider == "gemini" {
+			return &llm.GeminiProvider{ApiKeyEnv: profile.ApiKeyEnv, Headers: profile.Headers}, profile.Model, nil
+		}
+		return &llm.OpenAIProvider{BaseUrl: profile.BaseUrl, ApiKeyEnv: profile.ApiKeyEnv, Headers: profile.Headers},
+			profile.Model, nil
+	})
+}
@@ -10,6 +10,7 @@ const (
 	ENV_OPENROUTER_API_KEY = "OPENROUTER_API_KEY"
 	ENV_MODEL_KEY          = "GOAIDER_MODEL_KEY" // customary OpenAI API compatible model key
 	ENV_MODEL              = "GOAIDER_MODEL"
+	ENV_CONFIG             = "GOAIDER_CONFIG" // config file path
 	ENV_TTS                = "GOAIDER_TTS"
 	ENV_FFMPEG             = "GOAIDER_FFMPEG"  // ffmpeg binary path
 	ENV_FFPROBE            = "GOAIDER_FFPROBE" // ffprobe binary path
@@ -17,6 +18,13 @@ const (
 	FFMPEG  = "ffmpeg"
 	FFPROBE = "ffprobe"
 
+	CONFIG_DIR_NAME = "goaider"     // config dir name in user config dir
+	CONFIG_FILENAME = "config.yaml" // config file name
+
+	USAGE_LEDGER_FILENAME = "usage.jsonl" // LLM usage ledger file name in config dir
+
+	LLM_CACHE_DIR_NAME = "llm" // LLM response cache dir name in "<UserCacheDir>/goaider"
+
 	// Default LLM model
 	DEFAULT_MODEL = "gemini-2.5-flash"
 
@@ -53,13 +61,17 @@ const HELP_MODEL = `LLM model. It supports Gemini, OpenAI, OpenRouter, or any Op
 	`OpenRouter model: "openrouter/<model-id>"; e.g. "openrouter/auto", "google/gemma-3-27b-it:free". ` +
 	`Any OpenAI API compatible model: "openai/<model-name>/<api-url>"; ` +
 	`e.g. "openai/gpt-oss-120b/http://localhost:8080/v1". ` +
-	`If not set, it uses ` + ENV_MODEL + ` env, then fallbacks to "` + DEFAULT_MODEL + `" by default`
+	`A named model profile defined in config file (` + ENV_CONFIG + ` env or "~/.config/goaider/config.yaml"); ` +
+	`e.g. "mylocal". ` +
+	`If not set, it uses the per-command default model of config file, then ` + ENV_MODEL + ` env, ` +
+	`then the "default_model" of config file, then fallbacks to "` + DEFAULT_MODEL + `" by default`
 
 const HELP_MODEL_KEY = `API key for the LLM model. If not set, it reads from env variable: ` +
 	`For Gemini model, it's ` + ENV_GEMINI_API_KEY + ` env; ` +
 	`For OpenAI model, it's ` + ENV_OPENAI_API_KEY + ` env; ` +
 	`For OpenRouter model, it's ` + ENV_OPENROUTER_API_KEY + ` env; ` +
-	`For customary OpenAI API compatible model, it's ` + ENV_MODEL_KEY + ` env`
+	`For customary OpenAI API compatible model, it's ` + ENV_MODEL_KEY + ` env; ` +
+	`For model profile of config file, it's the profile "api_key_env" env if set`
 
 const HELP_TEMPLATE_FLAG = `The Go text template string. If the value starts with "@", ` +
 	`it (the rest part after @) is treated as a filename, ` +
@@ -69,6 +81,32 @@ const HELP_TEMPLATE_FLAG = `The Go text template string. If the value starts wit
 const HELP_TEMPERATURE_FLAG = `The temperature to use for the model. Range 0.0-2.0 (some model capped at max 1.0). ` +
 	`Lower is deterministic; Higher is creative`
 
+const HELP_CONCURRENCY_FLAG = `Number of files to process concurrently. ` +
+	`The rate limit (config file "rate_limit") is shared by all workers`
+
+const HELP_BATCH_LIST_FLAG = `Process files of this list file (each line a file path) instead of walking dir. ` +
+	`Use "-" to read from stdin. Relative paths are resolved against dir arg (if provided). ` +
+	`The --failures file can be used as the list file to retry failed files`
+
+const HELP_BATCH_CSV_COLUMN_FLAG = `Treat --list as a CSV file, specify the column name to use as file path`
+
+const HELP_BATCH_RECURSIVE_FLAG = `Walk dir recursively`
+
+const HELP_BATCH_MAX_DEPTH_FLAG = `Max depth of walking dir (1: files of dir itself only). 0 == unlimited. ` +
+	`Implies --recursive if > 0`
+
+const HELP_BATCH_INCLUDE_FLAG = `Only process files matching this glob (relative to dir), e.g. "**/*.png" ` +
+	`("**/" matches zero or more dirs). Can be set multiple times`
+
+const HELP_BATCH_EXCLUDE_FLAG = `Skip files matching this glob (relative to dir), e.g. "rejected/**". ` +
+	`Can be set multiple times`
+
+const HELP_BATCH_FAILURES_FLAG = `Write failed files to this file (each line a file path)`
+
+const HELP_COMFYUI_PROGRESS_FLAG = `ComfyUI workflow run progress output: "text" (status line of each server ` +
+	`to stderr: current node, sampler step, cached nodes, elapsed time & ETA), ` +
+	`"json" (progress events as JSON lines to stdout) or "none"`
+
 // Normal languages that people actually use. No political correct or DEI ones.
 const HELP_LANGS = `"en", "ja", "fr", "de", "es", "pt", "ko", "ru", "ar", "zh-tw", "zh", "zh-cn", "cht", "chs"`
 
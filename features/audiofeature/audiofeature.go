@@ -0,0 +1,80 @@
+package audiofeature
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/sagan/goaider/features/ttsfeature"
+)
+
+// Decoded 16-bit signed little-endian interleaved PCM audio stream of a file.
+type Audio struct {
+	io.Reader
+	SampleRate   int
+	ChannelCount int
+	file         *os.File
+}
+
+func (a *Audio) Close() error {
+	if closer, ok := a.Reader.(io.Closer); ok {
+		closer.Close()
+	}
+	return a.file.Close()
+}
+
+// Bytes of a frame (one sample of all channels).
+func (a *Audio) FrameSize() int {
+	return a.ChannelCount * 2
+}
+
+// Open and decode an audio file.
+// 16-bit PCM wav and mp3 files are decoded natively, other formats (including 24-bit, 32-bit or float wav)
+// are decoded to 16-bit PCM by ffmpeg, which requires ttsfeature.Init() be called first.
+func Open(filename string) (*Audio, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	var reader io.Reader
+	var sampleRate, channelCount int
+	if isWav(file) && !isPcm16Wav(filename) {
+		reader, sampleRate, channelCount, err = ttsfeature.DecodeFfmpeg(file)
+	} else {
+		reader, sampleRate, channelCount, err = ttsfeature.GetOtoAudio(file, filename)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	audio := &Audio{Reader: reader, SampleRate: sampleRate, ChannelCount: channelCount, file: file}
+	if sampleRate <= 0 || channelCount <= 0 {
+		audio.Close()
+		return nil, fmt.Errorf("invalid audio: sample rate %d, channels %d", sampleRate, channelCount)
+	}
+	return audio, nil
+}
+
+// Check whether file is a RIFF wav file. The file is rewound to start.
+func isWav(file *os.File) bool {
+	header := make([]byte, 12)
+	_, err := io.ReadFull(file, header)
+	file.Seek(0, io.SeekStart)
+	return err == nil && string(header[:4]) == "RIFF" && string(header[8:]) == "WAVE"
+}
+
+// Return the RMS level (dBFS) of 16-bit PCM samples. Return -inf for empty or digital silence input.
+func Level(pcm []byte) float64 {
+	count := len(pcm) / 2
+	if count == 0 {
+		return math.Inf(-1)
+	}
+	sum := 0.0
+	for i := 0; i < count; i++ {
+		sample := float64(int16(binary.LittleEndian.Uint16(pcm[i*2:])))
+		sum += sample * sample
+	}
+	return 20 * math.Log10(math.Sqrt(sum/float64(count))/32768)
+}
@@ -0,0 +1,324 @@
+package audiofeature
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/natefinch/atomic"
+
+	"github.com/sagan/goaider/features/ttsfeature"
+)
+
+// Loudness normalization modes.
+const (
+	LOUDNESS_EBU  = "ebu"  // EBU R128 loudness normalization. Requires ffmpeg
+	LOUDNESS_PEAK = "peak" // peak normalization
+	LOUDNESS_NONE = "none"
+)
+
+// Output formats of normalization.
+const (
+	FORMAT_WAV  = "wav"
+	FORMAT_FLAC = "flac" // requires ffmpeg
+)
+
+// Audio normalization options.
+type NormalizeOptions struct {
+	SampleRate    int     // target sample rate. 0 == keep
+	Channels      int     // target channel count. 0 == keep
+	Loudness      string  // LOUDNESS_EBU, LOUDNESS_PEAK or LOUDNESS_NONE
+	Lufs          float64 // EBU R128 target integrated loudness (LUFS)
+	TruePeak      float64 // EBU R128 max true peak (dBTP)
+	Peak          float64 // peak normalization target peak level (dBFS)
+	Trim          bool    // trim leading and trailing silence
+	TrimThreshold float64 // level (dBFS) below which audio is considered silence when trimming
+	Format        string  // FORMAT_WAV or FORMAT_FLAC, output is 16-bit
+}
+
+// Whether the normalization of input file can be done natively, without ffmpeg.
+// Only 16-bit PCM wav / mp3 input, wav output, non-EBU normalization and no resampling are supported natively.
+func CanNormalizeNative(input string, options *NormalizeOptions) bool {
+	if options.Format != FORMAT_WAV || options.Loudness == LOUDNESS_EBU {
+		return false
+	}
+	switch strings.ToLower(filepath.Ext(input)) {
+	case ".mp3":
+		return options.SampleRate == 0
+	case ".wav":
+		format, bits, sampleRate := readWavFormat(input)
+		// format 1: PCM
+		return format == 1 && bits == 16 && (options.SampleRate == 0 || options.SampleRate == sampleRate)
+	}
+	return false
+}
+
+// Check whether file is a 16-bit PCM wav file, by it's "fmt " chunk.
+func isPcm16Wav(file string) bool {
+	format, bits, _ := readWavFormat(file)
+	return format == 1 && bits == 16
+}
+
+// Read the format tag, bits per sample and sample rate of the "fmt " chunk of a wav file.
+// Return zero values if file is not a valid wav file.
+func readWavFormat(file string) (format int, bits int, sampleRate int) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(f, header); err != nil || string(header[:4]) != "RIFF" || string(header[8:]) != "WAVE" {
+		return
+	}
+	for {
+		chunkHeader := make([]byte, 8)
+		if _, err := io.ReadFull(f, chunkHeader); err != nil {
+			return
+		}
+		size := int64(binary.LittleEndian.Uint32(chunkHeader[4:]))
+		if string(chunkHeader[:4]) != "fmt " {
+			if _, err := f.Seek(size+size%2, io.SeekCurrent); err != nil {
+				return
+			}
+			continue
+		}
+		body := make([]byte, 16)
+		if _, err := io.ReadFull(f, body); err != nil {
+			return
+		}
+		return int(binary.LittleEndian.Uint16(body[0:])), int(binary.LittleEndian.Uint16(body[14:])),
+			int(binary.LittleEndian.Uint32(body[4:]))
+	}
+}
+
+// Normalize input audio file and write the result to output file.
+// It's done natively if possible (see CanNormalizeNative), otherwise by ffmpeg.
+func Normalize(input string, output string, options *NormalizeOptions) error {
+	if CanNormalizeNative(input, options) {
+		return normalizeNative(input, output, options)
+	}
+	return normalizeFfmpeg(input, output, options)
+}
+
+// Normalize natively (trim, channel mixing and peak normalization), see CanNormalizeNative.
+// The input is decoded twice and processed in LEVEL_WINDOW blocks, so the whole audio isn't kept in memory:
+// the first pass measures the level and peak of each block, the second pass writes the output.
+func normalizeNative(input string, output string, options *NormalizeOptions) error {
+	audio, err := Open(input)
+	if err != nil {
+		return err
+	}
+	sampleRate, channels := audio.SampleRate, audio.ChannelCount
+	outChannels := channels
+	if options.Channels > 0 {
+		outChannels = options.Channels
+	}
+	window := max(int(float64(sampleRate)*LEVEL_WINDOW), 1) // frames of a block
+	var levels, peaks []float64                             // level (before mixing) and peak (after mixing) of blocks
+	frames := 0
+	err = readBlocks(audio, channels, window, func(samples []float64) error {
+		levels = append(levels, level(samples))
+		peak := 0.0
+		for _, sample := range mixChannels(samples, channels, outChannels) {
+			peak = max(peak, math.Abs(sample))
+		}
+		peaks = append(peaks, peak)
+		frames += len(samples) / channels
+		return nil
+	})
+	audio.Close()
+	if err != nil {
+		return err
+	}
+
+	start, end := 0, len(levels) // blocks range of output
+	if options.Trim {
+		start = slices.IndexFunc(levels, func(l float64) bool { return l >= options.TrimThreshold })
+		if start < 0 {
+			return fmt.Errorf("audio is silent")
+		}
+		for levels[end-1] < options.TrimThreshold {
+			end--
+		}
+	}
+	gain := 1.0
+	if options.Loudness == LOUDNESS_PEAK && start < end {
+		if peak := slices.Max(peaks[start:end]); peak > 0 {
+			gain = math.Pow(10, options.Peak/20) * 32767 / peak
+		}
+	}
+	dataSize := (min(end*window, frames) - min(start*window, frames)) * outChannels * 2
+
+	audio, err = Open(input)
+	if err != nil {
+		return err
+	}
+	reader, writer := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		block := 0
+		writer.CloseWithError(readBlocks(audio, channels, window, func(samples []float64) error {
+			block++
+			if block <= start || block > end {
+				return nil
+			}
+			samples = mixChannels(samples, channels, outChannels)
+			data := make([]byte, len(samples)*2)
+			for i, sample := range samples {
+				binary.LittleEndian.PutUint16(data[i*2:],
+					uint16(int16(max(min(math.Round(sample*gain), 32767), -32768))))
+			}
+			_, err := writer.Write(data)
+			return err
+		}))
+	}()
+	err = atomic.WriteFile(output, io.MultiReader(
+		bytes.NewReader(ttsfeature.WavHeader(dataSize, sampleRate, outChannels)), reader))
+	reader.CloseWithError(io.ErrClosedPipe) // stop the writing goroutine if failed
+	<-done
+	audio.Close()
+	return err
+}
+
+// Read audio in blocks of (at most) window frames, call fn with the samples of each block.
+func readBlocks(audio *Audio, channels int, window int, fn func(samples []float64) error) error {
+	buf := make([]byte, window*channels*2)
+	samples := make([]float64, window*channels)
+	for {
+		n, err := io.ReadFull(audio, buf)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		count := n / 2 / channels * channels
+		for i := 0; i < count; i++ {
+			samples[i] = float64(int16(binary.LittleEndian.Uint16(buf[i*2:])))
+		}
+		if count > 0 {
+			if err := fn(samples[:count]); err != nil {
+				return err
+			}
+		}
+		if n < len(buf) {
+			return nil
+		}
+	}
+}
+
+// RMS level (dBFS) of 16-bit samples.
+func level(samples []float64) float64 {
+	sum := 0.0
+	for _, sample := range samples {
+		sum += sample * sample
+	}
+	return 20 * math.Log10(math.Sqrt(sum/float64(max(len(samples), 1)))/32768)
+}
+
+// Convert interleaved samples of from channels to to channels.
+// Mono to multiple channels duplicates the channel; otherwise channels are averaged to mono first.
+func mixChannels(samples []float64, from int, to int) []float64 {
+	if from == to {
+		return samples
+	}
+	frames := len(samples) / from
+	mixed := make([]float64, frames*to)
+	for i := 0; i < frames; i++ {
+		sum := 0.0
+		for c := 0; c < from; c++ {
+			sum += samples[i*from+c]
+		}
+		for c := 0; c < to; c++ {
+			mixed[i*to+c] = sum / float64(from)
+		}
+	}
+	return mixed
+}
+
+var (
+	maxVolumeRegexp  = regexp.MustCompile(`max_volume:\s*(-?[\d.]+|-inf) dB`)
+	sampleRateRegexp = regexp.MustCompile(`Audio:.*?, (\d+) Hz`)
+)
+
+func normalizeFfmpeg(input string, output string, options *NormalizeOptions) error {
+	if ttsfeature.Ffmpeg == "" {
+		return fmt.Errorf("ffmpeg not found")
+	}
+	var filters []string
+	if options.Trim {
+		trim := fmt.Sprintf("silenceremove=start_periods=1:start_threshold=%gdB", options.TrimThreshold)
+		filters = append(filters, trim, "areverse", trim, "areverse")
+	}
+	sampleRate := options.SampleRate
+	switch options.Loudness {
+	case LOUDNESS_EBU:
+		filters = append(filters, fmt.Sprintf("loudnorm=I=%g:TP=%g:LRA=11", options.Lufs, options.TruePeak))
+		if sampleRate == 0 {
+			// loudnorm upsamples audio to 192kHz, so the original sample rate must be set explicitly
+			stderr, _ := runFfmpeg("-i", input)
+			if match := sampleRateRegexp.FindStringSubmatch(stderr); match != nil {
+				sampleRate, _ = strconv.Atoi(match[1])
+			}
+			if sampleRate == 0 {
+				return fmt.Errorf("failed to detect sample rate")
+			}
+		}
+	case LOUDNESS_PEAK:
+		stderr, err := runFfmpeg("-i", input, "-vn", "-af", strings.Join(append(slices.Clone(filters),
+			"volumedetect"), ","), "-f", "null", "-")
+		if err != nil {
+			return err
+		}
+		match := maxVolumeRegexp.FindStringSubmatch(stderr)
+		if match == nil {
+			return fmt.Errorf("failed to detect peak volume")
+		}
+		if match[1] == "-inf" {
+			return fmt.Errorf("audio is silent")
+		}
+		maxVolume, _ := strconv.ParseFloat(match[1], 64)
+		filters = append(filters, fmt.Sprintf("volume=%gdB", options.Peak-maxVolume))
+	}
+
+	args := []string{"-i", input, "-vn", "-map_metadata", "-1"}
+	if len(filters) > 0 {
+		args = append(args, "-af", strings.Join(filters, ","))
+	}
+	if sampleRate > 0 {
+		args = append(args, "-ar", strconv.Itoa(sampleRate))
+	}
+	if options.Channels > 0 {
+		args = append(args, "-ac", strconv.Itoa(options.Channels))
+	}
+	switch options.Format {
+	case FORMAT_FLAC:
+		args = append(args, "-c:a", "flac", "-sample_fmt", "s16", "-f", "flac")
+	default:
+		args = append(args, "-c:a", "pcm_s16le", "-f", "wav")
+	}
+	_, err := runFfmpeg(append(args, "-y", output)...)
+	return err
+}
+
+// Run ffmpeg with args, return it's stderr output.
+func runFfmpeg(args ...string) (string, error) {
+	cmd := exec.Command(ttsfeature.Ffmpeg, append([]string{"-hide_banner", "-nostdin"}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return stderr.String(), fmt.Errorf("ffmpeg failed: %w, stderr: %s", err, stderr.String())
+	}
+	return stderr.String(), nil
+}
@@ -0,0 +1,173 @@
+package audiofeature
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sagan/goaider/features/ttsfeature"
+)
+
+// Length of the analysis window of silence detection (seconds).
+const LEVEL_WINDOW = 0.01
+
+// Silence detection based audio slicing options. Lengths are in seconds.
+type SliceOptions struct {
+	Threshold  float64 // RMS level (dBFS) below which audio is considered silence
+	MinSilence float64 // min length of silence to cut at
+	MinLength  float64 // min clip length. Adjacent short clips are merged, clips still shorter are dropped
+	MaxLength  float64 // max clip length. Longer voiced parts are cut at the quietest point. 0 == unlimited
+	Padding    float64 // keep at most this much silence before and after each clip
+}
+
+// A slice of audio. Start and End are frame (sample of all channels) offsets.
+type Clip struct {
+	Start int64
+	End   int64
+}
+
+// Detect utterance clips of audio by silence.
+// It reads the whole audio. Return the clips in time order (not overlapped) and the total frames of audio.
+func DetectClips(audio *Audio, options *SliceOptions) (clips []*Clip, total int64, err error) {
+	windowFrames := max(int(float64(audio.SampleRate)*LEVEL_WINDOW), 1)
+	buf := make([]byte, windowFrames*audio.FrameSize())
+	var levels []float64 // level of each window
+	for {
+		n, err := io.ReadFull(audio, buf)
+		frames := n / audio.FrameSize()
+		if frames > 0 {
+			levels = append(levels, Level(buf[:frames*audio.FrameSize()]))
+			total += int64(frames)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return nil, 0, err
+		}
+	}
+	windows := func(seconds float64) int {
+		return int(seconds / LEVEL_WINDOW)
+	}
+	minSilence, minLength, maxLength := max(windows(options.MinSilence), 1), windows(options.MinLength),
+		windows(options.MaxLength)
+
+	// 1. Find voiced regions separated by silence of at least minSilence.
+	var regions []*Clip // in windows
+	start, lastVoiced := -1, -1
+	for i, level := range levels {
+		if level >= options.Threshold {
+			if start < 0 {
+				start = i
+			}
+			lastVoiced = i
+		} else if start >= 0 && i-lastVoiced >= minSilence {
+			regions = append(regions, &Clip{Start: int64(start), End: int64(lastVoiced + 1)})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		regions = append(regions, &Clip{Start: int64(start), End: int64(lastVoiced + 1)})
+	}
+
+	// 2. Cut regions longer than maxLength at their quietest window.
+	if maxLength > 0 {
+		var cutRegions []*Clip
+		for _, region := range regions {
+			for region.End-region.Start > int64(maxLength) {
+				cut := region.Start + int64(maxLength)
+				for i := region.Start + int64(min(minLength, maxLength-1)) + 1; i < region.Start+int64(maxLength); i++ {
+					if levels[i] < levels[cut] {
+						cut = i
+					}
+				}
+				cutRegions = append(cutRegions, &Clip{Start: region.Start, End: cut})
+				region = &Clip{Start: cut, End: region.End}
+			}
+			cutRegions = append(cutRegions, region)
+		}
+		regions = cutRegions
+	}
+
+	// 3. Merge short regions with the following ones, as long as the result is not longer than maxLength.
+	for _, region := range regions {
+		if n := len(clips); n > 0 && clips[n-1].End-clips[n-1].Start < int64(minLength) &&
+			(maxLength == 0 || region.End-clips[n-1].Start <= int64(maxLength)) {
+			clips[n-1].End = region.End
+			continue
+		}
+		clips = append(clips, &Clip{Start: region.Start, End: region.End})
+	}
+	var kept []*Clip
+	for _, clip := range clips {
+		if clip.End-clip.Start >= int64(minLength) {
+			kept = append(kept, clip)
+		}
+	}
+	clips = kept
+
+	// 4. Add padding, without overlapping neighbor clips, and convert windows to frames.
+	padding := int64(windows(options.Padding))
+	padded := make([]*Clip, len(clips))
+	for i, clip := range clips {
+		prevEnd, nextStart := int64(0), int64(len(levels))
+		if i > 0 {
+			prevEnd = clips[i-1].End
+		}
+		if i < len(clips)-1 {
+			nextStart = clips[i+1].Start
+		}
+		start, end := clip.Start-padding, clip.End+padding
+		if i > 0 {
+			start = max(start, (prevEnd+clip.Start+1)/2)
+		}
+		if i < len(clips)-1 {
+			end = min(end, (clip.End+nextStart)/2)
+		}
+		start, end = max(start, 0), min(end, int64(len(levels)))
+		padded[i] = &Clip{Start: start * int64(windowFrames), End: min(end*int64(windowFrames), total)}
+	}
+	return padded, total, nil
+}
+
+// Read audio and write each clip of it to a 16-bit PCM wav file of filenames[i].
+// clips must be in time order and not overlapped. audio must be at the beginning.
+func WriteClips(audio *Audio, clips []*Clip, filenames []string) error {
+	if len(clips) != len(filenames) {
+		return fmt.Errorf("clips and filenames mismatch")
+	}
+	frameSize := int64(audio.FrameSize())
+	reader := bufio.NewReaderSize(audio, 1<<20)
+	position := int64(0)
+	for i, clip := range clips {
+		if clip.Start < position {
+			return fmt.Errorf("clip %d overlaps previous clip", i)
+		}
+		if _, err := io.CopyN(io.Discard, reader, (clip.Start-position)*frameSize); err != nil {
+			return fmt.Errorf("failed to seek to clip %d: %w", i, err)
+		}
+		if err := writeWav(filenames[i], reader, int((clip.End-clip.Start)*frameSize),
+			audio.SampleRate, audio.ChannelCount); err != nil {
+			return fmt.Errorf("failed to write clip %d: %w", i, err)
+		}
+		position = clip.End
+	}
+	return nil
+}
+
+func writeWav(filename string, pcm io.Reader, size int, sampleRate int, channelCount int) (err error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	if _, err = file.Write(ttsfeature.WavHeader(size, sampleRate, channelCount)); err != nil {
+		return err
+	}
+	_, err = io.CopyN(file, pcm, int64(size))
+	return err
+}
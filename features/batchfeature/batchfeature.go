@@ -0,0 +1,122 @@
+package batchfeature
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/natefinch/atomic"
+
+	"github.com/sagan/goaider/util/stringutil"
+)
+
+// Processor processes an item (e.g. a file path).
+// It returns skipped = true if the item is skipped (e.g. output already exists).
+type Processor func(item string) (skipped bool, err error)
+
+// Batch processing summary.
+type Summary struct {
+	Success  int
+	Skipped  int
+	Failed   int
+	Failures []string // failed items, in input order
+}
+
+type itemResult struct {
+	done    chan struct{}
+	skipped bool
+	err     error
+}
+
+// Run processes items using concurrency workers.
+// The result of each item is printed to output in input order (instead of completion order),
+// e.g. "[3/100] foo.jpg: ✅ SUCCESS".
+func Run(items []string, concurrency int, output io.Writer, process Processor) *Summary {
+	concurrency = max(concurrency, 1)
+	results := make([]*itemResult, len(items))
+	for i := range results {
+		results[i] = &itemResult{done: make(chan struct{})}
+	}
+	indexes := make(chan int)
+	wg := &sync.WaitGroup{}
+	for range min(concurrency, len(items)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i].skipped, results[i].err = process(items[i])
+				close(results[i].done)
+			}
+		}()
+	}
+	go func() {
+		for i := range items {
+			indexes <- i
+		}
+		close(indexes)
+	}()
+
+	summary := &Summary{}
+	for i, result := range results {
+		<-result.done
+		prefix := fmt.Sprintf("[%d/%d] %s", i+1, len(items), items[i])
+		switch {
+		case result.err != nil:
+			fmt.Fprintf(output, "%s: ❌ FAILED (%v)\n", prefix, result.err)
+			summary.Failed++
+			summary.Failures = append(summary.Failures, items[i])
+		case result.skipped:
+			fmt.Fprintf(output, "%s: ⏩ SKIPPED (output already exists)\n", prefix)
+			summary.Skipped++
+		default:
+			fmt.Fprintf(output, "%s: ✅ SUCCESS\n", prefix)
+			summary.Success++
+		}
+	}
+	wg.Wait()
+	return summary
+}
+
+func (s *Summary) Print(output io.Writer) {
+	fmt.Fprintf(output, "Total %d: %d success, %d skipped, %d failed\n",
+		s.Success+s.Skipped+s.Failed, s.Success, s.Skipped, s.Failed)
+}
+
+// Write failed items to file, each line an item. The file can be used as input list file to retry them.
+// An empty file is written if there is no failure.
+func (s *Summary) WriteFailures(file string) error {
+	var b strings.Builder
+	for _, item := range s.Failures {
+		b.WriteString(item)
+		b.WriteByte('\n')
+	}
+	return atomic.WriteFile(file, strings.NewReader(b.String()))
+}
+
+// Read an input list file, each line an item. Empty lines and lines starting with "#" are ignored.
+// If name is "-", read from stdin.
+func ReadListFile(name string, stdin io.Reader) (items []string, err error) {
+	var input io.Reader
+	if name == "-" {
+		input = stdin
+	} else {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		input = f
+	}
+	scanner := bufio.NewScanner(stringutil.GetTextReader(input))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		items = append(items, line)
+	}
+	return items, scanner.Err()
+}
@@ -0,0 +1,175 @@
+package batchfeature
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sagan/goaider/features/csvfeature"
+	"github.com/sagan/goaider/util"
+	"github.com/sagan/goaider/util/helper"
+	"github.com/sagan/goaider/util/stringutil"
+)
+
+// Input files selection options of a batch processing command.
+type InputOptions struct {
+	Dir       string   // input dir
+	Recursive bool     // walk dir recursively
+	MaxDepth  int      // max depth of dir walking. 1: files of dir itself. 0: unlimited. > 0 implies Recursive
+	Includes  []string // only select files matching any of these globs, relative to Dir, e.g. "**/*.png"
+	Excludes  []string // skip files matching any of these globs, relative to Dir
+	ListFile  string   // select files of this list file instead of walking dir. "-": stdin
+	CsvColumn string   // treat ListFile as CSV, use this column as file path
+	// Only select files of this mime type prefix, e.g. "image/".
+	// It's not applied to the files of list file.
+	MimePrefix string
+}
+
+// Select input files. Relative paths of list file are resolved against Dir.
+func SelectInputs(options *InputOptions, stdin io.Reader) (files []string, err error) {
+	if options.ListFile != "" {
+		if options.CsvColumn != "" {
+			files, err = readCsvListFile(options.ListFile, options.CsvColumn, stdin)
+		} else {
+			files, err = ReadListFile(options.ListFile, stdin)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read list file: %w", err)
+		}
+		if options.Dir != "" {
+			for i, file := range files {
+				if !filepath.IsAbs(file) {
+					files[i] = filepath.Join(options.Dir, file)
+				}
+			}
+		}
+	} else {
+		if options.Dir == "" {
+			return nil, fmt.Errorf("input dir or list file is required")
+		}
+		if files, err = walkDir(options.Dir, options.Recursive, options.MaxDepth, options.MimePrefix); err != nil {
+			return nil, err
+		}
+	}
+	if len(options.Includes) > 0 {
+		includes := globFiles(options.Dir, options.Includes)
+		files = util.FilterSlice(files, func(file string) bool {
+			return includes[filepath.Clean(file)]
+		})
+	}
+	if len(options.Excludes) > 0 {
+		excludes := globFiles(options.Dir, options.Excludes)
+		files = util.FilterSlice(files, func(file string) bool {
+			return !excludes[filepath.Clean(file)]
+		})
+	}
+	return files, nil
+}
+
+// Return files of dir which mime type has mimePrefix, in lexical order.
+func walkDir(dir string, recursive bool, maxDepth int, mimePrefix string) (files []string, err error) {
+	if maxDepth > 0 {
+		recursive = true
+	} else if !recursive {
+		maxDepth = 1
+	}
+	dir = filepath.Clean(dir)
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		depth := 0 // depth of dir itself is 0; files of dir itself is 1
+		if path != dir {
+			relpath, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			depth = strings.Count(filepath.ToSlash(relpath), "/") + 1
+		}
+		if d.IsDir() {
+			if path != dir && maxDepth > 0 && depth >= maxDepth {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if mimePrefix == "" || strings.HasPrefix(util.GetMimeType(d.Name()), mimePrefix) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory %s: %w", dir, err)
+	}
+	return files, nil
+}
+
+// Expand globs relative to dir, return the set of cleaned matched paths.
+// A "**/" matches zero or more dirs, e.g. "**/*.png" also matches the png files of dir itself.
+func globFiles(dir string, patterns []string) map[string]bool {
+	matches := map[string]bool{}
+	for _, pattern := range patterns {
+		for _, variant := range globVariants(filepath.ToSlash(pattern)) {
+			if dir != "" && !filepath.IsAbs(variant) {
+				variant = filepath.Join(dir, variant)
+			}
+			for _, file := range helper.ParseGlobFilenames(variant) {
+				matches[filepath.Clean(file)] = true
+			}
+		}
+	}
+	return matches
+}
+
+// Return the variants of a slash-separated glob pattern, with each "**/" segment kept or removed,
+// as gobwas/glob "**/" requires at least one dir.
+func globVariants(pattern string) []string {
+	index := strings.Index(pattern, "**/")
+	for index > 0 && pattern[index-1] != '/' {
+		next := strings.Index(pattern[index+1:], "**/")
+		if next < 0 {
+			index = -1
+			break
+		}
+		index += 1 + next
+	}
+	if index < 0 {
+		return []string{pattern}
+	}
+	var variants []string
+	for _, rest := range globVariants(pattern[index+3:]) {
+		variants = append(variants, pattern[:index+3]+rest, pattern[:index]+rest)
+	}
+	return variants
+}
+
+// Read column values of a CSV list file.
+func readCsvListFile(name string, column string, stdin io.Reader) (items []string, err error) {
+	var input io.Reader
+	if name == "-" {
+		input = stdin
+	} else {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		input = f
+	}
+	rows, err := csvfeature.UnmarshalCsv[map[string]string](stringutil.GetTextReader(input))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal CSV: %w", err)
+	}
+	for _, row := range rows {
+		value, ok := row[column]
+		if !ok {
+			return nil, fmt.Errorf("CSV column %q not found", column)
+		}
+		if value != "" {
+			items = append(items, value)
+		}
+	}
+	return items, nil
+}
@@ -1,14 +1,22 @@
 package csvfeature
 
 import (
+	"database/sql"
 	"encoding/csv"
 	"errors"
 	"fmt"
 	"io"
+	"path/filepath"
 	"reflect"
 	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mithrandie/csvq-driver"
+	"github.com/mithrandie/csvq/lib/parser"
+
+	"github.com/sagan/goaider/util/stringutil"
 )
 
 // WriteListsToCsv combines multiple lists of columns and outputs them as CSV.
@@ -254,3 +262,198 @@ func setField(field reflect.Value, value string) error {
 	}
 	return nil
 }
+
+// WriteSqlRowsToCsv writes the result of a sql query to a CSV writer.
+// It sorts the columns alphabetically by name in the output.
+func WriteSqlRowsToCsv(rows *sql.Rows, csvOutput io.Writer, noHeader bool, oneLine bool) error {
+	// 1. Get the original column names from the query result
+	cols, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("failed to get columns: %w", err)
+	}
+
+	// 2. Create a mapping structure to track the original index of each column
+	type colMap struct {
+		Name      string
+		OrigIndex int
+	}
+
+	mapping := make([]colMap, len(cols))
+	for i, name := range cols {
+		mapping[i] = colMap{
+			Name:      name,
+			OrigIndex: i,
+		}
+	}
+
+	// 3. Sort the mapping slice alphabetically by column Name
+	sort.Slice(mapping, func(i, j int) bool {
+		return mapping[i].Name < mapping[j].Name
+	})
+
+	// 4. Set up the CSV writer
+	writer := csv.NewWriter(csvOutput)
+	defer writer.Flush()
+
+	// 5. Write the Header (using the sorted order)
+	if !noHeader {
+		header := make([]string, len(cols))
+		for i, m := range mapping {
+			header[i] = m.Name
+		}
+		if err := writer.Write(header); err != nil {
+			return fmt.Errorf("failed to write header: %w", err)
+		}
+	}
+
+	// 6. Prepare buffers for scanning rows
+	// values: holds the data scanned from the database (in original order)
+	// scanArgs: pointers to 'values' required by rows.Scan
+	values := make([]any, len(cols))
+	scanArgs := make([]any, len(cols))
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+
+	// Buffer to hold the reordered string data for the CSV writer
+	rowString := make([]string, len(cols))
+
+	// 7. Iterate through the rows
+	for rows.Next() {
+		if err := rows.Scan(scanArgs...); err != nil {
+			return fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		// Map the raw data from original index -> sorted index
+		for sortedIdx, m := range mapping {
+			val := values[m.OrigIndex]
+
+			// Convert interface{} to string
+			var strVal string
+			if val != nil {
+				switch v := val.(type) {
+				case []byte:
+					// Handle byte slices (common for strings/blobs in some drivers)
+					strVal = string(v)
+				default:
+					// generic string representation for ints, floats, dates, etc.
+					strVal = fmt.Sprintf("%v", v)
+				}
+			}
+			// If val is nil, strVal remains "" (empty string)
+
+			if oneLine {
+				strVal = stringutil.ReplaceNewLinesWithSpace(strVal)
+			}
+			rowString[sortedIdx] = strVal
+		}
+
+		// Write the sorted row to CSV
+		if err := writer.Write(rowString); err != nil {
+			return fmt.Errorf("failed to write row: %w", err)
+		}
+	}
+
+	// Check for errors that occurred during iteration
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return nil
+}
+
+// Query runs a csvq ( https://github.com/mithrandie/csvq ) SQL query on csv files of dir,
+// and writes the result to output as csv.
+func Query(dir string, query string, output io.Writer, noHeader bool, oneLine bool) error {
+	db, err := sqlx.Open("csvq", dir)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if noHeader {
+		if _, err = db.Exec("SET @@NO_HEADER TO TRUE"); err != nil {
+			return fmt.Errorf("failed to set no-header flag: %w", err)
+		}
+	}
+	rows, err := db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	return WriteSqlRowsToCsv(rows, output, noHeader, oneLine)
+}
+
+// Check that query is exactly one SELECT statement (not "SELECT ... FOR UPDATE").
+// The parsed statement is walked (by reflection) to check every table reference, including those of
+// joins and subqueries: it must be a local relative file path (no absolute or ".." path),
+// and url, stdin and table functions other than DATA (inline data) are rejected.
+// csvq runs any statement in autocommit mode (it has no read-only mode),
+// so untrusted queries must be checked before Query.
+func CheckReadOnlyQuery(query string) error {
+	statements, _, err := parser.Parse(query, "", false, false)
+	if err != nil {
+		return err
+	}
+	if len(statements) != 1 {
+		return fmt.Errorf("query must be exactly one SELECT statement, got %d statements", len(statements))
+	}
+	selectQuery, ok := statements[0].(parser.SelectQuery)
+	if !ok {
+		return fmt.Errorf("only SELECT statement is allowed")
+	}
+	if selectQuery.IsForUpdate() {
+		return fmt.Errorf("SELECT ... FOR UPDATE is not allowed")
+	}
+	return checkQueryTables(reflect.ValueOf(selectQuery))
+}
+
+// Walk the parsed query expression v and check the object of every table reference.
+func checkQueryTables(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return checkQueryTables(v.Elem())
+	case reflect.Slice, reflect.Array:
+		for i := range v.Len() {
+			if err := checkQueryTables(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		if v.Type() == reflect.TypeFor[parser.Table]() && v.CanInterface() {
+			if err := checkTableObject(v.Interface().(parser.Table).Object); err != nil {
+				return err
+			}
+		}
+		for i := range v.NumField() {
+			if err := checkQueryTables(v.Field(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Check a table object is a local (relative & not "..") file path.
+// Url, stdin and table functions other than DATA (inline data) are rejected.
+func checkTableObject(object parser.QueryExpression) error {
+	switch obj := object.(type) {
+	case parser.Identifier:
+		if !filepath.IsLocal(obj.Literal) {
+			return fmt.Errorf("table %q is not a local file of current dir", obj.Literal)
+		}
+	case parser.FormatSpecifiedFunction:
+		return checkTableObject(obj.Path)
+	case parser.TableFunction:
+		if !strings.EqualFold(obj.Name, "DATA") {
+			return fmt.Errorf("table function %s is not allowed", strings.ToUpper(obj.Name))
+		}
+	case parser.Url:
+		return fmt.Errorf("url table %q is not allowed", obj.Raw)
+	case parser.Stdin:
+		return fmt.Errorf("stdin table is not allowed")
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package datasetfeature
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/sagan/goaider/features/batchfeature"
+	"github.com/sagan/goaider/features/mediainfo"
+	"github.com/sagan/goaider/util/imgutil"
+	"github.com/sagan/goaider/util/stringutil"
+)
+
+// Issue codes of dataset check.
+const (
+	ISSUE_MISSING_CAPTION = "missing_caption" // media file has no caption file
+	ISSUE_ORPHAN_CAPTION  = "orphan_caption"  // caption file has no media file
+	ISSUE_EMPTY_CAPTION   = "empty_caption"
+	ISSUE_LONG_CAPTION    = "long_caption"   // caption exceeds max length or max tokens
+	ISSUE_NON_UTF8        = "non_utf8"       // caption file is not valid UTF-8 text
+	ISSUE_INVALID_MEDIA   = "invalid_media"  // media file can not be decoded / parsed
+	ISSUE_LOW_RESOLUTION  = "low_resolution" // image is smaller than min resolution
+	ISSUE_DURATION        = "duration"       // audio duration is out of range
+	ISSUE_READ_ERROR      = "read_error"     // file can not be read
+)
+
+// Dataset check options. Zero value limits are not checked.
+type CheckOptions struct {
+	MaxLength   int     // max caption length (chars)
+	MaxTokens   int     // max caption (CLIP) tokens, approximately
+	MinWidth    int     // min image width
+	MinHeight   int     // min image height
+	MinDuration float64 // min audio duration (seconds). Requires ffprobe
+	MaxDuration float64 // max audio duration (seconds). Requires ffprobe
+	Concurrency int     // number of files to check concurrently
+}
+
+// A problem found by dataset check.
+type Issue struct {
+	File    string `json:"file"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Check dataset items and orphan caption files. Return found issues, sorted by file.
+func Check(items []*Item, orphans []string, options *CheckOptions) (issues []*Issue) {
+	for _, orphan := range orphans {
+		issues = append(issues, &Issue{File: orphan, Code: ISSUE_ORPHAN_CAPTION,
+			Message: "caption file has no image / audio file"})
+	}
+	if (options.MinDuration > 0 || options.MaxDuration > 0) &&
+		slices.ContainsFunc(items, func(item *Item) bool { return item.Kind == KIND_AUDIO }) {
+		mediainfo.Init()
+	}
+	mu := &sync.Mutex{}
+	media := map[string]*Item{}
+	files := make([]string, 0, len(items))
+	for _, item := range items {
+		media[item.Media] = item
+		files = append(files, item.Media)
+	}
+	batchfeature.Run(files, options.Concurrency, io.Discard, func(file string) (bool, error) {
+		itemIssues := checkItem(media[file], options)
+		mu.Lock()
+		issues = append(issues, itemIssues...)
+		mu.Unlock()
+		return false, nil
+	})
+	sort.SliceStable(issues, func(i, j int) bool {
+		return issues[i].File < issues[j].File
+	})
+	return issues
+}
+
+func checkItem(item *Item, options *CheckOptions) (issues []*Issue) {
+	addIssue := func(file, code, format string, args ...any) {
+		issues = append(issues, &Issue{File: file, Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+	if item.Caption == "" {
+		addIssue(item.Media, ISSUE_MISSING_CAPTION, "%s file has no caption file", item.Kind)
+	} else if data, err := os.ReadFile(item.Caption); err != nil {
+		addIssue(item.Caption, ISSUE_READ_ERROR, "failed to read caption file: %v", err)
+	} else if !utf8.Valid(data) || bytes.HasPrefix(data, stringutil.Utf8bom) {
+		if !utf8.Valid(data) {
+			charset, confidence, _ := stringutil.DetectCharset(data)
+			addIssue(item.Caption, ISSUE_NON_UTF8, "caption file is not UTF-8 text (detected %s, confidence %d)",
+				charset, confidence)
+		} else {
+			addIssue(item.Caption, ISSUE_NON_UTF8, "caption file has UTF-8 BOM")
+		}
+	} else if caption := strings.TrimSpace(string(data)); caption == "" {
+		addIssue(item.Caption, ISSUE_EMPTY_CAPTION, "caption is empty")
+	} else {
+		if length := utf8.RuneCountInString(caption); options.MaxLength > 0 && length > options.MaxLength {
+			addIssue(item.Caption, ISSUE_LONG_CAPTION, "caption length %d exceeds max %d", length, options.MaxLength)
+		}
+		if tokens := stringutil.CountTokens(caption); options.MaxTokens > 0 && tokens > options.MaxTokens {
+			addIssue(item.Caption, ISSUE_LONG_CAPTION, "caption tokens %d exceeds max %d", tokens, options.MaxTokens)
+		}
+	}
+
+	switch item.Kind {
+	case KIND_IMAGE:
+		data, err := os.ReadFile(item.Media)
+		if err != nil {
+			addIssue(item.Media, ISSUE_READ_ERROR, "failed to read image: %v", err)
+			break
+		}
+		img, _, err := imgutil.Decode(data)
+		if err != nil {
+			addIssue(item.Media, ISSUE_INVALID_MEDIA, "failed to decode image: %v", err)
+			break
+		}
+		width, height := img.Bounds().Dx(), img.Bounds().Dy()
+		if width < options.MinWidth || height < options.MinHeight {
+			addIssue(item.Media, ISSUE_LOW_RESOLUTION, "image resolution %dx%d is lower than min %dx%d",
+				width, height, options.MinWidth, options.MinHeight)
+		}
+	case KIND_AUDIO:
+		if options.MinDuration <= 0 && options.MaxDuration <= 0 {
+			break
+		}
+		file, err := os.Open(item.Media)
+		if err != nil {
+			addIssue(item.Media, ISSUE_READ_ERROR, "failed to read audio: %v", err)
+			break
+		}
+		info, err := mediainfo.ParseVideoAudioMediaInfo(file)
+		file.Close()
+		if err != nil {
+			addIssue(item.Media, ISSUE_INVALID_MEDIA, "failed to parse audio: %v", err)
+			break
+		}
+		duration, err := strconv.ParseFloat(info.Duration, 64)
+		if err != nil {
+			addIssue(item.Media, ISSUE_INVALID_MEDIA, "invalid audio duration %q", info.Duration)
+		} else if duration < options.MinDuration || (options.MaxDuration > 0 && duration > options.MaxDuration) {
+			addIssue(item.Media, ISSUE_DURATION, "audio duration %.2fs is out of range %g-%gs",
+				duration, options.MinDuration, options.MaxDuration)
+		}
+	}
+	return issues
+}
@@ -0,0 +1,70 @@
+package datasetfeature
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sagan/goaider/util"
+)
+
+// Media kinds of dataset items.
+const (
+	KIND_IMAGE = "image"
+	KIND_AUDIO = "audio"
+)
+
+// Caption (or transcript) file ext.
+const CAPTION_EXT = ".txt"
+
+// A dataset item: an image / audio file and it's caption / transcript file of same name.
+// E.g. "foo.png" and "foo.txt".
+type Item struct {
+	Media   string // image / audio file path
+	Kind    string // KIND_IMAGE or KIND_AUDIO
+	Caption string // caption file path. Empty if not exists
+}
+
+// Return the media kind of file. Return "" if it's not an image or audio file.
+func GetKind(file string) string {
+	mimeType := util.GetMimeType(file)
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return KIND_IMAGE
+	case strings.HasPrefix(mimeType, "audio/"):
+		return KIND_AUDIO
+	}
+	return ""
+}
+
+// Pair media files of files with their caption files, by path without ext.
+// Other files are ignored. orphans are the caption files that have no media file.
+// Both items and orphans are sorted by path.
+func FindPairs(files []string) (items []*Item, orphans []string) {
+	captions := map[string]string{} // path without ext => caption file
+	for _, file := range files {
+		if strings.EqualFold(filepath.Ext(file), CAPTION_EXT) {
+			captions[strings.TrimSuffix(file, filepath.Ext(file))] = file
+		}
+	}
+	paired := map[string]bool{}
+	for _, file := range files {
+		kind := GetKind(file)
+		if kind == "" {
+			continue
+		}
+		stem := strings.TrimSuffix(file, filepath.Ext(file))
+		items = append(items, &Item{Media: file, Kind: kind, Caption: captions[stem]})
+		paired[stem] = true
+	}
+	for stem, caption := range captions {
+		if !paired[stem] {
+			orphans = append(orphans, caption)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Media < items[j].Media
+	})
+	sort.Strings(orphans)
+	return items, orphans
+}
@@ -0,0 +1,332 @@
+package datasetfeature
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// A minimal Parquet file writer: flat (or one level struct) schema of REQUIRED columns,
+// PLAIN encoding, uncompressed, one row group. No definition / repetition levels are needed.
+// See https://github.com/apache/parquet-format .
+
+// Parquet physical types.
+const (
+	PARQUET_INT64      int32 = 2
+	PARQUET_DOUBLE     int32 = 5
+	PARQUET_BYTE_ARRAY int32 = 6
+)
+
+const (
+	parquetMagic          = "PAR1"
+	parquetPageSizeLimit  = 8 * 1024 * 1024 // start a new data page when page size exceeds it
+	parquetRequired       = 0               // FieldRepetitionType REQUIRED
+	parquetConvertedUtf8  = 0               // ConvertedType UTF8
+	parquetEncodingPlain  = 0
+	parquetEncodingRle    = 3
+	parquetPageTypeData   = 0
+	parquetCodecNone      = 0
+	parquetCreatedBy      = "goaider"
+	parquetFormatVersion1 = 1
+)
+
+// A leaf column of parquet file.
+type ParquetColumn struct {
+	// Path in schema. E.g. ["text"], or ["image", "bytes"] for the "bytes" field of "image" struct.
+	// The fields of a struct must be adjacent columns.
+	Path []string
+	Type int32 // PARQUET_INT64, PARQUET_DOUBLE or PARQUET_BYTE_ARRAY
+	// Whether the BYTE_ARRAY column is UTF-8 string.
+	String bool
+	// Value returns the value of row: int64 (INT64), float64 (DOUBLE), []byte or string (BYTE_ARRAY).
+	// It's called exactly once for each row, in order, so large values (e.g. file contents) can be read lazily.
+	Value func(row int) (any, error)
+}
+
+// Write a parquet file of numRows rows to output. metadata is the file key-value metadata, can be nil.
+func WriteParquet(output io.Writer, columns []*ParquetColumn, numRows int, metadata map[string]string) error {
+	w := &countingWriter{w: output}
+	if _, err := w.Write([]byte(parquetMagic)); err != nil {
+		return err
+	}
+	type chunkInfo struct {
+		offset int64
+		size   int64
+	}
+	var chunks []chunkInfo
+	var totalSize int64
+	for _, column := range columns {
+		offset := w.n
+		if err := writeColumnChunk(w, column, numRows); err != nil {
+			return fmt.Errorf("column %v: %w", column.Path, err)
+		}
+		chunks = append(chunks, chunkInfo{offset: offset, size: w.n - offset})
+		totalSize += w.n - offset
+	}
+
+	t := &thriftWriter{}
+	t.i32(1, parquetFormatVersion1)
+	// schema
+	elements := 1
+	for i := 0; i < len(columns); i++ {
+		if len(columns[i].Path) > 1 && (i == 0 || columns[i-1].Path[0] != columns[i].Path[0]) {
+			elements++
+		}
+		elements++
+	}
+	t.listBegin(2, thriftStruct, elements)
+	t.elemBegin()
+	t.binary(4, []byte("schema"))
+	t.i32(5, int32(countTopFields(columns)))
+	t.structEnd()
+	for i, column := range columns {
+		if len(column.Path) > 2 {
+			return fmt.Errorf("column %v: nested struct is not supported", column.Path)
+		}
+		if len(column.Path) == 2 && (i == 0 || columns[i-1].Path[0] != column.Path[0]) {
+			children := 0
+			for _, c := range columns[i:] {
+				if len(c.Path) != 2 || c.Path[0] != column.Path[0] {
+					break
+				}
+				children++
+			}
+			t.elemBegin()
+			t.i32(3, parquetRequired)
+			t.binary(4, []byte(column.Path[0]))
+			t.i32(5, int32(children))
+			t.structEnd()
+		}
+		t.elemBegin()
+		t.i32(1, column.Type)
+		t.i32(3, parquetRequired)
+		t.binary(4, []byte(column.Path[len(column.Path)-1]))
+		if column.String {
+			t.i32(6, parquetConvertedUtf8)
+		}
+		t.structEnd()
+	}
+	t.i64(3, int64(numRows))
+	// row groups
+	t.listBegin(4, thriftStruct, 1)
+	t.elemBegin()
+	t.listBegin(1, thriftStruct, len(columns))
+	for i, column := range columns {
+		t.elemBegin()
+		t.i64(2, chunks[i].offset)
+		t.structBegin(3) // ColumnMetaData
+		t.i32(1, column.Type)
+		t.listBegin(2, thriftI32, 2)
+		t.varint(zigzag(parquetEncodingPlain))
+		t.varint(zigzag(parquetEncodingRle))
+		t.listBegin(3, thriftBinary, len(column.Path))
+		for _, name := range column.Path {
+			t.varint(uint64(len(name)))
+			t.buf.WriteString(name)
+		}
+		t.i32(4, parquetCodecNone)
+		t.i64(5, int64(numRows))
+		t.i64(6, chunks[i].size)
+		t.i64(7, chunks[i].size)
+		t.i64(9, chunks[i].offset)
+		t.structEnd()
+		t.structEnd()
+	}
+	t.i64(2, totalSize)
+	t.i64(3, int64(numRows))
+	t.structEnd()
+	if len(metadata) > 0 {
+		t.listBegin(5, thriftStruct, len(metadata))
+		for key, value := range metadata {
+			t.elemBegin()
+			t.binary(1, []byte(key))
+			t.binary(2, []byte(value))
+			t.structEnd()
+		}
+	}
+	t.binary(6, []byte(parquetCreatedBy))
+	t.buf.WriteByte(0) // FileMetaData end
+
+	if _, err := w.Write(t.buf.Bytes()); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(t.buf.Len())); err != nil {
+		return err
+	}
+	_, err := w.Write([]byte(parquetMagic))
+	return err
+}
+
+func countTopFields(columns []*ParquetColumn) (count int) {
+	for i, column := range columns {
+		if i == 0 || len(column.Path) == 1 || columns[i-1].Path[0] != column.Path[0] {
+			count++
+		}
+	}
+	return count
+}
+
+// Write the data pages of a column.
+func writeColumnChunk(w io.Writer, column *ParquetColumn, numRows int) error {
+	page := &bytes.Buffer{}
+	pageValues := 0
+	flush := func() error {
+		t := &thriftWriter{}
+		t.i32(1, parquetPageTypeData)
+		t.i32(2, int32(page.Len()))
+		t.i32(3, int32(page.Len()))
+		t.structBegin(5) // DataPageHeader
+		t.i32(1, int32(pageValues))
+		t.i32(2, parquetEncodingPlain)
+		t.i32(3, parquetEncodingRle)
+		t.i32(4, parquetEncodingRle)
+		t.structEnd()
+		t.buf.WriteByte(0)
+		if _, err := w.Write(t.buf.Bytes()); err != nil {
+			return err
+		}
+		_, err := w.Write(page.Bytes())
+		page.Reset()
+		pageValues = 0
+		return err
+	}
+	for row := range numRows {
+		value, err := column.Value(row)
+		if err != nil {
+			return err
+		}
+		switch column.Type {
+		case PARQUET_INT64:
+			v, ok := value.(int64)
+			if !ok {
+				return fmt.Errorf("row %d: invalid int64 value %v", row, value)
+			}
+			binary.Write(page, binary.LittleEndian, v)
+		case PARQUET_DOUBLE:
+			v, ok := value.(float64)
+			if !ok {
+				return fmt.Errorf("row %d: invalid double value %v", row, value)
+			}
+			binary.Write(page, binary.LittleEndian, math.Float64bits(v))
+		case PARQUET_BYTE_ARRAY:
+			var data []byte
+			switch v := value.(type) {
+			case []byte:
+				data = v
+			case string:
+				data = []byte(v)
+			default:
+				return fmt.Errorf("row %d: invalid byte array value %v", row, value)
+			}
+			if len(data) > math.MaxInt32 {
+				return fmt.Errorf("row %d: value is too large", row)
+			}
+			binary.Write(page, binary.LittleEndian, uint32(len(data)))
+			page.Write(data)
+		default:
+			return fmt.Errorf("unsupported type %d", column.Type)
+		}
+		pageValues++
+		if page.Len() >= parquetPageSizeLimit {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if pageValues > 0 || numRows == 0 {
+		return flush()
+	}
+	return nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Thrift compact protocol types.
+const (
+	thriftI32    byte = 5
+	thriftI64    byte = 6
+	thriftBinary byte = 8
+	thriftList   byte = 9
+	thriftStruct byte = 12
+)
+
+// A minimal Thrift compact protocol encoder, enough for parquet file metadata.
+type thriftWriter struct {
+	buf     bytes.Buffer
+	lastId  int16   // last field id of current struct
+	lastIds []int16 // stack of lastId of outer structs
+}
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	t.buf.Write(binary.AppendUvarint(nil, v))
+}
+
+func (t *thriftWriter) fieldHeader(id int16, typ byte) {
+	if delta := id - t.lastId; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.varint(zigzag(int64(id)))
+	}
+	t.lastId = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.fieldHeader(id, thriftI32)
+	t.varint(zigzag(int64(v)))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.fieldHeader(id, thriftI64)
+	t.varint(zigzag(v))
+}
+
+func (t *thriftWriter) binary(id int16, v []byte) {
+	t.fieldHeader(id, thriftBinary)
+	t.varint(uint64(len(v)))
+	t.buf.Write(v)
+}
+
+// Begin a struct field. Must be closed by structEnd.
+func (t *thriftWriter) structBegin(id int16) {
+	t.fieldHeader(id, thriftStruct)
+	t.elemBegin()
+}
+
+// Begin a struct element of list. Must be closed by structEnd.
+func (t *thriftWriter) elemBegin() {
+	t.lastIds = append(t.lastIds, t.lastId)
+	t.lastId = 0
+}
+
+func (t *thriftWriter) structEnd() {
+	t.buf.WriteByte(0)
+	t.lastId = t.lastIds[len(t.lastIds)-1]
+	t.lastIds = t.lastIds[:len(t.lastIds)-1]
+}
+
+// Begin a list field. The size elements must be written following it.
+func (t *thriftWriter) listBegin(id int16, elemType byte, size int) {
+	t.fieldHeader(id, thriftList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | elemType)
+	} else {
+		t.buf.WriteByte(0xF0 | elemType)
+		t.varint(uint64(size))
+	}
+}
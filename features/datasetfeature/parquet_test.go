@@ -0,0 +1,324 @@
+package datasetfeature
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type parquetTestRow struct {
+	ID        int64   `json:"id"`
+	Score     float64 `json:"score"`
+	Text      string  `json:"text"`
+	ImageSize int64   `json:"image_size"` // length of image.bytes
+	ImagePath string  `json:"image_path"`
+}
+
+var parquetTestRows = []parquetTestRow{
+	{ID: 1, Score: 0.5, Text: "a cat", ImageSize: 3, ImagePath: "1.png"},
+	{ID: -2, Score: -1.25, Text: "", ImageSize: 0, ImagePath: "2.png"},
+	{ID: math.MaxInt64, Score: 1e10, Text: "你好, world", ImageSize: 9 * 1024 * 1024, ImagePath: "3.png"},
+	{ID: 4, Score: 0, Text: "a dog", ImageSize: 4 * 1024 * 1024, ImagePath: "4.png"},
+}
+
+// Image bytes of row, large rows make the column span multiple data pages.
+func parquetTestImage(row int) []byte {
+	return bytes.Repeat([]byte{byte(row + 1)}, int(parquetTestRows[row].ImageSize))
+}
+
+func writeParquetTestFile(t *testing.T) []byte {
+	t.Helper()
+	columns := []*ParquetColumn{
+		{Path: []string{"id"}, Type: PARQUET_INT64, Value: func(row int) (any, error) {
+			return parquetTestRows[row].ID, nil
+		}},
+		{Path: []string{"score"}, Type: PARQUET_DOUBLE, Value: func(row int) (any, error) {
+			return parquetTestRows[row].Score, nil
+		}},
+		{Path: []string{"text"}, Type: PARQUET_BYTE_ARRAY, String: true, Value: func(row int) (any, error) {
+			return parquetTestRows[row].Text, nil
+		}},
+		{Path: []string{"image", "bytes"}, Type: PARQUET_BYTE_ARRAY, Value: func(row int) (any, error) {
+			return parquetTestImage(row), nil
+		}},
+		{Path: []string{"image", "path"}, Type: PARQUET_BYTE_ARRAY, String: true, Value: func(row int) (any, error) {
+			return parquetTestRows[row].ImagePath, nil
+		}},
+	}
+	buf := &bytes.Buffer{}
+	if err := WriteParquet(buf, columns, len(parquetTestRows), map[string]string{"foo": "bar"}); err != nil {
+		t.Fatalf("WriteParquet: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// Decode the written file with a generic Thrift compact protocol decoder,
+// and check the metadata fields against the parquet-format spec.
+func TestWriteParquet(t *testing.T) {
+	data := writeParquetTestFile(t)
+	if string(data[:4]) != parquetMagic || string(data[len(data)-4:]) != parquetMagic {
+		t.Fatalf("invalid magic")
+	}
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footerStart := len(data) - 8 - footerLen
+	r := &thriftReader{data: data[footerStart : len(data)-8]}
+	meta := r.readStruct()
+	if r.err != nil || r.pos != len(r.data) {
+		t.Fatalf("decode file metadata: err=%v, read %d of %d bytes", r.err, r.pos, len(r.data))
+	}
+	numRows := int64(len(parquetTestRows))
+	if meta[1] != int64(1) || meta[3] != numRows || string(meta[6].([]byte)) != parquetCreatedBy {
+		t.Errorf("invalid file metadata version / num_rows / created_by: %v", meta)
+	}
+
+	// schema: (type, repetition_type, name, num_children, converted_type), -1 if not set
+	wantSchema := [][5]any{
+		{-1, -1, "schema", 4, -1},
+		{int(PARQUET_INT64), 0, "id", -1, -1},
+		{int(PARQUET_DOUBLE), 0, "score", -1, -1},
+		{int(PARQUET_BYTE_ARRAY), 0, "text", -1, 0},
+		{-1, 0, "image", 2, -1},
+		{int(PARQUET_BYTE_ARRAY), 0, "bytes", -1, -1},
+		{int(PARQUET_BYTE_ARRAY), 0, "path", -1, 0},
+	}
+	var schema [][5]any
+	for _, e := range meta[2].([]any) {
+		element := e.(map[int16]any)
+		item := [5]any{-1, -1, string(element[4].([]byte)), -1, -1}
+		for i, id := range []int16{1, 3, -1, 5, 6} {
+			if v, ok := element[id].(int64); ok {
+				item[i] = int(v)
+			}
+		}
+		schema = append(schema, item)
+	}
+	if !reflect.DeepEqual(schema, wantSchema) {
+		t.Errorf("schema = %v, want %v", schema, wantSchema)
+	}
+
+	rowGroups := meta[4].([]any)
+	if len(rowGroups) != 1 {
+		t.Fatalf("row groups = %d, want 1", len(rowGroups))
+	}
+	rowGroup := rowGroups[0].(map[int16]any)
+	if rowGroup[3] != numRows {
+		t.Errorf("row group num_rows = %v, want %d", rowGroup[3], numRows)
+	}
+	var values [][]any
+	var totalSize int64
+	for i, c := range rowGroup[1].([]any) {
+		chunk := c.(map[int16]any)
+		columnMeta := chunk[3].(map[int16]any)
+		offset := columnMeta[9].(int64)
+		size := columnMeta[7].(int64)
+		totalSize += size
+		if chunk[2] != offset || columnMeta[6] != size || columnMeta[5] != numRows || columnMeta[4] != int64(0) {
+			t.Errorf("column %d: invalid column chunk %v", i, chunk)
+		}
+		columnValues, pages, err := readParquetTestChunk(data[offset:offset+size], columnMeta[1].(int64))
+		if err != nil {
+			t.Fatalf("column %d: %v", i, err)
+		}
+		if i == 3 && pages < 2 {
+			t.Errorf("column %d: pages = %d, want multiple pages", i, pages)
+		}
+		values = append(values, columnValues)
+	}
+	if rowGroup[2] != totalSize || footerStart != 4+int(totalSize) {
+		t.Errorf("row group total_byte_size = %v, want %d", rowGroup[2], totalSize)
+	}
+	if len(values) != 5 {
+		t.Fatalf("columns = %d, want 5", len(values))
+	}
+	for i, row := range parquetTestRows {
+		got := []any{values[0][i], values[1][i], string(values[2][i].([]byte)), values[3][i], string(values[4][i].([]byte))}
+		want := []any{row.ID, row.Score, row.Text, parquetTestImage(i), row.ImagePath}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("row %d: values mismatch", i)
+		}
+	}
+
+	keyValues := meta[5].([]any)
+	if len(keyValues) != 1 || string(keyValues[0].(map[int16]any)[1].([]byte)) != "foo" ||
+		string(keyValues[0].(map[int16]any)[2].([]byte)) != "bar" {
+		t.Errorf("invalid key_value_metadata %v", keyValues)
+	}
+}
+
+// Read the data pages of a column chunk, return PLAIN decoded values and number of pages.
+func readParquetTestChunk(chunk []byte, typ int64) (values []any, pages int, err error) {
+	for len(chunk) > 0 {
+		r := &thriftReader{data: chunk}
+		header := r.readStruct()
+		if r.err != nil {
+			return nil, 0, r.err
+		}
+		dataHeader, _ := header[5].(map[int16]any)
+		if header[1] != int64(0) || dataHeader == nil || dataHeader[2] != int64(0) || header[2] != header[3] {
+			return nil, 0, fmt.Errorf("invalid page header %v", header)
+		}
+		page := chunk[r.pos : r.pos+int(header[3].(int64))]
+		chunk = chunk[r.pos+len(page):]
+		pages++
+		for range dataHeader[1].(int64) {
+			switch int32(typ) {
+			case PARQUET_INT64:
+				values = append(values, int64(binary.LittleEndian.Uint64(page)))
+				page = page[8:]
+			case PARQUET_DOUBLE:
+				values = append(values, math.Float64frombits(binary.LittleEndian.Uint64(page)))
+				page = page[8:]
+			case PARQUET_BYTE_ARRAY:
+				n := int(binary.LittleEndian.Uint32(page))
+				values = append(values, page[4:4+n])
+				page = page[4+n:]
+			}
+		}
+		if len(page) > 0 {
+			return nil, 0, fmt.Errorf("page has %d trailing bytes", len(page))
+		}
+	}
+	return values, pages, nil
+}
+
+// Read the written file back with a real parquet reader: pyarrow or duckdb.
+// It's skipped if none of them is available, except in CI.
+func TestWriteParquetReader(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.parquet")
+	if err := os.WriteFile(file, writeParquetTestFile(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+	readers := map[string][]string{
+		"pyarrow": {"python3", "-c", `import sys, json, pyarrow.parquet as pq
+table = pq.read_table(sys.argv[1])
+assert table.schema.field("image").type.num_fields == 2
+print(json.dumps([{"id": r["id"], "score": r["score"], "text": r["text"],
+    "image_size": len(r["image"]["bytes"]), "image_path": r["image"]["path"]} for r in table.to_pylist()]))`, file},
+		"duckdb": {"duckdb", "-json", "-c", fmt.Sprintf(`SELECT id, score, text, octet_length(image.bytes) AS image_size,
+    image.path AS image_path FROM read_parquet('%s')`, file)},
+	}
+	tested := false
+	for name, command := range readers {
+		output, err := exec.Command(command[0], command[1:]...).Output()
+		if err != nil {
+			t.Logf("%s is not available: %v", name, err)
+			continue
+		}
+		tested = true
+		var rows []parquetTestRow
+		if err := json.Unmarshal(output, &rows); err != nil {
+			t.Errorf("%s: invalid output %q: %v", name, output, err)
+		} else if !reflect.DeepEqual(rows, parquetTestRows) {
+			t.Errorf("%s: rows = %v, want %v", name, rows, parquetTestRows)
+		}
+	}
+	if !tested {
+		if os.Getenv("CI") != "" {
+			t.Fatalf("no parquet reader (pyarrow or duckdb) is available")
+		}
+		t.Skip("no parquet reader (pyarrow or duckdb) is available")
+	}
+}
+
+// A minimal generic Thrift compact protocol decoder. Structs are decoded as map of field id => value,
+// integers as int64, binaries as []byte, lists as []any.
+type thriftReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *thriftReader) byte() byte {
+	if r.pos >= len(r.data) {
+		r.err = fmt.Errorf("unexpected end of data")
+		return 0
+	}
+	r.pos++
+	return r.data[r.pos-1]
+}
+
+func (r *thriftReader) varint() uint64 {
+	v, n := binary.Uvarint(r.data[min(r.pos, len(r.data)):])
+	if n <= 0 {
+		r.err = fmt.Errorf("invalid varint at %d", r.pos)
+		return 0
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) readStruct() map[int16]any {
+	fields := map[int16]any{}
+	var lastId int16
+	for r.err == nil {
+		b := r.byte()
+		if b == 0 {
+			break
+		}
+		id := lastId + int16(b>>4)
+		if b>>4 == 0 {
+			id = int16(r.zigzag())
+		}
+		lastId = id
+		switch typ := b & 0x0f; typ {
+		case 1, 2: // bool true, false
+			fields[id] = typ == 1
+		default:
+			fields[id] = r.readValue(typ)
+		}
+	}
+	return fields
+}
+
+func (r *thriftReader) readValue(typ byte) any {
+	switch typ {
+	case 1, 2: // bool list element
+		return r.byte() == 1
+	case 3: // byte
+		return int64(int8(r.byte()))
+	case 4, thriftI32, thriftI64:
+		return r.zigzag()
+	case 7: // double
+		if r.pos+8 > len(r.data) {
+			r.err = fmt.Errorf("unexpected end of data")
+			return nil
+		}
+		r.pos += 8
+		return math.Float64frombits(binary.LittleEndian.Uint64(r.data[r.pos-8:]))
+	case thriftBinary:
+		n := int(r.varint())
+		if r.err != nil || r.pos+n > len(r.data) {
+			r.err = fmt.Errorf("unexpected end of data")
+			return nil
+		}
+		r.pos += n
+		return r.data[r.pos-n : r.pos]
+	case thriftList:
+		b := r.byte()
+		size := int(b >> 4)
+		if size == 15 {
+			size = int(r.varint())
+		}
+		list := []any{}
+		for i := 0; i < size && r.err == nil; i++ {
+			list = append(list, r.readValue(b&0x0f))
+		}
+		return list
+	case thriftStruct:
+		return r.readStruct()
+	}
+	r.err = fmt.Errorf("unsupported type %d at %d", typ, r.pos)
+	return nil
+}
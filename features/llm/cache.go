@@ -0,0 +1,182 @@
+package llm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/natefinch/atomic"
+)
+
+// The on-disk response cache is a content-addressed store of non-streaming, temperature 0 responses.
+// Each response is saved as "<dir>/<key[:2]>/<key>.json" file,
+// where key is the sha256 of model, provider endpoint and the serialized request (including image bytes).
+const CACHE_EXT = ".json"
+
+var (
+	cacheMu  sync.RWMutex
+	cacheDir string // empty: cache disabled
+)
+
+// Enable the response cache using dir. An empty dir disables the cache.
+func SetCacheDir(dir string) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	cacheDir = dir
+}
+
+// Return the response cache dir. Return empty string if cache is disabled.
+func GetCacheDir() string {
+	cacheMu.RLock()
+	defer cacheMu.RUnlock()
+	return cacheDir
+}
+
+// Return the provider endpoint used in cache key.
+func providerEndpoint(provider Provider) string {
+	switch p := provider.(type) {
+	case *OpenAIProvider:
+		return p.BaseUrl
+	case *GeminiProvider:
+		return GEMINI_API_URL
+	default:
+		return fmt.Sprintf("%T", provider)
+	}
+}
+
+// Return the cache key of a request.
+func getCacheKey(model string, provider Provider, reqBody *OpenAIChatRequest) (string, error) {
+	hash := sha256.New()
+	encoder := json.NewEncoder(hash)
+	for _, v := range []any{model, providerEndpoint(provider), reqBody} {
+		if err := encoder.Encode(v); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func cacheFile(dir string, key string) string {
+	return filepath.Join(dir, key[:2], key+CACHE_EXT)
+}
+
+// Read a cached response. Return nil if not found.
+func readCache(dir string, key string) *OpenAIResponse {
+	contents, err := os.ReadFile(cacheFile(dir, key))
+	if err != nil {
+		return nil
+	}
+	resp := &OpenAIResponse{}
+	if err = json.Unmarshal(contents, resp); err != nil || len(resp.Choices) == 0 {
+		return nil
+	}
+	return resp
+}
+
+func writeCache(dir string, key string, resp *OpenAIResponse) error {
+	file := cacheFile(dir, key)
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	contents, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return atomic.WriteFile(file, bytes.NewReader(contents))
+}
+
+// Response cache dir stats.
+type CacheStats struct {
+	Entries int64
+	Size    int64
+	Oldest  time.Time // zero if no entries
+	Newest  time.Time // zero if no entries
+}
+
+type cacheEntry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// Return all entries of cache dir, sorted by mod time (oldest first).
+func listCache(dir string) (entries []*cacheEntry, err error) {
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir && errors.Is(err, fs.ErrNotExist) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), CACHE_EXT) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, &cacheEntry{path: path, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+	return entries, nil
+}
+
+// Return stats of cache dir.
+func GetCacheStats(dir string) (*CacheStats, error) {
+	entries, err := listCache(dir)
+	if err != nil {
+		return nil, err
+	}
+	stats := &CacheStats{Entries: int64(len(entries))}
+	for _, entry := range entries {
+		stats.Size += entry.size
+	}
+	if len(entries) > 0 {
+		stats.Oldest = entries[0].modTime
+		stats.Newest = entries[len(entries)-1].modTime
+	}
+	return stats, nil
+}
+
+// Prune cache dir. It removes entries older than maxAge (if > 0),
+// then removes the oldest entries until the total size is not larger than maxSize (if >= 0).
+// It returns the number of removed entries and freed bytes.
+func PruneCache(dir string, maxAge time.Duration, maxSize int64) (removed int64, freed int64, err error) {
+	entries, err := listCache(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	var size int64
+	for _, entry := range entries {
+		size += entry.size
+	}
+	for _, entry := range entries {
+		expired := maxAge > 0 && time.Since(entry.modTime) > maxAge
+		if !expired && (maxSize < 0 || size <= maxSize) {
+			continue
+		}
+		if err = os.Remove(entry.path); err != nil {
+			return removed, freed, err
+		}
+		size -= entry.size
+		removed++
+		freed += entry.size
+	}
+	return removed, freed, nil
+}
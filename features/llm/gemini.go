@@ -12,7 +12,10 @@ import (
 	"time"
 
 	"github.com/invopop/jsonschema"
+	"github.com/vincent-petithory/dataurl"
+
 	"github.com/sagan/goaider/constants"
+	"github.com/sagan/goaider/util"
 )
 
 const (
@@ -27,8 +30,30 @@ const (
 )
 
 type GeminiRequest struct {
-	Contents         []Content         `json:"contents"`
-	GenerationConfig *GenerationConfig `json:"generationConfig,omitempty"`
+	SystemInstruction *Content          `json:"systemInstruction,omitempty"`
+	Contents          []Content         `json:"contents"`
+	Tools             []GeminiTool      `json:"tools,omitempty"`
+	ToolConfig        *ToolConfig       `json:"toolConfig,omitempty"`
+	GenerationConfig  *GenerationConfig `json:"generationConfig,omitempty"`
+}
+
+type GeminiTool struct {
+	FunctionDeclarations []FunctionDeclaration `json:"functionDeclarations,omitempty"`
+}
+
+type FunctionDeclaration struct {
+	Name                 string             `json:"name"`
+	Description          string             `json:"description,omitempty"`
+	ParametersJsonSchema *jsonschema.Schema `json:"parametersJsonSchema,omitempty"`
+}
+
+type ToolConfig struct {
+	FunctionCallingConfig *FunctionCallingConfig `json:"functionCallingConfig,omitempty"`
+}
+
+type FunctionCallingConfig struct {
+	Mode                 string   `json:"mode,omitempty"` // "AUTO", "ANY", "NONE"
+	AllowedFunctionNames []string `json:"allowedFunctionNames,omitempty"`
 }
 
 type Content struct {
@@ -42,8 +67,23 @@ type InlineData struct {
 }
 
 type Part struct {
-	Text       string      `json:"text,omitempty"`
-	InlineData *InlineData `json:"inlineData,omitempty"`
+	Text             string            `json:"text,omitempty"`
+	InlineData       *InlineData       `json:"inlineData,omitempty"`
+	FunctionCall     *FunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *FunctionResponse `json:"functionResponse,omitempty"`
+	ThoughtSignature string            `json:"thoughtSignature,omitempty"`
+}
+
+type FunctionCall struct {
+	Id   string         `json:"id,omitempty"`
+	Name string         `json:"name"`
+	Args map[string]any `json:"args,omitempty"`
+}
+
+type FunctionResponse struct {
+	Id       string         `json:"id,omitempty"`
+	Name     string         `json:"name"`
+	Response map[string]any `json:"response"`
 }
 
 type GenerationConfig struct {
@@ -53,8 +93,17 @@ type GenerationConfig struct {
 }
 
 type GeminiResponse struct {
+	ResponseId     string         `json:"responseId"`
 	PromptFeedback PromptFeedback `json:"promptFeedback"`
 	Candidates     []Candidate    `json:"candidates"`
+	UsageMetadata  *UsageMetadata `json:"usageMetadata,omitempty"`
+}
+
+type UsageMetadata struct {
+	PromptTokenCount     int64 `json:"promptTokenCount"`
+	CandidatesTokenCount int64 `json:"candidatesTokenCount"`
+	ThoughtsTokenCount   int64 `json:"thoughtsTokenCount"`
+	TotalTokenCount      int64 `json:"totalTokenCount"`
 }
 
 type Candidate struct {
@@ -75,6 +124,12 @@ type PromptFeedback struct {
 }
 
 func Gemini(apiKey string, model string, reqBody *GeminiRequest) (*GeminiResponse, error) {
+	return callGemini(apiKey, model, nil, reqBody)
+}
+
+// callGemini is Gemini with extra http request headers.
+func callGemini(apiKey string, model string, headers map[string]string,
+	reqBody *GeminiRequest) (*GeminiResponse, error) {
 	if apiKey == "" {
 		apiKey = os.Getenv(constants.ENV_GEMINI_API_KEY)
 		if apiKey == "" {
@@ -93,6 +148,9 @@ func Gemini(apiKey string, model string, reqBody *GeminiRequest) (*GeminiRespons
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -102,7 +160,12 @@ func Gemini(apiKey string, model string, reqBody *GeminiRequest) (*GeminiRespons
 
 	if resp.StatusCode != 200 {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, &ApiError{Status: resp.StatusCode, Body: string(bodyBytes)}
+		return nil, &ApiError{
+			Status:     resp.StatusCode,
+			Body:       string(bodyBytes),
+			Message:    fmt.Sprintf("Gemini API returned status %d", resp.StatusCode),
+			RetryAfter: parseRetryAfter(resp.Header, bodyBytes),
+		}
 	}
 
 	var apiResp *GeminiResponse
@@ -260,3 +323,204 @@ func GeminiImageToJson[T any](apiKey string, model string, promptText string,
 
 	return result, nil
 }
+
+// OpenAIRequestToGemini translates an OpenAI chat request to the native Gemini request.
+// "system" messages are merged into systemInstruction; "assistant" role is mapped to "model";
+// "tool" messages are mapped to functionResponse parts.
+// Only data url images (or other inline binary data) are supported.
+func OpenAIRequestToGemini(reqBody *OpenAIChatRequest) (*GeminiRequest, error) {
+	geminiReq := &GeminiRequest{
+		GenerationConfig: &GenerationConfig{
+			Temperature: reqBody.Temperature,
+		},
+	}
+	toolCallNames := map[string]string{} // tool call id => function name
+	for _, msg := range reqBody.Messages {
+		parts, err := openAIContentToGeminiParts(msg.Content)
+		if err != nil {
+			return nil, err
+		}
+		switch msg.Role {
+		case "system":
+			if geminiReq.SystemInstruction == nil {
+				geminiReq.SystemInstruction = &Content{}
+			}
+			geminiReq.SystemInstruction.Parts = append(geminiReq.SystemInstruction.Parts, parts...)
+		case "assistant":
+			for _, toolCall := range msg.ToolCalls {
+				toolCallNames[toolCall.Id] = toolCall.Function.Name
+				var args map[string]any
+				if toolCall.Function.Arguments != "" {
+					if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &args); err != nil {
+						return nil, fmt.Errorf("invalid tool call %q arguments: %w", toolCall.Function.Name, err)
+					}
+				}
+				part := Part{FunctionCall: &FunctionCall{Id: toolCall.Id, Name: toolCall.Function.Name, Args: args}}
+				if toolCall.ExtraContent != nil && toolCall.ExtraContent.Google != nil {
+					part.ThoughtSignature = toolCall.ExtraContent.Google.ThoughtSignature
+				}
+				parts = append(parts, part)
+			}
+			geminiReq.Contents = append(geminiReq.Contents, Content{Role: "model", Parts: parts})
+		case "tool":
+			name := msg.Name
+			if name == "" {
+				name = toolCallNames[msg.ToolCallId]
+			}
+			text := ""
+			for _, part := range parts {
+				text += part.Text
+			}
+			var response map[string]any
+			if err := json.Unmarshal([]byte(text), &response); err != nil || response == nil {
+				response = map[string]any{"result": text}
+			}
+			part := Part{FunctionResponse: &FunctionResponse{Id: msg.ToolCallId, Name: name, Response: response}}
+			// All function responses of a turn should be in one content
+			if n := len(geminiReq.Contents); n > 0 && geminiReq.Contents[n-1].Role == "user" &&
+				geminiReq.Contents[n-1].Parts[0].FunctionResponse != nil {
+				geminiReq.Contents[n-1].Parts = append(geminiReq.Contents[n-1].Parts, part)
+			} else {
+				geminiReq.Contents = append(geminiReq.Contents, Content{Role: "user", Parts: []Part{part}})
+			}
+		default:
+			geminiReq.Contents = append(geminiReq.Contents, Content{Role: "user", Parts: parts})
+		}
+	}
+	if len(reqBody.Tools) > 0 {
+		tool := GeminiTool{}
+		for _, t := range reqBody.Tools {
+			if t.Function == nil {
+				continue
+			}
+			tool.FunctionDeclarations = append(tool.FunctionDeclarations, FunctionDeclaration{
+				Name:                 t.Function.Name,
+				Description:          t.Function.Description,
+				ParametersJsonSchema: t.Function.Parameters,
+			})
+		}
+		geminiReq.Tools = []GeminiTool{tool}
+	}
+	switch toolChoice := reqBody.ToolChoice.(type) {
+	case nil:
+	case string:
+		mode := map[string]string{"none": "NONE", "auto": "AUTO", "required": "ANY"}[toolChoice]
+		if mode == "" {
+			return nil, fmt.Errorf("unsupported tool_choice %q", toolChoice)
+		}
+		geminiReq.ToolConfig = &ToolConfig{FunctionCallingConfig: &FunctionCallingConfig{Mode: mode}}
+	default:
+		// {"type": "function", "function": {"name": "my_function"}}
+		var choice *OpenAITool
+		if err := json.Unmarshal([]byte(util.ToJson(toolChoice)), &choice); err != nil ||
+			choice == nil || choice.Function == nil {
+			return nil, fmt.Errorf("unsupported tool_choice %v", toolChoice)
+		}
+		geminiReq.ToolConfig = &ToolConfig{FunctionCallingConfig: &FunctionCallingConfig{
+			Mode:                 "ANY",
+			AllowedFunctionNames: []string{choice.Function.Name},
+		}}
+	}
+	if reqBody.ResponseFormat != nil {
+		switch reqBody.ResponseFormat.Type {
+		case "json_object":
+			geminiReq.GenerationConfig.ResponseMimeType = "application/json"
+		case "json_schema":
+			geminiReq.GenerationConfig.ResponseMimeType = "application/json"
+			if reqBody.ResponseFormat.JsonSchema != nil {
+				geminiReq.GenerationConfig.ResponseJsonSchema = reqBody.ResponseFormat.JsonSchema.Schema
+			}
+		}
+	}
+	return geminiReq, nil
+}
+
+func openAIContentToGeminiParts(content any) ([]Part, error) {
+	switch content := content.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if content == "" {
+			return nil, nil
+		}
+		return []Part{{Text: content}}, nil
+	case []OpenAIContentPart:
+		var parts []Part
+		for _, part := range content {
+			switch part.Type {
+			case "text":
+				parts = append(parts, Part{Text: part.Text})
+			case "image_url":
+				if part.ImageUrl == nil {
+					continue
+				}
+				data, err := dataurl.DecodeString(part.ImageUrl.Url)
+				if err != nil {
+					return nil, fmt.Errorf("only data url is supported for gemini: %w", err)
+				}
+				parts = append(parts, Part{InlineData: &InlineData{
+					MimeType: data.MediaType.ContentType(),
+					Data:     base64.StdEncoding.EncodeToString(data.Data),
+				}})
+			default:
+				return nil, fmt.Errorf("unsupported content part type %q", part.Type)
+			}
+		}
+		return parts, nil
+	default:
+		return nil, fmt.Errorf("unknown content type in message")
+	}
+}
+
+// GeminiResponseToOpenAI translates a native Gemini response to the OpenAI chat response.
+// Text parts of each candidate are concatenated; functionCall parts are mapped to tool calls.
+func GeminiResponseToOpenAI(geminiResp *GeminiResponse) *OpenAIResponse {
+	apiResp := &OpenAIResponse{ID: geminiResp.ResponseId}
+	if geminiResp.UsageMetadata != nil {
+		apiResp.Usage = &OpenAIUsage{
+			PromptTokens:     geminiResp.UsageMetadata.PromptTokenCount,
+			CompletionTokens: geminiResp.UsageMetadata.CandidatesTokenCount + geminiResp.UsageMetadata.ThoughtsTokenCount,
+			TotalTokens:      geminiResp.UsageMetadata.TotalTokenCount,
+		}
+	}
+	for _, candidate := range geminiResp.Candidates {
+		var text strings.Builder
+		message := OpenAIMessage{Role: "assistant"}
+		for _, part := range candidate.Content.Parts {
+			if part.FunctionCall != nil {
+				id := part.FunctionCall.Id
+				if id == "" {
+					id = fmt.Sprintf("call_%d", len(message.ToolCalls))
+				}
+				args := "{}"
+				if part.FunctionCall.Args != nil {
+					args = util.ToJson(part.FunctionCall.Args)
+				}
+				toolCall := &OpenAIToolCall{
+					Id:       id,
+					Type:     "function",
+					Function: OpenAIFunctionCall{Name: part.FunctionCall.Name, Arguments: args},
+				}
+				if part.ThoughtSignature != "" {
+					toolCall.ExtraContent = &OpenAIExtraContent{
+						Google: &OpenAIGoogleExtraContent{ThoughtSignature: part.ThoughtSignature},
+					}
+				}
+				message.ToolCalls = append(message.ToolCalls, toolCall)
+				continue
+			}
+			text.WriteString(part.Text)
+		}
+		message.Content = text.String()
+		finishReason := strings.ToLower(candidate.FinishReason)
+		if len(message.ToolCalls) > 0 {
+			finishReason = "tool_calls"
+		}
+		apiResp.Choices = append(apiResp.Choices, OpenAIChoice{
+			Index:        candidate.Index,
+			Message:      message,
+			FinishReason: finishReason,
+		})
+	}
+	return apiResp
+}
@@ -1,10 +1,16 @@
 package llm
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"slices"
 	"strings"
+	"time"
+
+	"github.com/invopop/jsonschema"
+	log "github.com/sirupsen/logrus"
+	"github.com/vincent-petithory/dataurl"
 
 	"github.com/sagan/goaider/util"
 )
@@ -24,6 +30,8 @@ type ApiError struct {
 	Status    int   // http status code
 	Err       error // wrapped error
 	Retryable bool  // busines logic defined retryable
+	// Server suggested retry delay, parsed from "Retry-After" header or Gemini RetryInfo. 0 if not provided
+	RetryAfter time.Duration
 }
 
 func (a *ApiError) Error() string {
@@ -43,113 +51,160 @@ func (a *ApiError) Unwrap() error {
 	return a.Err
 }
 
-// Wrapper of openai & gemini
-func ImageToJson[T any](apiKey string, model string, prompt string, imageBytes []byte, mimeType string,
-	temperature float64) (*T, error) {
-	if strings.HasPrefix(model, GEMINI_MODEL_PREFIX) {
-		return GeminiImageToJson[T](apiKey, model, prompt, imageBytes, mimeType, temperature)
-	} else if isOpenAiModel(model) {
-		return OpenAIImageToJson[T](OPENAI_API_URL, apiKey, model, prompt, imageBytes, mimeType, temperature)
-	} else if openrouterModel, ok := strings.CutPrefix(model, OPENROUTER_MODEL_PREFIX); ok {
-		if !strings.ContainsRune(openrouterModel, '/') {
-			openrouterModel = OPENROUTER_MODEL_PREFIX + openrouterModel
+// Complete resolves the provider of model and sends a non-streaming chat request to it.
+// reqBody.Model is overwritten by the provider side model name.
+// If response cache is enabled and the request is deterministic (temperature 0),
+// the cached response of an identical request is returned if exists.
+// Temporary errors are retried with the retry policy, and the rate limit of model is applied.
+func Complete(apiKey string, model string, reqBody *OpenAIChatRequest) (*OpenAIResponse, error) {
+	provider, providerModel, err := ResolveModel(model)
+	if err != nil {
+		return nil, err
+	}
+	reqBody.Model = providerModel
+	cacheKey := ""
+	// Sampled (temperature != 0) responses are not cached, each call should get a new one.
+	if dir := GetCacheDir(); dir != "" && reqBody.Temperature == 0 {
+		if cacheKey, err = getCacheKey(model, provider, reqBody); err != nil {
+			return nil, err
+		}
+		if resp := readCache(dir, cacheKey); resp != nil {
+			RecordCacheHit(model)
+			return resp, nil
 		}
-		return OpenAIImageToJson[T](OPENROUTER_API_URL, apiKey, openrouterModel, prompt, imageBytes, mimeType, temperature)
-	} else if strings.HasPrefix(model, OPENAI_COMPATIBLE_MODEL_PREFIX) { // "openai/model-name/http://localhost:8080/v1"
-		parts := strings.SplitN(model, "/", 3)
-		if len(parts) == 3 {
-			return OpenAIImageToJson[T](parts[2], apiKey, parts[1], prompt, imageBytes, mimeType, temperature)
+	}
+	resp, err := callWithRetry(model, func() bool { return true }, func() (*OpenAIResponse, error) {
+		return provider.Complete(apiKey, reqBody)
+	})
+	if err != nil {
+		return nil, err
+	}
+	RecordUsage(model, resp.Usage)
+	if cacheKey != "" && len(resp.Choices) > 0 {
+		if err := writeCache(GetCacheDir(), cacheKey, resp); err != nil {
+			log.Warnf("failed to write response cache: %v", err)
 		}
-		return nil, fmt.Errorf("invalid openai model %s", model)
 	}
-	return nil, fmt.Errorf("unsupported model %s", model)
+	return resp, nil
+}
+
+// Wrapper of all providers
+func ImageToJson[T any](apiKey string, model string, prompt string, imageBytes []byte, mimeType string,
+	temperature float64) (*T, error) {
+	reqBody := &OpenAIChatRequest{
+		Messages:       []*OpenAIMessage{{Role: "user", Content: imageContentParts(prompt, imageBytes, mimeType)}},
+		Temperature:    temperature,
+		ResponseFormat: jsonSchemaResponseFormat[T]("result_schema"),
+	}
+	return completeJson[T](apiKey, model, reqBody)
 }
 
 func ImageToText(apiKey string, model string, prompt string, imageBytes []byte, mimeType string,
 	temperature float64) (string, error) {
-	if strings.HasPrefix(model, GEMINI_API_URL) {
-		return GeminiImageToText(apiKey, model, prompt, imageBytes, mimeType, temperature)
-	} else if isOpenAiModel(model) {
-		return OpenAIImageToText(OPENAI_API_URL, apiKey, model, prompt, imageBytes, mimeType, temperature)
-	} else if openrouterModel, ok := strings.CutPrefix(model, OPENROUTER_MODEL_PREFIX); ok {
-		if !strings.ContainsRune(openrouterModel, '/') {
-			openrouterModel = OPENROUTER_MODEL_PREFIX + openrouterModel
-		}
-		return OpenAIImageToText(OPENROUTER_API_URL, apiKey, openrouterModel, prompt, imageBytes, mimeType, temperature)
-	} else if strings.HasPrefix(model, OPENAI_COMPATIBLE_MODEL_PREFIX) {
-		parts := strings.SplitN(model, "/", 3)
-		if len(parts) == 3 {
-			return OpenAIImageToText(parts[2], apiKey, parts[1], prompt, imageBytes, mimeType, temperature)
-		}
-		return "", fmt.Errorf("invalid openai model %s", model)
+	reqBody := &OpenAIChatRequest{
+		Messages:    []*OpenAIMessage{{Role: "user", Content: imageContentParts(prompt, imageBytes, mimeType)}},
+		Temperature: temperature,
 	}
-	return "", fmt.Errorf("unsupported model %s", model)
+	return completeText(apiKey, model, reqBody)
 }
 
 func ChatJsonResponse[T any](apiKey string, model string, prompt string, temperature float64) (*T, error) {
-	if strings.HasPrefix(model, GEMINI_MODEL_PREFIX) {
-		return GeminiJsonResponse[T](apiKey, model, prompt, temperature)
-	} else if isOpenAiModel(model) {
-		return OpenAIJsonResponse[T](OPENAI_API_URL, apiKey, model, prompt, temperature)
-	} else if openrouterModel, ok := strings.CutPrefix(model, OPENROUTER_MODEL_PREFIX); ok {
-		if !strings.ContainsRune(openrouterModel, '/') {
-			openrouterModel = OPENROUTER_MODEL_PREFIX + openrouterModel
-		}
-		return OpenAIJsonResponse[T](OPENROUTER_API_URL, apiKey, openrouterModel, prompt, temperature)
-	} else if strings.HasPrefix(model, OPENAI_COMPATIBLE_MODEL_PREFIX) {
-		parts := strings.SplitN(model, "/", 3)
-		if len(parts) == 3 {
-			return OpenAIJsonResponse[T](parts[2], apiKey, parts[1], prompt, temperature)
-		}
-		return nil, fmt.Errorf("invalid openai model %s", model)
+	reqBody := &OpenAIChatRequest{
+		Messages:       []*OpenAIMessage{{Role: "user", Content: prompt}},
+		Temperature:    temperature,
+		ResponseFormat: jsonSchemaResponseFormat[T]("response_schema"),
 	}
-	return nil, fmt.Errorf("unsupported model %s", model)
+	return completeJson[T](apiKey, model, reqBody)
 }
 
 func Chat(apiKey string, model string, prompt string, temperature float64) (string, error) {
-	if strings.HasPrefix(model, GEMINI_MODEL_PREFIX) {
-		return GeminiChat(apiKey, model, prompt, temperature)
-	} else if isOpenAiModel(model) {
-		return OpenAIChat(OPENAI_API_URL, apiKey, model, prompt, temperature)
-	} else if openrouterModel, ok := strings.CutPrefix(model, OPENROUTER_MODEL_PREFIX); ok {
-		if !strings.ContainsRune(openrouterModel, '/') {
-			openrouterModel = OPENROUTER_MODEL_PREFIX + openrouterModel
-		}
-		return OpenAIChat(OPENROUTER_API_URL, apiKey, openrouterModel, prompt, temperature)
-	} else if strings.HasPrefix(model, OPENAI_COMPATIBLE_MODEL_PREFIX) {
-		parts := strings.SplitN(model, "/", 3)
-		if len(parts) == 3 {
-			return OpenAIChat(parts[2], apiKey, parts[1], prompt, temperature)
-		}
-		return "", fmt.Errorf("invalid openai model %s", model)
+	reqBody := &OpenAIChatRequest{
+		Messages:    []*OpenAIMessage{{Role: "user", Content: prompt}},
+		Temperature: temperature,
 	}
-	return "", fmt.Errorf("unsupported model %s", model)
+	return completeText(apiKey, model, reqBody)
 }
 
-func Stream(apiKey string, model string, reqBody *OpenAIChatRequest, onChunk func(content string) error) error {
-	apiEndpoint := ""
-	if strings.HasPrefix(model, GEMINI_MODEL_PREFIX) {
-		apiEndpoint = GEMINI_OPENAI_COMPATIBLE_API_URL
-	} else if isOpenAiModel(model) {
-		apiEndpoint = OPENAI_API_URL
-	} else if openrouterModel, ok := strings.CutPrefix(model, OPENROUTER_MODEL_PREFIX); ok {
-		if !strings.ContainsRune(openrouterModel, '/') {
-			openrouterModel = OPENROUTER_MODEL_PREFIX + openrouterModel
-		}
-		model = openrouterModel
-		apiEndpoint = OPENROUTER_API_URL
-	} else if strings.HasPrefix(model, OPENAI_COMPATIBLE_MODEL_PREFIX) {
-		parts := strings.SplitN(model, "/", 3)
-		if len(parts) != 3 {
-			return fmt.Errorf("invalid openai model %s", model)
-		}
-		apiEndpoint = parts[2]
-		model = parts[1]
+// Stream resolves the provider of model and sends a streaming chat request to it.
+// It returns the assembled full response.
+// The retry policy and rate limit of model are applied as Complete.
+func Stream(apiKey string, model string, reqBody *OpenAIChatRequest,
+	onChunk func(content string) error) (*OpenAIResponse, error) {
+	provider, providerModel, err := ResolveModel(model)
+	if err != nil {
+		return nil, err
+	}
+	reqBody.Model = providerModel
+	// A failed stream request is only retried if nothing has been received,
+	// otherwise the received contents would be duplicated.
+	received := false
+	resp, err := callWithRetry(model, func() bool { return !received }, func() (*OpenAIResponse, error) {
+		return provider.Stream(apiKey, reqBody, func(content string) error {
+			received = true
+			return onChunk(content)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	RecordUsage(model, resp.Usage)
+	return resp, nil
+}
+
+func completeText(apiKey string, model string, reqBody *OpenAIChatRequest) (string, error) {
+	resp, err := Complete(apiKey, model, reqBody)
+	if err != nil {
+		return "", err
+	}
+	contentStr, ok := resp.Choices[0].Message.Content.(string)
+	if !ok {
+		return "", fmt.Errorf("unexpected content format")
+	}
+	return strings.TrimSpace(contentStr), nil
+}
+
+func completeJson[T any](apiKey string, model string, reqBody *OpenAIChatRequest) (*T, error) {
+	resp, err := Complete(apiKey, model, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	rawJsonString, ok := resp.Choices[0].Message.Content.(string)
+	if !ok {
+		return nil, fmt.Errorf("unexpected content format in response")
+	}
+	rawJsonString = StripJsonWrap(rawJsonString)
+
+	result := new(T)
+	if err := json.Unmarshal([]byte(rawJsonString), &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal internal JSON: %w", err)
+	}
+	return result, nil
+}
+
+// Return a strict "json_schema" response format of T.
+func jsonSchemaResponseFormat[T any](name string) *OpenAIResponseFormat {
+	return &OpenAIResponseFormat{
+		Type: "json_schema",
+		JsonSchema: &OpenAIJsonSchema{
+			Name:   name,
+			Schema: jsonschema.Reflect(new(T)),
+			Strict: true,
+		},
+	}
+}
+
+// Return content parts of a text prompt and a binary attachment (image or audio) encoded as data url.
+func imageContentParts(prompt string, imageBytes []byte, mimeType string) []OpenAIContentPart {
+	dataUrl := ""
+	if mimeType != "" {
+		dataUrl = dataurl.New(imageBytes, mimeType).String()
 	} else {
-		return fmt.Errorf("unsupported model %s", model)
+		dataUrl = dataurl.EncodeBytes(imageBytes)
+	}
+	return []OpenAIContentPart{
+		{Type: "text", Text: prompt},
+		{Type: "image_url", ImageUrl: &OpenAIImageUrl{Url: dataUrl}},
 	}
-	reqBody.Model = model
-	return CallOpenAIStream(apiEndpoint, apiKey, reqBody, onChunk)
 }
 
 // From https://platform.openai.com/docs/pricing
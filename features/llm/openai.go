@@ -10,8 +10,10 @@ import (
 	"os"
 	"slices"
 	"strings"
+	"sync"
 
 	"github.com/invopop/jsonschema"
+	log "github.com/sirupsen/logrus"
 	"github.com/vincent-petithory/dataurl"
 
 	"github.com/sagan/goaider/constants"
@@ -31,7 +33,14 @@ type OpenAIChatRequest struct {
 	Messages       []*OpenAIMessage      `json:"messages"`
 	Temperature    float64               `json:"temperature"`
 	ResponseFormat *OpenAIResponseFormat `json:"response_format,omitempty"`
+	Tools          []*OpenAITool         `json:"tools,omitempty"`
+	ToolChoice     any                   `json:"tool_choice,omitempty"` // "none", "auto", "required" or object
 	Stream         bool                  `json:"stream,omitempty"`
+	StreamOptions  *OpenAIStreamOptions  `json:"stream_options,omitempty"`
+}
+
+type OpenAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"` // send usage in the final chunk
 }
 
 // Return full text prompt.
@@ -70,8 +79,79 @@ func (req *OpenAIChatRequest) GetFullPrompt(sep string) (string, error) {
 }
 
 type OpenAIMessage struct {
-	Role    string `json:"role"`    // user, assistant, system, tool
-	Content any    `json:"content"` // Can be string or []OpenAIContentPart
+	Role       string            `json:"role"`                   // user, assistant, system, tool
+	Content    any               `json:"content"`                // Can be string or []OpenAIContentPart
+	ToolCalls  []*OpenAIToolCall `json:"tool_calls,omitempty"`   // "assistant" role only
+	ToolCallId string            `json:"tool_call_id,omitempty"` // "tool" role only
+	Name       string            `json:"name,omitempty"`         // "tool" role: the function name
+}
+
+// UnmarshalJSON decodes content to string or []OpenAIContentPart, instead of generic any.
+func (m *OpenAIMessage) UnmarshalJSON(data []byte) error {
+	type message OpenAIMessage
+	var raw struct {
+		message
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*m = OpenAIMessage(raw.message)
+	m.Content = nil
+	content := bytes.TrimSpace(raw.Content)
+	if len(content) == 0 || bytes.Equal(content, []byte("null")) {
+		return nil
+	}
+	if content[0] == '[' {
+		var parts []OpenAIContentPart
+		if err := json.Unmarshal(content, &parts); err != nil {
+			return err
+		}
+		m.Content = parts
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(content, &text); err != nil {
+		return err
+	}
+	m.Content = text
+	return nil
+}
+
+// Function calling tool definition
+type OpenAITool struct {
+	Type     string          `json:"type"` // "function"
+	Function *OpenAIFunction `json:"function"`
+}
+
+type OpenAIFunction struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description,omitempty"`
+	Parameters  *jsonschema.Schema `json:"parameters,omitempty"`
+	Strict      bool               `json:"strict,omitempty"`
+}
+
+type OpenAIToolCall struct {
+	Index        *int                `json:"index,omitempty"` // streaming delta only
+	Id           string              `json:"id,omitempty"`
+	Type         string              `json:"type,omitempty"` // "function"
+	Function     OpenAIFunctionCall  `json:"function"`
+	ExtraContent *OpenAIExtraContent `json:"extra_content,omitempty"` // Gemini only
+}
+
+// Gemini (OpenAI compatible API) specific tool call data.
+// The thought signature must be sent back to Gemini along with the function call.
+type OpenAIExtraContent struct {
+	Google *OpenAIGoogleExtraContent `json:"google,omitempty"`
+}
+
+type OpenAIGoogleExtraContent struct {
+	ThoughtSignature string `json:"thought_signature,omitempty"`
+}
+
+type OpenAIFunctionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"` // JSON string
 }
 
 type OpenAIContentPart struct {
@@ -99,9 +179,17 @@ type OpenAIJsonSchema struct {
 type OpenAIResponse struct {
 	ID      string         `json:"id"`
 	Choices []OpenAIChoice `json:"choices"`
+	Usage   *OpenAIUsage   `json:"usage,omitempty"`
 	Error   *OpenAIError   `json:"error,omitempty"` // Sometimes returned in 200 OK by proxies
 }
 
+// Token usage of a request
+type OpenAIUsage struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"` // including reasoning tokens
+	TotalTokens      int64 `json:"total_tokens"`
+}
+
 type OpenAIChoice struct {
 	Index        int           `json:"index"`
 	Message      OpenAIMessage `json:"message"`
@@ -121,6 +209,7 @@ type OpenAIError struct {
 type OpenAIStreamChunk struct {
 	ID      string               `json:"id"`
 	Choices []OpenAIStreamChoice `json:"choices"`
+	Usage   *OpenAIUsage         `json:"usage,omitempty"` // final chunk only, if "include_usage" is set
 }
 
 type OpenAIStreamChoice struct {
@@ -130,8 +219,9 @@ type OpenAIStreamChoice struct {
 }
 
 type OpenAIDelta struct {
-	Role    string `json:"role,omitempty"`
-	Content string `json:"content,omitempty"`
+	Role      string            `json:"role,omitempty"`
+	Content   string            `json:"content,omitempty"`
+	ToolCalls []*OpenAIToolCall `json:"tool_calls,omitempty"`
 }
 
 func getOpenAIApiKeyFromEnv(baseUrl string) (apiKey string, err error) {
@@ -160,6 +250,12 @@ func getOpenAIApiKeyFromEnv(baseUrl string) (apiKey string, err error) {
 // CallOpenAI is the base function for OpenAI compatible APIs.
 // baseUrl: e.g., "https://api.openai.com/v1" or "http://localhost:11434/v1"
 func CallOpenAI(baseUrl string, apiKey string, reqBody *OpenAIChatRequest) (apiResp *OpenAIResponse, err error) {
+	return callOpenAI(baseUrl, apiKey, nil, reqBody)
+}
+
+// callOpenAI is CallOpenAI with extra http request headers.
+func callOpenAI(baseUrl string, apiKey string, headers map[string]string,
+	reqBody *OpenAIChatRequest) (apiResp *OpenAIResponse, err error) {
 	if apiKey == "" {
 		apiKey, err = getOpenAIApiKeyFromEnv(baseUrl)
 		if err != nil {
@@ -167,6 +263,7 @@ func CallOpenAI(baseUrl string, apiKey string, reqBody *OpenAIChatRequest) (apiR
 		}
 	}
 	reqBody.Stream = false
+	reqBody.StreamOptions = nil
 
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
@@ -184,6 +281,9 @@ func CallOpenAI(baseUrl string, apiKey string, reqBody *OpenAIChatRequest) (apiR
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -200,10 +300,11 @@ func CallOpenAI(baseUrl string, apiKey string, reqBody *OpenAIChatRequest) (apiR
 	// Handle non-200 HTTP statuses
 	if resp.StatusCode != 200 {
 		return nil, &ApiError{
-			Status:    resp.StatusCode,
-			Body:      string(bodyBytes),
-			Message:   fmt.Sprintf("OpenAI API returned status %d", resp.StatusCode),
-			Retryable: resp.StatusCode == 429 || resp.StatusCode >= 500,
+			Status:     resp.StatusCode,
+			Body:       string(bodyBytes),
+			Message:    fmt.Sprintf("OpenAI API returned status %d", resp.StatusCode),
+			Retryable:  resp.StatusCode == 429 || resp.StatusCode >= 500,
+			RetryAfter: parseRetryAfter(resp.Header, bodyBytes),
 		}
 	}
 
@@ -223,54 +324,96 @@ func CallOpenAI(baseUrl string, apiKey string, reqBody *OpenAIChatRequest) (apiR
 	return apiResp, nil
 }
 
-// CallOpenAIStream handles streaming responses (SSE).
-// onChunk: A callback function invoked for every text token received.
-// Return an error from onChunk to stop streaming immediately.
-func CallOpenAIStream(baseUrl, apiKey string, reqBody *OpenAIChatRequest,
-	onChunk func(content string) error) (err error) {
-	if apiKey == "" {
-		apiKey, err = getOpenAIApiKeyFromEnv(baseUrl)
-		if err != nil {
-			return err
-		}
-	}
-	// Force stream to true
-	reqBody.Stream = true
+// Base urls of OpenAI-compatible servers which reject the "stream_options" field of streaming request.
+var noStreamOptionsUrls sync.Map
 
+// Send a streaming chat completions request. The caller must close the response body.
+func postOpenAIStream(baseUrl, apiKey string, headers map[string]string,
+	reqBody *OpenAIChatRequest) (*http.Response, error) {
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
-		return fmt.Errorf("failed to marshal request: %w", err)
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	baseUrl = strings.TrimRight(baseUrl, "/")
 	url := fmt.Sprintf("%s/chat/completions", baseUrl)
 
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
 	req.Header.Set("Accept", "text/event-stream") // Standard for SSE
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
 
-	resp, err := http.DefaultClient.Do(req)
+	return http.DefaultClient.Do(req)
+}
+
+// CallOpenAIStream handles streaming responses (SSE).
+// onChunk: A callback function invoked for every text token received.
+// Return an error from onChunk to stop streaming immediately.
+// It returns the assembled full response, which includes the streamed tool calls.
+func CallOpenAIStream(baseUrl, apiKey string, reqBody *OpenAIChatRequest,
+	onChunk func(content string) error) (*OpenAIResponse, error) {
+	return callOpenAIStream(baseUrl, apiKey, nil, reqBody, onChunk)
+}
+
+// callOpenAIStream is CallOpenAIStream with extra http request headers.
+func callOpenAIStream(baseUrl, apiKey string, headers map[string]string, reqBody *OpenAIChatRequest,
+	onChunk func(content string) error) (apiResp *OpenAIResponse, err error) {
+	if apiKey == "" {
+		apiKey, err = getOpenAIApiKeyFromEnv(baseUrl)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Force stream to true
+	reqBody.Stream = true
+	reqBody.StreamOptions = nil
+	baseUrl = strings.TrimRight(baseUrl, "/")
+	if _, ok := noStreamOptionsUrls.Load(baseUrl); !ok {
+		reqBody.StreamOptions = &OpenAIStreamOptions{IncludeUsage: true}
+	}
+
+	resp, err := postOpenAIStream(baseUrl, apiKey, headers, reqBody)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if resp.StatusCode == 400 && reqBody.StreamOptions != nil {
+		// Some strict OpenAI-compatible servers reject the "stream_options" field.
+		// Retry without it, the usage of response is unknown then.
+		resp.Body.Close()
+		log.Debugf("%s rejected stream_options, retry without it", baseUrl)
+		reqBody.StreamOptions = nil
+		if resp, err = postOpenAIStream(baseUrl, apiKey, headers, reqBody); err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == 200 {
+			noStreamOptionsUrls.Store(baseUrl, true)
+		}
 	}
 	defer resp.Body.Close()
 
 	// Handle non-200 errors (API errors usually come as JSON, but not streamed)
 	if resp.StatusCode != 200 {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return &ApiError{
-			Status:    resp.StatusCode,
-			Body:      string(bodyBytes),
-			Message:   fmt.Sprintf("OpenAI API returned status %d", resp.StatusCode),
-			Retryable: resp.StatusCode == 429 || resp.StatusCode >= 500,
+		return nil, &ApiError{
+			Status:     resp.StatusCode,
+			Body:       string(bodyBytes),
+			Message:    fmt.Sprintf("OpenAI API returned status %d", resp.StatusCode),
+			Retryable:  resp.StatusCode == 429 || resp.StatusCode >= 500,
+			RetryAfter: parseRetryAfter(resp.Header, bodyBytes),
 		}
 	}
 
+	message := &OpenAIMessage{Role: "assistant"}
+	apiResp = &OpenAIResponse{}
+	var content strings.Builder
+	finishReason := ""
+
 	// Read the stream line by line
 	scanner := bufio.NewScanner(resp.Body)
 	for scanner.Scan() {
@@ -294,25 +437,70 @@ func CallOpenAIStream(baseUrl, apiKey string, reqBody *OpenAIChatRequest,
 		if err := json.Unmarshal([]byte(dataStr), &chunk); err != nil {
 			// If we can't unmarshal a specific chunk, we might log it but continue,
 			// or fail. Here we fail to ensure integrity.
-			return fmt.Errorf("failed to unmarshal stream chunk: %w", err)
+			return nil, fmt.Errorf("failed to unmarshal stream chunk: %w", err)
+		}
+		if chunk.ID != "" {
+			apiResp.ID = chunk.ID
+		}
+		if chunk.Usage != nil {
+			apiResp.Usage = chunk.Usage
 		}
 
 		if len(chunk.Choices) > 0 {
-			content := chunk.Choices[0].Delta.Content
+			delta := chunk.Choices[0].Delta
+			if chunk.Choices[0].FinishReason != "" {
+				finishReason = chunk.Choices[0].FinishReason
+			}
+			message.ToolCalls = mergeToolCallDeltas(message.ToolCalls, delta.ToolCalls)
 			// Only trigger callback if there is actual content
-			if content != "" {
-				if err := onChunk(content); err != nil {
-					return err // User requested to stop
+			if delta.Content != "" {
+				content.WriteString(delta.Content)
+				if err := onChunk(delta.Content); err != nil {
+					return nil, err // User requested to stop
 				}
 			}
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("error reading stream: %w", err)
+		return nil, fmt.Errorf("error reading stream: %w", err)
 	}
 
-	return nil
+	message.Content = content.String()
+	apiResp.Choices = []OpenAIChoice{{Message: *message, FinishReason: finishReason}}
+	return apiResp, nil
+}
+
+// Merge streamed tool call deltas into tool calls.
+// The first delta of a tool call has id, type and function name; later ones have argument fragments.
+func mergeToolCallDeltas(toolCalls []*OpenAIToolCall, deltas []*OpenAIToolCall) []*OpenAIToolCall {
+	for _, delta := range deltas {
+		index := len(toolCalls) - 1
+		if delta.Index != nil {
+			index = *delta.Index
+		} else if delta.Id != "" {
+			index = len(toolCalls)
+		}
+		if index < 0 {
+			index = 0
+		}
+		for len(toolCalls) <= index {
+			toolCalls = append(toolCalls, &OpenAIToolCall{Type: "function"})
+		}
+		toolCall := toolCalls[index]
+		if delta.Id != "" {
+			toolCall.Id = delta.Id
+		}
+		if delta.Type != "" {
+			toolCall.Type = delta.Type
+		}
+		if delta.ExtraContent != nil {
+			toolCall.ExtraContent = delta.ExtraContent
+		}
+		toolCall.Function.Name += delta.Function.Name
+		toolCall.Function.Arguments += delta.Function.Arguments
+	}
+	return toolCalls
 }
 
 // OpenAIChat performs a simple text-in, text-out conversation.
@@ -382,7 +570,7 @@ func OpenAIJsonResponse[T any](baseUrl string, apiKey string, model string,
 }
 
 // OpenAIImageToText handles Vision capabilities.
-func OpenAIImageToText(apiKey string, baseUrl string, model string, promptText string,
+func OpenAIImageToText(baseUrl string, apiKey string, model string, promptText string,
 	imageBytes []byte, mimeType string, temperature float64) (string, error) {
 	dataUrl := ""
 	if mimeType != "" {
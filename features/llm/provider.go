@@ -0,0 +1,180 @@
+package llm
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Provider is a LLM service backend.
+// All requests are expressed in the OpenAI chat completions format,
+// providers with a different native API (e.g. Gemini) translate it themselves.
+// The reqBody.Model is already set to the provider side model name when the methods are called.
+type Provider interface {
+	// Complete sends a non-streaming chat request.
+	Complete(apiKey string, reqBody *OpenAIChatRequest) (*OpenAIResponse, error)
+	// Stream sends a streaming chat request. onChunk is invoked for every text token received.
+	// It returns the assembled full response.
+	Stream(apiKey string, reqBody *OpenAIChatRequest, onChunk func(content string) error) (*OpenAIResponse, error)
+}
+
+// ProviderResolver checks whether a model string is handled by a provider.
+// If it is, it returns the provider and the provider side model name.
+// If it isn't, it returns a nil provider and nil error.
+// A non-nil error means the model string is handled by the provider but is malformed.
+type ProviderResolver func(model string) (provider Provider, providerModel string, err error)
+
+type providerRegistration struct {
+	name     string
+	resolver ProviderResolver
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   []*providerRegistration
+)
+
+// RegisterProvider registers a provider resolver with a unique name.
+// Resolvers registered later take precedence over earlier ones,
+// so a custom registration can override the builtin providers.
+// Registering a name that already exists replaces the old resolver.
+func RegisterProvider(name string, resolver ProviderResolver) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	for i, p := range providers {
+		if p.name == name {
+			providers = append(providers[:i], providers[i+1:]...)
+			break
+		}
+	}
+	providers = append(providers, &providerRegistration{name: name, resolver: resolver})
+}
+
+// ResolveModel finds the provider for model string.
+// It returns the provider and the provider side model name.
+func ResolveModel(model string) (Provider, string, error) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	for i := len(providers) - 1; i >= 0; i-- {
+		provider, providerModel, err := providers[i].resolver(model)
+		if err != nil {
+			return nil, "", err
+		}
+		if provider != nil {
+			return provider, providerModel, nil
+		}
+	}
+	return nil, "", fmt.Errorf("unsupported model %s", model)
+}
+
+// OpenAIProvider is an OpenAI API compatible provider.
+type OpenAIProvider struct {
+	BaseUrl   string            // e.g. "https://api.openai.com/v1"
+	ApiKeyEnv string            // optional env name to read api key from if it's not provided
+	Headers   map[string]string // optional extra http request headers
+}
+
+func (p *OpenAIProvider) Complete(apiKey string, reqBody *OpenAIChatRequest) (*OpenAIResponse, error) {
+	apiKey, err := getApiKeyFromEnv(apiKey, p.ApiKeyEnv)
+	if err != nil {
+		return nil, err
+	}
+	return callOpenAI(p.BaseUrl, apiKey, p.Headers, reqBody)
+}
+
+func (p *OpenAIProvider) Stream(apiKey string, reqBody *OpenAIChatRequest,
+	onChunk func(content string) error) (*OpenAIResponse, error) {
+	apiKey, err := getApiKeyFromEnv(apiKey, p.ApiKeyEnv)
+	if err != nil {
+		return nil, err
+	}
+	return callOpenAIStream(p.BaseUrl, apiKey, p.Headers, reqBody, onChunk)
+}
+
+// GeminiProvider uses the native Gemini API for non-streaming requests,
+// and the Gemini OpenAI compatible API for streaming requests.
+type GeminiProvider struct {
+	ApiKeyEnv string            // optional env name to read api key from if it's not provided
+	Headers   map[string]string // optional extra http request headers
+}
+
+func (p *GeminiProvider) Complete(apiKey string, reqBody *OpenAIChatRequest) (*OpenAIResponse, error) {
+	apiKey, err := getApiKeyFromEnv(apiKey, p.ApiKeyEnv)
+	if err != nil {
+		return nil, err
+	}
+	geminiReq, err := OpenAIRequestToGemini(reqBody)
+	if err != nil {
+		return nil, err
+	}
+	geminiResp, err := callGemini(apiKey, reqBody.Model, p.Headers, geminiReq)
+	if err != nil {
+		return nil, err
+	}
+	return GeminiResponseToOpenAI(geminiResp), nil
+}
+
+func (p *GeminiProvider) Stream(apiKey string, reqBody *OpenAIChatRequest,
+	onChunk func(content string) error) (*OpenAIResponse, error) {
+	apiKey, err := getApiKeyFromEnv(apiKey, p.ApiKeyEnv)
+	if err != nil {
+		return nil, err
+	}
+	return callOpenAIStream(GEMINI_OPENAI_COMPATIBLE_API_URL, apiKey, p.Headers, reqBody, onChunk)
+}
+
+// Return apiKey if it's not empty, otherwise read it from env (if env is not empty).
+func getApiKeyFromEnv(apiKey string, env string) (string, error) {
+	if apiKey != "" || env == "" {
+		return apiKey, nil
+	}
+	apiKey = os.Getenv(env)
+	if apiKey == "" {
+		return "", fmt.Errorf("api key or %s env not set", env)
+	}
+	return apiKey, nil
+}
+
+var (
+	openaiProvider     = &OpenAIProvider{BaseUrl: OPENAI_API_URL}
+	openrouterProvider = &OpenAIProvider{BaseUrl: OPENROUTER_API_URL}
+	geminiProvider     = &GeminiProvider{}
+)
+
+func init() {
+	RegisterProvider("openai-compatible", func(model string) (Provider, string, error) {
+		// "openai/model-name/http://localhost:8080/v1"
+		if !strings.HasPrefix(model, OPENAI_COMPATIBLE_MODEL_PREFIX) {
+			return nil, "", nil
+		}
+		parts := strings.SplitN(model, "/", 3)
+		if len(parts) != 3 || parts[1] == "" || parts[2] == "" {
+			return nil, "", fmt.Errorf("invalid openai model %s", model)
+		}
+		return &OpenAIProvider{BaseUrl: parts[2]}, parts[1], nil
+	})
+	RegisterProvider("openrouter", func(model string) (Provider, string, error) {
+		openrouterModel, ok := strings.CutPrefix(model, OPENROUTER_MODEL_PREFIX)
+		if !ok {
+			return nil, "", nil
+		}
+		// "openrouter/auto" => "openrouter/auto"; "openrouter/google/gemma-3-27b-it:free" => "google/gemma-3-27b-it:free"
+		if !strings.ContainsRune(openrouterModel, '/') {
+			openrouterModel = OPENROUTER_MODEL_PREFIX + openrouterModel
+		}
+		return openrouterProvider, openrouterModel, nil
+	})
+	RegisterProvider("openai", func(model string) (Provider, string, error) {
+		if !isOpenAiModel(model) {
+			return nil, "", nil
+		}
+		return openaiProvider, model, nil
+	})
+	RegisterProvider("gemini", func(model string) (Provider, string, error) {
+		if !strings.HasPrefix(model, GEMINI_MODEL_PREFIX) {
+			return nil, "", nil
+		}
+		return geminiProvider, model, nil
+	})
+}
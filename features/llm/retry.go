@@ -0,0 +1,214 @@
+package llm
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/sagan/goaider/util"
+)
+
+// RetryPolicy is the retry policy of all LLM provider calls.
+type RetryPolicy struct {
+	MaxAttempts  int           // max attempts of a request, including the first one. <= 1: no retry
+	BaseBackoff  time.Duration // backoff of first retry. It doubles on every later retry
+	MaxBackoff   time.Duration // max backoff of a single retry
+	MaxTotalWait time.Duration // max total wait time of all retries of a request. 0: unlimited
+}
+
+// RateLimit is a client-side rate limit of a model.
+type RateLimit struct {
+	Rpm int64 // max requests per minute. 0: unlimited
+	Tpm int64 // max (prompt + completion) tokens per minute. 0: unlimited
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:  5,
+	BaseBackoff:  2 * time.Second,
+	MaxBackoff:   GeminiApiMaxBackoff,
+	MaxTotalWait: 5 * time.Minute,
+}
+
+var (
+	retryMu      sync.RWMutex
+	retryPolicy  = DefaultRetryPolicy
+	rateLimits   = map[string]*RateLimit{} // model => rate limit
+	defaultLimit *RateLimit
+	limiters     = map[string]*rateLimiter{}
+)
+
+// Set the retry policy of all LLM provider calls.
+func SetRetryPolicy(policy RetryPolicy) {
+	retryMu.Lock()
+	defer retryMu.Unlock()
+	retryPolicy = policy
+}
+
+func GetRetryPolicy() RetryPolicy {
+	retryMu.RLock()
+	defer retryMu.RUnlock()
+	return retryPolicy
+}
+
+// Set the client-side rate limit of a model. If model is empty, set the default rate limit of all models.
+// The limit is shared by all requests of the model in current process.
+func SetRateLimit(model string, limit *RateLimit) {
+	retryMu.Lock()
+	defer retryMu.Unlock()
+	if model == "" {
+		defaultLimit = limit
+	} else {
+		rateLimits[model] = limit
+	}
+}
+
+// Return the rate limiter of model. Return nil if model is not rate limited.
+func getRateLimiter(model string) *rateLimiter {
+	retryMu.Lock()
+	defer retryMu.Unlock()
+	if limiter := limiters[model]; limiter != nil {
+		return limiter
+	}
+	limit := rateLimits[model]
+	if limit == nil {
+		limit = defaultLimit
+	}
+	if limit == nil || (limit.Rpm <= 0 && limit.Tpm <= 0) {
+		return nil
+	}
+	limiter := &rateLimiter{limit: *limit}
+	limiters[model] = limiter
+	return limiter
+}
+
+type rateLimitEvent struct {
+	time   time.Time
+	tokens int64
+}
+
+// A sliding window (1 minute) rate limiter.
+// Requests are counted when they start; tokens are counted when the responses (usages) are received.
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  RateLimit
+	events []*rateLimitEvent
+}
+
+// Block until a new request is allowed, then count it. It returns the request event.
+func (l *rateLimiter) wait() *rateLimitEvent {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		for len(l.events) > 0 && now.Sub(l.events[0].time) >= time.Minute {
+			l.events = l.events[1:]
+		}
+		var tokens int64
+		for _, event := range l.events {
+			tokens += event.tokens
+		}
+		if (l.limit.Rpm <= 0 || int64(len(l.events)) < l.limit.Rpm) && (l.limit.Tpm <= 0 || tokens < l.limit.Tpm) {
+			event := &rateLimitEvent{time: now}
+			l.events = append(l.events, event)
+			l.mu.Unlock()
+			return event
+		}
+		wait := time.Minute - now.Sub(l.events[0].time)
+		l.mu.Unlock()
+		time.Sleep(wait)
+	}
+}
+
+// Count the tokens of a finished request.
+func (l *rateLimiter) record(event *rateLimitEvent, usage *OpenAIUsage) {
+	if usage == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	event.tokens = usage.PromptTokens + usage.CompletionTokens
+}
+
+// Call fn with the retry policy and the rate limit of model.
+// The error is retried if it's temporary (e.g. 429 or 5xx status, network timeout) and retryable returns true.
+// The server suggested retry delay (Retry-After header or Gemini RetryInfo) is honored.
+func callWithRetry(model string, retryable func() bool,
+	fn func() (*OpenAIResponse, error)) (resp *OpenAIResponse, err error) {
+	policy := GetRetryPolicy()
+	limiter := getRateLimiter(model)
+	var totalWait time.Duration
+	for attempt := 0; ; attempt++ {
+		var event *rateLimitEvent
+		if limiter != nil {
+			event = limiter.wait()
+		}
+		resp, err = fn()
+		if limiter != nil && resp != nil {
+			limiter.record(event, resp.Usage)
+		}
+		if err == nil || attempt+1 >= policy.MaxAttempts || !util.IsTemporaryError(err) || !retryable() {
+			return resp, err
+		}
+		wait := util.CalculateBackoff(policy.BaseBackoff, policy.MaxBackoff, attempt)
+		if apiErr, ok := err.(*ApiError); ok && apiErr.RetryAfter > 0 {
+			wait = apiErr.RetryAfter
+		}
+		if policy.MaxTotalWait > 0 && totalWait+wait > policy.MaxTotalWait {
+			return resp, err
+		}
+		totalWait += wait
+		log.Warnf("%s request error (%v), retrying in %v (attempt %d/%d)", model, err, wait,
+			attempt+2, policy.MaxAttempts)
+		time.Sleep(wait)
+	}
+}
+
+// Parse the server suggested retry delay from http response header and body. Return 0 if not found.
+// It supports "Retry-After" header (seconds or http date)
+// and Gemini "google.rpc.RetryInfo" error details, e.g. {"error":{"details":[{"retryDelay":"37s"}]}}.
+func parseRetryAfter(header http.Header, body []byte) time.Duration {
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+			return time.Duration(seconds * float64(time.Second))
+		}
+		if t, err := http.ParseTime(value); err == nil {
+			if wait := time.Until(t); wait > 0 {
+				return wait
+			}
+		}
+	}
+	type errorBody struct {
+		Error struct {
+			Details []struct {
+				Type       string `json:"@type"`
+				RetryDelay string `json:"retryDelay"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	var errorBodies []*errorBody
+	// Gemini OpenAI compatible API returns a list of error bodies
+	if err := json.Unmarshal(body, &errorBodies); err != nil {
+		errorBodies = []*errorBody{{}}
+		if err = json.Unmarshal(body, errorBodies[0]); err != nil {
+			return 0
+		}
+	}
+	for _, eb := range errorBodies {
+		if eb == nil {
+			continue
+		}
+		for _, detail := range eb.Error.Details {
+			if !strings.HasSuffix(detail.Type, "google.rpc.RetryInfo") || detail.RetryDelay == "" {
+				continue
+			}
+			if wait, err := time.ParseDuration(detail.RetryDelay); err == nil && wait > 0 {
+				return wait
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,118 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/invopop/jsonschema"
+)
+
+// Tool is a local function exposed to LLM via function calling.
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  *jsonschema.Schema
+	// Run executes the tool with JSON string arguments and returns the result text.
+	Run func(arguments string) (string, error)
+}
+
+// NewTool creates a tool which parameters schema is reflected from struct T.
+func NewTool[T any](name string, description string, run func(args *T) (string, error)) *Tool {
+	reflector := &jsonschema.Reflector{ExpandedStruct: true, DoNotReference: true}
+	schema := reflector.Reflect(new(T))
+	schema.Version = ""
+	return &Tool{
+		Name:        name,
+		Description: description,
+		Parameters:  schema,
+		Run: func(arguments string) (string, error) {
+			args := new(T)
+			if arguments != "" {
+				if err := json.Unmarshal([]byte(arguments), args); err != nil {
+					return "", fmt.Errorf("invalid arguments: %w", err)
+				}
+			}
+			return run(args)
+		},
+	}
+}
+
+// Return the OpenAI function calling definition of the tool.
+func (t *Tool) Definition() *OpenAITool {
+	return &OpenAITool{
+		Type: "function",
+		Function: &OpenAIFunction{
+			Name:        t.Name,
+			Description: t.Description,
+			Parameters:  t.Parameters,
+		},
+	}
+}
+
+// Return the OpenAI function calling definitions of tools.
+func ToolDefinitions(tools []*Tool) (definitions []*OpenAITool) {
+	for _, tool := range tools {
+		definitions = append(definitions, tool.Definition())
+	}
+	return definitions
+}
+
+// RunToolCalls executes the tool calls of an assistant message and returns the "tool" role result messages.
+// A tool error (or unknown tool) is not fatal, it's reported to the model as the result text.
+// onCall is invoked before each tool call is executed, it can be nil.
+func RunToolCalls(tools []*Tool, toolCalls []*OpenAIToolCall,
+	onCall func(toolCall *OpenAIToolCall)) (messages []*OpenAIMessage) {
+	for _, toolCall := range toolCalls {
+		if onCall != nil {
+			onCall(toolCall)
+		}
+		result := ""
+		var tool *Tool
+		for _, t := range tools {
+			if t.Name == toolCall.Function.Name {
+				tool = t
+				break
+			}
+		}
+		if tool == nil {
+			result = fmt.Sprintf("error: unknown tool %q", toolCall.Function.Name)
+		} else if output, err := tool.Run(toolCall.Function.Arguments); err != nil {
+			result = fmt.Sprintf("error: %v", err)
+		} else {
+			result = output
+		}
+		messages = append(messages, &OpenAIMessage{
+			Role:       "tool",
+			Content:    result,
+			ToolCallId: toolCall.Id,
+			Name:       toolCall.Function.Name,
+		})
+	}
+	return messages
+}
+
+// StreamWithTools streams a chat request and runs the tool loop:
+// while the model requests tool calls, they are executed and the results are sent back.
+// The assistant and tool messages are appended to reqBody.Messages.
+// maxRounds limits the number of tool call rounds. It returns the final response.
+func StreamWithTools(apiKey string, model string, reqBody *OpenAIChatRequest, tools []*Tool, maxRounds int,
+	onChunk func(content string) error, onCall func(toolCall *OpenAIToolCall)) (*OpenAIResponse, error) {
+	if len(tools) > 0 {
+		reqBody.Tools = ToolDefinitions(tools)
+	}
+	for round := 0; ; round++ {
+		resp, err := Stream(apiKey, model, reqBody, onChunk)
+		if err != nil {
+			return nil, err
+		}
+		message := resp.Choices[0].Message
+		reqBody.Messages = append(reqBody.Messages, &message)
+		if len(message.ToolCalls) == 0 {
+			return resp, nil
+		}
+		if round >= maxRounds {
+			return nil, fmt.Errorf("tool call rounds exceeded max limit %d", maxRounds)
+		}
+		reqBody.Messages = append(reqBody.Messages, RunToolCalls(tools, message.ToolCalls, onCall)...)
+	}
+}
@@ -0,0 +1,245 @@
+package llm
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Model price in USD per 1M tokens.
+type ModelPrice struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// Builtin model prices, from https://ai.google.dev/gemini-api/docs/pricing
+// and https://platform.openai.com/docs/pricing . Prices may be outdated.
+// Use RegisterModelPrice (or "input_price" / "output_price" of config file profile) to add or override.
+var modelPrices = map[string]*ModelPrice{
+	"gemini-2.0-flash-lite": {Input: 0.075, Output: 0.30},
+	"gemini-2.0-flash":      {Input: 0.10, Output: 0.40},
+	"gemini-2.5-flash-lite": {Input: 0.10, Output: 0.40},
+	"gemini-2.5-flash":      {Input: 0.30, Output: 2.50},
+	"gemini-2.5-pro":        {Input: 1.25, Output: 10.00},
+	"gpt-5-nano":            {Input: 0.05, Output: 0.40},
+	"gpt-5-mini":            {Input: 0.25, Output: 2.00},
+	"gpt-5":                 {Input: 1.25, Output: 10.00},
+	"gpt-5.1":               {Input: 1.25, Output: 10.00},
+}
+
+// Aggregated usage of a model.
+type UsageStats struct {
+	Requests       int64 `json:"requests"`
+	CachedRequests int64 `json:"cached_requests,omitempty"` // requests served by response cache. Free
+	// requests which usage is unknown (not reported by provider). Their tokens and cost are not counted
+	UnknownRequests  int64   `json:"unknown_requests,omitempty"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	Cost             float64 `json:"cost"` // USD. 0 if model price is unknown
+}
+
+func (u *UsageStats) Add(other *UsageStats) {
+	u.Requests += other.Requests
+	u.CachedRequests += other.CachedRequests
+	u.UnknownRequests += other.UnknownRequests
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.TotalTokens += other.TotalTokens
+	u.Cost += other.Cost
+}
+
+// A usage ledger file record. The ledger file is a JSONL file.
+type UsageRecord struct {
+	Time    string `json:"time"`
+	Command string `json:"command"`
+	Model   string `json:"model"`
+	UsageStats
+}
+
+var (
+	usageMu sync.Mutex
+	usages  = map[string]*UsageStats{} // model => usage of current run
+)
+
+// Add or override the price of a model.
+func RegisterModelPrice(model string, price *ModelPrice) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	modelPrices[model] = price
+}
+
+// RecordUsage adds the usage of a request to current run usage of model.
+// usage can be nil (provider doesn't report usage), in which case only the request is counted.
+func RecordUsage(model string, usage *OpenAIUsage) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	stats := usages[model]
+	if stats == nil {
+		stats = &UsageStats{}
+		usages[model] = stats
+	}
+	stats.Requests++
+	if usage == nil {
+		stats.UnknownRequests++
+		return
+	}
+	stats.PromptTokens += usage.PromptTokens
+	stats.CompletionTokens += usage.CompletionTokens
+	if usage.TotalTokens > 0 {
+		stats.TotalTokens += usage.TotalTokens
+	} else {
+		stats.TotalTokens += usage.PromptTokens + usage.CompletionTokens
+	}
+	if price := modelPrices[model]; price != nil {
+		stats.Cost += (float64(usage.PromptTokens)*price.Input + float64(usage.CompletionTokens)*price.Output) / 1e6
+	}
+}
+
+// RecordCacheHit counts a request of model which is served by the response cache.
+func RecordCacheHit(model string) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	stats := usages[model]
+	if stats == nil {
+		stats = &UsageStats{}
+		usages[model] = stats
+	}
+	stats.CachedRequests++
+}
+
+// Return a copy of current run usages, keyed by model.
+func GetUsages() map[string]*UsageStats {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	result := map[string]*UsageStats{}
+	for model, stats := range usages {
+		result[model] = &UsageStats{}
+		result[model].Add(stats)
+	}
+	return result
+}
+
+// Print current run usage summary to output. Do nothing if no LLM request was made.
+func PrintUsageSummary(output io.Writer) {
+	usages := GetUsages()
+	if len(usages) == 0 {
+		return
+	}
+	models := make([]string, 0, len(usages))
+	for model := range usages {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	fmt.Fprintf(output, "LLM usage:\n")
+	for _, model := range models {
+		stats := usages[model]
+		fmt.Fprintf(output, "  %s: %d requests (%d cached), %d prompt tokens, %d completion tokens, "+
+			"%d total tokens, $%.4f\n", model, stats.Requests, stats.CachedRequests,
+			stats.PromptTokens, stats.CompletionTokens, stats.TotalTokens, stats.Cost)
+		if stats.UnknownRequests > 0 {
+			fmt.Fprintf(output, "    (usage of %d requests is unknown and not counted)\n", stats.UnknownRequests)
+		}
+	}
+}
+
+// Append current run usages to the ledger file. Do nothing if no LLM request was made.
+func AppendUsageLedger(ledgerFile string, command string) error {
+	usages := GetUsages()
+	if len(usages) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(ledgerFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	now := time.Now().Format(time.RFC3339)
+	encoder := json.NewEncoder(f)
+	for model, stats := range usages {
+		if err := encoder.Encode(&UsageRecord{Time: now, Command: command, Model: model,
+			UsageStats: *stats}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read all records of the ledger file. Return nil if ledger file does not exist.
+func ReadUsageLedger(ledgerFile string) (records []*UsageRecord, err error) {
+	f, err := os.Open(ledgerFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		record := &UsageRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			return nil, fmt.Errorf("invalid ledger line %d: %w", lineno, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// Print usage ledger records summary to output as a table, grouped by day and model.
+func PrintUsageReport(output io.Writer, records []*UsageRecord, dateFormat string) error {
+	type key struct {
+		day   string
+		model string
+	}
+	groups := map[key]*UsageStats{}
+	var keys []key
+	total := &UsageStats{}
+	for _, record := range records {
+		t, err := time.Parse(time.RFC3339, record.Time)
+		if err != nil {
+			return fmt.Errorf("invalid ledger record time %q: %w", record.Time, err)
+		}
+		k := key{day: t.Local().Format(dateFormat), model: record.Model}
+		if groups[k] == nil {
+			groups[k] = &UsageStats{}
+			keys = append(keys, k)
+		}
+		groups[k].Add(&record.UsageStats)
+		total.Add(&record.UsageStats)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].day != keys[j].day {
+			return keys[i].day < keys[j].day
+		}
+		return keys[i].model < keys[j].model
+	})
+	w := tabwriter.NewWriter(output, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "Day\tModel\tRequests\tPrompt\tCompletion\tTotal\tCost\t\n")
+	for _, k := range keys {
+		stats := groups[k]
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t$%.4f\t\n", k.day, k.model,
+			stats.Requests, stats.PromptTokens, stats.CompletionTokens, stats.TotalTokens, stats.Cost)
+	}
+	fmt.Fprintf(w, "Total\t\t%d\t%d\t%d\t%d\t$%.4f\t\n",
+		total.Requests, total.PromptTokens, total.CompletionTokens, total.TotalTokens, total.Cost)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if total.UnknownRequests > 0 {
+		_, err := fmt.Fprintf(output, "Usage of %d requests is unknown (not reported by provider) and not counted.\n",
+			total.UnknownRequests)
+		return err
+	}
+	return nil
+}
@@ -14,6 +14,7 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -30,11 +31,13 @@ import (
 )
 
 type MediaFileInfo struct {
-	Width     int       `json:"width"`
-	Height    int       `json:"height"`
-	Duration  string    `json:"duration"`  // video / audio duration (seconds)
-	Signature string    `json:"signature"` // image signature (sha256 of pixel data)`
-	Ctime     time.Time `json:"ctime"`     // photo / video creation_time
+	Width      int       `json:"width"`
+	Height     int       `json:"height"`
+	Duration   string    `json:"duration"`    // video / audio duration (seconds)
+	SampleRate int       `json:"sample_rate"` // audio sample rate (Hz) of the first audio stream
+	Channels   int       `json:"channels"`    // audio channel count of the first audio stream
+	Signature  string    `json:"signature"`   // image signature (sha256 of pixel data)`
+	Ctime      time.Time `json:"ctime"`       // photo / video creation_time
 }
 
 type FfprobeOutput struct {
@@ -224,6 +227,13 @@ func ParseVideoAudioMediaInfo(input io.Reader) (info *MediaFileInfo, err error)
 		info.Width = videoProbeResult.Streams[0].Width
 		info.Height = videoProbeResult.Streams[0].Height
 	}
+	for _, stream := range videoProbeResult.Streams {
+		if stream.CodecType == "audio" {
+			info.SampleRate, _ = strconv.Atoi(stream.SampleRate)
+			info.Channels = stream.Channels
+			break
+		}
+	}
 	info.Duration = videoProbeResult.Format.Duration
 	if videoProbeResult.Format.Tags != nil {
 		if timeStr := videoProbeResult.Format.Tags["creation_time"]; timeStr != "" {
@@ -0,0 +1,186 @@
+package sttfeature
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"strings"
+
+	"github.com/sagan/goaider/features/ttsfeature"
+)
+
+// Transcript output formats.
+const (
+	FORMAT_TXT  = "txt"
+	FORMAT_SRT  = "srt"
+	FORMAT_VTT  = "vtt"
+	FORMAT_JSON = "json"
+)
+
+var Formats = []string{FORMAT_TXT, FORMAT_SRT, FORMAT_VTT, FORMAT_JSON}
+
+// Sample rate of the audio chunks split by SplitAudio. 16kHz mono is enough for speech recognition.
+const CHUNK_SAMPLE_RATE = 16000
+
+// Min chunk duration (seconds) of SplitAudio.
+const MIN_CHUNK_DURATION = 10
+
+const (
+	// SplitAudio cuts each chunk at the quietest frame of the last CUT_SEARCH_DURATION seconds
+	// (at most a quarter of chunk duration), so that words are not cut in half.
+	CUT_SEARCH_DURATION = 10
+	CUT_FRAME_DURATION  = 0.05 // seconds
+)
+
+// A timestamped transcript segment. Times are in seconds from the beginning of audio.
+type Segment struct {
+	Start float64 `json:"start" jsonschema:"description=Start time of the segment in seconds."`
+	End   float64 `json:"end" jsonschema:"description=End time of the segment in seconds."`
+	Text  string  `json:"text" jsonschema:"description=The transcribed text of the segment in it's original language."`
+}
+
+// A timestamped transcript, as written in "json" format.
+type Transcript struct {
+	Text     string     `json:"text"`
+	Segments []*Segment `json:"segments"`
+}
+
+// An audio chunk split by SplitAudio.
+type Chunk struct {
+	Offset   float64 // start time in original audio (seconds)
+	Duration float64 // seconds
+	Data     []byte  // wav file contents
+}
+
+// Clean up segments returned by LLM of an audio chunk: shift them by offset, drop empty ones,
+// clamp times to [offset, offset+duration] (if duration > 0) and make sure end >= start.
+func FixSegments(segments []*Segment, offset float64, duration float64) (fixed []*Segment) {
+	for _, segment := range segments {
+		text := strings.TrimSpace(segment.Text)
+		if text == "" {
+			continue
+		}
+		start, end := max(segment.Start, 0), max(segment.End, 0)
+		if duration > 0 {
+			start, end = min(start, duration), min(end, duration)
+		}
+		end = max(end, start)
+		fixed = append(fixed, &Segment{Start: start + offset, End: end + offset, Text: text})
+	}
+	return fixed
+}
+
+// Join segments texts to plain text, one segment per line.
+func JoinText(segments []*Segment) string {
+	lines := make([]string, 0, len(segments))
+	for _, segment := range segments {
+		lines = append(lines, segment.Text)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Write segments to output in format (one of Formats).
+func Write(output io.Writer, format string, segments []*Segment) error {
+	switch format {
+	case FORMAT_TXT:
+		_, err := io.WriteString(output, JoinText(segments))
+		return err
+	case FORMAT_SRT:
+		for i, segment := range segments {
+			if _, err := fmt.Fprintf(output, "%d\n%s --> %s\n%s\n\n", i+1, FormatTimestamp(segment.Start, ","),
+				FormatTimestamp(segment.End, ","), segment.Text); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FORMAT_VTT:
+		if _, err := io.WriteString(output, "WEBVTT\n\n"); err != nil {
+			return err
+		}
+		for _, segment := range segments {
+			if _, err := fmt.Fprintf(output, "%s --> %s\n%s\n\n", FormatTimestamp(segment.Start, "."),
+				FormatTimestamp(segment.End, "."), segment.Text); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FORMAT_JSON:
+		if segments == nil {
+			segments = []*Segment{}
+		}
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		return encoder.Encode(&Transcript{Text: JoinText(segments), Segments: segments})
+	}
+	return fmt.Errorf("unsupported format %q", format)
+}
+
+// Format seconds as "HH:MM:SS<sep>mmm" subtitle timestamp.
+// sep is "," for SRT and "." for WebVTT.
+func FormatTimestamp(seconds float64, sep string) string {
+	ms := int64(math.Round(max(seconds, 0) * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
+
+// Decode audio file with ffmpeg and split it to chunks of about chunkDuration seconds (the last chunk may be shorter).
+// Each chunk is cut at the quietest point near it's end, see CUT_SEARCH_DURATION.
+// Chunks are 16kHz mono 16-bit PCM wav. Requires ffmpeg (ttsfeature.Init() must be called).
+func SplitAudio(file string, chunkDuration float64) (chunks []*Chunk, err error) {
+	if ttsfeature.Ffmpeg == "" {
+		return nil, fmt.Errorf("ffmpeg not found")
+	}
+	if chunkDuration < MIN_CHUNK_DURATION {
+		return nil, fmt.Errorf("invalid chunk duration %g, min is %d", chunkDuration, MIN_CHUNK_DURATION)
+	}
+	cmd := exec.Command(ttsfeature.Ffmpeg, "-v", "error", "-i", file, "-vn",
+		"-ac", "1", "-ar", fmt.Sprint(CHUNK_SAMPLE_RATE), "-f", "s16le", "-")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	pcm, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg failed: %w, stderr: %s", err, stderr.String())
+	}
+	bytesPerSecond := CHUNK_SAMPLE_RATE * 2
+	for offset := 0; offset < len(pcm); {
+		end := cutPoint(pcm, offset, chunkDuration)
+		data := pcm[offset:end]
+		chunks = append(chunks, &Chunk{
+			Offset:   float64(offset) / float64(bytesPerSecond),
+			Duration: float64(len(data)) / float64(bytesPerSecond),
+			Data:     append(ttsfeature.WavHeader(len(data), CHUNK_SAMPLE_RATE, 1), data...),
+		})
+		offset = end
+	}
+	return chunks, nil
+}
+
+// Return the end (byte offset) of the chunk of 16-bit mono pcm which starts at offset:
+// the middle of the quietest frame of the search window before offset + chunkDuration.
+func cutPoint(pcm []byte, offset int, chunkDuration float64) int {
+	chunkSize := int(chunkDuration*CHUNK_SAMPLE_RATE) * 2
+	if offset+chunkSize >= len(pcm) {
+		return len(pcm)
+	}
+	frameSize := int(CUT_FRAME_DURATION*CHUNK_SAMPLE_RATE) * 2
+	searchSize := int(min(CUT_SEARCH_DURATION, chunkDuration/4)*CHUNK_SAMPLE_RATE) * 2
+	end := offset + chunkSize
+	minEnergy := math.Inf(1)
+	// Search backward, so the later frame wins a tie (e.g. digital silence).
+	for frameEnd := offset + chunkSize; frameEnd-frameSize >= offset+chunkSize-searchSize; frameEnd -= frameSize {
+		energy := 0.0
+		for i := frameEnd - frameSize; i < frameEnd; i += 2 {
+			sample := float64(int16(binary.LittleEndian.Uint16(pcm[i:])))
+			energy += sample * sample
+		}
+		if energy < minEnergy {
+			minEnergy = energy
+			end = frameEnd - frameSize/2
+		}
+	}
+	return end
+}
@@ -9,7 +9,6 @@ import (
 	"github.com/sagan/goaider/constants"
 	"github.com/sagan/goaider/util/helper"
 	"github.com/sagan/goaider/util/stringutil"
-	"github.com/saintfish/chardet"
 )
 
 // Convert input text file to UTF-8 & \n line breaks and write to output.
@@ -37,13 +36,13 @@ func Txt2Utf8(input, output, charset string, charsetDetectionThreshold int, over
 		if err != nil {
 			return err
 		}
-		detector := chardet.NewTextDetector()
-		charset, err := detector.DetectBest(data)
-		if err != nil || charset.Confidence < charsetDetectionThreshold {
-			return fmt.Errorf("can not get text file encoding: guess=%v, err=%v", charset, err)
+		charset, confidence, err := stringutil.DetectCharset(data)
+		if err != nil || confidence < charsetDetectionThreshold {
+			return fmt.Errorf("can not get text file encoding: guess=%s (confidence %d), err=%v",
+				charset, confidence, err)
 		}
-		log.Printf("detected %q charset: %v", input, charset)
-		newdata, err := stringutil.DecodeText(data, charset.Charset, force)
+		log.Printf("detected %q charset: %s (confidence %d)", input, charset, confidence)
+		newdata, err := stringutil.DecodeText(data, charset, force)
 		if err != nil {
 			return err
 		}
@@ -225,27 +225,67 @@ func GetOtoAudio(input io.Reader, filename string) (audio io.Reader, sampleRate
 	}
 
 	if Ffmpeg != "" {
-		// Use ffmpeg to decode audio to wav format
-		cmd := exec.Command(Ffmpeg, "-i", "-", "-f", "wav", "-")
-		var stderr bytes.Buffer
-		cmd.Stdin = input
-		cmd.Stderr = &stderr
-		audio, err := cmd.StdoutPipe()
-		if err != nil {
-			return nil, 0, 0, fmt.Errorf("failed to create stdout pipe for ffmpeg: %w", err)
-		}
-		if err := cmd.Start(); err != nil {
-			return nil, 0, 0, fmt.Errorf("failed to start ffmpeg: %w, stderr: %s", err, stderr.String())
-		}
-		sampleRate, channelCount, err := ReadWavHeader(audio)
-		if err != nil {
-			return nil, 0, 0, err
-		}
-		return audio, sampleRate, channelCount, nil
+		return DecodeFfmpeg(input)
 	}
 	return nil, 0, 0, fmt.Errorf("unknown or unsupported audio file, mime=%s", mimeType)
 }
 
+// Use ffmpeg to decode input audio to 16-bit PCM.
+// The returned audio stream is an io.ReadCloser, closing it stops ffmpeg.
+func DecodeFfmpeg(input io.Reader) (audio io.Reader, sampleRate int, channelCount int, err error) {
+	if Ffmpeg == "" {
+		return nil, 0, 0, fmt.Errorf("ffmpeg not found")
+	}
+	cmd := exec.Command(Ffmpeg, "-i", "-", "-f", "wav", "-c:a", "pcm_s16le", "-")
+	var stderr bytes.Buffer
+	cmd.Stdin = input
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to create stdout pipe for ffmpeg: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to start ffmpeg: %w, stderr: %s", err, stderr.String())
+	}
+	output := &ffmpegOutput{ReadCloser: stdout, cmd: cmd}
+	sampleRate, channelCount, err = ReadWavHeader(output)
+	if err != nil {
+		output.Close()
+		return nil, 0, 0, fmt.Errorf("%w, stderr: %s", err, stderr.String())
+	}
+	return output, sampleRate, channelCount, nil
+}
+
+type ffmpegOutput struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (o *ffmpegOutput) Close() error {
+	o.ReadCloser.Close()
+	o.cmd.Process.Kill()
+	o.cmd.Wait()
+	return nil
+}
+
+// WavHeader returns the 44 bytes RIFF header of a 16-bit PCM wav file of dataSize bytes audio data.
+func WavHeader(dataSize int, sampleRate int, channelCount int) []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, 44))
+	buf.WriteString("RIFF")
+	binary.Write(buf, binary.LittleEndian, uint32(36+dataSize))
+	buf.WriteString("WAVEfmt ")
+	binary.Write(buf, binary.LittleEndian, uint32(16))                        // fmt chunk size
+	binary.Write(buf, binary.LittleEndian, uint16(1))                         // PCM
+	binary.Write(buf, binary.LittleEndian, uint16(channelCount))              // channels
+	binary.Write(buf, binary.LittleEndian, uint32(sampleRate))                // sample rate
+	binary.Write(buf, binary.LittleEndian, uint32(sampleRate*channelCount*2)) // byte rate
+	binary.Write(buf, binary.LittleEndian, uint16(channelCount*2))            // block align
+	binary.Write(buf, binary.LittleEndian, uint16(16))                        // bits per sample
+	buf.WriteString("data")
+	binary.Write(buf, binary.LittleEndian, uint32(dataSize))
+	return buf.Bytes()
+}
+
 // ReadWavHeader parses the RIFF header and all subchunks until it finds the 'data' chunk.
 // It returns the sample rate, channel count, and any error encountered.
 func ReadWavHeader(input io.Reader) (sampleRate int, channelCount int, err error) {
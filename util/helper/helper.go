@@ -3,6 +3,7 @@ package helper
 
 import (
 	"bytes"
+	"encoding/csv"
 	"errors"
 	"fmt"
 	"io"
@@ -12,6 +13,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -278,6 +280,61 @@ func ReadFileHeader(name string, size int) ([]byte, error) {
 	return b[:n], err
 }
 
+// Write a CSV file of header and rows, merged with the rows of the existing file (if exists),
+// so that the records of previous runs are kept. An existing row is replaced by the new row of the same
+// keyColumn value; Other existing rows are kept before the new rows, their columns are matched by header name.
+func WriteMergedCsv(file string, header []string, rows [][]string, keyColumn string) error {
+	key := slices.Index(header, keyColumn)
+	if key < 0 {
+		return fmt.Errorf("invalid key column %q", keyColumn)
+	}
+	var merged [][]string
+	if f, err := os.Open(file); err == nil {
+		reader := csv.NewReader(f)
+		reader.FieldsPerRecord = -1
+		existing, err := reader.ReadAll()
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read existing %q: %w", file, err)
+		}
+		if len(existing) > 0 {
+			columns := make([]int, len(header)) // index of header column in existing file, -1 if not exists
+			for i, name := range header {
+				columns[i] = slices.Index(existing[0], name)
+			}
+			if columns[key] < 0 {
+				return fmt.Errorf("existing %q has no %q column", file, keyColumn)
+			}
+			updated := map[string]bool{}
+			for _, row := range rows {
+				updated[row[key]] = true
+			}
+			for _, row := range existing[1:] {
+				mapped := make([]string, len(header))
+				for i, j := range columns {
+					if j >= 0 && j < len(row) {
+						mapped[i] = row[j]
+					}
+				}
+				if !updated[mapped[key]] {
+					merged = append(merged, mapped)
+				}
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	merged = append(merged, rows...)
+	buf := &bytes.Buffer{}
+	writer := csv.NewWriter(buf)
+	writer.Write(header)
+	writer.WriteAll(merged)
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	return atomic.WriteFile(file, buf)
+}
+
 // Normalize file path names, truncate long names and replace restrictive chars.
 func NormalizeName(continueOnError bool, pathes ...string) (renamed int, err error) {
 	if len(pathes) == 0 {
@@ -1,25 +1,280 @@
 package imgutil
 
 import (
+	"bytes"
 	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
 	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
 
 	"github.com/disintegration/imaging"
+	log "github.com/sirupsen/logrus"
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/colornames"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+
+	"github.com/sagan/goaider/constants"
 )
 
-// Read image data from input, detect it's format (png / jpg (jpeg) / webp / gif / bmp, etc),
-// and convert to target format using best possible quality. Write converted image to output.
-// If input is already target format, output it as is.
-// ext : image format extension, with or without leading dot.
-// Use 	"github.com/disintegration/imaging" library.
-func ConvertFormat(input io.Reader, output io.Writer, ext string) error {
-	format, err := imaging.FormatFromExtension(ext)
+// Output image formats.
+// Other formats supported by imaging library ("gif", "bmp", "tiff") are also accepted by Encode.
+const (
+	FORMAT_PNG  = "png"
+	FORMAT_JPG  = "jpg"
+	FORMAT_WEBP = "webp"
+)
+
+const DEFAULT_QUALITY = 95
+
+// Input image file extensions that Decode supports (with leading dot, lowercase).
+// ".avif", ".heic" and ".heif" are decoded by ffmpeg.
+var InputExts = []string{".jpg", ".jpeg", ".png", ".webp", ".gif", ".bmp", ".tif", ".tiff",
+	".avif", ".heic", ".heif"}
+
+var (
+	initializeOnce sync.Once
+	Ffmpeg         string // ffmpeg binary path
+)
+
+// Image output options.
+type Options struct {
+	Format  string // output format ext, with or without leading dot. E.g. "png", "jpg", "webp"
+	Quality int    // jpg / webp quality (1-100). 0 == DEFAULT_QUALITY. webp quality 100 is lossless
+	// If not nil, flatten alpha channel onto this background color.
+	// jpg output is always flattened, onto white if Background is nil.
+	Background color.Color
+	// Preserve EXIF metadata of input (Convert only). Otherwise all metadata is stripped.
+	KeepMetadata bool
+}
+
+// Init function. Safe to call multiple times.
+func Init() {
+	initializeOnce.Do(func() {
+		ffmpeg := os.Getenv(constants.ENV_FFMPEG)
+		switch ffmpeg {
+		case constants.NULL:
+			log.Printf("imgutil: force disable ffmpeg")
+			return
+		case "":
+			ffmpeg, _ = exec.LookPath(constants.FFMPEG)
+		}
+		if ffmpeg == "" {
+			log.Tracef("imgutil: ffmpeg not found. avif / heif images and webp output are not supported. " +
+				"Install ffmpeg in PATH or set " + constants.ENV_FFMPEG + " env to it's binary path")
+		}
+		Ffmpeg = ffmpeg
+	})
+}
+
+// Normalize a format ext to lowercase name without leading dot. "jpeg" => "jpg", "tif" => "tiff".
+func NormalizeFormat(ext string) string {
+	format := strings.ToLower(strings.TrimPrefix(ext, "."))
+	switch format {
+	case "jpeg":
+		return FORMAT_JPG
+	case "tif":
+		return "tiff"
+	}
+	return format
+}
+
+// Parse a color: "#rgb", "#rrggbb" hex, or a SVG color name (e.g. "white", "black").
+func ParseColor(value string) (color.Color, error) {
+	if c, ok := colornames.Map[strings.ToLower(value)]; ok {
+		return c, nil
+	}
+	hex := strings.TrimPrefix(value, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	var r, g, b uint8
+	if len(hex) != 6 {
+		return nil, fmt.Errorf("invalid color %q", value)
+	}
+	if _, err := fmt.Sscanf(hex, "%02x%02x%02x", &r, &g, &b); err != nil {
+		return nil, fmt.Errorf("invalid color %q", value)
+	}
+	return color.NRGBA{R: r, G: g, B: b, A: 255}, nil
+}
+
+// Decode image data. The first frame is used for animated images (gif / webp).
+// JPEG EXIF orientation is applied. Images of RGB (matrix / TRC) color profile, e.g. Display P3 or Adobe RGB,
+// are converted to sRGB; other color profiles are ignored with a warning.
+// Formats that Go can't decode (avif / heif) are decoded using ffmpeg.
+// format is the detected image format name, e.g. "jpeg", "png", "avif".
+func Decode(data []byte) (img image.Image, format string, err error) {
+	_, format, err = image.DecodeConfig(bytes.NewReader(data))
+	if err == nil {
+		img, err = imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+	} else if err == image.ErrFormat {
+		format = detectIsoFormat(data)
+		if format == "" {
+			return nil, "", err
+		}
+		img, err = decodeByFfmpeg(data)
+	}
+	if err != nil {
+		return nil, format, err
+	}
+	if icc := IccProfile(data); icc != nil {
+		var iccErr error
+		if img, iccErr = toSrgb(img, icc); iccErr != nil {
+			log.Warnf("imgutil: image color profile is ignored, colors may be inaccurate: %v", iccErr)
+		}
+	}
+	return img, format, nil
+}
+
+// Detect ISO base media file format image ("avif", "heif") by "ftyp" box brand. Return "" if not.
+func detectIsoFormat(data []byte) string {
+	if len(data) < 12 || string(data[4:8]) != "ftyp" {
+		return ""
+	}
+	switch string(data[8:12]) {
+	case "avif", "avis":
+		return "avif"
+	case "heic", "heix", "hevc", "mif1", "msf1":
+		return "heif"
+	}
+	return ""
+}
+
+// Decode image using ffmpeg. ffmpeg can't read some formats from non-seekable stdin,
+// so data is written to a temp file first.
+func decodeByFfmpeg(data []byte) (image.Image, error) {
+	Init()
+	if Ffmpeg == "" {
+		return nil, fmt.Errorf("ffmpeg not found. Please install ffmpeg to decode this image format")
+	}
+	file, err := os.CreateTemp("", "goaider-image-*")
 	if err != nil {
-		return fmt.Errorf("%s: %w", ext, err)
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(data)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd := exec.Command(Ffmpeg, "-hide_banner", "-loglevel", "error", "-i", file.Name(),
+		"-frames:v", "1", "-f", "image2pipe", "-c:v", "png", "-")
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg failed to decode image: %w, stderr: %s", err, stderr.String())
+	}
+	return imaging.Decode(stdout)
+}
+
+// Encode img to target format and write to output, using best possible quality.
+// exif is the raw EXIF data to embed into output, can be nil.
+func Encode(output io.Writer, img image.Image, exif []byte, options *Options) error {
+	format := NormalizeFormat(options.Format)
+	quality := options.Quality
+	if quality <= 0 {
+		quality = DEFAULT_QUALITY
+	}
+	nrgba := imaging.Clone(img) // 8-bit sRGB (no embedded color profile) NRGBA
+	background := options.Background
+	if background == nil && format == FORMAT_JPG {
+		background = color.White
+	}
+	if background != nil && !nrgba.Opaque() {
+		canvas := imaging.New(nrgba.Bounds().Dx(), nrgba.Bounds().Dy(), background)
+		nrgba = imaging.Overlay(canvas, nrgba, image.Pt(0, 0), 1.0)
+	}
+	buf := &bytes.Buffer{}
+	var err error
+	switch format {
+	case FORMAT_JPG:
+		err = imaging.Encode(buf, nrgba, imaging.JPEG, imaging.JPEGQuality(quality))
+	case FORMAT_WEBP:
+		err = encodeWebp(buf, nrgba, quality)
+	default:
+		var f imaging.Format
+		if f, err = imaging.FormatFromExtension(format); err != nil {
+			return fmt.Errorf("%s: %w", format, err)
+		}
+		err = imaging.Encode(buf, nrgba, f)
 	}
-	img, err := imaging.Decode(input)
 	if err != nil {
 		return err
 	}
-	return imaging.Encode(output, img, format)
+	data := buf.Bytes()
+	if exif != nil {
+		if data, err = embedExif(format, data, exif, nrgba.Bounds().Dx(), nrgba.Bounds().Dy()); err != nil {
+			return err
+		}
+	}
+	_, err = output.Write(data)
+	return err
+}
+
+// Encode img to webp using ffmpeg (libwebp).
+func encodeWebp(output io.Writer, img image.Image, quality int) error {
+	Init()
+	if Ffmpeg == "" {
+		return fmt.Errorf("ffmpeg not found. Please install ffmpeg to encode webp image")
+	}
+	input := &bytes.Buffer{}
+	if err := imaging.Encode(input, img, imaging.PNG); err != nil {
+		return err
+	}
+	args := []string{"-hide_banner", "-loglevel", "error", "-f", "png_pipe", "-i", "-", "-c:v", "libwebp"}
+	if quality >= 100 {
+		args = append(args, "-lossless", "1")
+	} else {
+		args = append(args, "-quality", fmt.Sprint(quality))
+	}
+	args = append(args, "-f", "webp", "-")
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command(Ffmpeg, args...)
+	cmd.Stdin = input
+	cmd.Stdout = output
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg failed to encode webp: %w, stderr: %s", err, stderr.String())
+	}
+	return nil
+}
+
+// Read image data from input, and convert to options.Format using best possible quality.
+// Write converted image to output.
+// If input is already target format and no other conversion is required, output it as is.
+func Convert(input io.Reader, output io.Writer, options *Options) error {
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return err
+	}
+	img, format, err := Decode(data)
+	if err != nil {
+		return err
+	}
+	if NormalizeFormat(format) == NormalizeFormat(options.Format) && options.Quality == 0 &&
+		options.Background == nil && IccProfile(data) == nil && ExtractExif(data) == nil {
+		_, err = output.Write(data)
+		return err
+	}
+	var exif []byte
+	if options.KeepMetadata {
+		exif = ExtractExif(data)
+	}
+	return Encode(output, img, exif, options)
+}
+
+// Read image data from input, detect it's format (png / jpg (jpeg) / webp / gif / bmp, etc),
+// and convert to target format using best possible quality. Write converted image to output.
+// If input is already target format, output it as is.
+// ext : image format extension, with or without leading dot.
+func ConvertFormat(input io.Reader, output io.Writer, ext string) error {
+	return Convert(input, output, &Options{Format: ext})
 }
@@ -0,0 +1,451 @@
+package imgutil
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"io"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+var (
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+	exifHeader   = []byte("Exif\x00\x00")
+	iccHeader    = []byte("ICC_PROFILE\x00")
+)
+
+// Iterate JPEG segments before image data. fn receives marker and segment payload.
+func jpegSegments(data []byte, fn func(marker byte, payload []byte)) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return
+	}
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+		if marker == 0xFF { // fill byte
+			i++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 { // SOS / EOI
+			return
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return
+		}
+		fn(marker, data[i+4:i+2+length])
+		i += 2 + length
+	}
+}
+
+// Iterate PNG chunks. fn receives chunk type and data.
+func pngChunks(data []byte, fn func(typ string, payload []byte)) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return
+	}
+	for i := len(pngSignature); i+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		if length < 0 || i+12+length > len(data) {
+			return
+		}
+		fn(string(data[i+4:i+8]), data[i+8:i+8+length])
+		i += 12 + length
+	}
+}
+
+// Iterate WebP (RIFF) chunks. fn receives chunk FourCC and data.
+func webpChunks(data []byte, fn func(fourcc string, payload []byte)) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return
+	}
+	for i := 12; i+8 <= len(data); {
+		length := int(binary.LittleEndian.Uint32(data[i+4:]))
+		if length < 0 || i+8+length > len(data) {
+			return
+		}
+		fn(string(data[i:i+4]), data[i+8:i+8+length])
+		i += 8 + length + length%2
+	}
+}
+
+// Extract raw EXIF (TIFF structure) data from jpeg / png / webp image data. Return nil if not found.
+func ExtractExif(data []byte) (exif []byte) {
+	jpegSegments(data, func(marker byte, payload []byte) {
+		if exif == nil && marker == 0xE1 && bytes.HasPrefix(payload, exifHeader) {
+			exif = payload[len(exifHeader):]
+		}
+	})
+	pngChunks(data, func(typ string, payload []byte) {
+		if typ == "eXIf" {
+			exif = payload
+		}
+	})
+	webpChunks(data, func(fourcc string, payload []byte) {
+		if fourcc == "EXIF" {
+			exif = bytes.TrimPrefix(payload, exifHeader)
+		}
+	})
+	if len(exif) == 0 {
+		return nil
+	}
+	return bytes.Clone(exif)
+}
+
+// Extract ICC color profile from jpeg / png / webp image data. Return nil if not found.
+func IccProfile(data []byte) (icc []byte) {
+	jpegSegments(data, func(marker byte, payload []byte) {
+		// ICC profile may be split into multiple APP2 segments, which are stored in order.
+		if marker == 0xE2 && bytes.HasPrefix(payload, iccHeader) && len(payload) > len(iccHeader)+2 {
+			icc = append(icc, payload[len(iccHeader)+2:]...)
+		}
+	})
+	pngChunks(data, func(typ string, payload []byte) {
+		if typ != "iCCP" {
+			return
+		}
+		// profile name, null separator, compression method (0 == zlib), compressed profile
+		_, compressed, found := bytes.Cut(payload, []byte{0})
+		if !found || len(compressed) < 1 {
+			return
+		}
+		if reader, err := zlib.NewReader(bytes.NewReader(compressed[1:])); err == nil {
+			icc, _ = io.ReadAll(reader)
+			reader.Close()
+		}
+	})
+	webpChunks(data, func(fourcc string, payload []byte) {
+		if fourcc == "ICCP" {
+			icc = payload
+		}
+	})
+	if len(icc) == 0 {
+		return nil
+	}
+	return icc
+}
+
+// A matrix / TRC based RGB ICC profile.
+type iccProfile struct {
+	// The rXYZ, gXYZ and bXYZ colorant tags (PCS XYZ, D50 adapted), as the columns of linear RGB to XYZ matrix.
+	colorants [3][3]float64
+	// The rTRC, gTRC and bTRC tone reproduction curves: 8-bit channel value to linear value.
+	trc [3][256]float64
+}
+
+// Colorants of sRGB (IEC 61966-2.1) profile, D50 adapted. Linear sRGB to XYZ matrix.
+var srgbColorants = [3][3]float64{
+	{0.4361, 0.3851, 0.1431},
+	{0.2225, 0.7169, 0.0606},
+	{0.0139, 0.0971, 0.7141},
+}
+
+// Max difference of colorants and TRC values of a profile that is considered same as sRGB.
+const srgbTolerance = 0.005
+
+// Parse a RGB ICC profile by it's colorant and TRC tags.
+// Other profiles (e.g. gray, CMYK, LUT based or Lab PCS) are not supported.
+func parseIccProfile(icc []byte) (*iccProfile, error) {
+	if len(icc) < 132 || string(icc[36:40]) != "acsp" {
+		return nil, fmt.Errorf("invalid icc profile")
+	}
+	if string(icc[16:20]) != "RGB " || string(icc[20:24]) != "XYZ " {
+		return nil, fmt.Errorf("unsupported icc profile color space %q / pcs %q", icc[16:20], icc[20:24])
+	}
+	tags := map[string][]byte{}
+	count := int(binary.BigEndian.Uint32(icc[128:]))
+	for i := range count {
+		entry := 132 + i*12
+		if entry+12 > len(icc) {
+			return nil, fmt.Errorf("invalid icc profile tag table")
+		}
+		offset, size := int(binary.BigEndian.Uint32(icc[entry+4:])), int(binary.BigEndian.Uint32(icc[entry+8:]))
+		if offset < 0 || size < 0 || offset+size > len(icc) {
+			return nil, fmt.Errorf("invalid icc profile tag %q", icc[entry:entry+4])
+		}
+		tags[string(icc[entry:entry+4])] = icc[offset : offset+size]
+	}
+	profile := &iccProfile{}
+	for c, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		tag := tags[sig]
+		if len(tag) < 20 || string(tag[:4]) != "XYZ " {
+			return nil, fmt.Errorf("icc profile has no valid %s tag", sig)
+		}
+		for i := range 3 {
+			profile.colorants[i][c] = s15Fixed16(tag[8+i*4:])
+		}
+	}
+	for c, sig := range []string{"rTRC", "gTRC", "bTRC"} {
+		curve, err := parseIccCurve(tags[sig])
+		if err != nil {
+			return nil, fmt.Errorf("icc profile has no valid %s tag: %w", sig, err)
+		}
+		for i := range profile.trc[c] {
+			profile.trc[c][i] = curve(float64(i) / 255)
+		}
+	}
+	return profile, nil
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// Parse an ICC "curv" or "para" curve tag, return the curve function of [0, 1] input.
+func parseIccCurve(tag []byte) (func(float64) float64, error) {
+	if len(tag) < 12 {
+		return nil, fmt.Errorf("invalid curve")
+	}
+	switch string(tag[:4]) {
+	case "curv":
+		count := int(binary.BigEndian.Uint32(tag[8:]))
+		if len(tag) < 12+count*2 {
+			return nil, fmt.Errorf("invalid curv")
+		}
+		switch count {
+		case 0: // identity
+			return func(x float64) float64 { return x }, nil
+		case 1: // gamma, u8Fixed8
+			gamma := float64(binary.BigEndian.Uint16(tag[12:])) / 256
+			return func(x float64) float64 { return math.Pow(x, gamma) }, nil
+		}
+		table := make([]float64, count)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(tag[12+i*2:])) / 65535
+		}
+		return func(x float64) float64 {
+			position := x * float64(count-1)
+			index := min(int(position), count-2)
+			fraction := position - float64(index)
+			return table[index]*(1-fraction) + table[index+1]*fraction
+		}, nil
+	case "para":
+		// params count of function types 0-4
+		paramsCount := []int{1, 3, 4, 5, 7}
+		function := int(binary.BigEndian.Uint16(tag[8:]))
+		if function >= len(paramsCount) || len(tag) < 12+paramsCount[function]*4 {
+			return nil, fmt.Errorf("invalid para")
+		}
+		// g, a, b, c, d, e, f
+		p := [7]float64{1, 1, 0, 0, 0, 0, 0}
+		for i := range paramsCount[function] {
+			p[i] = s15Fixed16(tag[12+i*4:])
+		}
+		g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+		switch function {
+		case 1:
+			d = -b / a
+		case 2:
+			d, e, f = -b/a, c, c
+			c = 0
+		}
+		return func(x float64) float64 {
+			if function > 0 && x < d {
+				return c*x + f
+			}
+			return math.Pow(max(a*x+b, 0), g) + e
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported curve type %q", tag[:4])
+}
+
+// Whether the profile is (practically) same as sRGB.
+func (p *iccProfile) isSrgb() bool {
+	for i := range 3 {
+		for j := range 3 {
+			if math.Abs(p.colorants[i][j]-srgbColorants[i][j]) > srgbTolerance {
+				return false
+			}
+		}
+	}
+	for c := range 3 {
+		for i, v := range p.trc[c] {
+			if math.Abs(v-srgbToLinear(float64(i)/255)) > srgbTolerance {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSrgb(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// Multiply 3x3 matrices.
+func multiplyMatrix(a, b [3][3]float64) (m [3][3]float64) {
+	for i := range 3 {
+		for j := range 3 {
+			for k := range 3 {
+				m[i][j] += a[i][k] * b[k][j]
+			}
+		}
+	}
+	return m
+}
+
+// Inverse a 3x3 matrix. Return false if it's singular.
+func inverseMatrix(m [3][3]float64) (inv [3][3]float64, ok bool) {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) - m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	if math.Abs(det) < 1e-9 {
+		return inv, false
+	}
+	for i := range 3 {
+		for j := range 3 {
+			// cofactor of m[j][i]
+			a, b := [2]int{(j + 1) % 3, (j + 2) % 3}, [2]int{(i + 1) % 3, (i + 2) % 3}
+			inv[i][j] = (m[a[0]][b[0]]*m[a[1]][b[1]] - m[a[0]][b[1]]*m[a[1]][b[0]]) / det
+		}
+	}
+	return inv, true
+}
+
+// Convert img of RGB ICC color profile to sRGB, using the profile colorants and TRC.
+// It returns img as is if the profile is sRGB, or an error if the profile is not supported.
+func toSrgb(img image.Image, icc []byte) (image.Image, error) {
+	profile, err := parseIccProfile(icc)
+	if err != nil {
+		return img, err
+	}
+	if profile.isSrgb() {
+		return img, nil
+	}
+	srgbInverse, _ := inverseMatrix(srgbColorants)
+	m := multiplyMatrix(srgbInverse, profile.colorants) // linear RGB => XYZ => linear sRGB
+	const encodeSize = 4096
+	var encode [encodeSize + 1]uint8
+	for i := range encode {
+		encode[i] = uint8(math.Round(linearToSrgb(float64(i)/encodeSize) * 255))
+	}
+	nrgba := imaging.Clone(img)
+	decode := &profile.trc
+	for i := 0; i+3 < len(nrgba.Pix); i += 4 {
+		r, g, b := decode[0][nrgba.Pix[i]], decode[1][nrgba.Pix[i+1]], decode[2][nrgba.Pix[i+2]]
+		for c, v := range [3]float64{
+			m[0][0]*r + m[0][1]*g + m[0][2]*b,
+			m[1][0]*r + m[1][1]*g + m[1][2]*b,
+			m[2][0]*r + m[2][1]*g + m[2][2]*b,
+		} {
+			nrgba.Pix[i+c] = encode[int(math.Round(min(max(v, 0), 1)*encodeSize))]
+		}
+	}
+	return nrgba, nil
+}
+
+// Set the IFD0 Orientation tag of raw EXIF data to 1 (normal),
+// as the orientation is already applied to the decoded image. It modifies exif in place.
+func resetExifOrientation(exif []byte) {
+	if len(exif) < 8 {
+		return
+	}
+	var order binary.ByteOrder
+	switch string(exif[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return
+	}
+	offset := int(order.Uint32(exif[4:]))
+	if offset < 8 || offset+2 > len(exif) {
+		return
+	}
+	count := int(order.Uint16(exif[offset:]))
+	for i := range count {
+		entry := offset + 2 + i*12
+		if entry+12 > len(exif) {
+			return
+		}
+		// tag 0x0112 (Orientation), type 3 (SHORT)
+		if order.Uint16(exif[entry:]) == 0x0112 && order.Uint16(exif[entry+2:]) == 3 {
+			order.PutUint16(exif[entry+8:], 1)
+			return
+		}
+	}
+}
+
+// Embed raw EXIF data into encoded image data of format. Supported formats: jpg, png, webp.
+// width and height are the image size, which are required by webp extended format header.
+func embedExif(format string, data []byte, exif []byte, width, height int) ([]byte, error) {
+	exif = bytes.Clone(exif)
+	resetExifOrientation(exif)
+	buf := &bytes.Buffer{}
+	switch format {
+	case FORMAT_JPG:
+		// APP1 segment right after SOI
+		length := 2 + len(exifHeader) + len(exif)
+		if length > math.MaxUint16 {
+			return nil, fmt.Errorf("exif data is too large")
+		}
+		buf.Write(data[:2])
+		buf.Write([]byte{0xFF, 0xE1})
+		binary.Write(buf, binary.BigEndian, uint16(length))
+		buf.Write(exifHeader)
+		buf.Write(exif)
+		buf.Write(data[2:])
+	case FORMAT_PNG:
+		// eXIf chunk right after IHDR chunk (signature + 25 bytes)
+		ihdrEnd := len(pngSignature) + 25
+		buf.Write(data[:ihdrEnd])
+		binary.Write(buf, binary.BigEndian, uint32(len(exif)))
+		chunk := append([]byte("eXIf"), exif...)
+		buf.Write(chunk)
+		binary.Write(buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+		buf.Write(data[ihdrEnd:])
+	case FORMAT_WEBP:
+		// Convert simple format (VP8 / VP8L) webp to extended format: VP8X, image chunk(s), EXIF.
+		// The alpha flag is only set for lossy image with ALPH chunk. Lossless (VP8L) image carries alpha
+		// in it's own bitstream, and x/image/webp rejects VP8L image with the alpha flag.
+		chunks := &bytes.Buffer{}
+		var flags byte = 0x08 // EXIF
+		webpChunks(data, func(fourcc string, payload []byte) {
+			if fourcc == "ALPH" {
+				flags |= 0x10
+			}
+		})
+		chunks.WriteString("VP8X")
+		binary.Write(chunks, binary.LittleEndian, uint32(10))
+		chunks.Write([]byte{flags, 0, 0, 0})
+		chunks.Write([]byte{byte(width - 1), byte((width - 1) >> 8), byte((width - 1) >> 16)})
+		chunks.Write([]byte{byte(height - 1), byte((height - 1) >> 8), byte((height - 1) >> 16)})
+		webpChunks(data, func(fourcc string, payload []byte) {
+			if fourcc == "VP8X" || fourcc == "EXIF" {
+				return
+			}
+			chunks.WriteString(fourcc)
+			binary.Write(chunks, binary.LittleEndian, uint32(len(payload)))
+			chunks.Write(payload)
+			if len(payload)%2 == 1 {
+				chunks.WriteByte(0)
+			}
+		})
+		chunks.WriteString("EXIF")
+		binary.Write(chunks, binary.LittleEndian, uint32(len(exif)))
+		chunks.Write(exif)
+		if len(exif)%2 == 1 {
+			chunks.WriteByte(0)
+		}
+		buf.WriteString("RIFF")
+		binary.Write(buf, binary.LittleEndian, uint32(4+chunks.Len()))
+		buf.WriteString("WEBP")
+		buf.Write(chunks.Bytes())
+	default:
+		return nil, fmt.Errorf("metadata is not supported for %s format", format)
+	}
+	return buf.Bytes(), nil
+}
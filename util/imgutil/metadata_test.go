@@ -0,0 +1,347 @@
+package imgutil
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"slices"
+	"testing"
+)
+
+// D50 adapted colorants (columns: rXYZ, gXYZ, bXYZ) of test profiles.
+var (
+	displayP3Colorants = [3][3]float64{
+		{0.5151, 0.2919, 0.1572},
+		{0.2412, 0.6922, 0.0666},
+		{-0.0011, 0.0419, 0.7841},
+	}
+	adobeRgbColorants = [3][3]float64{
+		{0.6097, 0.2053, 0.1492},
+		{0.3111, 0.6257, 0.0632},
+		{0.0195, 0.0609, 0.7446},
+	}
+)
+
+// Reference linear RGB to linear sRGB matrices.
+var (
+	displayP3ToSrgb = [3][3]float64{
+		{1.2249, -0.2247, 0},
+		{-0.0420, 1.0419, 0},
+		{-0.0197, -0.0786, 1.0979},
+	}
+	adobeRgbToSrgb = [3][3]float64{
+		{1.3982, -0.3982, 0},
+		{0, 1, 0},
+		{0, -0.0429, 1.0429},
+	}
+)
+
+func iccFixed(v float64) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(int32(math.Round(v*65536))))
+}
+
+// sRGB transfer function as "para" curve of function type 3.
+func srgbParaCurve() []byte {
+	tag := []byte("para\x00\x00\x00\x00\x00\x03\x00\x00")
+	for _, v := range []float64{2.4, 1 / 1.055, 0.055 / 1.055, 1 / 12.92, 0.04045} {
+		tag = append(tag, iccFixed(v)...)
+	}
+	return tag
+}
+
+// sRGB transfer function as "curv" table of 1024 entries, like the IEC 61966-2.1 profile.
+func srgbTableCurve() []byte {
+	tag := binary.BigEndian.AppendUint32([]byte("curv\x00\x00\x00\x00"), 1024)
+	for i := range 1024 {
+		tag = binary.BigEndian.AppendUint16(tag, uint16(math.Round(srgbToLinear(float64(i)/1023)*65535)))
+	}
+	return tag
+}
+
+// Gamma curve of "curv" type.
+func gammaCurve(gamma float64) []byte {
+	tag := binary.BigEndian.AppendUint32([]byte("curv\x00\x00\x00\x00"), 1)
+	return binary.BigEndian.AppendUint16(tag, uint16(math.Round(gamma*256)))
+}
+
+// Build an ICC v2 display profile of colorSpace ("RGB ", "GRAY"...), with description, colorants and TRC tags.
+// The 3 TRC tags share the same data, like most real profiles.
+func buildIcc(colorSpace string, description string, colorants [3][3]float64, curve []byte) []byte {
+	desc := binary.BigEndian.AppendUint32([]byte("desc\x00\x00\x00\x00"), uint32(len(description)+1))
+	desc = append(append(desc, description...), 0)
+	desc = append(desc, make([]byte, 78)...) // empty unicode and scriptcode descriptions
+	type tag struct {
+		sig  string
+		data []byte
+	}
+	tags := []tag{{"desc", desc}}
+	for c, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		data := []byte("XYZ \x00\x00\x00\x00")
+		for i := range 3 {
+			data = append(data, iccFixed(colorants[i][c])...)
+		}
+		tags = append(tags, tag{sig, data})
+	}
+	data := &bytes.Buffer{}
+	offsets := map[string]int{}
+	tableSize := 4 + (len(tags)+3)*12
+	for _, t := range tags {
+		offsets[t.sig] = 128 + tableSize + data.Len()
+		data.Write(t.data)
+		for data.Len()%4 != 0 {
+			data.WriteByte(0)
+		}
+	}
+	curveOffset := 128 + tableSize + data.Len()
+	data.Write(curve)
+
+	header := make([]byte, 128)
+	binary.BigEndian.PutUint32(header[0:], uint32(128+tableSize+data.Len()))
+	binary.BigEndian.PutUint32(header[8:], 0x02100000)
+	copy(header[12:], "mntr")
+	copy(header[16:], colorSpace)
+	copy(header[20:], "XYZ ")
+	copy(header[36:], "acsp")
+	icc := binary.BigEndian.AppendUint32(header, uint32(len(tags)+3))
+	for _, t := range tags {
+		icc = append(icc, t.sig...)
+		icc = binary.BigEndian.AppendUint32(icc, uint32(offsets[t.sig]))
+		icc = binary.BigEndian.AppendUint32(icc, uint32(len(t.data)))
+	}
+	for _, sig := range []string{"rTRC", "gTRC", "bTRC"} {
+		icc = append(icc, sig...)
+		icc = binary.BigEndian.AppendUint32(icc, uint32(curveOffset))
+		icc = binary.BigEndian.AppendUint32(icc, uint32(len(curve)))
+	}
+	return append(icc, data.Bytes()...)
+}
+
+var testPixels = []color.NRGBA{
+	{200, 100, 50, 255}, {30, 180, 220, 255}, {128, 128, 128, 255}, {255, 255, 255, 255}, {0, 0, 0, 255},
+	{10, 250, 40, 128},
+}
+
+func testImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, len(testPixels), 1))
+	for i, c := range testPixels {
+		img.SetNRGBA(i, 0, c)
+	}
+	return img
+}
+
+func TestToSrgb(t *testing.T) {
+	tests := []struct {
+		name    string
+		icc     []byte
+		matrix  *[3][3]float64          // reference linear RGB to linear sRGB matrix. nil: unchanged
+		decode  func(v float64) float64 // reference transfer function of profile
+		wantErr bool
+	}{
+		{"sRGB para curve", buildIcc("RGB ", "sRGB built-in", srgbColorants, srgbParaCurve()), nil, nil, false},
+		{"sRGB curv table", buildIcc("RGB ", "sRGB IEC61966-2.1", srgbColorants, srgbTableCurve()), nil, nil, false},
+		{"sRGB described as Display P3",
+			buildIcc("RGB ", "Not Display P3", srgbColorants, srgbParaCurve()), nil, nil, false},
+		{"Display P3", buildIcc("RGB ", "Display P3", displayP3Colorants, srgbParaCurve()),
+			&displayP3ToSrgb, srgbToLinear, false},
+		{"Adobe RGB described as sRGB",
+			buildIcc("RGB ", "Adobe RGB (1998), not sRGB", adobeRgbColorants, gammaCurve(563.0/256)),
+			&adobeRgbToSrgb, func(v float64) float64 { return math.Pow(v, 563.0/256) }, false},
+		{"gray profile", buildIcc("GRAY", "sRGB gray", srgbColorants, srgbParaCurve()), nil, nil, true},
+		{"truncated profile", buildIcc("RGB ", "sRGB", srgbColorants, srgbParaCurve())[:200], nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := testImage()
+			converted, err := toSrgb(img, tt.icc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.matrix == nil {
+				if converted != image.Image(img) {
+					t.Errorf("image is converted, want unchanged")
+				}
+				return
+			}
+			result := converted.(*image.NRGBA)
+			m := tt.matrix
+			for i, c := range testPixels {
+				r, g, b := tt.decode(float64(c.R)/255), tt.decode(float64(c.G)/255), tt.decode(float64(c.B)/255)
+				var want [4]float64
+				for k, v := range [3]float64{
+					m[0][0]*r + m[0][1]*g + m[0][2]*b,
+					m[1][0]*r + m[1][1]*g + m[1][2]*b,
+					m[2][0]*r + m[2][1]*g + m[2][2]*b,
+				} {
+					want[k] = math.Round(linearToSrgb(min(max(v, 0), 1)) * 255)
+				}
+				want[3] = float64(c.A)
+				got := result.NRGBAAt(i, 0)
+				for k, v := range [4]uint8{got.R, got.G, got.B, got.A} {
+					if math.Abs(float64(v)-want[k]) > 2 {
+						t.Errorf("pixel %v => %v, want %v", c, got, want)
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+// Build a little-endian TIFF structure (raw EXIF) of IFD0 with Orientation and Software tags.
+func testExif(orientation uint16, software string) []byte {
+	exif := []byte("II*\x00\x08\x00\x00\x00")
+	exif = binary.LittleEndian.AppendUint16(exif, 2)
+	// Orientation, SHORT, count 1, value
+	exif = binary.LittleEndian.AppendUint16(exif, 0x0112)
+	exif = binary.LittleEndian.AppendUint16(exif, 3)
+	exif = binary.LittleEndian.AppendUint32(exif, 1)
+	exif = binary.LittleEndian.AppendUint32(exif, uint32(orientation))
+	// Software, ASCII, count, offset
+	exif = binary.LittleEndian.AppendUint16(exif, 0x0131)
+	exif = binary.LittleEndian.AppendUint16(exif, 2)
+	exif = binary.LittleEndian.AppendUint32(exif, uint32(len(software)+1))
+	exif = binary.LittleEndian.AppendUint32(exif, uint32(8+2+2*12+4))
+	exif = binary.LittleEndian.AppendUint32(exif, 0) // next IFD
+	return append(append(exif, software...), 0)
+}
+
+// 1x1 webp images: lossless (VP8L), and lossy with alpha (VP8X + ALPH + VP8).
+const (
+	webpLossless = "UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA=="
+	webpAlpha    = "UklGRkoAAABXRUJQVlA4WAoAAAAQAAAAAAAAAAAAQUxQSAwAAAARBxAR/Q9ERP8DAABWUDggGAAAABQBAJ0BKgEAAQAAAP4AAA3AAP7mtQAAAA=="
+)
+
+func TestEmbedExif(t *testing.T) {
+	img := testImage()
+	jpegData, pngData := &bytes.Buffer{}, &bytes.Buffer{}
+	if err := jpeg.Encode(jpegData, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(pngData, img); err != nil {
+		t.Fatal(err)
+	}
+	webpLosslessData, _ := base64.StdEncoding.DecodeString(webpLossless)
+	webpAlphaData, _ := base64.StdEncoding.DecodeString(webpAlpha)
+	tests := []struct {
+		name   string
+		format string
+		data   []byte
+		alpha  bool // webp VP8X alpha flag
+	}{
+		{"jpg", FORMAT_JPG, jpegData.Bytes(), false},
+		{"png", FORMAT_PNG, pngData.Bytes(), false},
+		{"webp lossless", FORMAT_WEBP, webpLosslessData, false},
+		{"webp lossy with alpha", FORMAT_WEBP, webpAlphaData, true},
+	}
+	// odd length, to test the padding of webp chunk
+	exif := testExif(6, "goaider test")
+	wantExif := testExif(1, "goaider test")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original, _, err := image.Decode(bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			bounds := original.Bounds()
+			data, err := embedExif(tt.format, tt.data, exif, bounds.Dx(), bounds.Dy())
+			if err != nil {
+				t.Fatalf("embedExif: %v", err)
+			}
+			if got := ExtractExif(data); !bytes.Equal(got, wantExif) {
+				t.Errorf("extracted exif = %q, want %q", got, wantExif)
+			}
+			decoded, format, err := image.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("decode %s: %v", format, err)
+			}
+			if !imagesEqual(decoded, original) {
+				t.Errorf("decoded image differs from original")
+			}
+			// the orientation is reset, so the image isn't rotated again
+			if decoded, _, err := Decode(data); err != nil || decoded.Bounds().Size() != bounds.Size() {
+				t.Errorf("Decode: size %v, err %v, want size %v", decoded.Bounds().Size(), err, bounds.Size())
+			}
+			switch tt.format {
+			case FORMAT_PNG:
+				checkPngChunks(t, data)
+			case FORMAT_WEBP:
+				checkWebpChunks(t, data, tt.alpha, bounds.Dx(), bounds.Dy())
+			}
+		})
+	}
+	if _, err := embedExif("gif", pngData.Bytes(), exif, 1, 1); err == nil {
+		t.Errorf("embedExif of gif: want error")
+	}
+}
+
+func imagesEqual(a, b image.Image) bool {
+	if a.Bounds() != b.Bounds() {
+		return false
+	}
+	for y := a.Bounds().Min.Y; y < a.Bounds().Max.Y; y++ {
+		for x := a.Bounds().Min.X; x < a.Bounds().Max.X; x++ {
+			r1, g1, b1, a1 := a.At(x, y).RGBA()
+			r2, g2, b2, a2 := b.At(x, y).RGBA()
+			if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Check the CRC of all png chunks, and that eXIf chunk is before IDAT.
+func checkPngChunks(t *testing.T, data []byte) {
+	t.Helper()
+	var types []string
+	for i := len(pngSignature); i < len(data); {
+		if i+12 > len(data) {
+			t.Fatalf("truncated chunk at %d", i)
+		}
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		chunk := data[i+4 : i+8+length]
+		if crc32.ChecksumIEEE(chunk) != binary.BigEndian.Uint32(data[i+8+length:]) {
+			t.Errorf("chunk %q: invalid crc", chunk[:4])
+		}
+		types = append(types, string(chunk[:4]))
+		i += 12 + length
+	}
+	if exif, idat := slices.Index(types, "eXIf"), slices.Index(types, "IDAT"); types[0] != "IHDR" || exif < 0 ||
+		exif > idat || types[len(types)-1] != "IEND" {
+		t.Errorf("invalid chunks order %v", types)
+	}
+}
+
+// Check RIFF size, VP8X header and chunks of extended webp.
+func checkWebpChunks(t *testing.T, data []byte, alpha bool, width, height int) {
+	t.Helper()
+	if int(binary.LittleEndian.Uint32(data[4:])) != len(data)-8 {
+		t.Errorf("RIFF size %d, want %d", binary.LittleEndian.Uint32(data[4:]), len(data)-8)
+	}
+	var fourccs []string
+	webpChunks(data, func(fourcc string, payload []byte) {
+		fourccs = append(fourccs, fourcc)
+		if fourcc != "VP8X" {
+			return
+		}
+		wantFlags := byte(0x08)
+		if alpha {
+			wantFlags |= 0x10
+		}
+		canvasWidth := int(payload[4]) | int(payload[5])<<8 | int(payload[6])<<16 + 1
+		canvasHeight := int(payload[7]) | int(payload[8])<<8 | int(payload[9])<<16 + 1
+		if len(payload) != 10 || payload[0] != wantFlags || canvasWidth != width || canvasHeight != height {
+			t.Errorf("invalid VP8X %x", payload)
+		}
+	})
+	if len(fourccs) < 3 || fourccs[0] != "VP8X" || fourccs[len(fourccs)-1] != "EXIF" ||
+		slices.Index(fourccs[1:], "VP8X") >= 0 {
+		t.Errorf("invalid chunks %v", fourccs)
+	}
+}
@@ -0,0 +1,96 @@
+package imgutil
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/bits"
+	"slices"
+
+	"github.com/disintegration/imaging"
+)
+
+// Perceptual hash algorithms.
+const (
+	HASH_PHASH = "phash" // DCT based perceptual hash. Robust to resizing, recompression and color adjustment
+	HASH_DHASH = "dhash" // gradient (difference) hash. Faster, slightly less robust
+)
+
+// Return the grayscale pixel values of img resized to width x height, row by row.
+// Transparent pixels are flattened onto white.
+func grayPixels(img image.Image, width, height int) []float64 {
+	canvas := imaging.New(img.Bounds().Dx(), img.Bounds().Dy(), color.White)
+	canvas = imaging.Overlay(canvas, img, image.Pt(0, 0), 1.0)
+	small := imaging.Grayscale(imaging.Resize(canvas, width, height, imaging.Lanczos))
+	pixels := make([]float64, 0, width*height)
+	for i := 0; i < len(small.Pix); i += 4 {
+		pixels = append(pixels, float64(small.Pix[i]))
+	}
+	return pixels
+}
+
+// PHash returns the 64 bit DCT perceptual hash of img:
+// the signs of the 8x8 lowest frequency DCT coefficients of the 32x32 grayscale image, relative to median.
+func PHash(img image.Image) uint64 {
+	const size, lowSize = 32, 8
+	pixels := grayPixels(img, size, size)
+	var cos [lowSize][size]float64
+	for u := range lowSize {
+		for x := range size {
+			cos[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * size))
+		}
+	}
+	// separable 2D DCT-II, only the low frequency part is computed
+	var rows [size][lowSize]float64
+	for y := range size {
+		for u := range lowSize {
+			sum := 0.0
+			for x := range size {
+				sum += pixels[y*size+x] * cos[u][x]
+			}
+			rows[y][u] = sum
+		}
+	}
+	coefficients := make([]float64, 0, lowSize*lowSize)
+	for v := range lowSize {
+		for u := range lowSize {
+			sum := 0.0
+			for y := range size {
+				sum += rows[y][u] * cos[v][y]
+			}
+			coefficients = append(coefficients, sum)
+		}
+	}
+	// the DC coefficient (average brightness) is excluded from median
+	sorted := slices.Clone(coefficients[1:])
+	slices.Sort(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+	var hash uint64
+	for i, c := range coefficients {
+		if c > median {
+			hash |= 1 << i
+		}
+	}
+	return hash
+}
+
+// DHash returns the 64 bit difference hash of img:
+// whether each pixel is brighter than it's right neighbor, of the 9x8 grayscale image.
+func DHash(img image.Image) uint64 {
+	const width, height = 9, 8
+	pixels := grayPixels(img, width, height)
+	var hash uint64
+	for y := range height {
+		for x := range width - 1 {
+			if pixels[y*width+x] > pixels[y*width+x+1] {
+				hash |= 1 << (y*(width-1) + x)
+			}
+		}
+	}
+	return hash
+}
+
+// Return the number of different bits of two hashes.
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
@@ -6,6 +6,7 @@ import (
 	"strings"
 	"unicode"
 
+	"github.com/saintfish/chardet"
 	"golang.org/x/text/encoding"
 	"golang.org/x/text/encoding/japanese"
 	"golang.org/x/text/encoding/korean"
@@ -32,6 +33,16 @@ var encodings = map[string]encoding.Encoding{
 	"utf-16le":    unicodeEncoding.UTF16(unicodeEncoding.LittleEndian, unicodeEncoding.IgnoreBOM),
 }
 
+// Detect the charset (IANA name, e.g. "UTF-8", "GB-18030") of text data using chardet.
+// Note the confidence (0-100) of chardet result is of very limited reference value.
+func DetectCharset(data []byte) (charset string, confidence int, err error) {
+	result, err := chardet.NewTextDetector().DetectBest(data)
+	if err != nil {
+		return "", 0, err
+	}
+	return result.Charset, result.Confidence, nil
+}
+
 func DecodeInput(input io.Reader, charset string) (output io.Reader, err error) {
 	if enc, ok := encodings[strings.ToLower(charset)]; ok {
 		return enc.NewDecoder().Reader(input), nil
@@ -196,3 +196,23 @@ func (c *crlfNormalizer) Read(p []byte) (n int, err error) {
 	}
 	return n, err
 }
+
+// Approximate the (CLIP) token count of a text: each word and each punctuation is a token.
+func CountTokens(text string) (count int) {
+	inWord := false
+	for _, r := range text {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if !inWord {
+				count++
+			}
+			inWord = true
+		case unicode.IsSpace(r):
+			inWord = false
+		default:
+			count++
+			inWord = false
+		}
+	}
+	return count
+}
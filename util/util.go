@@ -668,14 +668,13 @@ func Hash(input io.Reader, hashType string, hex bool) (string string, err error)
 	}
 }
 
-var temporaryError interface {
-	Temporary() bool
-}
-var timeoutError interface {
-	Timeout() bool
-}
-
 func IsTemporaryError(err error) bool {
+	var temporaryError interface {
+		Temporary() bool
+	}
+	var timeoutError interface {
+		Timeout() bool
+	}
 	// try to test if any err in tree is	"Timeout() bool" or "Temporary() bool"
 	if errors.As(err, &timeoutError) {
 		return timeoutError.Timeout()